@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 
@@ -127,6 +128,7 @@ func (p *program) Start(s service.Service) (err error) {
 
 	go func() {
 		log.Printf("demon pull streams")
+		fallback := rtsp.NewFFmpegFallback(rtsp.GetServer())
 		for {
 			var streams []models.Stream
 			db.SQLite.Find(&streams)
@@ -134,8 +136,16 @@ func (p *program) Start(s service.Service) (err error) {
 				log.Printf("find stream err:%v", err)
 				return
 			}
+			urls := make(map[string]bool)
+			for _, v := range streams {
+				urls[v.URL] = true
+			}
+			fallback.Retain(urls)
 			for i := len(streams) - 1; i > -1; i-- {
 				v := streams[i]
+				if fallback.Running(v.URL) {
+					continue
+				}
 				agent := fmt.Sprintf("EasyDarwinGo/%s", routers.BuildVersion)
 				if routers.BuildDateTime != "" {
 					agent = fmt.Sprintf("%s(%s)", agent, routers.BuildDateTime)
@@ -145,17 +155,28 @@ func (p *program) Start(s service.Service) (err error) {
 					continue
 				}
 				client.CustomPath = v.CustomPath
+				client.Priority = v.Priority
 
 				pusher := rtsp.NewClientPusher(client)
 				if rtsp.GetServer().GetPusher(pusher.Path()) != nil {
 					continue
 				}
+				release, err := rtsp.GetServer().AcquirePullSlot(v.Priority)
+				if err != nil {
+					log.Printf("Pull stream %s err :%v", rtsp.RedactURL(v.URL), err)
+					continue
+				}
 				err = client.Start(time.Duration(v.IdleTimeout) * time.Second)
 				if err != nil {
 					log.Printf("Pull stream err :%v", err)
+					// the ffmpeg fallback takes the slot over.
+					fallback.PullFailed(v.URL, pusher.Path())
+					release()
 					continue
 				}
+				fallback.PullSucceeded(v.URL)
 				rtsp.GetServer().AddPusher(pusher)
+				release()
 				//streams = streams[0:i]
 				//streams = append(streams[:i], streams[i+1:]...)
 			}
@@ -187,6 +208,17 @@ func main() {
 	log.Printf("build date:%s", buildDateTime)
 	routers.BuildVersion = fmt.Sprintf("%s.%s", routers.BuildVersion, gitCommitCode)
 	routers.BuildDateTime = buildDateTime
+	rtsp.ServerVersion = routers.BuildVersion
+	rtsp.ServerBuildDateTime = buildDateTime
+
+	if len(tail) > 0 && strings.ToLower(tail[0]) == "selftest" {
+		if !rtsp.SelfTest(os.Stdout) {
+			log.Println("selftest failed")
+			os.Exit(1)
+		}
+		log.Println("selftest passed")
+		return
+	}
 
 	sec := utils.Conf().Section("service")
 	svcConfig := &service.Config{
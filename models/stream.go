@@ -5,4 +5,5 @@ type Stream struct {
 	CustomPath        string `gorm:"type:varchar(256)"`
 	IdleTimeout       int
 	HeartbeatInterval int
+	Priority          int
 }
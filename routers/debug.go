@@ -0,0 +1,72 @@
+package routers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/EasyDarwin/EasyDarwin/rtsp"
+	"github.com/gin-gonic/gin"
+)
+
+/**
+ * @apiDefine debug 调试
+ */
+
+func debugPusher(c *gin.Context) *rtsp.Pusher {
+	id := c.Param("id")
+	for _, pusher := range rtsp.GetServer().GetPushers() {
+		if pusher.ID() == id {
+			return pusher
+		}
+	}
+	c.AbortWithStatusJSON(http.StatusNotFound, fmt.Sprintf("Pusher[%s] not found", id))
+	return nil
+}
+
+/**
+ * @api {get} /debug/channels/:id/sdp 获取通道当前SDP
+ * @apiGroup debug
+ * @apiName DebugSDP
+ * @apiDescription 开启 [http] debug_endpoints 时可用，需要登录
+ * @apiParam {String} id 推流ID
+ * @apiSuccess (200) {String} body 当前的SDP，Content-Type 为 application/sdp
+ */
+func (h *APIHandler) DebugSDP(c *gin.Context) {
+	pusher := debugPusher(c)
+	if pusher == nil {
+		return
+	}
+	sdp := pusher.SDPRaw()
+	if pusher.AudioDisabled() {
+		sdp = rtsp.StripSDPMedia(sdp, "audio")
+	}
+	c.Data(http.StatusOK, "application/sdp", []byte(sdp))
+}
+
+/**
+ * @api {get} /debug/channels/:id/keyframe.h264 下载通道最近的关键帧
+ * @apiGroup debug
+ * @apiName DebugKeyframe
+ * @apiDescription 开启 [http] debug_endpoints 和 gop_cache_enable 时可用，需要登录。H265通道使用 keyframe.h265
+ * @apiParam {String} id 推流ID
+ * @apiSuccess (200) {String} body Annex-B格式的关键帧，参数集在前
+ */
+func (h *APIHandler) DebugKeyframe(c *gin.Context) {
+	pusher := debugPusher(c)
+	if pusher == nil {
+		return
+	}
+	codec := strings.ToLower(pusher.VCodec())
+	if !strings.HasSuffix(c.Request.URL.Path, "."+codec) {
+		c.AbortWithStatusJSON(http.StatusNotFound, fmt.Sprintf("Pusher[%s] video is %s", pusher.ID(), pusher.VCodec()))
+		return
+	}
+	keyframe := pusher.LastKeyframe()
+	if keyframe == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, "No keyframe cached")
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.%s", pusher.ID(), codec))
+	c.Data(http.StatusOK, "application/octet-stream", keyframe)
+}
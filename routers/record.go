@@ -2,8 +2,10 @@ package routers
 
 import (
 	"bytes"
+	"fmt"
 	"log"
 	"math"
+	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -12,6 +14,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/EasyDarwin/EasyDarwin/rtsp"
 	"github.com/gin-gonic/gin"
 	"github.com/penggy/EasyGoLib/utils"
 )
@@ -186,3 +189,39 @@ func (h *APIHandler) RecordFiles(c *gin.Context) {
 	pr.Slice(form.Start, form.Limit)
 	c.IndentedJSON(200, pr)
 }
+
+/**
+ * @api {get} /api/v1/record/trigger 触发事件录像
+ * @apiGroup record
+ * @apiName RecordTrigger
+ * @apiDescription 开启 ts_record_enable 的通道在 ts_record_schedule 时间段之外，从下一个关键帧起录像 duration 秒
+ * @apiParam {String} path 推流PATH
+ * @apiParam {Number} [duration=60] 录像时长，秒
+ */
+func (h *APIHandler) RecordTrigger(c *gin.Context) {
+	type Form struct {
+		Path     string `form:"path" binding:"required"`
+		Duration int    `form:"duration"`
+	}
+	var form Form
+	if err := c.Bind(&form); err != nil {
+		log.Printf("record trigger bind err:%v", err)
+		return
+	}
+	if !strings.HasPrefix(form.Path, "/") {
+		form.Path = "/" + form.Path
+	}
+	if form.Duration <= 0 {
+		form.Duration = 60
+	}
+	pusher := rtsp.GetServer().GetPusher(form.Path)
+	if pusher == nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, fmt.Sprintf("Path %s not found", form.Path))
+		return
+	}
+	if !pusher.TriggerRecord(time.Duration(form.Duration) * time.Second) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, fmt.Sprintf("Path %s has no ts recording", form.Path))
+		return
+	}
+	c.IndentedJSON(200, "OK")
+}
@@ -131,6 +131,7 @@ func Init() (err error) {
 		api.GET("/defaultlogininfo", API.DefaultLoginInfo)
 		api.GET("/modifypassword", NeedLogin(), API.ModifyPassword)
 		api.GET("/serverinfo", API.GetServerInfo)
+		api.GET("/version", API.Version)
 		api.GET("/restart", API.Restart)
 
 		api.GET("/pushers", API.Pushers)
@@ -138,9 +139,19 @@ func Init() (err error) {
 
 		api.GET("/stream/start", API.StreamStart)
 		api.GET("/stream/stop", API.StreamStop)
+		api.GET("/stream/paramsets", API.StreamParamSets)
+		api.POST("/stream/setup/dryrun", API.StreamSetupDryRun)
 
 		api.GET("/record/folders", API.RecordFolders)
 		api.GET("/record/files", API.RecordFiles)
+		api.GET("/record/trigger", API.RecordTrigger)
+	}
+
+	if utils.Conf().Section("http").Key("debug_endpoints").MustBool(false) {
+		debug := Router.Group("/debug").Use(sessionHandle, NeedLogin())
+		debug.GET("/channels/:id/sdp", API.DebugSDP)
+		debug.GET("/channels/:id/keyframe.h264", API.DebugKeyframe)
+		debug.GET("/channels/:id/keyframe.h265", API.DebugKeyframe)
 	}
 
 	{
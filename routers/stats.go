@@ -41,6 +41,48 @@ import (
  * @apiSuccess (200) {Number} rows.outBytes 出口流量
  * @apiSuccess (200) {String} rows.startAt 开始时间
  * @apiSuccess (200) {Number} rows.onlines 在线人数
+ * @apiSuccess (200) {Boolean} rows.live 是否已可播放，设置 min_keyframes_live 时需收到足够的关键帧才为true
+ * @apiSuccess (200) {Object} [rows.reassembly] 视频分片重组统计，开启 fu_stats_enable 时返回
+ * @apiSuccess (200) {Number} rows.reassembly.completed 重组完成的NALU数
+ * @apiSuccess (200) {Number} rows.reassembly.discarded 因分片丢失而丢弃的不完整NALU数
+ * @apiSuccess (200) {Number} rows.reassembly.maxGap NALU分片中间出现的最大丢包数
+ * @apiSuccess (200) {Object} [rows.reorder] 视频解码顺序与显示顺序(B帧)错位统计，开启 reorder_stats_enable 时返回
+ * @apiSuccess (200) {Number} rows.reorder.maxDistance 最大重排距离(帧)
+ * @apiSuccess (200) {Number} rows.reorder.recentDistance 最近一个GOP内的最大重排距离(帧)
+ * @apiSuccess (200) {Number} rows.reorder.inversions 时间戳倒退的帧数，即需要单独计算DTS的帧数
+ * @apiSuccess (200) {Object} [rows.nalu] 各类型NAL单元(sps/pps/idr/slice/sei/aud等)的累计个数，开启 nalu_stats_enable 时返回
+ * @apiSuccess (200) {Boolean} rows.comfortNoise 音频当前是否为舒适噪声(CN)静音包
+ * @apiSuccess (200) {Number} rows.priority 优先级，越大越重要
+ * @apiSuccess (200) {Boolean} rows.paramSetsMissing 视频流既没有在SDP中也没有在码流中带SPS/PPS，无法解码
+ * @apiSuccess (200) {String} rows.fieldOrder 由H264 pic_timing SEI得到的场序：tff 顶场优先，bff 底场优先，progressive 逐行，空表示未知(需开启 sei_extract_enable)
+ * @apiSuccess (200) {Object} [rows.picTiming] 最近一个H264 pic_timing SEI，含 cpbRemovalDelay、dpbOutputDelay、picStruct、clockTimestamps
+ * @apiSuccess (200) {Object} [rows.timestampGuard] 时间戳异常检测，设置 timestamp_guard_max_jump 时返回
+ * @apiSuccess (200) {Number} rows.timestampGuard.dropped 因时间戳跳变被丢弃的包数
+ * @apiSuccess (200) {Number} rows.timestampGuard.clamped 时间戳跳变被修正的包数
+ * @apiSuccess (200) {Number} rows.timestampGuard.discontinuities 被后续包确认、按新时间线接受的时间戳跳变次数
+ * @apiSuccess (200) {Number} rows.misroutedPackets UDP推流中发到了错误端口（RTP发到RTCP端口或相反）并已纠正的包数
+ * @apiSuccess (200) {Object} [rows.ssrc] 学习到的SSRC，开启 ssrc_learning 时返回
+ * @apiSuccess (200) {Number} rows.ssrc.video 视频SSRC，-1表示尚未学习到
+ * @apiSuccess (200) {Number} rows.ssrc.audio 音频SSRC，-1表示尚未学习到
+ * @apiSuccess (200) {Number} rows.ssrc.rejected 因SSRC不符被丢弃的包数
+ * @apiSuccess (200) {Object} [rows.keyframeGap] 关键帧间隔监测，设置 max_keyframe_gap 时返回
+ * @apiSuccess (200) {Number} rows.keyframeGap.maxGap 允许的最大关键帧间隔(秒)
+ * @apiSuccess (200) {Number} rows.keyframeGap.gap 距上一个关键帧的时间(秒)
+ * @apiSuccess (200) {Number} rows.keyframeGap.keyframeRequests 因超过最大间隔向源端请求关键帧(PLI)的次数
+ * @apiSuccess (200) {Number} rows.keyframeGap.teardowns 请求关键帧无果而断开源端重连的次数
+ * @apiSuccess (200) {Object} [rows.egress] 出口带宽分配，设置 egress_bandwidth_limit 且有播放器时返回
+ * @apiSuccess (200) {Number} rows.egress.demand 不限速时该通道播放器所需的码率(bit/s)
+ * @apiSuccess (200) {Number} rows.egress.allocated 分配给该通道的码率(bit/s)
+ * @apiSuccess (200) {Number} rows.egress.used 该通道实际发送的码率(bit/s)
+ * @apiSuccess (200) {Number} rows.frameRate 根据RTP时间戳估算的视频帧率，未知时为0
+ * @apiSuccess (200) {Number} rows.goroutines 该通道正在运行的协程数
+ * @apiSuccess (200) {Object} rows.queue 接收队列统计
+ * @apiSuccess (200) {Number} rows.queue.limit 队列长度上限，0表示不限
+ * @apiSuccess (200) {Number} rows.queue.highWater 队列出现过的最大长度
+ * @apiSuccess (200) {Number} rows.queue.dropped 队列满时丢弃的包数
+ * @apiSuccess (200) {Object} [rows.codec] 视频编码参数，开启 codec_params_enable 时返回
+ * @apiSuccess (200) {Object} [rows.codec.bitstream] 从码流SPS解析的参数 codec/profile/level/tier/chromaFormat/bitDepthLuma/bitDepthChroma/width/height
+ * @apiSuccess (200) {Object} [rows.codec.sdp] SDP中声明的 profile/level/tier，与码流不一致时返回
  */
 func (h *APIHandler) Pushers(c *gin.Context) {
 	form := utils.NewPageForm()
@@ -59,15 +101,33 @@ func (h *APIHandler) Pushers(c *gin.Context) {
 			continue
 		}
 		pushers = append(pushers, map[string]interface{}{
-			"id":        pusher.ID(),
-			"url":       rtsp,
-			"path":      pusher.Path(),
-			"source":    pusher.Source(),
-			"transType": pusher.TransType(),
-			"inBytes":   pusher.InBytes(),
-			"outBytes":  pusher.OutBytes(),
-			"startAt":   utils.DateTime(pusher.StartAt()),
-			"onlines":   len(pusher.GetPlayers()),
+			"id":               pusher.ID(),
+			"url":              rtsp,
+			"path":             pusher.Path(),
+			"source":           pusher.Source(),
+			"transType":        pusher.TransType(),
+			"inBytes":          pusher.InBytes(),
+			"outBytes":         pusher.OutBytes(),
+			"startAt":          utils.DateTime(pusher.StartAt()),
+			"onlines":          len(pusher.GetPlayers()),
+			"live":             pusher.Live(),
+			"reassembly":       pusher.ReassemblyStats(),
+			"reorder":          pusher.FrameReorderStats(),
+			"nalu":             pusher.NALUStats(),
+			"comfortNoise":     pusher.ComfortNoise(),
+			"codec":            pusher.Server().CodecParams(pusher.Path()),
+			"queue":            pusher.QueueStats(),
+			"goroutines":       pusher.Goroutines(),
+			"frameRate":        pusher.FrameRate(),
+			"priority":         pusher.Priority(),
+			"paramSetsMissing": pusher.ParameterSetsMissing(),
+			"misroutedPackets": pusher.MisroutedPackets(),
+			"timestampGuard":   pusher.TimestampGuardStats(),
+			"fieldOrder":       pusher.FieldOrder(),
+			"picTiming":        pusher.PicTiming(),
+			"ssrc":             pusher.SSRCStats(),
+			"keyframeGap":      pusher.KeyframeGapStats(),
+			"egress":           pusher.Server().EgressAllocation(pusher.Path()),
 		})
 	}
 	pr := utils.NewPageResult(pushers)
@@ -95,6 +155,8 @@ func (h *APIHandler) Pushers(c *gin.Context) {
  * @apiSuccess (200) {Number} rows.inBytes 入口流量
  * @apiSuccess (200) {Number} rows.outBytes 出口流量
  * @apiSuccess (200) {String} rows.startAt 开始时间
+ * @apiSuccess (200) {Number} rows.egressBitrate 实际发给该播放器的码率(bit/s)，未开启 player_bitrate_enable 时为-1
+ * @apiSuccess (200) {String} rows.pacing 转发节奏，immediate 为收到即发，timestamp 为按 RTP 时间戳匀速发送
  */
 func (h *APIHandler) Players(c *gin.Context) {
 	form := utils.NewPageForm()
@@ -117,12 +179,14 @@ func (h *APIHandler) Players(c *gin.Context) {
 			rtsp = fmt.Sprintf("rtsp://%s%s", hostname, player.Path)
 		}
 		_players = append(_players, map[string]interface{}{
-			"id":        player.ID,
-			"path":      rtsp,
-			"transType": player.TransType.String(),
-			"inBytes":   player.InBytes,
-			"outBytes":  player.OutBytes,
-			"startAt":   utils.DateTime(player.StartAt),
+			"id":            player.ID,
+			"path":          rtsp,
+			"transType":     player.TransType.String(),
+			"inBytes":       player.InBytes,
+			"outBytes":      player.OutBytes,
+			"startAt":       utils.DateTime(player.StartAt),
+			"egressBitrate": player.EgressBitRate(),
+			"pacing":        player.Pacing,
 		})
 	}
 	pr := utils.NewPageResult(_players)
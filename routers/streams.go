@@ -1,7 +1,10 @@
 package routers
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"strings"
@@ -27,6 +30,7 @@ import (
  * @apiParam {String=TCP,UDP} [transType=TCP] 拉流传输模式
  * @apiParam {Number} [idleTimeout] 拉流时的超时时间
  * @apiParam {Number} [heartbeatInterval] 拉流时的心跳间隔，毫秒为单位。如果心跳间隔不为0，那拉流时会向源地址以该间隔发送OPTION请求用来心跳保活
+ * @apiParam {Number} [priority=0] 拉流优先级，越大越重要。拉流数达到上限 max_pull_sources 时，如果开启了 pull_evict_idle，则关闭优先级更低的空闲（无人观看）拉流为其让出名额
  * @apiSuccess (200) {String} ID	拉流的ID。后续可以通过该ID来停止拉流
  */
 func (h *APIHandler) StreamStart(c *gin.Context) {
@@ -36,6 +40,7 @@ func (h *APIHandler) StreamStart(c *gin.Context) {
 		TransType         string `form:"transType"`
 		IdleTimeout       int    `form:"idleTimeout"`
 		HeartbeatInterval int    `form:"heartbeatInterval"`
+		Priority          int    `form:"priority"`
 	}
 	var form Form
 	err := c.Bind(&form)
@@ -56,6 +61,7 @@ func (h *APIHandler) StreamStart(c *gin.Context) {
 		form.CustomPath = "/" + form.CustomPath
 	}
 	client.CustomPath = form.CustomPath
+	client.Priority = form.Priority
 	switch strings.ToLower(form.TransType) {
 	case "udp":
 		client.TransType = rtsp.TRANS_TYPE_UDP
@@ -70,13 +76,21 @@ func (h *APIHandler) StreamStart(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, fmt.Sprintf("Path %s already exists", client.Path))
 		return
 	}
+	release, err := rtsp.GetServer().AcquirePullSlot(form.Priority)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	defer release()
 	err = client.Start(time.Duration(form.IdleTimeout) * time.Second)
 	if err != nil {
 		log.Printf("Pull stream err :%v", err)
 		c.AbortWithStatusJSON(http.StatusBadRequest, fmt.Sprintf("Pull stream err: %v", err))
 		return
 	}
-	log.Printf("Pull to push %v success ", form)
+	logForm := form
+	logForm.URL = rtsp.RedactURL(form.URL)
+	log.Printf("Pull to push %v success ", logForm)
 	rtsp.GetServer().AddPusher(pusher)
 	// save to db.
 	var stream = models.Stream{
@@ -84,6 +98,7 @@ func (h *APIHandler) StreamStart(c *gin.Context) {
 		CustomPath:        form.CustomPath,
 		IdleTimeout:       form.IdleTimeout,
 		HeartbeatInterval: form.HeartbeatInterval,
+		Priority:          form.Priority,
 	}
 	if db.SQLite.Where(&models.Stream{URL: form.URL}).First(&models.Stream{}).RecordNotFound() {
 		db.SQLite.Create(&stream)
@@ -126,3 +141,84 @@ func (h *APIHandler) StreamStop(c *gin.Context) {
 	}
 	c.AbortWithStatusJSON(http.StatusBadRequest, fmt.Sprintf("Pusher[%s] not found", form.ID))
 }
+
+/**
+ * @api {get} /api/v1/stream/paramsets 获取视频参数集
+ * @apiGroup stream
+ * @apiName StreamParamSets
+ * @apiParam {String} path 推流PATH
+ * @apiSuccess (200) {String} codec 视频编码
+ * @apiSuccess (200) {Object[]} nalus 当前的参数集，H264为SPS/PPS，H265为VPS/SPS/PPS
+ * @apiSuccess (200) {String} nalus.type 类型 vps/sps/pps
+ * @apiSuccess (200) {String} nalus.base64 base64编码的NALU（含NALU头，不含起始码）
+ * @apiSuccess (200) {String} nalus.hex 十六进制的NALU
+ * @apiSuccess (200) {Object} [params] 从SPS解析的 profile/level/tier/chromaFormat/bitDepthLuma/bitDepthChroma/width/height
+ */
+func (h *APIHandler) StreamParamSets(c *gin.Context) {
+	type Form struct {
+		Path string `form:"path" binding:"required"`
+	}
+	var form Form
+	err := c.Bind(&form)
+	if err != nil {
+		log.Printf("get stream param sets err:%v", err)
+		return
+	}
+	if !strings.HasPrefix(form.Path, "/") {
+		form.Path = "/" + form.Path
+	}
+	pusher := rtsp.GetServer().GetPusher(form.Path)
+	if pusher == nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, fmt.Sprintf("Path %s not found", form.Path))
+		return
+	}
+	ps := pusher.ParameterSets()
+	nalus := make([]interface{}, 0)
+	for _, nalu := range []struct {
+		Type string
+		Data []byte
+	}{{"vps", ps.VPS}, {"sps", ps.SPS}, {"pps", ps.PPS}} {
+		if len(nalu.Data) == 0 {
+			continue
+		}
+		nalus = append(nalus, map[string]interface{}{
+			"type":   nalu.Type,
+			"base64": base64.StdEncoding.EncodeToString(nalu.Data),
+			"hex":    hex.EncodeToString(nalu.Data),
+		})
+	}
+	var params *rtsp.CodecParams
+	if cp := pusher.CodecParams(); cp != nil {
+		params = cp.Bitstream
+	}
+	c.IndentedJSON(200, map[string]interface{}{
+		"codec":  pusher.VCodec(),
+		"nalus":  nalus,
+		"params": params,
+	})
+}
+
+/**
+ * @api {post} /api/v1/stream/setup/dryrun 模拟SETUP请求
+ * @apiGroup stream
+ * @apiName StreamSetupDryRun
+ * @apiDescription 请求体为抓包得到的原始 RTSP SETUP 请求头，按播放器对当前通道的 SETUP 进行解析和协商，不建立任何媒体传输
+ * @apiSuccess (200) {String} path 匹配到的推流PATH
+ * @apiSuccess (200) {String} track 匹配到的轨道 audio/video
+ * @apiSuccess (200) {String} transType 传输方式 TCP/UDP
+ * @apiSuccess (200) {Number[]} channels TCP时为 interleaved 通道，UDP时为 client_port 端口
+ * @apiSuccess (200) {String} transport 将要回复的 Transport 头
+ */
+func (h *APIHandler) StreamSetupDryRun(c *gin.Context) {
+	raw, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	result, err := rtsp.GetServer().DryRunSetup(string(raw))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.IndentedJSON(200, result)
+}
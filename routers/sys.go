@@ -106,6 +106,10 @@ func (h *APIHandler) ModifyPassword(c *gin.Context) {
  * @apiSuccess (200) {String} RunningTime 运行时间
  * @apiSuccess (200) {String} StartUpTime 启动时间
  * @apiSuccess (200) {String} Server 软件信息
+ * @apiSuccess (200) {Number} pullSources 当前拉流数
+ * @apiSuccess (200) {Number} pullSourceLimit 拉流数上限，0表示不限
+ * @apiSuccess (200) {Number} pullRejected 因达到上限被拒绝的拉流次数
+ * @apiSuccess (200) {Number} oneWayMedia 信令成功但媒体不通（见 media_flow_timeout）的会话次数
  */
 func (h *APIHandler) GetServerInfo(c *gin.Context) {
 	c.IndentedJSON(http.StatusOK, gin.H{
@@ -118,6 +122,26 @@ func (h *APIHandler) GetServerInfo(c *gin.Context) {
 		"cpuData":          cpuData,
 		"pusherData":       pusherData,
 		"playerData":       playerData,
+		"pullSources":      rtsp.GetServer().PullSourceCount(),
+		"pullSourceLimit":  rtsp.GetServer().PullSourceLimit(),
+		"pullRejected":     rtsp.GetServer().PullSourcesRejected(),
+		"oneWayMedia":      rtsp.GetServer().OneWayMediaCount(),
+	})
+}
+
+/**
+ * @api {get} /api/v1/version 获取版本信息
+ * @apiGroup sys
+ * @apiName Version
+ * @apiSuccess (200) {String} version 版本号（含git提交）
+ * @apiSuccess (200) {String} buildDateTime 编译时间
+ * @apiSuccess (200) {String} rtspServer RTSP应答中的Server头，为空表示不发送
+ */
+func (h *APIHandler) Version(c *gin.Context) {
+	c.IndentedJSON(http.StatusOK, gin.H{
+		"version":       BuildVersion,
+		"buildDateTime": BuildDateTime,
+		"rtspServer":    rtsp.ServerHeader(),
 	})
 }
 
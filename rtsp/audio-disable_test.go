@@ -0,0 +1,51 @@
+package rtsp
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestStripSDPMedia(t *testing.T) {
+	sdp := "v=0\r\ns=test\r\nm=video 0 RTP/AVP 96\r\na=control:streamid=0\r\nm=audio 0 RTP/AVP 97\r\na=control:streamid=1\r\nm=application 0 RTP/AVP 107\r\na=control:streamid=2\r\n"
+	for _, c := range []struct {
+		media string
+		want  string
+	}{
+		{"audio", "v=0\r\ns=test\r\nm=video 0 RTP/AVP 96\r\na=control:streamid=0\r\nm=application 0 RTP/AVP 107\r\na=control:streamid=2\r\n"},
+		{"video", "v=0\r\ns=test\r\nm=audio 0 RTP/AVP 97\r\na=control:streamid=1\r\nm=application 0 RTP/AVP 107\r\na=control:streamid=2\r\n"},
+		{"text", sdp},
+	} {
+		if got := StripSDPMedia(sdp, c.media); got != c.want {
+			t.Errorf("strip %s: got\n%s\nwant\n%s", c.media, got, c.want)
+		}
+	}
+	// lf line ends come out as crlf
+	if got := StripSDPMedia(strings.Replace(sdp, "\r\n", "\n", -1), "audio"); got != "v=0\r\ns=test\r\nm=video 0 RTP/AVP 96\r\na=control:streamid=0\r\nm=application 0 RTP/AVP 107\r\na=control:streamid=2\r\n" {
+		t.Errorf("strip with lf line ends: got\n%s", got)
+	}
+}
+
+func TestAudioDisabledDropsAudio(t *testing.T) {
+	setTestConf(t, "audio_disable", "1")
+	pusher := newTestPusher("h264")
+	pusher.Session.SDPMap = map[string]*SDPInfo{"video": {}, "audio": {}}
+	pusher.cond = sync.NewCond(&sync.Mutex{})
+	if pusher.HasAudio() {
+		t.Error("audio reported with audio_disable")
+	}
+	for _, typ := range []RTPType{RTP_TYPE_AUDIO, RTP_TYPE_AUDIOCONTROL, RTP_TYPE_VIDEO} {
+		pusher.QueueRTP(&RTPPack{Type: typ, Buffer: bytes.NewBuffer(selfTestRTP(96, 0, 0, true, []byte{0x65, 1}))})
+	}
+	if len(pusher.queue) != 1 || pusher.queue[0].Type != RTP_TYPE_VIDEO {
+		t.Errorf("queued %d packets, want the video one only", len(pusher.queue))
+	}
+
+	pusher = newTestPusher("h264")
+	pusher.Session.SDPMap = map[string]*SDPInfo{"video": {}, "audio": {}}
+	setTestConf(t, "audio_disable", "0")
+	if !pusher.HasAudio() {
+		t.Error("audio not reported without audio_disable")
+	}
+}
@@ -0,0 +1,104 @@
+package rtsp
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	// the largest rtp packet over udp.
+	maxBlocksize = 65507
+)
+
+// sessionBlocksize returns the Blocksize a player asked for, clamped to blocksize_min of the
+// channel and the largest udp payload. 0 means no limit, or that the header is ignored.
+func sessionBlocksize(path string, header string) int {
+	if header == "" || !ChannelKey(path, "blocksize_enable").MustBool(true) {
+		return 0
+	}
+	blocksize, err := strconv.Atoi(strings.TrimSpace(header))
+	if err != nil || blocksize <= 0 {
+		return 0
+	}
+	if min := ChannelKey(path, "blocksize_min").MustInt(256); blocksize < min {
+		blocksize = min
+	}
+	if blocksize > maxBlocksize {
+		blocksize = maxBlocksize
+	}
+	return blocksize
+}
+
+// fragmentRTP splits a video rtp packet larger than blocksize, the rtp header included, into FU-A
+// (h264) or FU (h265) packets, the sequence numbers are left to the caller. It returns nil if the
+// packet fits or is not a single nal unit or a fragment, e.g. an aggregation packet.
+func fragmentRTP(codec string, rtpBytes []byte, blocksize int) (frags [][]byte) {
+	info := ParseRTP(rtpBytes)
+	if info == nil || info.PayloadOffset+len(info.Payload) <= blocksize {
+		return nil
+	}
+	payload := info.Payload
+	var fuIndicator []byte
+	var fuType byte
+	var start, end bool
+	var data []byte
+	if strings.EqualFold(codec, "h265") {
+		if len(payload) < 3 {
+			return nil
+		}
+		switch naluType := (payload[0] >> 1) & 0x3F; {
+		case naluType < 48:
+			fuIndicator = []byte{payload[0]&0x81 | 49<<1, payload[1]}
+			fuType, start, end, data = naluType, true, true, payload[2:]
+		case naluType == 49:
+			fuIndicator = payload[:2]
+			fuType, start, end, data = payload[2]&0x3F, payload[2]&0x80 != 0, payload[2]&0x40 != 0, payload[3:]
+		default:
+			return nil
+		}
+	} else {
+		if len(payload) < 2 {
+			return nil
+		}
+		switch naluType := payload[0] & 0x1F; {
+		case naluType >= 1 && naluType <= 23:
+			fuIndicator = []byte{payload[0]&0xE0 | 28}
+			fuType, start, end, data = naluType, true, true, payload[1:]
+		case naluType == 28:
+			fuIndicator = payload[:1]
+			fuType, start, end, data = payload[1]&0x1F, payload[1]&0x80 != 0, payload[1]&0x40 != 0, payload[2:]
+		default:
+			return nil
+		}
+	}
+	chunk := blocksize - info.PayloadOffset - len(fuIndicator) - 1
+	if chunk < 1 {
+		return nil
+	}
+	header := append([]byte{}, rtpBytes[:info.PayloadOffset]...)
+	header[0] &= 0xDF // no padding
+	for len(data) > 0 {
+		n := chunk
+		if n > len(data) {
+			n = len(data)
+		}
+		fuHeader := fuType
+		if start && len(frags) == 0 {
+			fuHeader |= 0x80
+		}
+		last := n == len(data)
+		if end && last {
+			fuHeader |= 0x40
+		}
+		frag := append([]byte{}, header...)
+		if !last || !info.Marker {
+			frag[1] &= 0x7F
+		}
+		frag = append(frag, fuIndicator...)
+		frag = append(frag, fuHeader)
+		frag = append(frag, data[:n]...)
+		frags = append(frags, frag)
+		data = data[n:]
+	}
+	return
+}
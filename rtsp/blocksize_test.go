@@ -0,0 +1,53 @@
+package rtsp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFragmentRTP(t *testing.T) {
+	h264 := append([]byte{0x65}, bytes.Repeat([]byte{0x11, 0x22, 0x33}, 200)...)
+	h265 := append([]byte{0x26, 0x01}, bytes.Repeat([]byte{0x11, 0x22, 0x33}, 200)...)
+	for _, c := range []struct {
+		name      string
+		codec     string
+		nalu      []byte
+		blocksize int
+		frags     int
+	}{
+		// 12 bytes rtp header, 2 bytes FU-A indicator and header
+		{"h264 fits", "h264", h264, RTP_FIXED_HEADER_LENGTH + len(h264), 0},
+		{"h264 one over", "h264", h264, RTP_FIXED_HEADER_LENGTH + len(h264) - 1, 2},
+		{"h264 100", "h264", h264, 100, (len(h264) - 1 + 85) / 86},
+		// 3 bytes FU payload header and FU header
+		{"h265 fits", "h265", h265, RTP_FIXED_HEADER_LENGTH + len(h265), 0},
+		{"h265 256", "h265", h265, 256, (len(h265) - 2 + 240) / 241},
+		{"no room", "h264", h264, RTP_FIXED_HEADER_LENGTH + 2, 0},
+	} {
+		rtp := selfTestRTP(96, 7, 3000, true, c.nalu)
+		frags := fragmentRTP(c.codec, rtp, c.blocksize)
+		if len(frags) != c.frags {
+			t.Errorf("%s: %d fragments, want %d", c.name, len(frags), c.frags)
+			continue
+		}
+		if len(frags) == 0 {
+			continue
+		}
+		r := NewFUReassembler(c.codec)
+		var got [][]byte
+		for i, frag := range frags {
+			if len(frag) > c.blocksize {
+				t.Errorf("%s: fragment %d size[%d] exceeds blocksize[%d]", c.name, i, len(frag), c.blocksize)
+			}
+			info := ParseRTP(frag)
+			if info.Marker != (i == len(frags)-1) {
+				t.Errorf("%s: fragment %d marker %v", c.name, i, info.Marker)
+			}
+			info.SequenceNumber = i
+			got = append(got, r.Push(info)...)
+		}
+		if len(got) != 1 || !bytes.Equal(got[0], c.nalu) {
+			t.Errorf("%s: fragments do not reassemble to the nal unit", c.name)
+		}
+	}
+}
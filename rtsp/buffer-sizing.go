@@ -0,0 +1,84 @@
+package rtsp
+
+import (
+	"time"
+)
+
+// bufferSizer measures a channel during a warmup, to size its receive queue and gop cache to the
+// largest gop (in packets, audio included) it sees. An audio-only channel is sized to one second
+// of packets instead.
+type bufferSizer struct {
+	warmup   time.Duration
+	video    bool
+	start    time.Time
+	packets  int
+	total    int
+	bytes    int
+	keyframe bool
+	maxGop   int
+	done     bool
+}
+
+func newBufferSizer(warmup time.Duration, video bool) *bufferSizer {
+	return &bufferSizer{warmup: warmup, video: video}
+}
+
+// push counts pack, it returns the gop size in packets and the bitrate in bits per second once,
+// when the warmup is over; gop is 0 until then.
+func (s *bufferSizer) push(pack *RTPPack) (gop int, bitrate int) {
+	if s.done {
+		return
+	}
+	now := time.Now()
+	if s.start.IsZero() {
+		s.start = now
+	}
+	if pack.Keyframe {
+		if s.keyframe && s.packets > s.maxGop {
+			s.maxGop = s.packets
+		}
+		s.keyframe = true
+		s.packets = 0
+	}
+	s.packets++
+	s.total++
+	s.bytes += pack.Buffer.Len()
+	elapsed := now.Sub(s.start)
+	if elapsed < s.warmup {
+		return
+	}
+	if !s.video {
+		s.maxGop = int(float64(s.total) / elapsed.Seconds())
+	}
+	if s.maxGop == 0 {
+		// no complete gop yet, keep measuring.
+		return
+	}
+	s.done = true
+	return s.maxGop, int(float64(s.bytes*8) / elapsed.Seconds())
+}
+
+// sizeBuffers sets the receive queue limit to two gops and the gop cache capacity to a gop and a
+// quarter, within adaptive_buffers_min and adaptive_buffers_max of the channel.
+func (pusher *Pusher) sizeBuffers(gop int, bitrate int) {
+	min := ChannelKey(pusher.Path(), "adaptive_buffers_min").MustInt(256)
+	max := ChannelKey(pusher.Path(), "adaptive_buffers_max").MustInt(8192)
+	clamp := func(n int) int {
+		if n < min {
+			return min
+		}
+		if n > max {
+			return max
+		}
+		return n
+	}
+	limit := clamp(2 * gop)
+	pusher.cond.L.Lock()
+	pusher.queueStats.Limit = limit
+	pusher.cond.L.Unlock()
+	capacity := clamp(gop + gop/4)
+	pusher.gopCacheLock.Lock()
+	pusher.gopCacheCap = capacity
+	pusher.gopCacheLock.Unlock()
+	pusher.Logger().Printf("buffers sized to gop[%d packets] bitrate[%d kbps]: queue limit[%d] gop cache[%d]", gop, bitrate/1000, limit, capacity)
+}
@@ -0,0 +1,92 @@
+package rtsp
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBufferSizerSteadySource(t *testing.T) {
+	s := newBufferSizer(50*time.Millisecond, true)
+	// gops of 30 packets of 1000 bytes
+	var gop, bitrate int
+	for i := 0; gop == 0; i++ {
+		if i > 100000 {
+			t.Fatal("sizer never done")
+		}
+		gop, bitrate = s.push(&RTPPack{Type: RTP_TYPE_VIDEO, Keyframe: i%30 == 0, Buffer: bytes.NewBuffer(make([]byte, 1000))})
+		if i%30 == 29 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if gop != 30 || bitrate <= 0 {
+		t.Errorf("gop[%d] bitrate[%d], want a gop of 30 packets", gop, bitrate)
+	}
+	if gop, _ := s.push(&RTPPack{Type: RTP_TYPE_VIDEO, Keyframe: true, Buffer: bytes.NewBuffer(nil)}); gop != 0 {
+		t.Errorf("sized again after the warmup")
+	}
+
+	setTestConf(t, "adaptive_buffers_min", "10")
+	setTestConf(t, "adaptive_buffers_max", "50")
+	pusher := newTestPusher("h264")
+	pusher.cond = sync.NewCond(&sync.Mutex{})
+	for _, c := range []struct {
+		gop, limit, capacity int
+	}{
+		{30, 50, 37},
+		{4, 10, 10},
+		{100, 50, 50},
+	} {
+		pusher.sizeBuffers(c.gop, 1000000)
+		if pusher.queueStats.Limit != c.limit || pusher.gopCacheCap != c.capacity {
+			t.Errorf("gop[%d]: queue limit[%d] gop cache[%d], want %d %d", c.gop, pusher.queueStats.Limit, pusher.gopCacheCap, c.limit, c.capacity)
+		}
+	}
+}
+
+func TestGopCacheCap(t *testing.T) {
+	pusher := newTestPusher("h264")
+	pusher.gopStart = newGopStartDetector(300, 2*time.Second)
+	pusher.gopCacheCap = 5
+	packet := func(keyframe bool) *RTPPack {
+		return &RTPPack{Type: RTP_TYPE_VIDEO, Keyframe: keyframe, Buffer: bytes.NewBuffer(nil)}
+	}
+	gop := func(packets int) (keyframe *RTPPack) {
+		keyframe = packet(true)
+		pusher.cacheGop(keyframe, false)
+		for i := 1; i < packets; i++ {
+			pusher.cacheGop(packet(false), false)
+		}
+		return
+	}
+	for _, c := range []struct {
+		name    string
+		packets int
+		cached  int
+	}{
+		{"gop within the cap", 4, 4},
+		{"gop over the cap", 8, 0},
+		{"gop at the cap", 5, 5},
+		{"gop within the cap again", 3, 3},
+	} {
+		keyframe := gop(c.packets)
+		if len(pusher.gopCache) != c.cached {
+			t.Errorf("%s: %d packets cached, want %d", c.name, len(pusher.gopCache), c.cached)
+			continue
+		}
+		if c.cached > 0 && pusher.gopCache[0] != keyframe {
+			t.Errorf("%s: gop cache does not start at the keyframe", c.name)
+		}
+	}
+
+	// parameter sets after an oversized gop start the cache, with their keyframe.
+	gop(8)
+	ps := packet(false)
+	pusher.cacheGop(ps, true)
+	keyframe := packet(true)
+	pusher.cacheGop(keyframe, false)
+	if len(pusher.gopCache) != 2 || pusher.gopCache[0] != ps || pusher.gopCache[1] != keyframe {
+		t.Errorf("%d packets cached, want the parameter sets and the keyframe", len(pusher.gopCache))
+	}
+}
@@ -0,0 +1,15 @@
+package rtsp
+
+import (
+	"github.com/go-ini/ini"
+	"github.com/penggy/EasyGoLib/utils"
+)
+
+// ChannelKey looks up key in the [channel:<path>] section first, and falls back to the [rtsp] section,
+// so any rtsp option read through it can be overridden per channel.
+func ChannelKey(path string, key string) *ini.Key {
+	if sec, err := utils.Conf().GetSection("channel:" + path); err == nil && sec.HasKey(key) {
+		return sec.Key(key)
+	}
+	return utils.Conf().Section("rtsp").Key(key)
+}
@@ -0,0 +1,135 @@
+package rtsp
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+)
+
+// common clock rates, an estimate this close to one is snapped to it.
+var commonClockRates = []int{8000, 16000, 22050, 24000, 32000, 44100, 48000, 90000}
+
+// clockRateEstimator estimates the clock rate a source really stamps its rtp packets with, from the
+// rtp timestamp advance against the wallclock (ntp) advance between its RTCP sender reports.
+type clockRateEstimator struct {
+	declared int
+	firstNTP float64
+	firstRTP uint32
+	started  bool
+	rate     int
+	lock     sync.RWMutex
+}
+
+// minClockRateSpan is the wallclock span of sender reports an estimate needs.
+const minClockRateSpan = 5.0
+
+// pushSR feeds a rtcp packet, it returns the effective clock rate when its mismatch with the
+// declared one is found or is over.
+func (e *clockRateEstimator) pushSR(rtcp []byte) (rate int, changed bool) {
+	if len(rtcp) < 20 || rtcp[1] != 200 {
+		return
+	}
+	ntp := float64(binary.BigEndian.Uint32(rtcp[8:])) + float64(binary.BigEndian.Uint32(rtcp[12:]))/(1<<32)
+	return e.push(ntp, binary.BigEndian.Uint32(rtcp[16:]))
+}
+
+// push feeds a rtp timestamp with the wallclock it was sampled at, in seconds.
+func (e *clockRateEstimator) push(ntp float64, rtp uint32) (rate int, changed bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if !e.started || ntp < e.firstNTP {
+		e.firstNTP, e.firstRTP, e.started = ntp, rtp, true
+		return
+	}
+	span := ntp - e.firstNTP
+	if span < minClockRateSpan {
+		return
+	}
+	estimate := float64(rtp-e.firstRTP) / span
+	rate = int(math.Floor(estimate + 0.5))
+	for _, common := range commonClockRates {
+		if math.Abs(estimate-float64(common)) < float64(common)*0.01 {
+			rate = common
+		}
+	}
+	// a mismatch is more than 5% off the declared rate.
+	if e.declared > 0 && math.Abs(float64(rate-e.declared)) <= float64(e.declared)*0.05 {
+		rate = 0
+	}
+	changed = rate != e.rate
+	e.rate = rate
+	if rate == 0 {
+		rate = e.declared
+	}
+	return
+}
+
+// effective returns the estimated clock rate when it mismatches the declared one, else 0.
+func (e *clockRateEstimator) effective() int {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.rate
+}
+
+func (m *FrameRateMeter) setClockRate(clockRate int) {
+	m.lock.Lock()
+	m.clockRate = clockRate
+	m.lock.Unlock()
+}
+
+// ClockRate returns the clock rate to turn the rtp timestamps of a track (RTP_TYPE_VIDEO or
+// RTP_TYPE_AUDIO) into time: video_clock_rate/audio_clock_rate of the channel if set, else the rate
+// measured from the RTCP sender reports when clock_rate_check finds the sdp wrong, else the sdp one.
+func (pusher *Pusher) ClockRate(t RTPType) int {
+	key, media, estimator := "video_clock_rate", "video", pusher.vClockRate
+	if t == RTP_TYPE_AUDIO {
+		key, media, estimator = "audio_clock_rate", "audio", pusher.aClockRate
+	}
+	if rate := ChannelKey(pusher.Path(), key).MustInt(0); rate > 0 {
+		return rate
+	}
+	if estimator != nil {
+		if rate := estimator.effective(); rate > 0 {
+			return rate
+		}
+	}
+	if sdp, ok := pusher.SDPMap()[media]; ok {
+		return sdp.TimeScale
+	}
+	return 0
+}
+
+// checkClockRate feeds a rtcp packet of the track to its estimator, and logs a clock rate mismatch.
+func (pusher *Pusher) checkClockRate(pack *RTPPack) {
+	estimator := pusher.vClockRate
+	if pack.Type == RTP_TYPE_AUDIOCONTROL {
+		estimator = pusher.aClockRate
+	}
+	if estimator == nil {
+		return
+	}
+	rate, changed := estimator.pushSR(pack.Buffer.Bytes())
+	pusher.clockRateChanged(estimator, pack.Type == RTP_TYPE_VIDEOCONTROL, rate, changed)
+}
+
+// clockRateChanged logs an estimate of the clock rate of a track, and applies it to the frame rate.
+func (pusher *Pusher) clockRateChanged(estimator *clockRateEstimator, video bool, rate int, changed bool) {
+	if !changed {
+		return
+	}
+	media, source := "video", "the sender reports"
+	if !video {
+		media = "audio"
+	}
+	if pusher.srIgnore {
+		source = "the arrival times"
+	}
+	if rate != estimator.declared {
+		pusher.Logger().Printf("%s clock rate is %d by %s, the sdp declares %d", media, rate, source, estimator.declared)
+	} else {
+		pusher.Logger().Printf("%s clock rate agrees with the sdp again", media)
+	}
+	if media == "video" && pusher.frameRate != nil {
+		pusher.frameRate.setClockRate(pusher.ClockRate(RTP_TYPE_VIDEO))
+	}
+}
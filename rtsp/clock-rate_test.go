@@ -0,0 +1,87 @@
+package rtsp
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// testSR builds a rtcp sender report sampled at ntp seconds with rtp timestamp.
+func testSR(ntp float64, rtp uint32) []byte {
+	sr := make([]byte, 28)
+	sr[0], sr[1] = 0x80, 200
+	binary.BigEndian.PutUint16(sr[2:], 6)
+	secs := uint32(ntp)
+	binary.BigEndian.PutUint32(sr[8:], secs)
+	binary.BigEndian.PutUint32(sr[12:], uint32((ntp-float64(secs))*(1<<32)))
+	binary.BigEndian.PutUint32(sr[16:], rtp)
+	return sr
+}
+
+func TestClockRateEstimator(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		declared int
+		// the clock the source really stamps with
+		actual float64
+		want   int
+	}{
+		{"agrees", 90000, 90000, 0},
+		{"within 5%", 90000, 93000, 0},
+		{"audio doubled", 8000, 16000, 16000},
+		{"snapped to common", 44100, 47800, 48000},
+		{"uncommon", 90000, 1000, 1000},
+	} {
+		e := &clockRateEstimator{declared: c.declared}
+		start, base := 3800000000.25, uint32(0xFFFF0000)
+		var changes int
+		for i := 0; i <= 10; i++ {
+			elapsed := float64(i)
+			rate, changed := e.pushSR(testSR(start+elapsed, base+uint32(elapsed*c.actual)))
+			if changed {
+				changes++
+				if rate != c.want {
+					t.Errorf("%s: changed to %d, want %d", c.name, rate, c.want)
+				}
+			}
+			if elapsed < minClockRateSpan && changed {
+				t.Errorf("%s: estimate after %vs", c.name, elapsed)
+			}
+		}
+		wantChanges := 0
+		if c.want != 0 {
+			wantChanges = 1
+		}
+		if changes != wantChanges {
+			t.Errorf("%s: %d changes, want %d", c.name, changes, wantChanges)
+		}
+		if got := e.effective(); got != c.want {
+			t.Errorf("%s: effective %d, want %d", c.name, got, c.want)
+		}
+	}
+	// a receiver report is no sender report
+	e := &clockRateEstimator{declared: 8000}
+	rr := testSR(1, 0)
+	rr[1] = 201
+	if _, changed := e.pushSR(rr); changed || e.started {
+		t.Error("receiver report taken as a sender report")
+	}
+}
+
+func TestPusherClockRate(t *testing.T) {
+	pusher := newTestPusher("h264")
+	pusher.Session.SDPMap = map[string]*SDPInfo{"video": {TimeScale: 90000}, "audio": {TimeScale: 8000}}
+	if got := pusher.ClockRate(RTP_TYPE_AUDIO); got != 8000 {
+		t.Errorf("sdp audio clock rate %d", got)
+	}
+	pusher.aClockRate = &clockRateEstimator{declared: 8000, rate: 16000}
+	if got := pusher.ClockRate(RTP_TYPE_AUDIO); got != 16000 {
+		t.Errorf("estimated audio clock rate %d", got)
+	}
+	setTestConf(t, "audio_clock_rate", "11025")
+	if got := pusher.ClockRate(RTP_TYPE_AUDIO); got != 11025 {
+		t.Errorf("configured audio clock rate %d", got)
+	}
+	if got := pusher.ClockRate(RTP_TYPE_VIDEO); got != 90000 {
+		t.Errorf("video clock rate %d", got)
+	}
+}
@@ -0,0 +1,40 @@
+package rtsp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/penggy/EasyGoLib/utils"
+)
+
+const (
+	H264ProfileActionLog       = "log"
+	H264ProfileActionReject    = "reject"
+	H264ProfileActionTranscode = "transcode"
+)
+
+// CheckH264Profile validates the sps against h264_max_profile/h264_max_level of the channel, and
+// returns the h264_profile_action to take when the stream exceeds them. The transcode action
+// falls back to reject without ffmpeg_path to transcode with.
+func CheckH264Profile(path string, sps *H264SPS) (action string, err error) {
+	maxProfile := ChannelKey(path, "h264_max_profile").MustInt(0)
+	maxLevel := ChannelKey(path, "h264_max_level").MustInt(0)
+	if maxProfile > 0 && sps.ProfileIdc > maxProfile {
+		err = fmt.Errorf("h264 profile[%d] exceeds max profile[%d]", sps.ProfileIdc, maxProfile)
+	} else if maxLevel > 0 && sps.LevelIdc > maxLevel {
+		err = fmt.Errorf("h264 level[%d] exceeds max level[%d]", sps.LevelIdc, maxLevel)
+	}
+	if err == nil {
+		return
+	}
+	switch action = strings.ToLower(ChannelKey(path, "h264_profile_action").MustString(H264ProfileActionLog)); action {
+	case H264ProfileActionReject:
+	case H264ProfileActionTranscode:
+		if utils.Conf().Section("rtsp").Key("ffmpeg_path").MustString("") == "" {
+			action = H264ProfileActionReject
+		}
+	default:
+		action = H264ProfileActionLog
+	}
+	return
+}
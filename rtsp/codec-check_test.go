@@ -0,0 +1,74 @@
+package rtsp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckH264Profile(t *testing.T) {
+	high, err := ParseH264SPS(testSPS{profile: 100, level: 40, width: 1920, height: 1080}.h264())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		maxProfile, maxLevel string
+		action, ffmpeg       string
+		want                 string
+		exceeds              bool
+	}{
+		{"0", "0", "reject", "", "", false},
+		{"100", "40", "reject", "", "", false},
+		{"77", "0", "log", "", H264ProfileActionLog, true},
+		{"77", "0", "", "", H264ProfileActionLog, true},
+		{"77", "0", "Reject", "", H264ProfileActionReject, true},
+		{"0", "31", "reject", "", H264ProfileActionReject, true},
+		{"66", "31", "transcode", "ffmpeg", H264ProfileActionTranscode, true},
+		{"66", "31", "transcode", "", H264ProfileActionReject, true},
+	} {
+		setTestConf(t, "h264_max_profile", c.maxProfile)
+		setTestConf(t, "h264_max_level", c.maxLevel)
+		setTestConf(t, "h264_profile_action", c.action)
+		setTestConf(t, "ffmpeg_path", c.ffmpeg)
+		action, err := CheckH264Profile("/camera", high)
+		if (err != nil) != c.exceeds || action != c.want {
+			t.Errorf("max profile[%s] level[%s] action[%s] ffmpeg[%s]: action[%s] err[%v], want action[%s] exceeds[%v]",
+				c.maxProfile, c.maxLevel, c.action, c.ffmpeg, action, err, c.want, c.exceeds)
+		}
+	}
+}
+
+func TestH264CompatTranscode(t *testing.T) {
+	// stands in for ffmpeg whatever its arguments
+	ffmpeg := filepath.Join(t.TempDir(), "ffmpeg")
+	if err := os.WriteFile(ffmpeg, []byte("#!/bin/sh\nexec sleep 30\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	setTestConf(t, "ffmpeg_path", ffmpeg)
+	setTestConf(t, "h264_max_profile", "66")
+	setTestConf(t, "h264_max_level", "31")
+	server := newTestServer()
+	if _, err := server.H264CompatTranscode("/camera"); err != ErrTranscodeStarting {
+		t.Fatalf("compat transcode not pushed yet, err:%v", err)
+	}
+	server.transcodesLock.Lock()
+	cmd := server.transcodes["/camera"+compatSuffix]
+	server.transcodesLock.Unlock()
+	if cmd == nil {
+		t.Fatalf("no ffmpeg transcoding to /camera%s", compatSuffix)
+	}
+	args := strings.Join(cmd.Args[1:], " ")
+	for _, want := range []string{"-profile:v baseline", "-level 3.1", "rtsp://127.0.0.1:0/camera ", "rtsp://127.0.0.1:0/camera" + compatSuffix} {
+		if !strings.Contains(args, want) {
+			t.Errorf("ffmpeg args %q, want %q", args, want)
+		}
+	}
+	server.Stop()
+	for deadline := time.Now().Add(5 * time.Second); transcodeRunning(server, "/camera"+compatSuffix); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("ffmpeg compat transcode not killed on server stop")
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package rtsp
+
+import (
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// CodecParams is the decoder config of a video track. Fields unknown to the source are zero.
+type CodecParams struct {
+	Codec          string `json:"codec"`
+	Profile        int    `json:"profile"`
+	Level          int    `json:"level"`
+	Tier           int    `json:"tier"`
+	ChromaFormat   int    `json:"chromaFormat,omitempty"`
+	BitDepthLuma   int    `json:"bitDepthLuma,omitempty"`
+	BitDepthChroma int    `json:"bitDepthChroma,omitempty"`
+	Width          int    `json:"width,omitempty"`
+	Height         int    `json:"height,omitempty"`
+}
+
+// ChannelCodecParams holds the codec params parsed from the bitstream, and the ones declared
+// by the sdp fmtp when they differ from the bitstream.
+type ChannelCodecParams struct {
+	Bitstream *CodecParams `json:"bitstream,omitempty"`
+	SDP       *CodecParams `json:"sdp,omitempty"`
+}
+
+// CodecParams returns the video codec params of the channel at path, nil if there is no such
+// channel, codec_params_enable is off for it, or nothing could be parsed.
+func (server *Server) CodecParams(path string) *ChannelCodecParams {
+	pusher := server.GetPusher(path)
+	if pusher == nil || !ChannelKey(path, "codec_params_enable").MustBool(false) {
+		return nil
+	}
+	return pusher.CodecParams()
+}
+
+func (pusher *Pusher) CodecParams() *ChannelCodecParams {
+	codec := strings.ToLower(pusher.VCodec())
+	params := &ChannelCodecParams{}
+	ps := pusher.ParameterSets()
+	switch codec {
+	case "h264":
+		if sps, err := ParseH264SPS(ps.SPS); err == nil {
+			params.Bitstream = &CodecParams{
+				Codec:          codec,
+				Profile:        sps.ProfileIdc,
+				Level:          sps.LevelIdc,
+				ChromaFormat:   sps.ChromaFormatIdc,
+				BitDepthLuma:   sps.BitDepthLuma,
+				BitDepthChroma: sps.BitDepthChroma,
+				Width:          sps.Width,
+				Height:         sps.Height,
+			}
+		}
+	case "h265":
+		if sps, err := ParseH265SPS(ps.SPS); err == nil {
+			params.Bitstream = &CodecParams{
+				Codec:          codec,
+				Profile:        sps.ProfileIdc,
+				Level:          sps.LevelIdc,
+				Tier:           sps.TierFlag,
+				ChromaFormat:   sps.ChromaFormatIdc,
+				BitDepthLuma:   sps.BitDepthLuma,
+				BitDepthChroma: sps.BitDepthChroma,
+				Width:          sps.Width,
+				Height:         sps.Height,
+			}
+		}
+	}
+	if sdp, ok := pusher.SDPMap()["video"]; ok {
+		declared := sdpCodecParams(codec, sdp.Fmtp)
+		if declared != nil && (params.Bitstream == nil || declared.Profile != params.Bitstream.Profile ||
+			declared.Level != params.Bitstream.Level || declared.Tier != params.Bitstream.Tier) {
+			params.SDP = declared
+		}
+	}
+	if params.Bitstream == nil && params.SDP == nil {
+		return nil
+	}
+	return params
+}
+
+// sdpCodecParams reads profile-level-id of h264 (RFC 6184), profile-id/level-id/tier-flag of h265 (RFC 7798).
+func sdpCodecParams(codec string, fmtp map[string]string) *CodecParams {
+	switch codec {
+	case "h264":
+		id, err := hex.DecodeString(fmtp["profile-level-id"])
+		if err != nil || len(id) != 3 {
+			return nil
+		}
+		return &CodecParams{Codec: codec, Profile: int(id[0]), Level: int(id[2])}
+	case "h265":
+		if fmtp["profile-id"] == "" && fmtp["level-id"] == "" {
+			return nil
+		}
+		params := &CodecParams{Codec: codec}
+		params.Profile, _ = strconv.Atoi(fmtp["profile-id"])
+		params.Level, _ = strconv.Atoi(fmtp["level-id"])
+		params.Tier, _ = strconv.Atoi(fmtp["tier-flag"])
+		return params
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package rtsp
+
+import (
+	"testing"
+)
+
+var (
+	// high 4:2:2 profile, level 4.0, 10 bits, 1920x1080
+	testH264SPS422 = []byte{0x67, 0x7A, 0x00, 0x28, 0xB6, 0xCB, 0x40, 0x3C, 0x01, 0x13, 0xF1, 0x28}
+	// main profile, high tier, level 5.1, 3840x2160, with its emulation prevention bytes
+	testH265SPSHighTier = []byte{0x42, 0x01, 0x01, 0x21, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00, 0x03, 0x00, 0x00, 0x03, 0x00, 0x99,
+		0xA0, 0x01, 0xE0, 0x20, 0x02, 0x1C, 0x5C}
+)
+
+func TestPusherCodecParams(t *testing.T) {
+	h264 := &CodecParams{Codec: "h264", Profile: 122, Level: 40, ChromaFormat: 2, BitDepthLuma: 10, BitDepthChroma: 10, Width: 1920, Height: 1080}
+	h265 := &CodecParams{Codec: "h265", Profile: 1, Level: 153, Tier: 1, ChromaFormat: 1, BitDepthLuma: 8, BitDepthChroma: 8, Width: 3840, Height: 2160}
+	for _, c := range []struct {
+		name  string
+		codec string
+		sps   []byte
+		fmtp  string
+		want  *ChannelCodecParams
+	}{
+		{"h264 sdp agrees", "H264", testH264SPS422, "packetization-mode=1;profile-level-id=7A0028", &ChannelCodecParams{Bitstream: h264}},
+		{"h264 no fmtp", "H264", testH264SPS422, "", &ChannelCodecParams{Bitstream: h264}},
+		{"h264 sdp profile differs", "H264", testH264SPS422, "profile-level-id=640028",
+			&ChannelCodecParams{Bitstream: h264, SDP: &CodecParams{Codec: "h264", Profile: 100, Level: 40}}},
+		{"h264 sdp level differs", "H264", testH264SPS422, "profile-level-id=7A001F",
+			&ChannelCodecParams{Bitstream: h264, SDP: &CodecParams{Codec: "h264", Profile: 122, Level: 31}}},
+		{"h264 sdp only", "H264", nil, "profile-level-id=42E01F",
+			&ChannelCodecParams{SDP: &CodecParams{Codec: "h264", Profile: 66, Level: 31}}},
+		{"h265 sdp agrees", "H265", testH265SPSHighTier, "profile-id=1;level-id=153;tier-flag=1", &ChannelCodecParams{Bitstream: h265}},
+		{"h265 sdp tier differs", "H265", testH265SPSHighTier, "profile-id=1;level-id=153",
+			&ChannelCodecParams{Bitstream: h265, SDP: &CodecParams{Codec: "h265", Profile: 1, Level: 153}}},
+		{"h265 sdp profile differs", "H265", testH265SPSHighTier, "profile-id=2;level-id=153;tier-flag=1",
+			&ChannelCodecParams{Bitstream: h265, SDP: &CodecParams{Codec: "h265", Profile: 2, Level: 153, Tier: 1}}},
+		{"h265 sdp level differs", "H265", testH265SPSHighTier, "profile-id=1;level-id=150;tier-flag=1",
+			&ChannelCodecParams{Bitstream: h265, SDP: &CodecParams{Codec: "h265", Profile: 1, Level: 150, Tier: 1}}},
+		{"nothing known", "H264", nil, "packetization-mode=1", nil},
+	} {
+		pusher := newTestPusher(c.codec)
+		pusher.Session.SDPRaw = "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=test\r\nt=0 0\r\n" +
+			"m=video 0 RTP/AVP 96\r\na=rtpmap:96 " + c.codec + "/90000\r\n"
+		if c.fmtp != "" {
+			pusher.Session.SDPRaw += "a=fmtp:96 " + c.fmtp + "\r\n"
+		}
+		if c.sps != nil {
+			pusher.paramSets.update(pusher.VCodec(), c.sps)
+			if c.codec == "H264" {
+				pusher.paramSets.update("h264", []byte{0x68, 0xCE, 0x3C, 0x80})
+			}
+		}
+		got := pusher.CodecParams()
+		if (got == nil) != (c.want == nil) {
+			t.Errorf("%s: codec params %+v, want %+v", c.name, got, c.want)
+			continue
+		}
+		if got == nil {
+			continue
+		}
+		for _, p := range []struct {
+			field     string
+			got, want *CodecParams
+		}{{"bitstream", got.Bitstream, c.want.Bitstream}, {"sdp", got.SDP, c.want.SDP}} {
+			if (p.got == nil) != (p.want == nil) || (p.got != nil && *p.got != *p.want) {
+				t.Errorf("%s: %s %+v, want %+v", c.name, p.field, p.got, p.want)
+			}
+		}
+	}
+}
+
+func TestSDPCodecParams(t *testing.T) {
+	for _, c := range []struct {
+		codec string
+		fmtp  map[string]string
+		want  *CodecParams
+	}{
+		{"h264", map[string]string{"profile-level-id": "4d401f"}, &CodecParams{Codec: "h264", Profile: 77, Level: 31}},
+		{"h264", map[string]string{"profile-level-id": "4d40"}, nil},
+		{"h264", map[string]string{"profile-level-id": "zz401f"}, nil},
+		{"h264", map[string]string{"packetization-mode": "1"}, nil},
+		{"h265", map[string]string{"level-id": "93"}, &CodecParams{Codec: "h265", Level: 93}},
+		{"h265", map[string]string{"tier-flag": "1"}, nil},
+		{"mpeg4", map[string]string{"profile-level-id": "1"}, nil},
+	} {
+		got := sdpCodecParams(c.codec, c.fmtp)
+		if (got == nil) != (c.want == nil) || (got != nil && *got != *c.want) {
+			t.Errorf("%s %v: %+v, want %+v", c.codec, c.fmtp, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package rtsp
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// DescribeBaseURL returns the base url to resolve track controls of a DESCRIBE response against:
+// Content-Base, then Content-Location, then the request url (RFC 2326 C.1.1). Some NVRs answer
+// with a Content-Base different from the request url.
+func DescribeBaseURL(resp *Response, requestURL string) string {
+	for _, name := range []string{"Content-Base", "Content-Location"} {
+		if base := responseHeader(resp, name); base != "" {
+			// a relative header value is resolved against the request url.
+			if u, err := url.Parse(base); err == nil && !u.IsAbs() {
+				if r, err := url.Parse(requestURL); err == nil {
+					return r.ResolveReference(u).String()
+				}
+			}
+			return base
+		}
+	}
+	return requestURL
+}
+
+// responseHeader looks up a single value header case insensitively, as some devices spell
+// headers like "Content-base".
+func responseHeader(resp *Response, name string) string {
+	for key, val := range resp.Header {
+		if v, ok := val.(string); ok && strings.EqualFold(key, name) {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// ResolveControlURL resolves the a=control of a track against base, the Content-Base (or the
+// request url) of the DESCRIBE response, as RFC 2326 C.1.1 does. Control may be absolute
+// ("rtsp://host/path/trackID=1"), relative ("trackID=1", "?ctype=video") or the wildcard "*",
+// which means the base url itself.
+func ResolveControlURL(base string, control string) string {
+	control = strings.TrimSpace(control)
+	if control == "" || control == "*" {
+		return base
+	}
+	lower := strings.ToLower(control)
+	if strings.HasPrefix(lower, "rtsp://") || strings.HasPrefix(lower, "rtsps://") || strings.HasPrefix(lower, "rtspu://") {
+		return control
+	}
+	if strings.HasPrefix(control, "?") {
+		return strings.TrimRight(base, "/") + control
+	}
+	return strings.TrimRight(base, "/") + "/" + strings.TrimLeft(control, "/")
+}
+
+// setupControlURL normalizes an absolute control url with the default rtsp port, so the url of a
+// SETUP request compares with it. A relative control is returned as is.
+func setupControlURL(control string) (string, error) {
+	if strings.Index(strings.ToLower(control), "rtsp://") != 0 {
+		return control, nil
+	}
+	u, err := url.Parse(control)
+	if err != nil {
+		return "", err
+	}
+	if u.Port() == "" {
+		u.Host = fmt.Sprintf("%s:554", u.Host)
+	}
+	return u.String(), nil
+}
+
+// matchControl tells whether the url of a SETUP request targets the track of control, which is
+// either an absolute url or a path relative to the presentation.
+func matchControl(setupPath string, control string) bool {
+	if control == "" || control == "*" {
+		return false
+	}
+	return setupPath == control || strings.HasSuffix(setupPath, control)
+}
+
+// setupTrack tells which track a SETUP request is for. A track with a wildcard or empty control
+// takes the SETUP request that matches no other track.
+func (session *Session) setupTrack(setupPath string, aPath string, vPath string) (audio bool, video bool) {
+	audio = matchControl(setupPath, aPath)
+	video = !audio && matchControl(setupPath, vPath)
+	if audio || video {
+		return
+	}
+	if session.VCodec != "" && (vPath == "" || vPath == "*") {
+		video = true
+	} else if session.ACodec != "" && (aPath == "" || aPath == "*") {
+		audio = true
+	}
+	return
+}
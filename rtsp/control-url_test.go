@@ -0,0 +1,63 @@
+package rtsp
+
+import (
+	"testing"
+)
+
+func TestResolveControlURL(t *testing.T) {
+	for _, c := range []struct {
+		base, control, want string
+	}{
+		{"rtsp://cam/live/", "trackID=1", "rtsp://cam/live/trackID=1"},
+		{"rtsp://cam/live", "trackID=1", "rtsp://cam/live/trackID=1"},
+		{"rtsp://cam/live/", "/trackID=1", "rtsp://cam/live/trackID=1"},
+		{"rtsp://cam/live/", "?ctype=video", "rtsp://cam/live?ctype=video"},
+		{"rtsp://cam/live/", "*", "rtsp://cam/live/"},
+		{"rtsp://cam/live/", "", "rtsp://cam/live/"},
+		{"rtsp://cam/live/", "rtsp://cam:8554/other/track1", "rtsp://cam:8554/other/track1"},
+		{"rtsp://cam/live/", "RTSP://cam/other/track1", "RTSP://cam/other/track1"},
+	} {
+		if got := ResolveControlURL(c.base, c.control); got != c.want {
+			t.Errorf("base[%s] control[%s]: %s, want %s", c.base, c.control, got, c.want)
+		}
+	}
+}
+
+func TestDescribeBaseURL(t *testing.T) {
+	request := "rtsp://cam/live"
+	for _, c := range []struct {
+		name   string
+		header map[string]interface{}
+		want   string
+	}{
+		{"content-base", map[string]interface{}{"Content-Base": "rtsp://nvr/ch1/", "Content-Location": "rtsp://x/"}, "rtsp://nvr/ch1/"},
+		{"content-location", map[string]interface{}{"Content-Location": "rtsp://nvr/ch2/"}, "rtsp://nvr/ch2/"},
+		{"lower case header", map[string]interface{}{"Content-base": "rtsp://nvr/ch3/"}, "rtsp://nvr/ch3/"},
+		{"relative content-base", map[string]interface{}{"Content-Base": "/ch4/"}, "rtsp://cam/ch4/"},
+		{"request url", map[string]interface{}{}, request},
+	} {
+		if got := DescribeBaseURL(&Response{Header: c.header}, request); got != c.want {
+			t.Errorf("%s: %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestSetupTrack(t *testing.T) {
+	for _, c := range []struct {
+		name                string
+		setup, aPath, vPath string
+		acodec              string
+		audio, video        bool
+	}{
+		{"relative", "rtsp://srv:554/live/trackID=2", "trackID=2", "trackID=1", "aac", true, false},
+		{"absolute", "rtsp://srv:554/live/trackID=1", "rtsp://srv:554/live/trackID=2", "rtsp://srv:554/live/trackID=1", "aac", false, true},
+		{"video aggregate", "rtsp://srv:554/live", "trackID=2", "*", "aac", false, true},
+		{"audio aggregate", "rtsp://srv:554/live", "", "trackID=1", "aac", true, false},
+		{"no track", "rtsp://srv:554/live/trackID=9", "trackID=2", "trackID=1", "aac", false, false},
+	} {
+		session := &Session{VCodec: "h264", ACodec: c.acodec}
+		if audio, video := session.setupTrack(c.setup, c.aPath, c.vPath); audio != c.audio || video != c.video {
+			t.Errorf("%s: audio %v video %v, want %v %v", c.name, audio, video, c.audio, c.video)
+		}
+	}
+}
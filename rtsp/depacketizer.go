@@ -0,0 +1,258 @@
+package rtsp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Depacketizer turns the rtp packets of one track back into access units.
+// complete is true when accessUnit holds a whole access unit.
+type Depacketizer interface {
+	Depacketize(info *RTPInfo) (accessUnit []byte, complete bool, err error)
+}
+
+// DepacketizerFactory creates a depacketizer for a track, sdp may be nil when the track has no sdp info.
+type DepacketizerFactory func(sdp *SDPInfo) Depacketizer
+
+var (
+	depacketizers     = make(map[string]DepacketizerFactory)
+	depacketizersLock sync.RWMutex
+)
+
+// RegisterDepacketizer registers a depacketizer factory by codec name (as in SDPInfo.Codec, case
+// insensitive) or by payload type (decimal string, e.g. "96") for codecs unknown to ParseSDP.
+// A registered factory replaces the previous one with the same key, built-in ones included.
+func RegisterDepacketizer(key string, factory DepacketizerFactory) {
+	depacketizersLock.Lock()
+	depacketizers[strings.ToLower(key)] = factory
+	depacketizersLock.Unlock()
+}
+
+// NewDepacketizer creates a depacketizer for codec, falling back to payloadType, nil if none registered.
+func NewDepacketizer(codec string, payloadType int, sdp *SDPInfo) Depacketizer {
+	depacketizersLock.RLock()
+	factory, ok := depacketizers[strings.ToLower(codec)]
+	if !ok {
+		factory, ok = depacketizers[strconv.Itoa(payloadType)]
+	}
+	depacketizersLock.RUnlock()
+	if !ok {
+		return nil
+	}
+	return factory(sdp)
+}
+
+func init() {
+	RegisterDepacketizer("h264", func(sdp *SDPInfo) Depacketizer {
+		return NewNALUDepacketizer("h264")
+	})
+	RegisterDepacketizer("h265", func(sdp *SDPInfo) Depacketizer {
+		return NewNALUDepacketizer("h265")
+	})
+	RegisterDepacketizer("aac", func(sdp *SDPInfo) Depacketizer {
+		return NewAACDepacketizer(sdp)
+	})
+}
+
+// NALUDepacketizer outputs H264/H265 access units in Annex-B format, an access unit
+// completes on the marker bit, or when the timestamp changes.
+// Once the stream carries access unit delimiters, alone or aggregated, an access unit completes
+// at the delimiter starting the next one, even of the same timestamp, and the marker is ignored;
+// a timestamp change still completes it when the delimiter is lost.
+// Some encoders set the marker on every packet, the marker is ignored while most of the marked
+// packets of a window are followed by a packet of the same timestamp.
+type NALUDepacketizer struct {
+	*FUReassembler
+	codec     string
+	au        []byte
+	timestamp int
+
+	lastMarker   bool
+	packets      int
+	markers      int
+	falseMarkers int
+	ignoreMarker bool
+	audDelimited bool
+}
+
+const markerCheckWindow = 100
+
+func NewNALUDepacketizer(codec string) *NALUDepacketizer {
+	return &NALUDepacketizer{
+		FUReassembler: NewFUReassembler(codec),
+		codec:         codec,
+		timestamp:     -1,
+	}
+}
+
+var annexBStartCode = []byte{0, 0, 0, 1}
+
+func (d *NALUDepacketizer) Depacketize(info *RTPInfo) (accessUnit []byte, complete bool, err error) {
+	if info.Keepalive {
+		// in no access unit, only its sequence number counts for the fragmentation units.
+		d.Push(info)
+		return
+	}
+	if len(d.au) > 0 && info.Timestamp != d.timestamp {
+		// the marker of the previous access unit is lost, this packet starts a new one.
+		accessUnit, complete = d.au, true
+		d.au = nil
+	}
+	d.checkMarker(info)
+	d.timestamp = info.Timestamp
+	for _, nalu := range d.Push(info) {
+		if NALUType(d.codec, nalu) == NALU_TYPE_AUD {
+			d.audDelimited = true
+			if len(d.au) > 0 && !complete {
+				accessUnit, complete = d.au, true
+				d.au = nil
+			}
+		}
+		d.au = append(d.au, annexBStartCode...)
+		d.au = append(d.au, nalu...)
+	}
+	if info.Marker && !d.ignoreMarker && !d.audDelimited && len(d.au) > 0 && !complete {
+		accessUnit, complete = d.au, true
+		d.au = nil
+	}
+	return
+}
+
+func (d *NALUDepacketizer) checkMarker(info *RTPInfo) {
+	if d.lastMarker && info.Timestamp == d.timestamp {
+		d.falseMarkers++
+	}
+	d.lastMarker = info.Marker
+	if info.Marker {
+		d.markers++
+	}
+	d.packets++
+	if d.packets < markerCheckWindow {
+		return
+	}
+	d.ignoreMarker = d.falseMarkers > 0 && d.falseMarkers*2 >= d.markers
+	d.packets, d.markers, d.falseMarkers = 0, 0, 0
+}
+
+// IgnoreMarker tells whether access units are told apart by the timestamp only, for an encoder
+// setting the marker on every packet.
+func (d *NALUDepacketizer) IgnoreMarker() bool {
+	return d.ignoreMarker
+}
+
+// AACDepacketizer parses RFC 3640 (MPEG4-GENERIC, AAC-hbr) payloads, and outputs the
+// access units of a packet as ADTS frames.
+type AACDepacketizer struct {
+	sizeLength  int
+	indexLength int
+	objectType  int
+	freqIndex   int
+	channels    int
+}
+
+func NewAACDepacketizer(sdp *SDPInfo) *AACDepacketizer {
+	d := &AACDepacketizer{
+		sizeLength:  13,
+		indexLength: 3,
+		objectType:  2,
+		freqIndex:   4,
+		channels:    2,
+	}
+	if sdp != nil {
+		if sdp.SizeLength > 0 {
+			d.sizeLength = sdp.SizeLength
+			d.indexLength = sdp.IndexLength
+		}
+		// AudioSpecificConfig: 5 bits object type, 4 bits frequency index, 4 bits channel configuration.
+		if len(sdp.Config) >= 2 {
+			d.objectType = int(sdp.Config[0] >> 3)
+			d.freqIndex = int(sdp.Config[0]&0x07)<<1 | int(sdp.Config[1]>>7)
+			d.channels = int(sdp.Config[1]>>3) & 0x0F
+		}
+	}
+	return d
+}
+
+func (d *AACDepacketizer) Depacketize(info *RTPInfo) (accessUnit []byte, complete bool, err error) {
+	if info.Keepalive {
+		return
+	}
+	payload := info.Payload
+	if len(payload) < 2 {
+		err = fmt.Errorf("aac payload too short, size[%d]", len(payload))
+		return
+	}
+	headersBits := int(payload[0])<<8 | int(payload[1])
+	headersLen := (headersBits + 7) / 8
+	if 2+headersLen > len(payload) {
+		err = fmt.Errorf("aac au headers length[%d] exceeds payload size[%d]", headersLen, len(payload))
+		return
+	}
+	r := &bitReader{buf: payload[2 : 2+headersLen]}
+	data := payload[2+headersLen:]
+	for r.pos+d.sizeLength+d.indexLength <= headersBits {
+		size := int(r.readBits(d.sizeLength))
+		r.readBits(d.indexLength)
+		if size > len(data) {
+			err = fmt.Errorf("aac au size[%d] exceeds payload left[%d]", size, len(data))
+			return
+		}
+		accessUnit = append(accessUnit, d.adtsHeader(size)...)
+		accessUnit = append(accessUnit, data[:size]...)
+		data = data[size:]
+	}
+	complete = len(accessUnit) > 0
+	return
+}
+
+func (d *AACDepacketizer) adtsHeader(size int) []byte {
+	frameLen := size + 7
+	profile := d.objectType - 1
+	if profile < 0 {
+		profile = 1
+	}
+	return []byte{
+		0xFF,
+		0xF1,
+		byte(profile<<6 | d.freqIndex<<2 | d.channels>>2),
+		byte((d.channels&0x03)<<6 | frameLen>>11),
+		byte(frameLen >> 3),
+		byte((frameLen&0x07)<<5 | 0x1F),
+		0xFC,
+	}
+}
+
+// AccessUnitHandle receives the access units of a pusher, timestamp is the rtp timestamp of the access unit,
+// in units of Pusher.ClockRate.
+type AccessUnitHandle func(t RTPType, timestamp int, accessUnit []byte)
+
+// trackDepacketizer remembers the timestamp of the pending access unit, as a depacketizer may
+// complete an access unit with the first packet of the next one.
+type trackDepacketizer struct {
+	Depacketizer
+	pending   bool
+	timestamp int
+	// the last IgnoreMarker of a NALUDepacketizer, to log its changes
+	ignoreMarker bool
+}
+
+func (t *trackDepacketizer) push(info *RTPInfo) (accessUnit []byte, timestamp int, complete bool, err error) {
+	if info.Keepalive {
+		_, _, err = t.Depacketize(info)
+		return
+	}
+	if !t.pending {
+		t.pending = true
+		t.timestamp = info.Timestamp
+	}
+	accessUnit, complete, err = t.Depacketize(info)
+	if !complete {
+		return
+	}
+	timestamp = t.timestamp
+	t.pending = info.Timestamp != timestamp
+	t.timestamp = info.Timestamp
+	return
+}
@@ -0,0 +1,106 @@
+package rtsp
+
+import (
+	"bytes"
+	"testing"
+)
+
+// annexBNALUs makes the Annex-B access unit of the NAL units.
+func annexBNALUs(nalus ...[]byte) (au []byte) {
+	for _, nalu := range nalus {
+		au = append(append(au, annexBStartCode...), nalu...)
+	}
+	return
+}
+
+func TestNALUDepacketizerAUD(t *testing.T) {
+	aud := []byte{0x09, 0xF0}
+	idr := []byte{0x65, 0x88, 0x84, 0x00}
+	slice := []byte{0x41, 0x9A, 0x02}
+	annexB := annexBNALUs
+	type packet struct {
+		timestamp uint32
+		marker    bool
+		payload   []byte
+	}
+	for _, c := range []struct {
+		name    string
+		packets []packet
+		want    [][]byte
+	}{
+		{"single aud, marker unset, same timestamp", []packet{
+			{3000, false, aud}, {3000, false, idr},
+			{3000, false, aud}, {3000, false, slice},
+			{3000, false, aud},
+		}, [][]byte{annexB(aud, idr), annexB(aud, slice)}},
+		{"stap-a aud, marker unset", []packet{
+			{3000, false, stapA(aud, idr)},
+			{3000, false, stapA(aud, slice)},
+			{6000, false, stapA(aud, slice)},
+		}, [][]byte{annexB(aud, idr), annexB(aud, slice)}},
+		{"false marker mid access unit", []packet{
+			{3000, true, aud}, {3000, true, idr}, {3000, true, slice},
+			{6000, true, aud}, {6000, true, slice},
+			{9000, true, aud},
+		}, [][]byte{annexB(aud, idr, slice), annexB(aud, slice)}},
+		{"lost aud, timestamp change", []packet{
+			{3000, false, aud}, {3000, false, idr},
+			{6000, false, slice},
+			{9000, false, aud},
+		}, [][]byte{annexB(aud, idr), annexB(slice)}},
+		{"no aud, marker", []packet{
+			{3000, false, idr}, {3000, true, slice},
+			{6000, true, slice},
+		}, [][]byte{annexB(idr, slice), annexB(slice)}},
+	} {
+		d := NewNALUDepacketizer("h264")
+		var got [][]byte
+		for i, p := range c.packets {
+			au, complete, err := d.Depacketize(ParseRTP(selfTestRTP(96, uint16(i), p.timestamp, p.marker, p.payload)))
+			if err != nil {
+				t.Fatalf("%s: packet %d: %v", c.name, i, err)
+			}
+			if complete {
+				got = append(got, au)
+			}
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("%s: %d access units, want %d", c.name, len(got), len(c.want))
+			continue
+		}
+		for i := range got {
+			if !bytes.Equal(got[i], c.want[i]) {
+				t.Errorf("%s: access unit %d % x, want % x", c.name, i, got[i], c.want[i])
+			}
+		}
+	}
+}
+
+func TestNALUDepacketizerMarkerOnEveryPacket(t *testing.T) {
+	slices := [][]byte{{0x41, 0x9A, 0x01}, {0x41, 0x9A, 0x02}, {0x41, 0x9A, 0x03}}
+	d := NewNALUDepacketizer("h264")
+	var aus [][]byte
+	frames := 2 * markerCheckWindow / len(slices)
+	for frame := 0; frame < frames; frame++ {
+		// a frame of three slices, each packet marked
+		for i, slice := range slices {
+			au, complete, err := d.Depacketize(ParseRTP(selfTestRTP(96, uint16(frame*len(slices)+i), uint32(3000*frame), true, slice)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if complete {
+				aus = append(aus, au)
+			}
+		}
+	}
+	if !d.IgnoreMarker() {
+		t.Fatalf("marker still trusted after %d packets all marked", frames*len(slices))
+	}
+	// frames assemble on the timestamp change once the marker is ignored
+	want := annexBNALUs(slices...)
+	for i, au := range aus[len(aus)-5:] {
+		if !bytes.Equal(au, want) {
+			t.Errorf("access unit %d from the end % x, want % x", 5-i, au, want)
+		}
+	}
+}
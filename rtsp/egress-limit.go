@@ -0,0 +1,201 @@
+package rtsp
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// EgressAllocation is the egress bandwidth of a channel under egress_bandwidth_limit, in bits per second.
+type EgressAllocation struct {
+	// Demand is what the players of the channel would get without the limit.
+	Demand    int `json:"demand"`
+	Allocated int `json:"allocated"`
+	Used      int `json:"used"`
+}
+
+// egressScheduler shares the egress_bandwidth_limit of the server among the channels with players,
+// max-min fair: a channel asking for less than an equal share gets what it asks for, and the rest
+// is split equally among the others. The allocation of a channel is split equally among its players,
+// and is recomputed every second from the measured demands.
+type egressScheduler struct {
+	limit        int
+	channels     map[string]*channelEgress // path <-> egress of its players
+	rebalancedAt time.Time
+	lock         sync.Mutex
+}
+
+type channelEgress struct {
+	demand    *RateMeter
+	used      *RateMeter
+	players   int
+	allocated int
+}
+
+// newEgressScheduler returns nil for no limit, limit is in kbit/s.
+func newEgressScheduler(limit int) *egressScheduler {
+	if limit <= 0 {
+		return nil
+	}
+	return &egressScheduler{
+		limit:    limit * 1000,
+		channels: make(map[string]*channelEgress),
+	}
+}
+
+func (s *egressScheduler) join(path string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ch, ok := s.channels[path]
+	if !ok {
+		ch = &channelEgress{demand: NewRateMeter(3), used: NewRateMeter(3)}
+		s.channels[path] = ch
+		// a new channel gets its share right away rather than at the next rebalance.
+		s.rebalancedAt = time.Time{}
+	}
+	ch.players++
+}
+
+func (s *egressScheduler) leave(path string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if ch, ok := s.channels[path]; ok {
+		if ch.players--; ch.players <= 0 {
+			delete(s.channels, path)
+		}
+	}
+}
+
+// offer accounts a packet of size bytes meant for a player of path, sent or not.
+func (s *egressScheduler) offer(path string, size int, sent bool) {
+	s.lock.Lock()
+	ch := s.channels[path]
+	s.lock.Unlock()
+	if ch == nil {
+		return
+	}
+	ch.demand.Add(size)
+	if sent {
+		ch.used.Add(size)
+	}
+}
+
+// playerRate returns the bits per second a player of path may send.
+func (s *egressScheduler) playerRate(path string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if time.Since(s.rebalancedAt) >= time.Second {
+		s.rebalance()
+	}
+	ch, ok := s.channels[path]
+	if !ok || ch.players == 0 {
+		return 0
+	}
+	return ch.allocated / ch.players
+}
+
+// rebalance recomputes the allocations, caller holds the lock.
+func (s *egressScheduler) rebalance() {
+	demands := make(map[string]int, len(s.channels))
+	for path, ch := range s.channels {
+		demands[path] = ch.demand.BitRate()
+	}
+	for path, allocated := range fairShare(s.limit, demands) {
+		s.channels[path].allocated = allocated
+	}
+	s.rebalancedAt = time.Now()
+}
+
+// fairShare splits limit among demands max-min fair, what no one asks for is split equally
+// so that demands growing, or not measured yet, have room.
+func fairShare(limit int, demands map[string]int) map[string]int {
+	paths := make([]string, 0, len(demands))
+	for path := range demands {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool { return demands[paths[i]] < demands[paths[j]] })
+	shares := make(map[string]int, len(paths))
+	remaining := limit
+	for i, path := range paths {
+		share := remaining / (len(paths) - i)
+		if demands[path] < share {
+			share = demands[path]
+		}
+		shares[path] = share
+		remaining -= share
+	}
+	for _, path := range paths {
+		shares[path] += remaining / len(paths)
+	}
+	return shares
+}
+
+func (s *egressScheduler) allocation(path string) *EgressAllocation {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ch, ok := s.channels[path]
+	if !ok {
+		return nil
+	}
+	return &EgressAllocation{
+		Demand:    ch.demand.BitRate(),
+		Allocated: ch.allocated,
+		Used:      ch.used.BitRate(),
+	}
+}
+
+// egressShaper holds a player to its share of the egress limit with a token bucket. A video packet
+// over budget is dropped with the rest of its gop, the player resumes at the next keyframe, so
+// that players degrade to skipping gops rather than to broken pictures; audio and rtcp always pass.
+type egressShaper struct {
+	scheduler    *egressScheduler
+	path         string
+	tokens       float64
+	refilledAt   time.Time
+	waitKeyframe bool
+}
+
+// newEgressShaper starts the bucket full, the first packet a player gets is the gop cache keyframe.
+func newEgressShaper(scheduler *egressScheduler, path string) *egressShaper {
+	return &egressShaper{
+		scheduler:  scheduler,
+		path:       path,
+		tokens:     float64(scheduler.playerRate(path)) / 8,
+		refilledAt: time.Now(),
+	}
+}
+
+func (shaper *egressShaper) allow(pack *RTPPack) bool {
+	size := pack.Buffer.Len()
+	if pack.Type != RTP_TYPE_VIDEO {
+		shaper.scheduler.offer(shaper.path, size, true)
+		return true
+	}
+	now := time.Now()
+	rate := float64(shaper.scheduler.playerRate(shaper.path)) / 8
+	shaper.tokens += rate * now.Sub(shaper.refilledAt).Seconds()
+	// bursts up to a second, enough for a keyframe at most rates.
+	if shaper.tokens > rate {
+		shaper.tokens = rate
+	}
+	shaper.refilledAt = now
+	if shaper.waitKeyframe && pack.Keyframe {
+		shaper.waitKeyframe = false
+	}
+	if shaper.waitKeyframe || shaper.tokens < float64(size) {
+		shaper.waitKeyframe = true
+		shaper.scheduler.offer(shaper.path, size, false)
+		return false
+	}
+	shaper.tokens -= float64(size)
+	shaper.scheduler.offer(shaper.path, size, true)
+	return true
+}
+
+// EgressAllocation returns nil unless egress_bandwidth_limit is set and the channel has players.
+func (server *Server) EgressAllocation(path string) *EgressAllocation {
+	if server.egress == nil {
+		return nil
+	}
+	return server.egress.allocation(path)
+}
@@ -0,0 +1,45 @@
+package rtsp
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestFairShare(t *testing.T) {
+	for _, c := range []struct {
+		limit   int
+		demands map[string]int
+		want    map[string]int
+	}{
+		{900, map[string]int{"a": 0}, map[string]int{"a": 900}},
+		{900, map[string]int{"a": 100, "b": 1000, "c": 1000}, map[string]int{"a": 100, "b": 400, "c": 400}},
+		{900, map[string]int{"a": 100, "b": 200}, map[string]int{"a": 400, "b": 500}},
+	} {
+		if got := fairShare(c.limit, c.demands); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("fairShare(%d, %v) = %v, want %v", c.limit, c.demands, got, c.want)
+		}
+	}
+}
+
+func TestEgressShaperFirstKeyframe(t *testing.T) {
+	// 800 kbit/s, a bucket of 100000 bytes
+	scheduler := newEgressScheduler(800)
+	scheduler.join("/test")
+	shaper := newEgressShaper(scheduler, "/test")
+	pack := func(size int, keyframe bool) *RTPPack {
+		return &RTPPack{Type: RTP_TYPE_VIDEO, Buffer: bytes.NewBuffer(make([]byte, size)), Keyframe: keyframe}
+	}
+	if !shaper.allow(pack(60000, true)) {
+		t.Fatalf("gop cache keyframe dropped by a fresh player")
+	}
+	if shaper.allow(pack(60000, false)) {
+		t.Errorf("packet over budget sent")
+	}
+	if shaper.allow(pack(100, false)) {
+		t.Errorf("packet of a dropped gop sent")
+	}
+	if !shaper.allow(&RTPPack{Type: RTP_TYPE_AUDIO, Buffer: bytes.NewBuffer(make([]byte, 60000))}) {
+		t.Errorf("audio dropped")
+	}
+}
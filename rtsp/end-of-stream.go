@@ -0,0 +1,81 @@
+package rtsp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+// endOfStreamDrainTimeout bounds the wait for a player to send what it has queued before the end of stream.
+const endOfStreamDrainTimeout = 2 * time.Second
+
+// RTCPBye builds a rtcp BYE (RFC 3550 6.6) of ssrc.
+func RTCPBye(ssrc uint32) []byte {
+	bye := make([]byte, 8)
+	bye[0] = 0x80 | 1 // version 2, one source
+	bye[1] = 203
+	binary.BigEndian.PutUint16(bye[2:], 1)
+	binary.BigEndian.PutUint32(bye[4:], ssrc)
+	return bye
+}
+
+// isRTCPBye tells whether a (compound) rtcp packet holds a BYE.
+func isRTCPBye(rtcp []byte) bool {
+	for len(rtcp) >= 4 {
+		if rtcp[1] == 203 {
+			return true
+		}
+		size := (int(binary.BigEndian.Uint16(rtcp[2:])) + 1) * 4
+		if size > len(rtcp) {
+			break
+		}
+		rtcp = rtcp[size:]
+	}
+	return false
+}
+
+// endOfStream tells a player the channel it plays has ended, as a recorded source does at the end
+// of the file: after what is queued, a rtcp BYE on each track and a PLAY_NOTIFY with
+// Notify-Reason end-of-stream (RFC 7826 13.5), then the session is stopped. Clients that do
+// not know PLAY_NOTIFY still get the BYE.
+func (player *Player) endOfStream() {
+	deadline := time.Now().Add(endOfStreamDrainTimeout)
+	for !player.Stoped && time.Now().Before(deadline) {
+		player.cond.L.Lock()
+		queued := len(player.queue)
+		player.cond.L.Unlock()
+		if queued == 0 {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if player.Stoped {
+		return
+	}
+	player.Pusher.lastRTP.lock.RLock()
+	positions := player.Pusher.lastRTP.positions
+	for t, control := range map[RTPType]RTPType{RTP_TYPE_VIDEO: RTP_TYPE_VIDEOCONTROL, RTP_TYPE_AUDIO: RTP_TYPE_AUDIOCONTROL} {
+		if position, ok := positions[t]; ok {
+			player.SendRTP(&RTPPack{Type: control, Buffer: bytes.NewBuffer(RTCPBye(position.ssrc))})
+		}
+	}
+	player.Pusher.lastRTP.lock.RUnlock()
+	req := &Request{
+		Method:  "PLAY_NOTIFY",
+		URL:     player.URL,
+		Version: RTSP_VERSION,
+		Header: map[string]string{
+			"CSeq":          "1",
+			"Session":       player.ID,
+			"Notify-Reason": "end-of-stream",
+		},
+	}
+	player.connWLock.Lock()
+	if player.connRW != nil {
+		player.connRW.WriteString(req.String())
+		player.connRW.Flush()
+	}
+	player.connWLock.Unlock()
+	player.logger.Printf("%v end of stream", player)
+	player.Stop()
+}
@@ -0,0 +1,29 @@
+package rtsp
+
+import (
+	"testing"
+)
+
+func TestIsRTCPBye(t *testing.T) {
+	// an empty receiver report and a sender report without report blocks
+	rr := []byte{0x80, 201, 0, 1, 1, 2, 3, 4}
+	sr := append([]byte{0x80, 200, 0, 6}, make([]byte, 24)...)
+	bye := RTCPBye(0x01020304)
+	for _, c := range []struct {
+		name string
+		rtcp []byte
+		want bool
+	}{
+		{"bye", bye, true},
+		{"receiver report", rr, false},
+		{"sender report then bye", append(append([]byte{}, sr...), bye...), true},
+		{"receiver report then bye", append(append([]byte{}, rr...), bye...), true},
+		{"truncated after report", append(append([]byte{}, sr...), bye[:2]...), false},
+		{"report length over the packet", append([]byte{0x80, 201, 0, 9}, bye...), false},
+		{"empty", nil, false},
+	} {
+		if got := isRTCPBye(c.rtcp); got != c.want {
+			t.Errorf("%s: bye %v, want %v", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package rtsp
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/penggy/EasyGoLib/utils"
+)
+
+// FFmpegFallback pulls a camera through ffmpeg, which pushes it back in as a rtsp pusher, once the
+// native pull of the camera failed ffmpeg_fallback_after times in a row. When ffmpeg exits the
+// native pull is tried again. The ffmpeg processes count as pull sources, and are killed when the
+// server stops.
+type FFmpegFallback struct {
+	server   *Server
+	failures map[string]int       // url <-> consecutive native pull failures
+	cmds     map[string]*exec.Cmd // url <-> running ffmpeg
+	stopped  bool
+	lock     sync.Mutex
+}
+
+func NewFFmpegFallback(server *Server) *FFmpegFallback {
+	f := &FFmpegFallback{
+		server:   server,
+		failures: make(map[string]int),
+		cmds:     make(map[string]*exec.Cmd),
+	}
+	server.pullLock.Lock()
+	server.ffmpegFallback = f
+	server.pullLock.Unlock()
+	return f
+}
+
+// Count returns the number of ffmpeg processes running.
+func (f *FFmpegFallback) Count() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.cmds)
+}
+
+// Stop kills the ffmpeg processes, no more are started.
+func (f *FFmpegFallback) Stop() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.stopped = true
+	for rawURL, cmd := range f.cmds {
+		f.server.logger.Printf("stop ffmpeg fallback for %s", RedactURL(rawURL))
+		cmd.Process.Kill()
+	}
+}
+
+// Running tells whether ffmpeg is pulling rawURL, the native pull is not to be tried meanwhile.
+func (f *FFmpegFallback) Running(rawURL string) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	_, ok := f.cmds[rawURL]
+	return ok
+}
+
+func (f *FFmpegFallback) PullSucceeded(rawURL string) {
+	f.lock.Lock()
+	delete(f.failures, rawURL)
+	f.lock.Unlock()
+}
+
+// PullFailed counts a native pull failure of rawURL, to be published at path, and falls back to
+// ffmpeg when there are enough of them in a row.
+func (f *FFmpegFallback) PullFailed(rawURL string, path string) {
+	logger := f.server.logger
+	conf := utils.Conf().Section("rtsp")
+	after := conf.Key("ffmpeg_fallback_after").MustInt(0)
+	ffmpeg := conf.Key("ffmpeg_path").MustString("")
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.failures[rawURL]++
+	if after <= 0 || ffmpeg == "" || f.failures[rawURL] < after || f.cmds[rawURL] != nil || f.stopped {
+		return
+	}
+	output := fmt.Sprintf("rtsp://127.0.0.1:%d%s", f.server.TCPPort, path)
+	paramStr := conf.Key("ffmpeg_fallback_params").MustString("-rtsp_transport tcp -i {input} -c copy -rtsp_transport tcp -f rtsp {output}")
+	params := strings.Fields(paramStr)
+	for i := range params {
+		params[i] = strings.Replace(strings.Replace(params[i], "{input}", rawURL, -1), "{output}", output, -1)
+	}
+	cmd := exec.Command(ffmpeg, params...)
+	if err := cmd.Start(); err != nil {
+		logger.Printf("start ffmpeg fallback for %s err:%v", RedactURL(rawURL), err)
+		return
+	}
+	logger.Printf("native pull of %s failed %d times, ffmpeg[%d] pulls it to %s", RedactURL(rawURL), f.failures[rawURL], cmd.Process.Pid, output)
+	f.cmds[rawURL] = cmd
+	go func() {
+		err := cmd.Wait()
+		logger.Printf("ffmpeg fallback for %s exited, err:%v", RedactURL(rawURL), err)
+		f.lock.Lock()
+		if f.cmds[rawURL] == cmd {
+			delete(f.cmds, rawURL)
+		}
+		delete(f.failures, rawURL)
+		f.lock.Unlock()
+	}()
+}
+
+// Retain stops the ffmpeg of the urls not in urls, e.g. streams that were removed.
+func (f *FFmpegFallback) Retain(urls map[string]bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for rawURL, cmd := range f.cmds {
+		if !urls[rawURL] {
+			f.server.logger.Printf("stop ffmpeg fallback for removed stream %s", RedactURL(rawURL))
+			cmd.Process.Kill()
+		}
+	}
+	for rawURL := range f.failures {
+		if !urls[rawURL] {
+			delete(f.failures, rawURL)
+		}
+	}
+}
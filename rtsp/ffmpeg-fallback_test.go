@@ -0,0 +1,34 @@
+package rtsp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFFmpegFallbackCountedAndStopped(t *testing.T) {
+	setTestConf(t, "ffmpeg_fallback_after", "2")
+	// a process standing in for ffmpeg
+	setTestConf(t, "ffmpeg_path", "sleep")
+	setTestConf(t, "ffmpeg_fallback_params", "30")
+	server := newTestServer()
+	f := NewFFmpegFallback(server)
+	f.PullFailed("rtsp://camera/1", "/camera")
+	if f.Running("rtsp://camera/1") {
+		t.Fatalf("fallback before ffmpeg_fallback_after failures")
+	}
+	f.PullFailed("rtsp://camera/1", "/camera")
+	if !f.Running("rtsp://camera/1") || server.PullSourceCount() != 1 {
+		t.Fatalf("fallback running %v, %d pull sources", f.Running("rtsp://camera/1"), server.PullSourceCount())
+	}
+	server.Stop()
+	for deadline := time.Now().Add(5 * time.Second); f.Count() > 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("ffmpeg fallback not killed on server stop")
+		}
+	}
+	f.PullFailed("rtsp://camera/1", "/camera")
+	f.PullFailed("rtsp://camera/1", "/camera")
+	if f.Running("rtsp://camera/1") {
+		t.Errorf("fallback started after the server stopped")
+	}
+}
@@ -0,0 +1,85 @@
+package rtsp
+
+import (
+	"math"
+	"sync"
+)
+
+// FrameRateMeter estimates the frame rate of a video track from the rtp timestamps of the last
+// frames. Spanning a window of frames keeps the estimate right with b-frames, whose timestamps
+// do not grow in decode order.
+type FrameRateMeter struct {
+	clockRate  int
+	window     int
+	timestamps []uint32
+	lastTs     int
+	frames     int
+	rate       float64
+	settled    float64
+	lock       sync.Mutex
+}
+
+func NewFrameRateMeter(clockRate int, window int) *FrameRateMeter {
+	if clockRate <= 0 {
+		clockRate = 90000
+	}
+	if window < 2 {
+		window = 30
+	}
+	return &FrameRateMeter{
+		clockRate: clockRate,
+		window:    window,
+		lastTs:    -1,
+	}
+}
+
+// FrameRate returns the estimated frames per second, 0 before a window of frames is seen.
+func (m *FrameRateMeter) FrameRate() float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.rate
+}
+
+// Push feeds the timestamp of a video rtp packet. Every window of frames, it returns the new
+// frame rate and true if it moved more than 20% from the last settled one.
+func (m *FrameRateMeter) Push(timestamp int) (rate float64, changed bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if timestamp == m.lastTs {
+		return
+	}
+	m.lastTs = timestamp
+	m.timestamps = append(m.timestamps, uint32(timestamp))
+	if len(m.timestamps) > m.window {
+		m.timestamps = m.timestamps[1:]
+	}
+	if len(m.timestamps) < m.window {
+		return
+	}
+	// span of the window, relative to the first timestamp to survive wraparound.
+	first := m.timestamps[0]
+	minDelta, maxDelta := int32(0), int32(0)
+	for _, ts := range m.timestamps {
+		delta := int32(ts - first)
+		if delta < minDelta {
+			minDelta = delta
+		}
+		if delta > maxDelta {
+			maxDelta = delta
+		}
+	}
+	if maxDelta == minDelta {
+		return
+	}
+	m.rate = float64(len(m.timestamps)-1) * float64(m.clockRate) / float64(maxDelta-minDelta)
+	m.frames++
+	if m.frames%m.window != 0 {
+		return
+	}
+	rate = m.rate
+	if m.settled == 0 || math.Abs(rate-m.settled)/m.settled > 0.2 {
+		changed = m.settled != 0
+		m.settled = rate
+	}
+	return
+}
@@ -0,0 +1,79 @@
+package rtsp
+
+import (
+	"sync"
+)
+
+type FrameReorderStats struct {
+	// MaxDistance is the largest number of frames a frame was presented ahead of, in decode order.
+	MaxDistance int `json:"maxDistance"`
+	// RecentDistance is the largest reorder distance seen since the last keyframe.
+	RecentDistance int `json:"recentDistance"`
+	// Inversions counts the frames whose timestamp (pts) went backwards, which is a frame a
+	// muxer has to give a dts different from its pts.
+	Inversions int `json:"inversions"`
+}
+
+// FrameReorderMeter measures the decode order vs presentation order mismatch of a video track.
+// Rtp timestamps are presentation timestamps while packets arrive in decode order, so the
+// reorder distance of a frame is how many of the recently decoded frames have a later timestamp.
+type FrameReorderMeter struct {
+	window     []uint32
+	windowSize int
+	lastTs     int
+	maxTs      uint32
+	stats      FrameReorderStats
+	lock       sync.Mutex
+}
+
+func NewFrameReorderMeter(windowSize int) *FrameReorderMeter {
+	if windowSize < 1 {
+		windowSize = 16
+	}
+	return &FrameReorderMeter{
+		windowSize: windowSize,
+		lastTs:     -1,
+	}
+}
+
+func (m *FrameReorderMeter) Stats() FrameReorderStats {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.stats
+}
+
+// Push feeds the timestamp of a video rtp packet, keyframe tells whether the packet starts a keyframe.
+func (m *FrameReorderMeter) Push(timestamp int, keyframe bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if timestamp == m.lastTs {
+		return
+	}
+	m.lastTs = timestamp
+	ts := uint32(timestamp)
+	if keyframe {
+		m.stats.RecentDistance = 0
+	}
+	distance := 0
+	for _, prev := range m.window {
+		// compare with wraparound, rtp timestamps are 32 bits.
+		if int32(prev-ts) > 0 {
+			distance++
+		}
+	}
+	if len(m.window) > 0 && int32(m.maxTs-ts) > 0 {
+		m.stats.Inversions++
+	} else {
+		m.maxTs = ts
+	}
+	if distance > m.stats.MaxDistance {
+		m.stats.MaxDistance = distance
+	}
+	if distance > m.stats.RecentDistance {
+		m.stats.RecentDistance = distance
+	}
+	m.window = append(m.window, ts)
+	if len(m.window) > m.windowSize {
+		m.window = m.window[1:]
+	}
+}
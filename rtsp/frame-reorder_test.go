@@ -0,0 +1,45 @@
+package rtsp
+
+import "testing"
+
+func TestFrameReorderMeter(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		// presentation order of the frames in decode order, the first a keyframe
+		order []int
+		want  FrameReorderStats
+	}{
+		{"no b-frames", []int{0, 1, 2, 3, 4}, FrameReorderStats{}},
+		{"one b-frame", []int{0, 2, 1, 4, 3}, FrameReorderStats{MaxDistance: 1, RecentDistance: 1, Inversions: 2}},
+		{"two b-frames", []int{0, 3, 1, 2, 6, 4, 5}, FrameReorderStats{MaxDistance: 1, RecentDistance: 1, Inversions: 4}},
+		{"b-pyramid", []int{0, 4, 2, 1, 3}, FrameReorderStats{MaxDistance: 2, RecentDistance: 2, Inversions: 3}},
+	} {
+		m := NewFrameReorderMeter(16)
+		// timestamps wrapping past 2^32 within the gop
+		base := uint32(0xFFFFFFFF - 3600*2)
+		for i, frame := range c.order {
+			ts := int(base + uint32(frame*3600))
+			// each frame spans a few packets of the same timestamp
+			for p := 0; p < 3; p++ {
+				m.Push(ts, i == 0 && p == 0)
+			}
+		}
+		if got := m.Stats(); got != c.want {
+			t.Errorf("%s: got %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestFrameReorderMeterKeyframe(t *testing.T) {
+	m := NewFrameReorderMeter(4)
+	for i, frame := range []int{0, 4, 2, 1, 3} {
+		m.Push(frame*3600, i == 0)
+	}
+	// a gop without b-frames after the keyframe
+	for i, frame := range []int{5, 6, 7, 8, 9, 10} {
+		m.Push(frame*3600, i == 0)
+	}
+	if got, want := m.Stats(), (FrameReorderStats{MaxDistance: 2, RecentDistance: 0, Inversions: 3}); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
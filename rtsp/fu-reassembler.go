@@ -0,0 +1,214 @@
+package rtsp
+
+import (
+	"encoding/binary"
+	"strings"
+	"sync"
+)
+
+type ReassemblyStats struct {
+	Completed int `json:"completed"`
+	Discarded int `json:"discarded"`
+	MaxGap    int `json:"maxGap"`
+}
+
+// FUReassembler rebuilds NAL units from H264/H265 RTP payloads (single NAL, aggregation and
+// fragmentation units), and counts how many NAL units completed or were discarded because
+// fragments were lost.
+type FUReassembler struct {
+	Codec string
+	// Only, if set, keeps only the NAL units whose header it accepts, the fragments of the
+	// others are not buffered.
+	Only func(header []byte) bool
+
+	buf      []byte
+	inFU     bool
+	dropping bool
+	lastSeq  int
+	stats    ReassemblyStats
+	lock     sync.Mutex
+}
+
+func NewFUReassembler(codec string) *FUReassembler {
+	return &FUReassembler{
+		Codec:   strings.ToLower(codec),
+		lastSeq: -1,
+	}
+}
+
+func (r *FUReassembler) Stats() ReassemblyStats {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.stats
+}
+
+// Push feeds a rtp packet, and returns the NAL units completed by it.
+func (r *FUReassembler) Push(rtp *RTPInfo) (nalus [][]byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.lastSeq >= 0 {
+		gap := (rtp.SequenceNumber - r.lastSeq - 1) & 0xFFFF
+		// a huge gap is a reordered or duplicated packet, not a loss.
+		if gap > 0 && gap < 0x8000 && r.inFU {
+			if gap > r.stats.MaxGap {
+				r.stats.MaxGap = gap
+			}
+			r.discard()
+		}
+	}
+	r.lastSeq = rtp.SequenceNumber
+	switch r.Codec {
+	case "h264":
+		return r.pushH264(rtp.Payload)
+	case "h265":
+		return r.pushH265(rtp.Payload)
+	}
+	return
+}
+
+func (r *FUReassembler) discard() {
+	if r.inFU {
+		r.stats.Discarded++
+	}
+	r.buf = nil
+	r.inFU = false
+	r.dropping = true
+}
+
+// skip drops the fragmentation unit being started, its other fragments are ignored up to the end one.
+func (r *FUReassembler) skip() {
+	r.buf = nil
+	r.inFU = false
+	r.dropping = true
+}
+
+func (r *FUReassembler) complete(nalus [][]byte, nalu []byte) [][]byte {
+	if r.Only != nil && !r.Only(nalu) {
+		return nalus
+	}
+	r.stats.Completed++
+	return append(nalus, nalu)
+}
+
+func (r *FUReassembler) pushH264(payload []byte) (nalus [][]byte) {
+	if len(payload) < 1 {
+		return
+	}
+	naluType := payload[0] & 0x1F
+	switch {
+	case naluType >= 1 && naluType <= 23:
+		if r.inFU {
+			r.discard()
+		}
+		r.dropping = false
+		nalus = r.complete(nalus, payload)
+	case naluType == 24: // STAP-A
+		if r.inFU {
+			r.discard()
+		}
+		r.dropping = false
+		for _, nalu := range splitAggregation(payload[1:]) {
+			nalus = r.complete(nalus, nalu)
+		}
+	case naluType == 28: // FU-A
+		if len(payload) < 2 {
+			return
+		}
+		fuHeader := payload[1]
+		if fuHeader&0x80 != 0 {
+			if r.inFU {
+				r.discard()
+			}
+			r.dropping = false
+			r.inFU = true
+			r.buf = append([]byte{payload[0]&0xE0 | fuHeader&0x1F}, payload[2:]...)
+			if r.Only != nil && !r.Only(r.buf[:1]) {
+				r.skip()
+			}
+		} else if r.inFU {
+			r.buf = append(r.buf, payload[2:]...)
+		} else if !r.dropping {
+			// middle or end fragment without its start.
+			r.stats.Discarded++
+			r.dropping = true
+		}
+		if fuHeader&0x40 != 0 {
+			if r.inFU {
+				nalus = r.complete(nalus, r.buf)
+			}
+			r.buf = nil
+			r.inFU = false
+			r.dropping = false
+		}
+	}
+	return
+}
+
+func (r *FUReassembler) pushH265(payload []byte) (nalus [][]byte) {
+	if len(payload) < 3 {
+		return
+	}
+	naluType := (payload[0] >> 1) & 0x3F
+	switch naluType {
+	case 48: // Aggregation Packets
+		if r.inFU {
+			r.discard()
+		}
+		r.dropping = false
+		for _, nalu := range splitAggregation(payload[2:]) {
+			nalus = r.complete(nalus, nalu)
+		}
+	case 49: // Fragmentation Units
+		fuHeader := payload[2]
+		if fuHeader&0x80 != 0 {
+			if r.inFU {
+				r.discard()
+			}
+			r.dropping = false
+			r.inFU = true
+			r.buf = append([]byte{payload[0]&0x81 | (fuHeader&0x3F)<<1, payload[1]}, payload[3:]...)
+			if r.Only != nil && !r.Only(r.buf[:2]) {
+				r.skip()
+			}
+		} else if r.inFU {
+			r.buf = append(r.buf, payload[3:]...)
+		} else if !r.dropping {
+			r.stats.Discarded++
+			r.dropping = true
+		}
+		if fuHeader&0x40 != 0 {
+			if r.inFU {
+				nalus = r.complete(nalus, r.buf)
+			}
+			r.buf = nil
+			r.inFU = false
+			r.dropping = false
+		}
+	case 50: // PACI Packets
+	default:
+		if r.inFU {
+			r.discard()
+		}
+		r.dropping = false
+		nalus = r.complete(nalus, payload)
+	}
+	return
+}
+
+// splitAggregation splits the 2 bytes size prefixed NAL units of a STAP-A/AP payload. A zero
+// sized unit is skipped, so the units after it, an IDR behind the parameter sets say, are kept.
+func splitAggregation(data []byte) (nalus [][]byte) {
+	for len(data) > 2 {
+		size := int(binary.BigEndian.Uint16(data))
+		data = data[2:]
+		if size == 0 {
+			continue
+		}
+		if size > len(data) {
+			return
+		}
+		nalus = append(nalus, data[:size])
+		data = data[size:]
+	}
+	return
+}
@@ -0,0 +1,82 @@
+package rtsp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFUReassemblerOnly(t *testing.T) {
+	for _, c := range []struct {
+		codec string
+		sps   []byte
+		slice []byte
+	}{
+		{"h264", append([]byte{0x67, 0x42}, bytes.Repeat([]byte{0x55}, 3000)...), append([]byte{0x41}, bytes.Repeat([]byte{0x22}, 3000)...)},
+		{"h265", append([]byte{0x42, 0x01}, bytes.Repeat([]byte{0x55}, 3000)...), append([]byte{0x02, 0x01}, bytes.Repeat([]byte{0x22}, 3000)...)},
+	} {
+		r := NewFUReassembler(c.codec)
+		r.Only = func(header []byte) bool { return NALUType(c.codec, header) == NALU_TYPE_SPS }
+		var got [][]byte
+		for _, rtp := range testRTPPackets(c.codec, 1200, 0xFFFE, 0, c.slice, c.sps, c.slice) {
+			got = append(got, r.Push(rtp)...)
+		}
+		if len(got) != 1 || !bytes.Equal(got[0], c.sps) {
+			t.Errorf("%s: %d nal units, want the whole sps", c.codec, len(got))
+		}
+		if stats := r.Stats(); stats.Discarded != 0 {
+			t.Errorf("%s: %d discarded, skipped fragments are not losses", c.codec, stats.Discarded)
+		}
+	}
+}
+
+func TestFUReassemblerIncomplete(t *testing.T) {
+	for _, codec := range []string{"h264", "h265"} {
+		header := []byte{0x41}
+		if codec == "h265" {
+			header = []byte{0x02, 0x01}
+		}
+		nalu := func(size int) []byte {
+			return append(append([]byte{}, header...), bytes.Repeat([]byte{0x22}, size)...)
+		}
+		// 3000 bytes are 3 fragments at the blocksize, 6000 bytes 6
+		long, longer, short := nalu(3000), nalu(6000), nalu(10)
+		for _, c := range []struct {
+			name  string
+			nalus [][]byte
+			// the packets lost
+			drop []int
+			// the source never sent them, the sequence numbers have no gap
+			renumber bool
+			want     ReassemblyStats
+		}{
+			{"complete", [][]byte{long, short}, nil, false, ReassemblyStats{Completed: 2}},
+			{"end fragment never sent", [][]byte{long, short}, []int{2}, true, ReassemblyStats{Completed: 1, Discarded: 1}},
+			{"end fragment lost", [][]byte{long, short}, []int{2}, false, ReassemblyStats{Completed: 1, Discarded: 1, MaxGap: 1}},
+			{"gap mid nal unit", [][]byte{long, short}, []int{1}, false, ReassemblyStats{Completed: 1, Discarded: 1, MaxGap: 1}},
+			{"gap of 3 mid nal unit", [][]byte{longer, short}, []int{1, 2, 3}, false, ReassemblyStats{Completed: 1, Discarded: 1, MaxGap: 3}},
+			{"gaps in two nal units", [][]byte{longer, long, short}, []int{2, 7}, false, ReassemblyStats{Completed: 1, Discarded: 2, MaxGap: 1}},
+			{"fragments without their start", [][]byte{long, short}, []int{0}, false, ReassemblyStats{Completed: 1, Discarded: 1}},
+			{"loss between whole nal units", [][]byte{short, short, short}, []int{1}, false, ReassemblyStats{Completed: 2}},
+		} {
+			r := NewFUReassembler(codec)
+			seq := 0xFFF0
+			for i, rtp := range testRTPPackets(codec, 1200, uint16(seq), 0, c.nalus...) {
+				lost := false
+				for _, d := range c.drop {
+					lost = lost || d == i
+				}
+				if lost {
+					continue
+				}
+				if c.renumber {
+					rtp.SequenceNumber = seq
+				}
+				seq = (seq + 1) & 0xFFFF
+				r.Push(rtp)
+			}
+			if stats := r.Stats(); stats != c.want {
+				t.Errorf("%s %s: stats %+v, want %+v", codec, c.name, stats, c.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package rtsp
+
+import (
+	"time"
+)
+
+// gopStartDetector decides where a gop starts in the gop cache. Parameter sets may come well
+// before the keyframe they belong to, so a parameter set only marks a candidate start, which
+// becomes the gop start when a keyframe follows within the packet and time budget; otherwise
+// the gop starts at the keyframe itself.
+type gopStartDetector struct {
+	maxPackets int
+	maxDelay   time.Duration
+
+	pending bool
+	index   int
+	packets int
+	since   time.Time
+}
+
+func newGopStartDetector(maxPackets int, maxDelay time.Duration) *gopStartDetector {
+	return &gopStartDetector{
+		maxPackets: maxPackets,
+		maxDelay:   maxDelay,
+	}
+}
+
+// candidate tells whether a parameter set waits for its keyframe.
+func (d *gopStartDetector) candidate() bool {
+	return d.pending
+}
+
+// reset forgets the candidate start, when the gop cache is dropped.
+func (d *gopStartDetector) reset() {
+	d.pending = false
+}
+
+// push feeds a video packet about to be appended at cacheLen of the gop cache, and returns
+// whether a new gop starts with it and the cache index the new gop starts from.
+func (d *gopStartDetector) push(cacheLen int, paramSet, keyframe bool) (start bool, from int) {
+	if d.pending {
+		d.packets++
+		if d.packets > d.maxPackets || time.Since(d.since) > d.maxDelay {
+			d.pending = false
+		}
+	}
+	if paramSet && !d.pending {
+		d.pending = true
+		d.index = cacheLen
+		d.packets = 0
+		d.since = time.Now()
+	}
+	if !keyframe {
+		return
+	}
+	start, from = true, cacheLen
+	if d.pending && d.index <= cacheLen {
+		from = d.index
+	}
+	d.pending = false
+	return
+}
@@ -0,0 +1,55 @@
+package rtsp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGopStartDetector(t *testing.T) {
+	const (
+		ps = iota + 1
+		idr
+		slice
+	)
+	for _, c := range []struct {
+		name       string
+		maxPackets int
+		packets    []int
+		// the cache indexes the gops start from, one per keyframe
+		starts []int
+	}{
+		{"sps right before the idr", 300, []int{ps, ps, idr, slice}, []int{0}},
+		{"sps far ahead of the idr", 300, append(append([]int{ps}, make([]int, 50)...), idr), []int{0}},
+		{"sps past the packet budget", 10, append(append([]int{ps}, make([]int, 50)...), idr), []int{51}},
+		{"idr without sps", 300, []int{slice, idr, slice}, []int{1}},
+		{"repeated sps, one gop start", 300, []int{ps, slice, ps, slice, idr}, []int{0}},
+		{"two gops", 300, []int{ps, idr, slice, slice, ps, idr}, []int{0, 4}},
+	} {
+		d := newGopStartDetector(c.maxPackets, time.Minute)
+		var starts []int
+		for i, p := range c.packets {
+			if start, from := d.push(i, p == ps, p == idr); start {
+				starts = append(starts, from)
+			}
+		}
+		if len(starts) != len(c.starts) {
+			t.Errorf("%s: gop starts %v, want %v", c.name, starts, c.starts)
+			continue
+		}
+		for i := range starts {
+			if starts[i] != c.starts[i] {
+				t.Errorf("%s: gop starts %v, want %v", c.name, starts, c.starts)
+				break
+			}
+		}
+	}
+
+	// the time budget
+	d := newGopStartDetector(300, 10*time.Millisecond)
+	d.push(0, true, false)
+	time.Sleep(20 * time.Millisecond)
+	d.push(1, false, false)
+	if _, from := d.push(2, false, true); from != 2 {
+		t.Errorf("gop starts at %d past the time budget, want the idr", from)
+	}
+}
@@ -0,0 +1,35 @@
+package rtsp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUDPGoroutinesAccounted(t *testing.T) {
+	client := &RTSPClient{}
+	clientPusher := NewClientPusher(client)
+	playerPusher := newTestPusher("h264")
+	udpClient := &UDPClient{Session: &Session{Player: &Player{Pusher: playerPusher}}}
+	for _, c := range []struct {
+		name   string
+		pusher *Pusher
+		run    func(f func())
+	}{
+		{"client udp server", clientPusher, (&UDPServer{RTSPClient: client}).goroutine},
+		{"player udp client", playerPusher, udpClient.goroutine},
+	} {
+		release := make(chan struct{})
+		for i := 0; i < 2; i++ {
+			c.run(func() { <-release })
+		}
+		if n := c.pusher.Goroutines(); n != 2 {
+			t.Errorf("%s: %d goroutines accounted, want 2", c.name, n)
+		}
+		close(release)
+		for deadline := time.Now().Add(5 * time.Second); c.pusher.Goroutines() != 0; time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: %d goroutines accounted after they returned", c.name, c.pusher.Goroutines())
+			}
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package rtsp
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// RTSP over HTTP tunneling, as QuickTime does: the client opens a GET connection for the server to
+// client direction and a POST connection, carrying base64 encoded RTSP requests (and interleaved
+// data), for the other, both tagged by the same x-sessioncookie header.
+
+const httpTunnelPairTimeout = 30 * time.Second
+
+type httpTunnel struct {
+	getConn net.Conn
+	paired  chan struct{}
+}
+
+// bufferedConn is a conn whose reads go through the reader that peeked it.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (conn *bufferedConn) Read(b []byte) (n int, err error) {
+	return conn.reader.Read(b)
+}
+
+// handleConn starts a rtsp session on conn, or handles it as one half of a http tunnel.
+func (server *Server) handleConn(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	conn = &bufferedConn{Conn: conn, reader: reader}
+	// "GET_PARAMETER" is a rtsp method, a tunnel GET is followed by the request path.
+	if head, err := reader.Peek(5); err == nil && (string(head) == "GET /" || string(head) == "POST ") {
+		server.handleTunnel(conn, reader)
+		return
+	}
+	NewSession(server, conn).Start()
+}
+
+func (server *Server) handleTunnel(conn net.Conn, reader *bufio.Reader) {
+	logger := server.logger
+	req, err := http.ReadRequest(reader)
+	if err != nil {
+		logger.Printf("http tunnel read request from %v err:%v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	cookie := req.Header.Get("x-sessioncookie")
+	if cookie == "" {
+		logger.Printf("http tunnel %s from %v without x-sessioncookie", req.Method, conn.RemoteAddr())
+		conn.Write([]byte("HTTP/1.0 400 Bad Request\r\nConnection: close\r\n\r\n"))
+		conn.Close()
+		return
+	}
+	switch req.Method {
+	case "GET":
+		tunnel := &httpTunnel{getConn: conn, paired: make(chan struct{})}
+		server.tunnelsLock.Lock()
+		server.tunnels[cookie] = tunnel
+		server.tunnelsLock.Unlock()
+		conn.Write([]byte("HTTP/1.0 200 OK\r\nContent-Type: application/x-rtsp-tunnelled\r\nCache-Control: no-store\r\nPragma: no-cache\r\nConnection: close\r\n\r\n"))
+		select {
+		case <-tunnel.paired:
+		case <-time.After(httpTunnelPairTimeout):
+			server.tunnelsLock.Lock()
+			if server.tunnels[cookie] == tunnel {
+				delete(server.tunnels, cookie)
+			}
+			server.tunnelsLock.Unlock()
+			logger.Printf("http tunnel GET from %v not paired by a POST in %v", conn.RemoteAddr(), httpTunnelPairTimeout)
+			conn.Close()
+		}
+	case "POST":
+		server.tunnelsLock.Lock()
+		tunnel, ok := server.tunnels[cookie]
+		delete(server.tunnels, cookie)
+		server.tunnelsLock.Unlock()
+		if !ok {
+			logger.Printf("http tunnel POST from %v got no GET for cookie[%s]", conn.RemoteAddr(), cookie)
+			conn.Close()
+			return
+		}
+		close(tunnel.paired)
+		logger.Printf("http tunnel from %v paired, cookie[%s]", conn.RemoteAddr(), cookie)
+		// the body of the POST is the base64 encoded rtsp stream, its Content-Length is meaningless.
+		NewSession(server, &httpTunnelConn{
+			Conn:    tunnel.getConn,
+			post:    conn,
+			decoder: &tunnelDecoder{reader: reader},
+		}).Start()
+	default:
+		conn.Write([]byte("HTTP/1.0 405 Method Not Allowed\r\nConnection: close\r\n\r\n"))
+		conn.Close()
+	}
+}
+
+// httpTunnelConn reads the decoded POST connection and writes the GET connection.
+type httpTunnelConn struct {
+	net.Conn
+	post    net.Conn
+	decoder *tunnelDecoder
+}
+
+func (conn *httpTunnelConn) Read(b []byte) (n int, err error) {
+	return conn.decoder.Read(b)
+}
+
+func (conn *httpTunnelConn) Close() error {
+	conn.post.Close()
+	return conn.Conn.Close()
+}
+
+func (conn *httpTunnelConn) RemoteAddr() net.Addr {
+	return conn.post.RemoteAddr()
+}
+
+func (conn *httpTunnelConn) SetDeadline(t time.Time) error {
+	conn.post.SetReadDeadline(t)
+	return conn.Conn.SetWriteDeadline(t)
+}
+
+func (conn *httpTunnelConn) SetReadDeadline(t time.Time) error {
+	return conn.post.SetReadDeadline(t)
+}
+
+// tunnelDecoder decodes base64 quantum by quantum, as clients encode each request on its own,
+// leaving padding in the middle of the stream.
+type tunnelDecoder struct {
+	reader  *bufio.Reader
+	quantum []byte
+	out     []byte
+}
+
+func (d *tunnelDecoder) Read(b []byte) (n int, err error) {
+	for len(d.out) == 0 {
+		c, err := d.reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if c == '\r' || c == '\n' || c == ' ' || c == '\t' {
+			continue
+		}
+		d.quantum = append(d.quantum, c)
+		if len(d.quantum) < 4 {
+			continue
+		}
+		buf := make([]byte, 3)
+		m, err := base64.StdEncoding.Decode(buf, d.quantum)
+		d.quantum = d.quantum[:0]
+		if err != nil {
+			return 0, fmt.Errorf("http tunnel base64 decode err:%v", err)
+		}
+		d.out = buf[:m]
+	}
+	n = copy(b, d.out)
+	d.out = d.out[n:]
+	return
+}
@@ -0,0 +1,125 @@
+package rtsp
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readTestResponse reads a rtsp response, its headers and its body.
+func readTestResponse(t *testing.T, reader *bufio.Reader) (status string, header map[string]string, body string) {
+	header = make(map[string]string)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read response: %v", err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if status == "" {
+			status = line
+		} else if i := strings.Index(line, ":"); i > 0 {
+			header[line[:i]] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	if n, _ := strconv.Atoi(header["Content-Length"]); n > 0 {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(reader, b); err != nil {
+			t.Fatalf("read response body: %v", err)
+		}
+		body = string(b)
+	}
+	return
+}
+
+func TestHTTPTunnelDescribe(t *testing.T) {
+	server := newTestServer()
+	server.tunnels = make(map[string]*httpTunnel)
+	pusher := newTestPusher("h264")
+	pusher.Session.Server = server
+	pusher.Session.SDPRaw = "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=test\r\nt=0 0\r\n" +
+		"m=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\na=control:streamid=0\r\n"
+	server.pushers["/test"] = pusher
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.handleConn(conn)
+		}
+	}()
+
+	get, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer get.Close()
+	get.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(get, "GET /test HTTP/1.0\r\nx-sessioncookie: tunnel1\r\nAccept: application/x-rtsp-tunnelled\r\n\r\n")
+	getReader := bufio.NewReader(get)
+	resp, err := http.ReadResponse(getReader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "application/x-rtsp-tunnelled" {
+		t.Fatalf("GET answered %s, content type %q", resp.Status, resp.Header.Get("Content-Type"))
+	}
+
+	post, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer post.Close()
+	io.WriteString(post, "POST /test HTTP/1.0\r\nx-sessioncookie: tunnel1\r\nContent-Type: application/x-rtsp-tunnelled\r\nContent-Length: 32767\r\n\r\n")
+	url := fmt.Sprintf("rtsp://%s/test", listener.Addr())
+	// each request encoded on its own, with its padding, as clients do
+	for i, req := range []string{
+		fmt.Sprintf("OPTIONS %s RTSP/1.0\r\nCSeq: 1\r\n\r\n", url),
+		fmt.Sprintf("DESCRIBE %s RTSP/1.0\r\nCSeq: 2\r\nAccept: application/sdp\r\n\r\n", url),
+	} {
+		io.WriteString(post, base64.StdEncoding.EncodeToString([]byte(req))+"\r\n")
+		status, header, body := readTestResponse(t, getReader)
+		if status != "RTSP/1.0 200 OK" || header["CSeq"] != strconv.Itoa(i+1) {
+			t.Fatalf("request %d answered %q, CSeq %q", i+1, status, header["CSeq"])
+		}
+		if i == 1 && !strings.Contains(body, "m=video 0 RTP/AVP 96") {
+			t.Errorf("DESCRIBE through the tunnel got sdp %q", body)
+		}
+	}
+
+	server.tunnelsLock.Lock()
+	left := len(server.tunnels)
+	server.tunnelsLock.Unlock()
+	if left != 0 {
+		t.Errorf("%d tunnels waiting after the pairing", left)
+	}
+}
+
+func TestHTTPTunnelPostWithoutGet(t *testing.T) {
+	server := newTestServer()
+	server.tunnels = make(map[string]*httpTunnel)
+	client, conn := net.Pipe()
+	defer client.Close()
+	go server.handleConn(conn)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	go io.WriteString(client, "POST /test HTTP/1.0\r\nx-sessioncookie: nobody\r\n\r\n")
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("POST with no GET read err %v, want it closed", err)
+	}
+}
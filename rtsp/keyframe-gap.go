@@ -0,0 +1,138 @@
+package rtsp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type KeyframeGapStats struct {
+	// MaxGap is the max_keyframe_gap of the channel in seconds, Gap the time since the last keyframe.
+	MaxGap           float64 `json:"maxGap"`
+	Gap              float64 `json:"gap"`
+	KeyframeRequests int     `json:"keyframeRequests"`
+	Teardowns        int     `json:"teardowns"`
+}
+
+// keyframeGapPolicy watches the time since the last keyframe of a video track. Past max_keyframe_gap
+// a keyframe is requested from the source by a rtcp PLI, and when none comes within
+// keyframe_request_timeout, or the PLI can not be sent, the source is torn down to be reconnected.
+type keyframeGapPolicy struct {
+	maxGap         time.Duration
+	requestTimeout time.Duration
+	lastKeyframe   time.Time
+	requestedAt    time.Time
+	ssrc           uint32
+	stats          KeyframeGapStats
+	lock           sync.Mutex
+}
+
+const (
+	keyframeGapOK = iota
+	keyframeGapRequest
+	keyframeGapTeardown
+)
+
+// push feeds a video packet, returns what to do about the source.
+func (p *keyframeGapPolicy) push(rtp *RTPInfo, keyframe bool, now time.Time) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.ssrc = uint32(rtp.SSRC)
+	if keyframe || p.lastKeyframe.IsZero() {
+		p.lastKeyframe = now
+		p.requestedAt = time.Time{}
+		return keyframeGapOK
+	}
+	if now.Sub(p.lastKeyframe) <= p.maxGap {
+		return keyframeGapOK
+	}
+	if p.requestedAt.IsZero() {
+		p.requestedAt = now
+		p.stats.KeyframeRequests++
+		return keyframeGapRequest
+	}
+	if now.Sub(p.requestedAt) <= p.requestTimeout {
+		return keyframeGapOK
+	}
+	p.tornDown(now)
+	return keyframeGapTeardown
+}
+
+func (p *keyframeGapPolicy) tornDown(now time.Time) {
+	p.stats.Teardowns++
+	// not to tear down again and again while the source is stopping.
+	p.lastKeyframe = now
+	p.requestedAt = time.Time{}
+}
+
+func (p *keyframeGapPolicy) Stats() KeyframeGapStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	stats := p.stats
+	stats.MaxGap = p.maxGap.Seconds()
+	if !p.lastKeyframe.IsZero() {
+		stats.Gap = time.Since(p.lastKeyframe).Seconds()
+	}
+	return stats
+}
+
+// PLIPacket builds a rtcp Picture Loss Indication (RFC 4585 6.3.1) for the media source ssrc.
+func PLIPacket(ssrc uint32) []byte {
+	pli := make([]byte, 12)
+	pli[0] = 0x80 | 1 // version 2, FMT 1
+	pli[1] = 206      // PSFB
+	binary.BigEndian.PutUint16(pli[2:], 2)
+	binary.BigEndian.PutUint32(pli[8:], ssrc)
+	return pli
+}
+
+// checkKeyframeGap applies max_keyframe_gap to a video packet.
+func (pusher *Pusher) checkKeyframeGap(pack *RTPPack, rtp *RTPInfo) {
+	if pusher.keyframeGap == nil {
+		return
+	}
+	logger := pusher.Logger()
+	switch pusher.keyframeGap.push(rtp, pack.Keyframe, time.Now()) {
+	case keyframeGapRequest:
+		err := pusher.requestKeyframe(pusher.keyframeGap.ssrc)
+		if err == nil {
+			logger.Printf("no keyframe for more than %v, PLI sent", pusher.keyframeGap.maxGap)
+			return
+		}
+		logger.Printf("no keyframe for more than %v, and no keyframe request: %v, teardown the source", pusher.keyframeGap.maxGap, err)
+		pusher.keyframeGap.lock.Lock()
+		pusher.keyframeGap.tornDown(time.Now())
+		pusher.keyframeGap.lock.Unlock()
+		go pusher.Stop()
+	case keyframeGapTeardown:
+		logger.Printf("no keyframe %v after the PLI, teardown the source", pusher.keyframeGap.requestTimeout)
+		go pusher.Stop()
+	}
+}
+
+// KeyframeGapStats returns nil unless max_keyframe_gap is set for the channel.
+func (pusher *Pusher) KeyframeGapStats() *KeyframeGapStats {
+	if pusher.keyframeGap == nil {
+		return nil
+	}
+	stats := pusher.keyframeGap.Stats()
+	return &stats
+}
+
+// requestKeyframe sends a PLI to the source on its video rtcp channel, which is only known for
+// interleaved tcp.
+func (pusher *Pusher) requestKeyframe(ssrc uint32) error {
+	if pusher.TransType() != TRANS_TYPE_TCP.String() {
+		return fmt.Errorf("source uses %s transport", pusher.TransType())
+	}
+	pli := PLIPacket(ssrc)
+	if pusher.Session != nil {
+		if pusher.Session.vRTPControlChannel < 0 {
+			return fmt.Errorf("source has no video rtcp channel")
+		}
+		return pusher.Session.SendRTP(&RTPPack{Type: RTP_TYPE_VIDEOCONTROL, Buffer: bytes.NewBuffer(pli)})
+	}
+	return pusher.RTSPClient.SendInterleaved(pusher.RTSPClient.vRTPControlChannel, pli)
+}
@@ -0,0 +1,57 @@
+package rtsp
+
+import (
+	"strings"
+)
+
+// LastKeyframe returns the keyframe the gop cache starts with, in Annex-B with the parameter sets
+// first, nil if the video is not h264/h265 or the cache holds no keyframe.
+func (pusher *Pusher) LastKeyframe() []byte {
+	codec := strings.ToLower(pusher.VCodec())
+	if codec != "h264" && codec != "h265" {
+		return nil
+	}
+	pusher.gopCacheLock.RLock()
+	cache := pusher.gopCache
+	pusher.gopCacheLock.RUnlock()
+	reassembler := NewFUReassembler(codec)
+	timestamp := -1
+	var nalus [][]byte
+	for _, pack := range cache {
+		if pack.Type != RTP_TYPE_VIDEO {
+			continue
+		}
+		if timestamp < 0 && !pack.Keyframe {
+			continue
+		}
+		rtp := ParseRTP(pack.Buffer.Bytes())
+		if rtp == nil || rtp.Keepalive {
+			continue
+		}
+		if timestamp < 0 {
+			timestamp = rtp.Timestamp
+		}
+		if rtp.Timestamp != timestamp {
+			break
+		}
+		nalus = append(nalus, reassembler.Push(rtp)...)
+	}
+	if len(nalus) == 0 {
+		return nil
+	}
+	hasSPS := false
+	for _, nalu := range nalus {
+		if NALUType(codec, nalu) == NALU_TYPE_SPS {
+			hasSPS = true
+		}
+	}
+	if !hasSPS {
+		nalus = append(pusher.ParameterSets().NALUnits(), nalus...)
+	}
+	var keyframe []byte
+	for _, nalu := range nalus {
+		keyframe = append(keyframe, annexBStartCode...)
+		keyframe = append(keyframe, nalu...)
+	}
+	return keyframe
+}
@@ -0,0 +1,48 @@
+package rtsp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLastKeyframe(t *testing.T) {
+	sps := testSPS{profile: 66, level: 30, width: 640, height: 480}.h264()
+	pps := []byte{0x68, 0xCE, 0x3C, 0x80}
+	idr := append([]byte{0x65}, bytes.Repeat([]byte{0x11}, 3000)...)
+	slice := append([]byte{0x41}, bytes.Repeat([]byte{0x22}, 100)...)
+	// the packets of the gop cache, inspected as the pusher does
+	cache := func(pusher *Pusher, frames ...[][]byte) (packs []*RTPPack) {
+		pusher.psReassembler = pusher.newParamSetsReassembler("h264")
+		seq := uint16(0)
+		for i, frame := range frames {
+			for _, rtp := range testRTPPackets("h264", 1200, seq, uint32(3600*i), frame...) {
+				pack := &RTPPack{Type: RTP_TYPE_VIDEO, Buffer: bytes.NewBuffer(selfTestRTP(96, uint16(rtp.SequenceNumber), uint32(rtp.Timestamp), rtp.Marker, rtp.Payload))}
+				pusher.inspectVideo(pack, rtp)
+				packs = append(packs, pack)
+				seq++
+			}
+			packs = append(packs, &RTPPack{Type: RTP_TYPE_AUDIO, Buffer: bytes.NewBuffer(selfTestRTP(97, seq, 0, true, []byte{1, 2}))})
+		}
+		return
+	}
+	for _, c := range []struct {
+		name   string
+		frames [][][]byte
+		want   []byte
+	}{
+		{"in band parameter sets", [][][]byte{{sps, pps, idr}, {slice}}, annexBNALUs(sps, pps, idr)},
+		{"cached parameter sets", [][][]byte{{sps, pps}, {idr}, {slice}}, annexBNALUs(sps, pps, idr)},
+		{"no keyframe", [][][]byte{{slice}, {slice}}, nil},
+	} {
+		pusher := newTestPusher("h264")
+		pusher.gopCache = cache(pusher, c.frames...)
+		if got := pusher.LastKeyframe(); !bytes.Equal(got, c.want) {
+			t.Errorf("%s: keyframe of %d bytes, want %d", c.name, len(got), len(c.want))
+		}
+	}
+	pusher := newTestPusher("mpeg4")
+	pusher.gopCache = []*RTPPack{{Type: RTP_TYPE_VIDEO, Keyframe: true, Buffer: bytes.NewBuffer(selfTestRTP(96, 0, 0, true, idr))}}
+	if pusher.LastKeyframe() != nil {
+		t.Error("keyframe of a video neither h264 nor h265")
+	}
+}
@@ -0,0 +1,56 @@
+package rtsp
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// liveGate holds a source back from players until it sent min_keyframes_live keyframes within
+// min_keyframes_window seconds, so a camera that connects and sends a single keyframe before
+// garbage is never played. Once live, the source stays so.
+type liveGate struct {
+	need      int
+	window    time.Duration
+	keyframes []time.Time
+	live      int32
+}
+
+// newLiveGate returns nil, for a source live at once, if min_keyframes_live is not above 1 or the
+// video is not h264/h265, whose keyframes are not detected.
+func newLiveGate(pusher *Pusher) *liveGate {
+	need := ChannelKey(pusher.Path(), "min_keyframes_live").MustInt(0)
+	codec := strings.ToLower(pusher.VCodec())
+	if need <= 1 || (codec != "h264" && codec != "h265") {
+		return nil
+	}
+	return &liveGate{
+		need:   need,
+		window: time.Duration(ChannelKey(pusher.Path(), "min_keyframes_window").MustInt(10)) * time.Second,
+	}
+}
+
+// keyframe counts a keyframe received at now, it returns true when it makes the source live.
+func (g *liveGate) keyframe(now time.Time) bool {
+	if atomic.LoadInt32(&g.live) == 1 {
+		return false
+	}
+	kept := g.keyframes[:0]
+	for _, at := range g.keyframes {
+		if now.Sub(at) <= g.window {
+			kept = append(kept, at)
+		}
+	}
+	g.keyframes = append(kept, now)
+	if len(g.keyframes) < g.need {
+		return false
+	}
+	atomic.StoreInt32(&g.live, 1)
+	g.keyframes = nil
+	return true
+}
+
+// Live tells whether the source is shown to players, see min_keyframes_live.
+func (pusher *Pusher) Live() bool {
+	return pusher.liveGate == nil || atomic.LoadInt32(&pusher.liveGate.live) == 1
+}
@@ -0,0 +1,61 @@
+package rtsp
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// One way media: the signaling went through but the media does not flow, most often udp packets
+// dropped by a NAT or firewall that let the rtsp connection pass. The clients just show black,
+// so it is reported after media_flow_timeout seconds.
+
+// watchMediaFlow reports a udp player that got through PLAY but is not heard from in
+// media_flow_timeout seconds, neither a rtcp receiver report nor a nat punching packet.
+func (session *Session) watchMediaFlow() {
+	timeout := ChannelKey(session.Path, "media_flow_timeout").MustInt(0)
+	client := session.UDPClient
+	if timeout <= 0 || session.TransType != TRANS_TYPE_UDP || client == nil || session.mediaFlowWatched {
+		return
+	}
+	session.mediaFlowWatched = true
+	time.AfterFunc(time.Duration(timeout)*time.Second, func() {
+		if session.Stoped || client.Heard() {
+			return
+		}
+		session.Server.reportOneWayMedia()
+		session.logger.Printf("%v media not flowing despite successful signaling: sent %d bytes, nothing came back from the player's udp ports in %ds, check the NAT/firewall on the way or play over tcp",
+			session, session.OutBytes, timeout)
+	})
+}
+
+// watchMediaFlow reports a pull source that got through PLAY but sent no media in
+// media_flow_timeout seconds.
+func (client *RTSPClient) watchMediaFlow() {
+	timeout := ChannelKey(client.Path, "media_flow_timeout").MustInt(0)
+	if timeout <= 0 {
+		return
+	}
+	time.AfterFunc(time.Duration(timeout)*time.Second, func() {
+		if client.Stoped || client.InBytes > 0 {
+			return
+		}
+		if client.Server != nil {
+			client.Server.reportOneWayMedia()
+		}
+		hint := "the source has nothing to send"
+		if client.TransType == TRANS_TYPE_UDP {
+			hint = "check the NAT/firewall on the way or pull over tcp"
+		}
+		client.logger.Printf("%v media not flowing despite successful signaling: no rtp over %v in %ds since PLAY, %s",
+			client, client.TransType, timeout, hint)
+	})
+}
+
+func (server *Server) reportOneWayMedia() {
+	atomic.AddInt64(&server.oneWayMedia, 1)
+}
+
+// OneWayMediaCount returns how many sessions got through the signaling with no media flowing.
+func (server *Server) OneWayMediaCount() int {
+	return int(atomic.LoadInt64(&server.oneWayMedia))
+}
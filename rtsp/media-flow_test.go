@@ -0,0 +1,67 @@
+package rtsp
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestMediaFlowTimeout(t *testing.T) {
+	setTestConf(t, "media_flow_timeout", "1")
+	player := func(trans TransType, heard bool) *Server {
+		server := newTestServer()
+		session := &Session{Server: server, Path: "/test", TransType: trans, logger: log.New(io.Discard, "", 0)}
+		session.UDPClient = &UDPClient{Session: session}
+		if heard {
+			session.UDPClient.heard = 1
+		}
+		session.watchMediaFlow()
+		// watched once per session, a second PLAY does not report twice
+		session.watchMediaFlow()
+		return server
+	}
+	pull := func(inBytes int) *Server {
+		server := newTestServer()
+		client := &RTSPClient{Server: server, Path: "/test", InBytes: inBytes}
+		client.logger = log.New(io.Discard, "", 0)
+		client.watchMediaFlow()
+		return server
+	}
+	silentPlayer, heardPlayer, tcpPlayer := player(TRANS_TYPE_UDP, false), player(TRANS_TYPE_UDP, true), player(TRANS_TYPE_TCP, false)
+	silentPull, flowingPull := pull(0), pull(1400)
+
+	for deadline := time.Now().Add(5 * time.Second); silentPlayer.OneWayMediaCount() == 0 || silentPull.OneWayMediaCount() == 0; time.Sleep(50 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("one way media reported %d times for a silent udp player, %d for a silent pull, want 1",
+				silentPlayer.OneWayMediaCount(), silentPull.OneWayMediaCount())
+		}
+	}
+	// the others had the same timeout to be reported
+	time.Sleep(100 * time.Millisecond)
+	for name, c := range map[string]struct {
+		server *Server
+		want   int
+	}{
+		"silent udp player": {silentPlayer, 1},
+		"heard udp player":  {heardPlayer, 0},
+		"tcp player":        {tcpPlayer, 0},
+		"silent pull":       {silentPull, 1},
+		"flowing pull":      {flowingPull, 0},
+	} {
+		if got := c.server.OneWayMediaCount(); got != c.want {
+			t.Errorf("%s: one way media reported %d times, want %d", name, got, c.want)
+		}
+	}
+}
+
+func TestMediaFlowTimeoutOff(t *testing.T) {
+	setTestConf(t, "media_flow_timeout", "0")
+	server := newTestServer()
+	session := &Session{Server: server, Path: "/test", TransType: TRANS_TYPE_UDP, logger: log.New(io.Discard, "", 0)}
+	session.UDPClient = &UDPClient{Session: session}
+	session.watchMediaFlow()
+	if session.mediaFlowWatched {
+		t.Error("media flow watched with media_flow_timeout=0")
+	}
+}
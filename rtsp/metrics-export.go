@@ -0,0 +1,183 @@
+package rtsp
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/penggy/EasyGoLib/utils"
+)
+
+// MetricsSink receives batches of metrics in InfluxDB line protocol.
+type MetricsSink interface {
+	Write(lines []string) error
+}
+
+// MetricsSinkFactory creates a sink for the metrics_export_url of its scheme.
+type MetricsSinkFactory func(u *url.URL) (MetricsSink, error)
+
+var (
+	metricsSinks     = make(map[string]MetricsSinkFactory)
+	metricsSinksLock sync.RWMutex
+)
+
+// RegisterMetricsSink registers a sink factory by url scheme, replacing the previous one, built-in
+// ones (http, https and udp) included.
+func RegisterMetricsSink(scheme string, factory MetricsSinkFactory) {
+	metricsSinksLock.Lock()
+	metricsSinks[strings.ToLower(scheme)] = factory
+	metricsSinksLock.Unlock()
+}
+
+func NewMetricsSink(rawURL string) (MetricsSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	metricsSinksLock.RLock()
+	factory, ok := metricsSinks[strings.ToLower(u.Scheme)]
+	metricsSinksLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no metrics sink for scheme[%s]", u.Scheme)
+	}
+	return factory(u)
+}
+
+func init() {
+	httpSink := func(u *url.URL) (MetricsSink, error) {
+		return &httpMetricsSink{url: u.String(), client: &http.Client{Timeout: 5 * time.Second}}, nil
+	}
+	RegisterMetricsSink("http", httpSink)
+	RegisterMetricsSink("https", httpSink)
+	RegisterMetricsSink("udp", func(u *url.URL) (MetricsSink, error) {
+		return &udpMetricsSink{addr: u.Host}, nil
+	})
+}
+
+// httpMetricsSink posts a batch as one request, e.g. to http://influxdb:8086/write?db=easydarwin.
+type httpMetricsSink struct {
+	url    string
+	client *http.Client
+}
+
+func (sink *httpMetricsSink) Write(lines []string) error {
+	resp, err := sink.client.Post(sink.url, "text/plain; charset=utf-8", strings.NewReader(strings.Join(lines, "\n")+"\n"))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("metrics sink %s answered %s", sink.url, resp.Status)
+	}
+	return nil
+}
+
+// udpMetricsSink sends a batch in datagrams of whole lines.
+type udpMetricsSink struct {
+	addr string
+}
+
+func (sink *udpMetricsSink) Write(lines []string) error {
+	const maxDatagram = 1400
+	conn, err := net.Dial("udp", sink.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if buf.Len() > 0 && buf.Len()+len(line)+1 > maxDatagram {
+			if _, err := conn.Write(buf.Bytes()); err != nil {
+				return err
+			}
+			buf.Reset()
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	if buf.Len() > 0 {
+		_, err = conn.Write(buf.Bytes())
+	}
+	return err
+}
+
+// exportMetrics pushes the per channel and aggregate stats to metrics_export_url every
+// metrics_export_interval seconds until stop is closed. Batches a sink failed to take are kept,
+// up to metrics_export_max_pending lines, the oldest dropped first, and sent with the next one.
+func (server *Server) exportMetrics(stop chan struct{}) {
+	logger := server.logger
+	conf := utils.Conf().Section("rtsp")
+	sink, err := NewMetricsSink(conf.Key("metrics_export_url").MustString(""))
+	if err != nil {
+		logger.Printf("metrics export disabled, %v", err)
+		return
+	}
+	maxPending := conf.Key("metrics_export_max_pending").MustInt(10000)
+	ticker := time.NewTicker(time.Duration(conf.Key("metrics_export_interval").MustInt(10)) * time.Second)
+	defer ticker.Stop()
+	var pending []string
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			pending = append(pending, server.metricsLines(now)...)
+			if len(pending) > maxPending {
+				logger.Printf("metrics export dropped %d pending lines", len(pending)-maxPending)
+				pending = pending[len(pending)-maxPending:]
+			}
+			if err := sink.Write(pending); err != nil {
+				logger.Printf("metrics export of %d lines err:%v", len(pending), err)
+				continue
+			}
+			pending = nil
+		}
+	}
+}
+
+func (server *Server) metricsLines(now time.Time) (lines []string) {
+	ts := now.UnixNano()
+	pushers := server.GetPushers()
+	paths := make([]string, 0, len(pushers))
+	for path := range pushers {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	totalPlayers, totalIn, totalOut := 0, 0, 0
+	for _, path := range paths {
+		pusher := pushers[path]
+		players := len(pusher.GetPlayers())
+		queue := pusher.QueueStats()
+		lines = append(lines, fmt.Sprintf("easydarwin_channel,path=%s players=%di,inBytes=%di,outBytes=%di,frameRate=%g,queueDropped=%di,goroutines=%di,misroutedPackets=%di %d",
+			metricsTag(path), players, pusher.InBytes(), pusher.OutBytes(), pusher.FrameRate(), queue.Dropped, pusher.Goroutines(), pusher.MisroutedPackets(), ts))
+		if ssrc := pusher.SSRCStats(); ssrc != nil {
+			lines = append(lines, fmt.Sprintf("easydarwin_ssrc,path=%s video=%di,audio=%di,rejected=%di %d",
+				metricsTag(path), ssrc.Video, ssrc.Audio, ssrc.Rejected, ts))
+		}
+		if gap := pusher.KeyframeGapStats(); gap != nil {
+			lines = append(lines, fmt.Sprintf("easydarwin_keyframe_gap,path=%s maxGap=%g,gap=%g,keyframeRequests=%di,teardowns=%di %d",
+				metricsTag(path), gap.MaxGap, gap.Gap, gap.KeyframeRequests, gap.Teardowns, ts))
+		}
+		if egress := server.EgressAllocation(path); egress != nil {
+			lines = append(lines, fmt.Sprintf("easydarwin_egress,path=%s demand=%di,allocated=%di,used=%di %d",
+				metricsTag(path), egress.Demand, egress.Allocated, egress.Used, ts))
+		}
+		totalPlayers += players
+		totalIn += pusher.InBytes()
+		totalOut += pusher.OutBytes()
+	}
+	lines = append(lines, fmt.Sprintf("easydarwin_server channels=%di,players=%di,inBytes=%di,outBytes=%di,pullSources=%di,oneWayMedia=%di %d",
+		len(paths), totalPlayers, totalIn, totalOut, server.PullSourceCount(), server.OneWayMediaCount(), ts))
+	return
+}
+
+// metricsTag escapes a tag value of the line protocol.
+func metricsTag(value string) string {
+	return strings.NewReplacer(",", "\\,", "=", "\\=", " ", "\\ ").Replace(value)
+}
@@ -0,0 +1,146 @@
+package rtsp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMetricsTag(t *testing.T) {
+	for value, want := range map[string]string{
+		"/live/cam1":  "/live/cam1",
+		"/a b,c=d":    "/a\\ b\\,c\\=d",
+		"/中文 channel": "/中文\\ channel",
+	} {
+		if got := metricsTag(value); got != want {
+			t.Errorf("metricsTag(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
+
+func TestMetricsLines(t *testing.T) {
+	server := newTestServer()
+	addTestPullSource(server, "/b", 0, time.Now())
+	addTestPullSource(server, "/a x", 0, time.Now())
+	lines := server.metricsLines(time.Unix(1, 0))
+	if len(lines) != 3 {
+		t.Fatalf("lines %q, want two channels and the server", lines)
+	}
+	for i, prefix := range []string{"easydarwin_channel,path=/a\\ x ", "easydarwin_channel,path=/b ", "easydarwin_server channels=2i,players=0i,"} {
+		if !strings.HasPrefix(lines[i], prefix) || !strings.HasSuffix(lines[i], " 1000000000") {
+			t.Errorf("line %d: %q, want prefix %q", i, lines[i], prefix)
+		}
+	}
+}
+
+type testMetricsSink struct {
+	lock    sync.Mutex
+	fails   int
+	batches [][]string
+}
+
+func (sink *testMetricsSink) Write(lines []string) error {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	if sink.fails > 0 {
+		sink.fails--
+		return errors.New("sink down")
+	}
+	sink.batches = append(sink.batches, append([]string{}, lines...))
+	return nil
+}
+
+func (sink *testMetricsSink) get() [][]string {
+	sink.lock.Lock()
+	defer sink.lock.Unlock()
+	return sink.batches
+}
+
+func TestExportMetricsPending(t *testing.T) {
+	sink := &testMetricsSink{fails: 1}
+	RegisterMetricsSink("test", func(u *url.URL) (MetricsSink, error) {
+		return sink, nil
+	})
+	t.Cleanup(func() {
+		metricsSinksLock.Lock()
+		delete(metricsSinks, "test")
+		metricsSinksLock.Unlock()
+	})
+	setTestConf(t, "metrics_export_url", "test://sink")
+	setTestConf(t, "metrics_export_interval", "1")
+	server := newTestServer()
+	addTestPullSource(server, "/a", 0, time.Now())
+	stop := make(chan struct{})
+	done := make(chan bool)
+	go func() {
+		server.exportMetrics(stop)
+		close(done)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); len(sink.get()) == 0 && time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+	}
+	close(stop)
+	<-done
+	batches := sink.get()
+	if len(batches) != 1 {
+		t.Fatalf("%d batches written", len(batches))
+	}
+	// the batch the sink failed to take comes with the next one
+	if b := batches[0]; len(b) != 4 || !strings.HasPrefix(b[0], "easydarwin_channel,path=/a ") || !strings.HasPrefix(b[2], "easydarwin_channel,path=/a ") || b[0] == b[2] {
+		t.Errorf("batch %q, want the lines of two exports", b)
+	}
+}
+
+func TestMetricsSinks(t *testing.T) {
+	if _, err := NewMetricsSink("ftp://host"); err == nil {
+		t.Error("sink of an unknown scheme")
+	}
+	var body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+	sink, err := NewMetricsSink(ts.URL + "/write?db=easydarwin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write([]string{"a x=1i 1", "b x=2i 1"}); err != nil {
+		t.Fatal(err)
+	}
+	if body != "a x=1i 1\nb x=2i 1\n" {
+		t.Errorf("http sink posted %q", body)
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if sink, err = NewMetricsSink("udp://" + conn.LocalAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+	// lines of 500 bytes, two in a datagram
+	line := strings.Repeat("x", 499)
+	if err := sink.Write([]string{line, line, line}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 2048)
+	for _, want := range []int{1000, 500} {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want || !strings.HasSuffix(string(buf[:n]), "x\n") {
+			t.Errorf("datagram of %d bytes, want %d of whole lines", n, want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package rtsp
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// naluStats counts the NAL units of a video track by type, a quick look at what a camera sends.
+type naluStats struct {
+	counts map[string]int
+	lock   sync.Mutex
+}
+
+func (s *naluStats) push(codec string, nalu []byte) {
+	name := NALUTypeName(codec, nalu)
+	s.lock.Lock()
+	s.counts[name]++
+	s.lock.Unlock()
+}
+
+func (s *naluStats) get() map[string]int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	counts := make(map[string]int, len(s.counts))
+	for name, count := range s.counts {
+		counts[name] = count
+	}
+	return counts
+}
+
+// NALUTypeName names the type of a h264/h265 NAL unit, "type<n>" for the less common ones.
+func NALUTypeName(codec string, nalu []byte) string {
+	if len(nalu) < 1 {
+		return "empty"
+	}
+	if strings.EqualFold(codec, "h265") {
+		switch t := (nalu[0] >> 1) & 0x3F; {
+		case t <= 9:
+			return "slice"
+		case t >= 16 && t <= 18:
+			return "bla"
+		case t == 19 || t == 20:
+			return "idr"
+		case t == 21:
+			return "cra"
+		case t == 32:
+			return "vps"
+		case t == 33:
+			return "sps"
+		case t == 34:
+			return "pps"
+		case t == 35:
+			return "aud"
+		case t == 39 || t == 40:
+			return "sei"
+		default:
+			return fmt.Sprintf("type%d", t)
+		}
+	}
+	switch t := nalu[0] & 0x1F; t {
+	case 1:
+		return "slice"
+	case 5:
+		return "idr"
+	case 6:
+		return "sei"
+	case 7:
+		return "sps"
+	case 8:
+		return "pps"
+	case 9:
+		return "aud"
+	case 12:
+		return "filler"
+	default:
+		return fmt.Sprintf("type%d", t)
+	}
+}
+
+// NALUStats returns the count of NAL units of the video by type, nil if nalu_stats_enable is off.
+func (pusher *Pusher) NALUStats() map[string]int {
+	if pusher.naluStats == nil {
+		return nil
+	}
+	return pusher.naluStats.get()
+}
@@ -0,0 +1,61 @@
+package rtsp
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestNALUTypeName(t *testing.T) {
+	for _, c := range []struct {
+		codec string
+		nalu  []byte
+		want  string
+	}{
+		{"h264", []byte{0x65}, "idr"},
+		{"h264", []byte{0x41}, "slice"},
+		{"h264", []byte{0x67}, "sps"},
+		{"h264", []byte{0x68}, "pps"},
+		{"h264", []byte{0x06}, "sei"},
+		{"h264", []byte{0x09}, "aud"},
+		{"h264", []byte{0x0C}, "filler"},
+		{"h264", []byte{0x6E}, "type14"},
+		{"H265", []byte{19 << 1, 1}, "idr"},
+		{"h265", []byte{21 << 1, 1}, "cra"},
+		{"h265", []byte{1 << 1, 1}, "slice"},
+		{"h265", []byte{32 << 1, 1}, "vps"},
+		{"h265", []byte{40 << 1, 1}, "sei"},
+		{"h265", []byte{48 << 1, 1}, "type48"},
+		{"h264", nil, "empty"},
+	} {
+		if got := NALUTypeName(c.codec, c.nalu); got != c.want {
+			t.Errorf("%s % x: %s, want %s", c.codec, c.nalu, got, c.want)
+		}
+	}
+}
+
+func TestNALUStatsHistogram(t *testing.T) {
+	sps := testSPS{profile: 66, level: 30, width: 640, height: 480}.h264()
+	pps := []byte{0x68, 0xCE, 0x3C, 0x80}
+	sei := []byte{0x06, 0x05, 0x01, 0x80}
+	aud := []byte{0x09, 0xF0}
+	idr := append([]byte{0x65}, bytes.Repeat([]byte{0x11}, 3000)...)
+	slice := []byte{0x41, 0x9A, 0x02}
+	pusher := newTestPusher("h264")
+	pusher.psReassembler = pusher.newParamSetsReassembler("h264")
+	pusher.naluStats = &naluStats{counts: make(map[string]int)}
+	packets := []*RTPInfo{ParseRTP(selfTestRTP(96, 0, 3000, false, stapA(aud, sps, pps)))}
+	packets = append(packets, testRTPPackets("h264", 1200, 1, 3000, sei, idr)...)
+	packets = append(packets, testRTPPackets("h264", 1200, 10, 6000, aud, slice)...)
+	packets = append(packets, testRTPPackets("h264", 1200, 20, 9000, aud, slice)...)
+	for _, rtp := range packets {
+		pusher.inspectVideo(&RTPPack{Type: RTP_TYPE_VIDEO}, rtp)
+	}
+	want := map[string]int{"aud": 3, "sps": 1, "pps": 1, "sei": 1, "idr": 1, "slice": 2}
+	if got := pusher.NALUStats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("histogram %v, want %v", got, want)
+	}
+	if newTestPusher("h264").NALUStats() != nil {
+		t.Errorf("histogram without nalu_stats_enable")
+	}
+}
@@ -0,0 +1,88 @@
+package rtsp
+
+import (
+	"encoding/binary"
+	"strings"
+	"time"
+)
+
+const (
+	PacingImmediate = "immediate"
+	PacingTimestamp = "timestamp"
+)
+
+const (
+	// a packet due further behind than this is sent at once and re-anchors the track, the
+	// output fell behind the source, like after a source stall.
+	pacingMaxLag = time.Second
+	// a packet due further ahead than this re-anchors the track, its timestamps jumped.
+	pacingMaxAhead = 2 * time.Second
+)
+
+// rtpPacer holds each media packet of a player until the wallclock has run as far as its rtp
+// timestamp since the first packet of the track, so the player gets the source's own timing
+// rather than the bursts the packets come in. A player joining with the gop cache is paced from
+// the start of the cached gop, so it stays that much behind the live edge.
+type rtpPacer struct {
+	video pacerTrack
+	audio pacerTrack
+}
+
+type pacerTrack struct {
+	// resolved once for the player, not per packet
+	clockRate int
+	started   bool
+	last      uint32
+	// rtp timestamp ticks since the anchor
+	elapsed  int64
+	anchorAt time.Time
+}
+
+// parsePacing returns the pacing mode of mode, immediate if unknown.
+func parsePacing(mode string) string {
+	if strings.EqualFold(strings.TrimSpace(mode), PacingTimestamp) {
+		return PacingTimestamp
+	}
+	return PacingImmediate
+}
+
+// due returns when the packet of timestamp should be sent, the zero time to send it now.
+func (track *pacerTrack) due(timestamp uint32, clockRate int, now time.Time) time.Time {
+	if !track.started {
+		track.started, track.last, track.elapsed, track.anchorAt = true, timestamp, 0, now
+		return time.Time{}
+	}
+	track.elapsed += int64(int32(timestamp - track.last))
+	track.last = timestamp
+	due := track.anchorAt.Add(time.Duration(track.elapsed * int64(time.Second) / int64(clockRate)))
+	if due.Before(now.Add(-pacingMaxLag)) || due.After(now.Add(pacingMaxAhead)) {
+		track.elapsed, track.anchorAt = 0, now
+		return time.Time{}
+	}
+	return due
+}
+
+func newRTPPacer(pusher *Pusher) rtpPacer {
+	return rtpPacer{
+		video: pacerTrack{clockRate: pusher.ClockRate(RTP_TYPE_VIDEO)},
+		audio: pacerTrack{clockRate: pusher.ClockRate(RTP_TYPE_AUDIO)},
+	}
+}
+
+// wait sleeps until pack is due.
+func (p *rtpPacer) wait(pack *RTPPack) {
+	track := &p.video
+	if pack.Type == RTP_TYPE_AUDIO {
+		track = &p.audio
+	} else if pack.Type != RTP_TYPE_VIDEO {
+		return
+	}
+	b := pack.Buffer.Bytes()
+	if track.clockRate <= 0 || len(b) < RTP_FIXED_HEADER_LENGTH {
+		return
+	}
+	now := time.Now()
+	if due := track.due(binary.BigEndian.Uint32(b[4:]), track.clockRate, now); due.After(now) {
+		time.Sleep(due.Sub(now))
+	}
+}
@@ -0,0 +1,73 @@
+package rtsp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestParsePacing(t *testing.T) {
+	for mode, want := range map[string]string{
+		"":            PacingImmediate,
+		"immediate":   PacingImmediate,
+		"timestamp":   PacingTimestamp,
+		" Timestamp ": PacingTimestamp,
+		"bogus":       PacingImmediate,
+	} {
+		if got := parsePacing(mode); got != want {
+			t.Errorf("parsePacing(%q) = %q, want %q", mode, got, want)
+		}
+	}
+}
+
+func TestPacerTrackDue(t *testing.T) {
+	start := time.Unix(1000, 0)
+	var track pacerTrack
+	base := uint32(0xFFFFF000)
+	if due := track.due(base, 90000, start); !due.IsZero() {
+		t.Fatalf("first packet due %v", due)
+	}
+	// 40ms frames, the timestamp wrapping past 2^32
+	for i := 1; i <= 5; i++ {
+		timestamp := base + uint32(i*3600)
+		want := start.Add(time.Duration(i) * 40 * time.Millisecond)
+		// packets coming in a burst are held to their timestamps
+		if due := track.due(timestamp, 90000, start); !due.Equal(want) {
+			t.Errorf("frame %d due %v, want %v", i, due.Sub(start), want.Sub(start))
+		}
+	}
+	// a source stall leaves the output behind, the late packet goes at once and re-anchors
+	late := start.Add(5 * time.Second)
+	if due := track.due(base+6*3600, 90000, late); !due.IsZero() {
+		t.Errorf("late packet due %v", due.Sub(start))
+	}
+	if due := track.due(base+7*3600, 90000, late); !due.Equal(late.Add(40 * time.Millisecond)) {
+		t.Errorf("packet after the stall due %v", due.Sub(late))
+	}
+	// a timestamp jump re-anchors too
+	if due := track.due(0x10000000, 90000, late); !due.IsZero() {
+		t.Errorf("packet after a jump due %v", due.Sub(late))
+	}
+	if due := track.due(0x10000000+3600, 90000, late); !due.Equal(late.Add(40 * time.Millisecond)) {
+		t.Errorf("packet after the jump due %v", due.Sub(late))
+	}
+}
+
+func TestPacerClockRates(t *testing.T) {
+	setTestConf(t, "video_clock_rate", "90000")
+	setTestConf(t, "audio_clock_rate", "48000")
+	setTestConf(t, "relay_pacing", "timestamp")
+	session := &Session{Path: "/test", Server: newTestServer()}
+	player := NewPlayer(session, newTestPusher("h264"))
+	if video, audio := player.pacer.video.clockRate, player.pacer.audio.clockRate; video != 90000 || audio != 48000 {
+		t.Errorf("pacer clock rates %d/%d, want 90000/48000", video, audio)
+	}
+	// the rates are resolved with the player, a later change of the config does not reach it
+	setTestConf(t, "video_clock_rate", "1000")
+	start := time.Now()
+	player.pacer.wait(&RTPPack{Type: RTP_TYPE_VIDEO, Buffer: bytes.NewBuffer(selfTestRTP(96, 0, 0, true, nil))})
+	player.pacer.wait(&RTPPack{Type: RTP_TYPE_VIDEO, Buffer: bytes.NewBuffer(selfTestRTP(96, 1, 900, true, nil))})
+	if elapsed := time.Since(start); elapsed < 5*time.Millisecond || elapsed > 500*time.Millisecond {
+		t.Errorf("paced 900 ticks in %v, want 10ms at 90kHz", elapsed)
+	}
+}
@@ -0,0 +1,270 @@
+package rtsp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ParameterSets caches the latest VPS(h265)/SPS/PPS of a video track, NAL header included.
+type ParameterSets struct {
+	VPS []byte
+	SPS []byte
+	PPS []byte
+}
+
+func (ps ParameterSets) Empty() bool {
+	return len(ps.SPS) == 0 || len(ps.PPS) == 0
+}
+
+// NALUnits returns the parameter sets in decoding order.
+func (ps ParameterSets) NALUnits() (nalus [][]byte) {
+	for _, nalu := range [][]byte{ps.VPS, ps.SPS, ps.PPS} {
+		if len(nalu) > 0 {
+			nalus = append(nalus, nalu)
+		}
+	}
+	return
+}
+
+type parameterSetsCache struct {
+	ParameterSets
+	lock sync.RWMutex
+	// timestamp of the last rtp packet carrying a parameter set, to tell whether a keyframe is preceded by them.
+	timestamp int
+	seen      bool
+}
+
+func (c *parameterSetsCache) get() ParameterSets {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.ParameterSets
+}
+
+// update stores nalu if it is a parameter set, returns whether it is and whether it differs from the cached one.
+func (c *parameterSetsCache) update(codec string, nalu []byte) (paramSet bool, changed bool) {
+	var dst *[]byte
+	switch NALUType(codec, nalu) {
+	case NALU_TYPE_VPS:
+		dst = &c.VPS
+	case NALU_TYPE_SPS:
+		dst = &c.SPS
+	case NALU_TYPE_PPS:
+		dst = &c.PPS
+	default:
+		return
+	}
+	c.lock.Lock()
+	if !bytes.Equal(*dst, nalu) {
+		*dst = append([]byte{}, nalu...)
+		changed = true
+	}
+	c.lock.Unlock()
+	return true, changed
+}
+
+// ValidateParameterSet checks that a VPS/SPS/PPS is plausible before it is cached: a sane NAL header,
+// a minimal length before the trailing zeros, and a parsable SPS with a picture size.
+// A truncated parameter set, left by a lost packet, fails one of them.
+func ValidateParameterSet(codec string, nalu []byte) error {
+	kind := NALUType(codec, nalu)
+	h265 := strings.EqualFold(codec, "h265")
+	minLen := map[int]int{NALU_TYPE_VPS: 17, NALU_TYPE_SPS: 4, NALU_TYPE_PPS: 2}[kind]
+	if h265 {
+		minLen = map[int]int{NALU_TYPE_VPS: 17, NALU_TYPE_SPS: 16, NALU_TYPE_PPS: 3}[kind]
+	}
+	if minLen == 0 {
+		return fmt.Errorf("%s nal unit is not a parameter set", codec)
+	}
+	if len(nalu) < minLen {
+		return fmt.Errorf("%s parameter set too short, nal header[%02x], size[%d]", codec, nalu[0], len(nalu))
+	}
+	if nalu[0]&0x80 != 0 {
+		return fmt.Errorf("%s parameter set forbidden_zero_bit set, nal header[%02x]", codec, nalu[0])
+	}
+	if h265 && nalu[1]&0x07 == 0 {
+		return fmt.Errorf("h265 parameter set nuh_temporal_id_plus1 is 0")
+	}
+	// trailing zero bytes are tolerated, some encoders pad parameter sets with them.
+	if len(bytes.TrimRight(nalu, "\x00")) < minLen {
+		return fmt.Errorf("%s parameter set too short without its trailing zeros, nal header[%02x], size[%d]", codec, nalu[0], len(nalu))
+	}
+	if kind != NALU_TYPE_SPS {
+		return nil
+	}
+	width, height := 0, 0
+	if h265 {
+		sps, err := ParseH265SPS(nalu)
+		if err != nil {
+			return err
+		}
+		width, height = sps.Width, sps.Height
+	} else {
+		sps, err := ParseH264SPS(nalu)
+		if err != nil {
+			return err
+		}
+		width, height = sps.Width, sps.Height
+	}
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("%s sps has invalid picture size %dx%d", codec, width, height)
+	}
+	return nil
+}
+
+// codec independent NAL unit kinds
+const (
+	NALU_TYPE_OTHER = iota
+	NALU_TYPE_VPS
+	NALU_TYPE_SPS
+	NALU_TYPE_PPS
+	NALU_TYPE_IDR
+	NALU_TYPE_SLICE
+	// access unit delimiter
+	NALU_TYPE_AUD
+)
+
+// NALUType classifies a NAL unit of codec h264/h265 by its header.
+func NALUType(codec string, nalu []byte) int {
+	if len(nalu) < 1 {
+		return NALU_TYPE_OTHER
+	}
+	if strings.EqualFold(codec, "h265") {
+		switch t := (nalu[0] >> 1) & 0x3F; {
+		case t == 32:
+			return NALU_TYPE_VPS
+		case t == 33:
+			return NALU_TYPE_SPS
+		case t == 34:
+			return NALU_TYPE_PPS
+		case t >= 16 && t <= 21:
+			return NALU_TYPE_IDR
+		case t <= 9:
+			return NALU_TYPE_SLICE
+		case t == 35:
+			return NALU_TYPE_AUD
+		}
+		return NALU_TYPE_OTHER
+	}
+	switch nalu[0] & 0x1F {
+	case 7:
+		return NALU_TYPE_SPS
+	case 8:
+		return NALU_TYPE_PPS
+	case 5:
+		return NALU_TYPE_IDR
+	case 1:
+		return NALU_TYPE_SLICE
+	case 9:
+		return NALU_TYPE_AUD
+	}
+	return NALU_TYPE_OTHER
+}
+
+// RTPNALUnits returns the NAL units carried by a h264/h265 rtp payload: the whole NAL unit for
+// single NAL and aggregation packets, and only the rebuilt NAL header for the first fragment of
+// a fragmentation unit. Non-first fragments return nothing.
+func RTPNALUnits(codec string, payload []byte) (nalus [][]byte) {
+	if len(payload) < 1 {
+		return
+	}
+	if strings.EqualFold(codec, "h265") {
+		if len(payload) < 3 {
+			return
+		}
+		switch (payload[0] >> 1) & 0x3F {
+		case 48:
+			return splitAggregation(payload[2:])
+		case 49:
+			if payload[2]&0x80 != 0 {
+				nalus = append(nalus, []byte{payload[0]&0x81 | (payload[2]&0x3F)<<1, payload[1]})
+			}
+			return
+		case 50:
+			return
+		}
+		return [][]byte{payload}
+	}
+	switch t := payload[0] & 0x1F; {
+	case t >= 1 && t <= 23:
+		return [][]byte{payload}
+	case t == 24:
+		return splitAggregation(payload[1:])
+	case t == 28 || t == 29:
+		if len(payload) >= 2 && payload[1]&0x80 != 0 {
+			nalus = append(nalus, []byte{payload[0]&0xE0 | payload[1]&0x1F})
+		}
+	}
+	return
+}
+
+// parameterSetsPayloads packs the parameter sets into one STAP-A/AP payload, or one single NAL
+// payload each if the aggregation would be too large.
+func parameterSetsPayloads(codec string, ps ParameterSets) (payloads [][]byte) {
+	const maxPayload = 1400
+	nalus := ps.NALUnits()
+	var payload []byte
+	if strings.EqualFold(codec, "h265") {
+		payload = []byte{48 << 1, 1}
+	} else {
+		nri := byte(0)
+		for _, nalu := range nalus {
+			if nalu[0]&0x60 > nri {
+				nri = nalu[0] & 0x60
+			}
+		}
+		payload = []byte{nri | 24}
+	}
+	size := make([]byte, 2)
+	for _, nalu := range nalus {
+		binary.BigEndian.PutUint16(size, uint16(len(nalu)))
+		payload = append(payload, size...)
+		payload = append(payload, nalu...)
+	}
+	if len(payload) <= maxPayload {
+		return [][]byte{payload}
+	}
+	return nalus
+}
+
+// rtpWithSequence returns a copy of a rtp packet with its sequence number replaced.
+func rtpWithSequence(rtpBytes []byte, seq uint16) []byte {
+	out := append([]byte{}, rtpBytes...)
+	binary.BigEndian.PutUint16(out[2:], seq)
+	return out
+}
+
+// missingParamSetsDetector tells a video track that got no parameter sets, neither in the sdp nor
+// in band, after maxPackets packets. Nothing can decode such a track.
+type missingParamSetsDetector struct {
+	maxPackets int
+	packets    int
+	missing    bool
+	lock       sync.RWMutex
+}
+
+// push counts a video packet, returns whether the missing state changed.
+func (d *missingParamSetsDetector) push(haveParamSets bool) (changed bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if haveParamSets {
+		d.packets = 0
+		changed = d.missing
+		d.missing = false
+		return
+	}
+	d.packets++
+	if !d.missing && d.packets >= d.maxPackets {
+		d.missing = true
+		changed = true
+	}
+	return
+}
+
+func (d *missingParamSetsDetector) isMissing() bool {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	return d.missing
+}
@@ -0,0 +1,103 @@
+package rtsp
+
+import (
+	"testing"
+)
+
+// stapA aggregates the NAL units in a h264 STAP-A payload.
+func stapA(nalus ...[]byte) []byte {
+	payload := []byte{0x78}
+	for _, nalu := range nalus {
+		payload = append(payload, byte(len(nalu)>>8), byte(len(nalu)))
+		payload = append(payload, nalu...)
+	}
+	return payload
+}
+
+func TestRTPNALUnitsAggregation(t *testing.T) {
+	sei := []byte{0x06, 0x05, 0x01, 0x80}
+	sps := testSPS{profile: 66, level: 30, width: 640, height: 480}.h264()
+	pps := []byte{0x68, 0xCE, 0x3C, 0x80}
+	idr := []byte{0x65, 0x88, 0x84, 0x00}
+	for _, c := range []struct {
+		name     string
+		payload  []byte
+		types    []int
+		paramSet bool
+		keyframe bool
+	}{
+		{"sei sps pps", stapA(sei, sps, pps), []int{NALU_TYPE_OTHER, NALU_TYPE_SPS, NALU_TYPE_PPS}, true, false},
+		{"sps pps idr", stapA(sps, pps, idr), []int{NALU_TYPE_SPS, NALU_TYPE_PPS, NALU_TYPE_IDR}, true, true},
+		{"sps zero sized idr", stapA(sps, nil, idr), []int{NALU_TYPE_SPS, NALU_TYPE_IDR}, true, true},
+		{"sei idr", stapA(sei, idr), []int{NALU_TYPE_OTHER, NALU_TYPE_IDR}, false, true},
+		{"truncated", stapA(sps, pps)[:len(sps)+5], []int{NALU_TYPE_SPS}, true, false},
+		{"single pps", pps, []int{NALU_TYPE_PPS}, true, false},
+	} {
+		nalus := RTPNALUnits("h264", c.payload)
+		var types []int
+		for _, nalu := range nalus {
+			types = append(types, NALUType("h264", nalu))
+		}
+		if len(types) != len(c.types) {
+			t.Errorf("%s: nal types %v, want %v", c.name, types, c.types)
+			continue
+		}
+		for i := range types {
+			if types[i] != c.types[i] {
+				t.Errorf("%s: nal types %v, want %v", c.name, types, c.types)
+				break
+			}
+		}
+
+		pusher := newTestPusher("h264")
+		pusher.psReassembler = pusher.newParamSetsReassembler("h264")
+		pack := &RTPPack{Type: RTP_TYPE_VIDEO}
+		paramSet := pusher.inspectVideo(pack, ParseRTP(selfTestRTP(96, 1, 3000, true, c.payload)))
+		if paramSet != c.paramSet || pack.Keyframe != c.keyframe {
+			t.Errorf("%s: parameter set %v keyframe %v, want %v %v", c.name, paramSet, pack.Keyframe, c.paramSet, c.keyframe)
+		}
+		if c.keyframe && c.paramSet && !pack.ParameterSetsInline {
+			t.Errorf("%s: keyframe not marked with inline parameter sets", c.name)
+		}
+	}
+}
+
+func TestMissingParamSetsDetector(t *testing.T) {
+	pusher := newTestPusher("h264")
+	pusher.psReassembler = pusher.newParamSetsReassembler("h264")
+	pusher.paramSetsMissing = &missingParamSetsDetector{maxPackets: 5}
+	slice := append([]byte{0x41}, make([]byte, 100)...)
+	sps := testSPS{profile: 66, level: 30, width: 640, height: 480}.h264()
+	pps := []byte{0x68, 0xCE, 0x3C, 0x80}
+	idr := append([]byte{0x65}, make([]byte, 100)...)
+	var changes []bool
+	push := func(nalus ...[]byte) {
+		for _, rtp := range testRTPPackets("h264", 1400, 0, 0, nalus...) {
+			pusher.inspectVideo(&RTPPack{Type: RTP_TYPE_VIDEO}, rtp)
+			if pusher.paramSetsMissing.push(!pusher.ParameterSets().Empty()) {
+				changes = append(changes, pusher.ParameterSetsMissing())
+			}
+		}
+	}
+	push(slice, slice, slice, slice)
+	if pusher.ParameterSetsMissing() {
+		t.Fatal("missing before param_sets_missing_packets packets")
+	}
+	push(slice)
+	if !pusher.ParameterSetsMissing() {
+		t.Fatal("not missing after param_sets_missing_packets packets")
+	}
+	push(slice, slice, slice, slice, slice, slice)
+	push(sps, pps, idr)
+	if pusher.ParameterSetsMissing() {
+		t.Error("still missing after an in band sps/pps")
+	}
+	push(slice, slice, slice, slice, slice, slice)
+	// reported once when found missing, once when they came
+	if len(changes) != 2 || !changes[0] || changes[1] {
+		t.Errorf("changes %v, want [true false]", changes)
+	}
+	if (&Pusher{}).ParameterSetsMissing() {
+		t.Error("missing with the detection off")
+	}
+}
@@ -1,6 +1,8 @@
 package rtsp
 
 import (
+	"bytes"
+	"encoding/binary"
 	"sync"
 	"time"
 )
@@ -10,6 +12,26 @@ type Player struct {
 	Pusher *Pusher
 	cond   *sync.Cond
 	queue  []*RTPPack
+
+	// LowLatency skips the gop cache on join, relaying from the live edge even mid-GOP.
+	LowLatency bool
+	// PinParameterSets sends the cached parameter sets before every keyframe that lacks them.
+	PinParameterSets bool
+	// drops the video temporal layers above the cap the player asked for, nil for all layers
+	temporalLayers *temporalLayerFilter
+	// the largest rtp packet the player asked for in its Blocksize header, 0 for no limit
+	Blocksize int
+	// added to the video sequence numbers for the packets injected so far
+	vSeqOffset uint16
+	// bytes sent to the player, nil if player_bitrate_enable is off
+	egressRate *RateMeter
+	// nil unless egress_bandwidth_limit is set
+	egressShaper *egressShaper
+	// Pacing is immediate to relay packets as they come, or timestamp to space them by their rtp timestamps.
+	Pacing string
+	pacer  rtpPacer
+	// nil unless padding_min_packet_rate is set
+	padder *rtpPadder
 }
 
 func NewPlayer(session *Session, pusher *Pusher) (player *Player) {
@@ -18,6 +40,21 @@ func NewPlayer(session *Session, pusher *Pusher) (player *Player) {
 		Pusher:  pusher,
 		cond:    sync.NewCond(&sync.Mutex{}),
 		queue:   make([]*RTPPack, 0),
+		Pacing:  parsePacing(ChannelKey(session.Path, "relay_pacing").MustString(PacingImmediate)),
+		padder:  newRTPPadder(session.Path),
+	}
+	if ChannelKey(session.Path, "player_bitrate_enable").MustBool(false) {
+		player.egressRate = NewRateMeter(ChannelKey(session.Path, "player_bitrate_window").MustInt(5))
+	}
+	if player.Pacing == PacingTimestamp {
+		player.pacer = newRTPPacer(pusher)
+	}
+	if scheduler := session.Server.egress; scheduler != nil {
+		scheduler.join(session.Path)
+		player.egressShaper = newEgressShaper(scheduler, session.Path)
+		session.StopHandles = append(session.StopHandles, func() {
+			scheduler.leave(session.Path)
+		})
 	}
 	session.StopHandles = append(session.StopHandles, func() {
 		pusher.RemovePlayer(player)
@@ -42,6 +79,9 @@ func (player *Player) QueueRTP(pack *RTPPack) *Player {
 func (player *Player) Start() {
 	logger := player.logger
 	timer := time.Unix(0, 0)
+	if player.padder != nil {
+		player.Pusher.Go(player.padIdle)
+	}
 	for !player.Stoped {
 		var pack *RTPPack
 		player.cond.L.Lock()
@@ -59,8 +99,22 @@ func (player *Player) Start() {
 			}
 			continue
 		}
-		if err := player.SendRTP(pack); err != nil {
+		if pack.padding {
+			if err := player.sendPadding(); err != nil {
+				logger.Println(err)
+			}
+			continue
+		}
+		if player.egressShaper != nil && !player.egressShaper.allow(pack) {
+			continue
+		}
+		if player.Pacing == PacingTimestamp {
+			player.pacer.wait(pack)
+		}
+		if err := player.sendRTP(pack); err != nil {
 			logger.Println(err)
+		} else if player.egressRate != nil {
+			player.egressRate.Add(pack.Buffer.Len())
 		}
 		elapsed := time.Now().Sub(timer)
 		if elapsed >= 30*time.Second {
@@ -69,3 +123,72 @@ func (player *Player) Start() {
 		}
 	}
 }
+
+// EgressBitRate returns the bits per second sent to the player, -1 if player_bitrate_enable is off.
+func (player *Player) EgressBitRate() int {
+	if player.egressRate == nil {
+		return -1
+	}
+	return player.egressRate.BitRate()
+}
+
+func (player *Player) sendRTP(pack *RTPPack) (err error) {
+	rtpBytes := pack.Buffer.Bytes()
+	if player.padder != nil && pack.Type == RTP_TYPE_VIDEO && len(rtpBytes) >= RTP_FIXED_HEADER_LENGTH {
+		player.padder.videoSent(rtpBytes)
+	}
+	if (!player.PinParameterSets && player.Blocksize == 0 && player.temporalLayers == nil && player.padder == nil) || pack.Type != RTP_TYPE_VIDEO || len(rtpBytes) < RTP_FIXED_HEADER_LENGTH {
+		return player.SendRTP(pack)
+	}
+	seq := binary.BigEndian.Uint16(rtpBytes[2:])
+	if player.temporalLayers != nil {
+		if rtp := ParseRTP(rtpBytes); rtp != nil && player.temporalLayers.drop(rtp.Payload) {
+			// later packets close the gap in sequence numbers.
+			player.vSeqOffset--
+			return
+		}
+	}
+	if player.PinParameterSets && pack.Keyframe && !pack.ParameterSetsInline {
+		ps := player.Pusher.ParameterSets()
+		if !ps.Empty() {
+			for _, payload := range parameterSetsPayloads(player.VCodec, ps) {
+				header := append([]byte{}, rtpBytes[:RTP_FIXED_HEADER_LENGTH]...)
+				header[0] &= 0xC0 // no padding, extension or csrc
+				header[1] &= 0x7F // no marker
+				if err = player.sendVideoRTP(&RTPPack{Type: RTP_TYPE_VIDEO, Buffer: bytes.NewBuffer(append(header, payload...))}, seq); err != nil {
+					return
+				}
+				player.vSeqOffset++
+			}
+		}
+	}
+	return player.sendVideoRTP(pack, seq)
+}
+
+// sendVideoRTP sends pack with the sequence number seq shifted by the packets injected so far,
+// fragmented to the Blocksize of the player.
+func (player *Player) sendVideoRTP(pack *RTPPack, seq uint16) (err error) {
+	rtpBytes := pack.Buffer.Bytes()
+	if player.Blocksize > 0 {
+		if frags := fragmentRTP(player.VCodec, rtpBytes, player.Blocksize); frags != nil {
+			for i, frag := range frags {
+				if i > 0 {
+					player.vSeqOffset++
+				}
+				binary.BigEndian.PutUint16(frag[2:], seq+player.vSeqOffset)
+				if err = player.SendRTP(&RTPPack{Type: pack.Type, Buffer: bytes.NewBuffer(frag), Keyframe: pack.Keyframe && i == 0}); err != nil {
+					return
+				}
+			}
+			return
+		}
+	}
+	if player.vSeqOffset != 0 {
+		pack = &RTPPack{
+			Type:     pack.Type,
+			Buffer:   bytes.NewBuffer(rtpWithSequence(rtpBytes, seq+player.vSeqOffset)),
+			Keyframe: pack.Keyframe,
+		}
+	}
+	return player.SendRTP(pack)
+}
@@ -0,0 +1,98 @@
+package rtsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log"
+	"testing"
+)
+
+// readInterleaved splits what was sent to a tcp player into its rtp packets.
+func readInterleaved(t *testing.T, b []byte) (packets [][]byte) {
+	for len(b) > 0 {
+		if len(b) < 4 || b[0] != 0x24 {
+			t.Fatalf("not an interleaved frame: % x", b[:4])
+		}
+		n := int(binary.BigEndian.Uint16(b[2:]))
+		packets, b = append(packets, b[4:4+n]), b[4+n:]
+	}
+	return
+}
+
+func TestPlayerPinParameterSets(t *testing.T) {
+	sps := testSPS{profile: 66, level: 30, width: 640, height: 480}.h264()
+	pps := []byte{0x68, 0xCE, 0x3C, 0x80}
+	idr := append([]byte{0x65}, bytes.Repeat([]byte{0x88}, 60)...)
+	slice := []byte{0x41, 0x9A, 0x02}
+	for _, blocksize := range []int{0, RTP_FIXED_HEADER_LENGTH + 40} {
+		var out bytes.Buffer
+		session := &Session{ID: "player", Path: "/test", VCodec: "h264", TransType: TRANS_TYPE_TCP,
+			vRTPChannel: 0, vRTPControlChannel: 1, aRTPChannel: -1, aRTPControlChannel: -1, logger: log.New(io.Discard, "", 0)}
+		session.connRW = bufio.NewReadWriter(bufio.NewReader(bytes.NewReader(nil)), bufio.NewWriter(&out))
+		pusher := newTestPusher("h264")
+		pusher.paramSets.update("h264", sps)
+		pusher.paramSets.update("h264", pps)
+		player := &Player{Session: session, Pusher: pusher, PinParameterSets: true, Blocksize: blocksize}
+
+		seq := uint16(0xFFFE)
+		for i, p := range []struct {
+			payload []byte
+			pack    RTPPack
+		}{
+			{idr, RTPPack{Keyframe: true}},
+			{slice, RTPPack{}},
+			{slice, RTPPack{}},
+			{idr, RTPPack{Keyframe: true}},
+			// a keyframe carrying its own parameter sets gets none pinned
+			{stapA(sps, pps, idr[:4]), RTPPack{Keyframe: true, ParameterSetsInline: true}},
+			{slice, RTPPack{}},
+		} {
+			pack := p.pack
+			pack.Type = RTP_TYPE_VIDEO
+			pack.Buffer = bytes.NewBuffer(selfTestRTP(96, seq+uint16(i), uint32(i*3600), true, p.payload))
+			if err := player.sendRTP(&pack); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		packets := readInterleaved(t, out.Bytes())
+		pinned, keyframes := 0, 0
+		for i, rtp := range packets {
+			if got := binary.BigEndian.Uint16(rtp[2:]); got != seq+uint16(i) {
+				t.Errorf("blocksize %d: packet %d seq %d, want %d", blocksize, i, got, seq+uint16(i))
+			}
+			if blocksize > 0 && len(rtp) > blocksize {
+				t.Errorf("blocksize %d: packet %d of %d bytes", blocksize, i, len(rtp))
+			}
+			payload := rtp[RTP_FIXED_HEADER_LENGTH:]
+			naluType := payload[0] & 0x1F
+			if naluType == 28 && payload[1]&0x80 != 0 {
+				// the first fragment of a FU-A
+				naluType = payload[1] & 0x1F
+			}
+			switch naluType {
+			case 24:
+				var types []int
+				for _, nalu := range RTPNALUnits("h264", payload) {
+					types = append(types, NALUType("h264", nalu))
+				}
+				if len(types) == 2 && types[0] == NALU_TYPE_SPS && types[1] == NALU_TYPE_PPS {
+					pinned++
+					if rtp[1]&0x80 != 0 {
+						t.Errorf("blocksize %d: pinned parameter sets with the marker bit", blocksize)
+					}
+				}
+			case 5:
+				keyframes++
+				if i == 0 || packets[i-1][RTP_FIXED_HEADER_LENGTH]&0x1F != 24 {
+					t.Errorf("blocksize %d: keyframe %d not preceded by the parameter sets", blocksize, keyframes)
+				}
+			}
+		}
+		if pinned != 2 || keyframes != 2 {
+			t.Errorf("blocksize %d: %d parameter sets pinned before %d keyframes, want 2 before 2", blocksize, pinned, keyframes)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package rtsp
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/penggy/EasyGoLib/utils"
+)
+
+// PullSourceLimit returns max_pull_sources, the server wide cap of pull sources, 0 for no cap.
+func (server *Server) PullSourceLimit() int {
+	return utils.Conf().Section("rtsp").Key("max_pull_sources").MustInt(0)
+}
+
+// PullSourceCount returns the number of active pull sources, the ffmpeg fallbacks included: they
+// push back in, their pushers are sessions.
+func (server *Server) PullSourceCount() (count int) {
+	for _, pusher := range server.GetPushers() {
+		if pusher.RTSPClient != nil {
+			count++
+		}
+	}
+	if server.ffmpegFallback != nil {
+		count += server.ffmpegFallback.Count()
+	}
+	return
+}
+
+// PullSourcesRejected returns how many pulls were rejected by the cap.
+func (server *Server) PullSourcesRejected() int {
+	server.pullLock.Lock()
+	defer server.pullLock.Unlock()
+	return server.pullRejected
+}
+
+// AcquirePullSlot reserves a slot for a new pull source of priority against max_pull_sources. When
+// the cap is reached and pull_evict_idle is on, an idle (without players) pull source of a lower
+// priority is evicted, the lowest priority first and then the one idle for the longest time;
+// otherwise the pull is rejected. The slot stays reserved, so that concurrent pulls do not all pass
+// the cap, until release is called, once the pull source is added to the server or failed to start.
+func (server *Server) AcquirePullSlot(priority int) (release func(), err error) {
+	limit := server.PullSourceLimit()
+	if limit <= 0 {
+		return func() {}, nil
+	}
+	server.pullLock.Lock()
+	defer server.pullLock.Unlock()
+	reserve := func() func() {
+		server.pullReserved++
+		var once sync.Once
+		return func() {
+			once.Do(func() {
+				server.pullLock.Lock()
+				server.pullReserved--
+				server.pullLock.Unlock()
+			})
+		}
+	}
+	if server.PullSourceCount()+server.pullReserved < limit {
+		return reserve(), nil
+	}
+	if utils.Conf().Section("rtsp").Key("pull_evict_idle").MustBool(false) {
+		var victim *Pusher
+		var victimIdle time.Time
+		victimPriority := priority
+		for _, pusher := range server.GetPushers() {
+			if pusher.RTSPClient == nil {
+				continue
+			}
+			idle, ok := pusher.IdleSince()
+			p := pusher.Priority()
+			if !ok || p >= priority {
+				continue
+			}
+			if victim == nil || p < victimPriority || p == victimPriority && idle.Before(victimIdle) {
+				victim, victimIdle, victimPriority = pusher, idle, p
+			}
+		}
+		if victim != nil {
+			server.logger.Printf("pull source limit[%d] reached, evict %v of priority[%d] idle since %v", limit, victim, victimPriority, utils.DateTime(victimIdle))
+			// the stop handles of the client remove it from the server.
+			victim.Stop()
+			return reserve(), nil
+		}
+	}
+	server.pullRejected++
+	return nil, fmt.Errorf("pull source limit[%d] reached", limit)
+}
@@ -0,0 +1,100 @@
+package rtsp
+
+import (
+	"io"
+	"log"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/penggy/EasyGoLib/utils"
+)
+
+// setTestConf sets a key of the rtsp section for the test.
+func setTestConf(t *testing.T, key string, value string) {
+	sec := utils.Conf().Section("rtsp")
+	had, old := sec.HasKey(key), sec.Key(key).String()
+	sec.Key(key).SetValue(value)
+	t.Cleanup(func() {
+		if had {
+			sec.Key(key).SetValue(old)
+		} else {
+			sec.DeleteKey(key)
+		}
+	})
+}
+
+func newTestServer() *Server {
+	return &Server{
+		SessionLogger:  SessionLogger{log.New(io.Discard, "", 0)},
+		pushers:        make(map[string]*Pusher),
+		addPusherCh:    make(chan *Pusher, 16),
+		removePusherCh: make(chan *Pusher, 16),
+		transcodes:     make(map[string]*exec.Cmd),
+	}
+}
+
+// addTestPullSource adds a pull source of priority, idle since idle.
+func addTestPullSource(server *Server, path string, priority int, idle time.Time) *Pusher {
+	client := &RTSPClient{Server: server, Path: path, ID: path, Priority: priority, StartAt: idle}
+	client.logger = log.New(io.Discard, "", 0)
+	pusher := NewClientPusher(client)
+	server.pushers[path] = pusher
+	return pusher
+}
+
+func TestAcquirePullSlotReserves(t *testing.T) {
+	setTestConf(t, "max_pull_sources", "3")
+	server := newTestServer()
+	addTestPullSource(server, "/a", 0, time.Now())
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var releases []func()
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if release, err := server.AcquirePullSlot(0); err == nil {
+				lock.Lock()
+				releases = append(releases, release)
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(releases) != 2 || server.PullSourcesRejected() != 8 {
+		t.Fatalf("%d slots acquired and %d rejected, want 2 and 8", len(releases), server.PullSourcesRejected())
+	}
+	// a pull failing to start gives its slot back, once.
+	releases[0]()
+	releases[0]()
+	if _, err := server.AcquirePullSlot(0); err != nil {
+		t.Errorf("released slot not available: %v", err)
+	}
+	if _, err := server.AcquirePullSlot(0); err == nil {
+		t.Errorf("slot acquired over the cap")
+	}
+}
+
+func TestAcquirePullSlotEvicts(t *testing.T) {
+	setTestConf(t, "max_pull_sources", "3")
+	setTestConf(t, "pull_evict_idle", "true")
+	server := newTestServer()
+	now := time.Now()
+	addTestPullSource(server, "/high", 5, now.Add(-time.Hour))
+	older := addTestPullSource(server, "/older", 1, now.Add(-time.Hour))
+	addTestPullSource(server, "/newer", 1, now.Add(-time.Minute))
+	if _, err := server.AcquirePullSlot(1); err == nil {
+		t.Fatalf("pull evicted a source of the same priority")
+	}
+	if _, err := server.AcquirePullSlot(3); err != nil {
+		t.Fatalf("pull of a higher priority rejected: %v", err)
+	}
+	if !older.RTSPClient.Stoped || server.GetPusher("/older") != nil {
+		t.Errorf("the source idle the longest was not evicted")
+	}
+	if server.PullSourceCount() != 2 {
+		t.Errorf("%d pull sources left, want 2", server.PullSourceCount())
+	}
+}
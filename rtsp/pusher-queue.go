@@ -0,0 +1,113 @@
+package rtsp
+
+import (
+	"strings"
+)
+
+type QueueStats struct {
+	Limit     int `json:"limit"`
+	HighWater int `json:"highWater"`
+	Dropped   int `json:"dropped"`
+}
+
+// enqueue appends pack to the receive queue, caller holds pusher.cond.L.
+// When the queue is full (pusher_queue_limit of the channel), the queued packets are dropped up to the
+// next keyframe, or all of them if there is none, in which case video waits for the next keyframe.
+func (pusher *Pusher) enqueue(pack *RTPPack) {
+	if pusher.queueStats.Limit < 0 {
+		pusher.queueStats.Limit = ChannelKey(pusher.Path(), "pusher_queue_limit").MustInt(0)
+	}
+	if pusher.queueWaitKeyframe && pack.Type == RTP_TYPE_VIDEO {
+		if !pusher.startsKeyframe(pack) {
+			pusher.queueStats.Dropped++
+			return
+		}
+		pusher.queueWaitKeyframe = false
+	}
+	if limit := pusher.queueStats.Limit; limit > 0 && len(pusher.queue) >= limit {
+		next := pusher.queueTrimIndex()
+		if next < 0 {
+			// video dropped mid gop waits for a keyframe, even when an audio packet made the room.
+			droppedVideo := false
+			for _, queued := range pusher.queue {
+				droppedVideo = droppedVideo || queued.Type == RTP_TYPE_VIDEO
+			}
+			pusher.queueStats.Dropped += len(pusher.queue)
+			pusher.queue = make([]*RTPPack, 0)
+			if pack.Type == RTP_TYPE_VIDEO {
+				pusher.queueWaitKeyframe = !pusher.startsKeyframe(pack)
+			} else {
+				pusher.queueWaitKeyframe = droppedVideo
+			}
+			if pusher.queueWaitKeyframe && pack.Type == RTP_TYPE_VIDEO {
+				pusher.queueStats.Dropped++
+				return
+			}
+		} else {
+			pusher.queueStats.Dropped += next
+			pusher.queue = pusher.queue[next:]
+		}
+		pusher.Logger().Printf("pusher queue full, limit[%d], dropped[%d] in total", limit, pusher.queueStats.Dropped)
+	}
+	pusher.queue = append(pusher.queue, pack)
+	if len(pusher.queue) > pusher.queueStats.HighWater {
+		pusher.queueStats.HighWater = len(pusher.queue)
+	}
+}
+
+// queueTrimIndex returns the index the full queue is trimmed to, -1 to drop it all. It is the next
+// keyframe when there is video, and for an audio-only channel the next audio frame, told apart by
+// the timestamp, as audio has no keyframe to wait for.
+func (pusher *Pusher) queueTrimIndex() int {
+	if !pusher.HasVideo() {
+		ts := -1
+		for i, pack := range pusher.queue {
+			rtp := ParseRTP(pack.Buffer.Bytes())
+			if pack.Type != RTP_TYPE_AUDIO || rtp == nil || rtp.Keepalive {
+				continue
+			}
+			if ts >= 0 && rtp.Timestamp != ts {
+				return i
+			}
+			ts = rtp.Timestamp
+		}
+		return -1
+	}
+	for i := 1; i < len(pusher.queue); i++ {
+		if pusher.queue[i].Type == RTP_TYPE_VIDEO && pusher.startsKeyframe(pusher.queue[i]) {
+			return i
+		}
+	}
+	return -1
+}
+
+// startsKeyframe tells whether a video packet carries a parameter set or the start of an IDR.
+// Packets of codecs other than h264/h265 are all taken as keyframes.
+func (pusher *Pusher) startsKeyframe(pack *RTPPack) bool {
+	codec := pusher.VCodec()
+	if !strings.EqualFold(codec, "h264") && !strings.EqualFold(codec, "h265") {
+		return true
+	}
+	rtp := ParseRTP(pack.Buffer.Bytes())
+	if rtp == nil {
+		return false
+	}
+	for _, nalu := range RTPNALUnits(codec, rtp.Payload) {
+		switch NALUType(codec, nalu) {
+		case NALU_TYPE_IDR, NALU_TYPE_VPS, NALU_TYPE_SPS:
+			return true
+		}
+	}
+	return false
+}
+
+// QueueStats returns the receive queue stats, limit is 0 when the queue is unbounded.
+func (pusher *Pusher) QueueStats() QueueStats {
+	pusher.cond.L.Lock()
+	defer pusher.cond.L.Unlock()
+	stats := pusher.queueStats
+	if stats.Limit < 0 {
+		stats.Limit = 0
+	}
+	return stats
+}
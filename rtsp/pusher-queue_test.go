@@ -0,0 +1,62 @@
+package rtsp
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func TestPusherQueueWaitsKeyframe(t *testing.T) {
+	pack := func(t RTPType, payload ...byte) *RTPPack {
+		return &RTPPack{Type: t, Buffer: bytes.NewBuffer(selfTestRTP(96, 0, 0, false, payload))}
+	}
+	slice, idr, audio := []byte{0x41, 1}, []byte{0x65, 1}, []byte{0x21, 1}
+	for _, c := range []struct {
+		name string
+		// the packet arriving at the full queue, then the ones after it
+		packs []*RTPPack
+		// how many of packs are queued
+		want int
+	}{
+		{"audio drops video", []*RTPPack{pack(RTP_TYPE_AUDIO, audio...), pack(RTP_TYPE_VIDEO, slice...), pack(RTP_TYPE_AUDIO, audio...), pack(RTP_TYPE_VIDEO, idr...)}, 3},
+		{"video drops video", []*RTPPack{pack(RTP_TYPE_VIDEO, slice...), pack(RTP_TYPE_AUDIO, audio...), pack(RTP_TYPE_VIDEO, idr...)}, 2},
+		{"keyframe drops video", []*RTPPack{pack(RTP_TYPE_VIDEO, idr...), pack(RTP_TYPE_VIDEO, slice...)}, 2},
+	} {
+		pusher := newTestPusher("h264")
+		pusher.Session.SDPMap = map[string]*SDPInfo{"video": {}, "audio": {}}
+		pusher.cond = sync.NewCond(&sync.Mutex{})
+		pusher.queueStats.Limit = 3
+		// a full queue with no keyframe to trim to
+		for i := 0; i < 3; i++ {
+			pusher.enqueue(pack(RTP_TYPE_VIDEO, slice...))
+		}
+		for _, p := range c.packs {
+			pusher.enqueue(p)
+		}
+		if len(pusher.queue) != c.want {
+			t.Errorf("%s: %d queued, want %d", c.name, len(pusher.queue), c.want)
+		}
+	}
+}
+
+func TestAudioOnlyQueueTrim(t *testing.T) {
+	pusher := newTestPusher("")
+	pusher.Session.SDPRaw = testSDPHead + testAudioSDP
+	pusher.cond = sync.NewCond(&sync.Mutex{})
+	pusher.queueStats.Limit = 3
+	// a frame of two packets, then a frame of one
+	pusher.enqueue(testAudioPack(0, 0))
+	pusher.enqueue(testAudioPack(1, 0))
+	pusher.enqueue(testAudioPack(2, 1024))
+	pusher.enqueue(testAudioPack(3, 2048))
+	if len(pusher.queue) != 2 || pusher.queueStats.Dropped != 2 {
+		t.Errorf("full queue trimmed to %d, dropped %d, want the first frame dropped", len(pusher.queue), pusher.queueStats.Dropped)
+	}
+	if pusher.queueWaitKeyframe {
+		t.Error("audio-only queue waits for a keyframe")
+	}
+	pusher.enqueue(testAudioPack(4, 3072))
+	if len(pusher.queue) != 3 {
+		t.Errorf("%d queued after the trim, want 3", len(pusher.queue))
+	}
+}
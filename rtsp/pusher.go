@@ -1,6 +1,7 @@
 package rtsp
 
 import (
+	"encoding/hex"
 	"log"
 	"strings"
 	"sync"
@@ -12,15 +13,90 @@ import (
 type Pusher struct {
 	*Session
 	*RTSPClient
-	players           map[string]*Player //SessionID <-> Player
-	playersLock       sync.RWMutex
-	gopCacheEnable    bool
-	gopCache          []*RTPPack
-	gopCacheLock      sync.RWMutex
-	UDPServer         *UDPServer
-	spsppsInSTAPaPack bool
-	cond              *sync.Cond
-	queue             []*RTPPack
+	players        map[string]*Player //SessionID <-> Player
+	playersLock    sync.RWMutex
+	gopCacheEnable bool
+	gopCache       []*RTPPack
+	gopCacheLock   sync.RWMutex
+	// capacity of the gop cache, set by adaptive_buffers_enable, 0 for no limit. A gop larger is
+	// not cached, gopCacheFull until the next gop starts.
+	gopCacheCap  int
+	gopCacheFull bool
+	bufferSizer  *bufferSizer
+	UDPServer    *UDPServer
+	gopStart     *gopStartDetector
+	cond         *sync.Cond
+	queue        []*RTPPack
+	// set with cond.L held when the source stops, the queue loop of Start ends on it
+	queueClosed bool
+
+	queueStats        QueueStats
+	queueWaitKeyframe bool
+
+	fuReassembler *FUReassembler
+	// rebuilds the parameter sets and sei sent in fragmentation units, nil unless h264/h265
+	psReassembler *FUReassembler
+	reorderMeter  *FrameReorderMeter
+	naluStats     *naluStats
+	// nil unless sei_extract_enable is on
+	sei       *SEIExtractor
+	frameRate *FrameRateMeter
+
+	// made on the first packet if reorder_buffer_depth is set, under cond.L
+	reorderConfigured bool
+	vReorderBuffer    *ReorderBuffer
+	aReorderBuffer    *ReorderBuffer
+
+	paramSets        parameterSetsCache
+	paramSetsMissing *missingParamSetsDetector
+	// param_sets_validate and log_parameter_sets of the channel
+	paramSetsValidate bool
+	paramSetsLog      bool
+	// an in-band sps came, the first one is not taken as a change from the sdp one
+	inBandSPS             bool
+	lastKeyframeTimestamp int
+
+	vDepacketizer         *trackDepacketizer
+	aDepacketizer         *trackDepacketizer
+	accessUnitHandles     []AccessUnitHandle
+	accessUnitHandlesLock sync.RWMutex
+
+	lastRTP lastRTPPositions
+
+	audioDisabled     bool
+	audioDisabledOnce sync.Once
+
+	// nil unless clock_rate_check is on
+	vClockRate *clockRateEstimator
+	aClockRate *clockRateEstimator
+
+	// rtcp_sr_ignore of the channel, and the last arrivals it maps rtp timestamps to
+	srIgnore bool
+	vArrival arrivalClock
+	aArrival arrivalClock
+
+	// nil unless ts_record_enable is on
+	tsRecorder *TSRecorder
+	// nil unless min_keyframes_live is set
+	liveGate *liveGate
+
+	// nil unless max_keyframe_gap is set
+	keyframeGap *keyframeGapPolicy
+
+	ssrc     *ssrcFilters
+	ssrcOnce sync.Once
+
+	timestampGuard     *timestampGuards
+	timestampGuardOnce sync.Once
+
+	comfortNoise     bool
+	comfortNoiseLock sync.RWMutex
+
+	goroutines     int
+	goroutinesLock sync.Mutex
+
+	// when the last player left, guarded by playersLock
+	lastPlayerAt time.Time
 }
 
 func (pusher *Pusher) String() string {
@@ -122,7 +198,7 @@ func (pusher *Pusher) InBytes() int {
 	if pusher.Session != nil {
 		return pusher.Session.InBytes
 	}
-	return pusher.RTSPClient.InBytes
+	return pusher.RTSPClient.TotalInBytes()
 }
 
 func (pusher *Pusher) OutBytes() int {
@@ -153,6 +229,132 @@ func (pusher *Pusher) Source() string {
 	return pusher.RTSPClient.URL
 }
 
+// Priority returns the priority of the source, higher ones are dropped last under resource pressure.
+// It is the priority given to a pull, or the priority config of the channel.
+func (pusher *Pusher) Priority() int {
+	if pusher.RTSPClient != nil && pusher.RTSPClient.Priority != 0 {
+		return pusher.RTSPClient.Priority
+	}
+	return ChannelKey(pusher.Path(), "priority").MustInt(0)
+}
+
+func (pusher *Pusher) SDPMap() map[string]*SDPInfo {
+	if pusher.Session != nil && pusher.Session.SDPMap != nil {
+		return pusher.Session.SDPMap
+	}
+	return ParseSDP(pusher.SDPRaw())
+}
+
+// Go runs f on a goroutine accounted to the channel. A channel is expected to run one goroutine per
+// player plus a few of its own (goroutine_warn_margin), exceeding that is logged as a likely leak.
+func (pusher *Pusher) Go(f func()) {
+	pusher.goroutinesLock.Lock()
+	pusher.goroutines++
+	count := pusher.goroutines
+	pusher.goroutinesLock.Unlock()
+	if bound := len(pusher.GetPlayers()) + ChannelKey(pusher.Path(), "goroutine_warn_margin").MustInt(8); count > bound {
+		pusher.Logger().Printf("goroutines[%d] exceeds expected bound[%d], may be leaking", count, bound)
+	}
+	go func() {
+		defer func() {
+			pusher.goroutinesLock.Lock()
+			pusher.goroutines--
+			pusher.goroutinesLock.Unlock()
+		}()
+		f()
+	}()
+}
+
+// Goroutines returns the number of running goroutines accounted to the channel.
+func (pusher *Pusher) Goroutines() int {
+	pusher.goroutinesLock.Lock()
+	defer pusher.goroutinesLock.Unlock()
+	return pusher.goroutines
+}
+
+// HasVideo tells whether the sdp of the pusher has a video track.
+func (pusher *Pusher) HasVideo() bool {
+	_, ok := pusher.SDPMap()["video"]
+	return ok
+}
+
+// HasAudio tells whether the sdp of the pusher has an audio track.
+func (pusher *Pusher) HasAudio() bool {
+	if pusher.AudioDisabled() {
+		return false
+	}
+	_, ok := pusher.SDPMap()["audio"]
+	return ok
+}
+
+// AudioDisabled tells whether audio_disable is on for the channel: its audio is dropped on
+// arrival and left out of the sdp served to players and recorders.
+func (pusher *Pusher) AudioDisabled() bool {
+	pusher.audioDisabledOnce.Do(func() {
+		pusher.audioDisabled = ChannelKey(pusher.Path(), "audio_disable").MustBool(false)
+	})
+	return pusher.audioDisabled
+}
+
+// MisroutedPackets returns the count of udp packets the source sent to the rtcp port of their
+// track while being rtp, or the reverse, 0 for tcp.
+func (pusher *Pusher) MisroutedPackets() int {
+	udpServer := pusher.UDPServer
+	if pusher.RTSPClient != nil {
+		udpServer = pusher.RTSPClient.UDPServer
+	}
+	if udpServer == nil {
+		return 0
+	}
+	return udpServer.Misrouted()
+}
+
+// PicTiming returns the last h264 pic_timing SEI of the video, nil if none or sei_extract_enable is off.
+func (pusher *Pusher) PicTiming() *H264PicTiming {
+	if pusher.sei == nil {
+		return nil
+	}
+	return pusher.sei.PicTiming()
+}
+
+// FieldOrder returns the field order of the video by its pic_timing SEI: "tff", "bff",
+// "progressive", or "" if unknown.
+func (pusher *Pusher) FieldOrder() string {
+	if timing := pusher.PicTiming(); timing != nil {
+		return timing.FieldOrder()
+	}
+	return ""
+}
+
+// ParameterSets returns the latest parameter sets seen in sdp or in band.
+func (pusher *Pusher) ParameterSets() ParameterSets {
+	return pusher.paramSets.get()
+}
+
+// ParameterSetsMissing tells whether the video got no SPS/PPS at all, see param_sets_missing_packets.
+func (pusher *Pusher) ParameterSetsMissing() bool {
+	return pusher.paramSetsMissing != nil && pusher.paramSetsMissing.isMissing()
+}
+
+// H264SPS returns the parsed sps of the sprop-parameter-sets in sdp, nil if not found.
+func (pusher *Pusher) H264SPS() *H264SPS {
+	if !strings.EqualFold(pusher.VCodec(), "h264") {
+		return nil
+	}
+	sdp, ok := pusher.SDPMap()["video"]
+	if !ok {
+		return nil
+	}
+	for _, nalu := range sdp.SpropParameterSets {
+		if len(nalu) > 0 && nalu[0]&0x1F == 7 {
+			if sps, err := ParseH264SPS(nalu); err == nil {
+				return sps
+			}
+		}
+	}
+	return nil
+}
+
 func NewClientPusher(client *RTSPClient) (pusher *Pusher) {
 	pusher = &Pusher{
 		RTSPClient:     client,
@@ -163,14 +365,19 @@ func NewClientPusher(client *RTSPClient) (pusher *Pusher) {
 
 		cond:  sync.NewCond(&sync.Mutex{}),
 		queue: make([]*RTPPack, 0),
+
+		queueStats: QueueStats{Limit: -1},
+
+		lastKeyframeTimestamp: -1,
 	}
+	client.pusher = pusher
 	client.RTPHandles = append(client.RTPHandles, func(pack *RTPPack) {
 		pusher.QueueRTP(pack)
 	})
 	client.StopHandles = append(client.StopHandles, func() {
 		pusher.ClearPlayer()
 		pusher.Server().RemovePusher(pusher)
-		pusher.cond.Broadcast()
+		pusher.closeQueue()
 	})
 	return
 }
@@ -185,6 +392,10 @@ func NewPusher(session *Session) (pusher *Pusher) {
 
 		cond:  sync.NewCond(&sync.Mutex{}),
 		queue: make([]*RTPPack, 0),
+
+		queueStats: QueueStats{Limit: -1},
+
+		lastKeyframeTimestamp: -1,
 	}
 	pusher.bindSession(session)
 	return
@@ -206,7 +417,7 @@ func (pusher *Pusher) bindSession(session *Session) {
 		}
 		pusher.ClearPlayer()
 		pusher.Server().RemovePusher(pusher)
-		pusher.cond.Broadcast()
+		pusher.closeQueue()
 		if pusher.UDPServer != nil {
 			pusher.UDPServer.Stop()
 			pusher.UDPServer = nil
@@ -246,45 +457,438 @@ func (pusher *Pusher) RebindClient(client *RTSPClient) bool {
 }
 
 func (pusher *Pusher) QueueRTP(pack *RTPPack) *Pusher {
+	if (pack.Type == RTP_TYPE_AUDIO || pack.Type == RTP_TYPE_AUDIOCONTROL) && pusher.AudioDisabled() {
+		return pusher
+	}
+	if !pusher.filterSSRC(pack) {
+		return pusher
+	}
+	if !pusher.guardTimestamp(pack) {
+		return pusher
+	}
 	pusher.cond.L.Lock()
-	pusher.queue = append(pusher.queue, pack)
+	if buffer := pusher.reorderBuffer(pack.Type); buffer != nil {
+		for _, pack := range buffer.Push(pack) {
+			pusher.enqueue(pack)
+		}
+	} else {
+		pusher.enqueue(pack)
+	}
 	pusher.cond.Signal()
 	pusher.cond.L.Unlock()
 	return pusher
 }
 
+// reorderBuffer returns the reorder buffer of a media track, nil when reordering is off or bypassed,
+// caller holds cond.L. TCP (and unix socket) sources are neither lossy nor reordered, so they bypass
+// it unless reorder_bypass_tcp is turned off for a source tunneled over something lossy. The config
+// is read once, packets may be queued before Start runs.
+func (pusher *Pusher) reorderBuffer(t RTPType) *ReorderBuffer {
+	if !pusher.reorderConfigured {
+		pusher.reorderConfigured = true
+		depth := ChannelKey(pusher.Path(), "reorder_buffer_depth").MustInt(0)
+		bypass := pusher.TransType() != TRANS_TYPE_UDP.String() && ChannelKey(pusher.Path(), "reorder_bypass_tcp").MustBool(true)
+		if depth > 0 && !bypass {
+			pusher.vReorderBuffer = NewReorderBuffer(depth)
+			pusher.aReorderBuffer = NewReorderBuffer(depth)
+		}
+	}
+	switch t {
+	case RTP_TYPE_VIDEO:
+		return pusher.vReorderBuffer
+	case RTP_TYPE_AUDIO:
+		return pusher.aReorderBuffer
+	}
+	return nil
+}
+
+// ReassemblyStats returns the FU reassembly stats of video, nil if fu_stats_enable is off.
+func (pusher *Pusher) ReassemblyStats() *ReassemblyStats {
+	if pusher.fuReassembler == nil {
+		return nil
+	}
+	stats := pusher.fuReassembler.Stats()
+	return &stats
+}
+
+// FrameRate returns the video frame rate estimated from rtp timestamps, 0 if unknown.
+func (pusher *Pusher) FrameRate() float64 {
+	if pusher.frameRate == nil {
+		return 0
+	}
+	return pusher.frameRate.FrameRate()
+}
+
+// FrameReorderStats returns the decode vs presentation order stats of video, nil if reorder_stats_enable is off.
+func (pusher *Pusher) FrameReorderStats() *FrameReorderStats {
+	if pusher.reorderMeter == nil {
+		return nil
+	}
+	stats := pusher.reorderMeter.Stats()
+	return &stats
+}
+
+// ComfortNoise tells whether the audio is currently comfort noise (silence of a VoIP source).
+func (pusher *Pusher) ComfortNoise() bool {
+	pusher.comfortNoiseLock.RLock()
+	defer pusher.comfortNoiseLock.RUnlock()
+	return pusher.comfortNoise
+}
+
+func (pusher *Pusher) setComfortNoise(cn bool) {
+	pusher.comfortNoiseLock.Lock()
+	if pusher.comfortNoise != cn {
+		pusher.comfortNoise = cn
+		pusher.Logger().Printf("audio comfort noise active:%v", cn)
+	}
+	pusher.comfortNoiseLock.Unlock()
+}
+
 func (pusher *Pusher) Start() {
 	logger := pusher.Logger()
-	for !pusher.Stoped() {
+	if ChannelKey(pusher.Path(), "fu_stats_enable").MustBool(false) {
+		pusher.fuReassembler = NewFUReassembler(pusher.VCodec())
+	}
+	pusher.gopStart = newGopStartDetector(ChannelKey(pusher.Path(), "gop_start_max_packets").MustInt(300),
+		time.Duration(ChannelKey(pusher.Path(), "gop_start_max_delay").MustInt(2000))*time.Millisecond)
+	if ChannelKey(pusher.Path(), "reorder_stats_enable").MustBool(false) {
+		pusher.reorderMeter = NewFrameReorderMeter(ChannelKey(pusher.Path(), "reorder_stats_window").MustInt(16))
+	}
+	if codec := strings.ToLower(pusher.VCodec()); ChannelKey(pusher.Path(), "nalu_stats_enable").MustBool(false) && (codec == "h264" || codec == "h265") {
+		pusher.naluStats = &naluStats{counts: make(map[string]int)}
+	}
+	pusher.srIgnore = ChannelKey(pusher.Path(), "rtcp_sr_ignore").MustBool(false)
+	pusher.paramSetsValidate = ChannelKey(pusher.Path(), "param_sets_validate").MustBool(true)
+	pusher.paramSetsLog = ChannelKey(pusher.Path(), "log_parameter_sets").MustBool(false)
+	sdpMap := pusher.SDPMap()
+	if sdp, ok := sdpMap["video"]; ok {
+		if ChannelKey(pusher.Path(), "clock_rate_check").MustBool(false) {
+			pusher.vClockRate = &clockRateEstimator{declared: sdp.TimeScale}
+		}
+		pusher.frameRate = NewFrameRateMeter(pusher.ClockRate(RTP_TYPE_VIDEO), ChannelKey(pusher.Path(), "frame_rate_window").MustInt(30))
+		if codec := strings.ToLower(pusher.VCodec()); codec == "h264" || codec == "h265" {
+			if ChannelKey(pusher.Path(), "sei_extract_enable").MustBool(false) {
+				pusher.sei = NewSEIExtractor(codec)
+			}
+			pusher.psReassembler = pusher.newParamSetsReassembler(codec)
+		}
+		for _, nalu := range sdp.SpropParameterSets {
+			pusher.paramSets.update(pusher.VCodec(), nalu)
+			if pusher.sei != nil {
+				pusher.sei.Push(nalu)
+			}
+		}
+		if d := NewDepacketizer(pusher.VCodec(), sdp.PayloadType, sdp); d != nil {
+			pusher.vDepacketizer = &trackDepacketizer{Depacketizer: d}
+		}
+		codec := strings.ToLower(pusher.VCodec())
+		if n := ChannelKey(pusher.Path(), "param_sets_missing_packets").MustInt(1000); n > 0 && (codec == "h264" || codec == "h265") {
+			pusher.paramSetsMissing = &missingParamSetsDetector{maxPackets: n}
+		}
+		if gap := ChannelKey(pusher.Path(), "max_keyframe_gap").MustInt(0); gap > 0 && (codec == "h264" || codec == "h265") {
+			pusher.keyframeGap = &keyframeGapPolicy{
+				maxGap:         time.Duration(gap) * time.Second,
+				requestTimeout: time.Duration(ChannelKey(pusher.Path(), "keyframe_request_timeout").MustInt(3)) * time.Second,
+			}
+		}
+	}
+	aSDP := sdpMap["audio"]
+	if sdp, ok := sdpMap["audio"]; ok {
+		if ChannelKey(pusher.Path(), "clock_rate_check").MustBool(false) {
+			pusher.aClockRate = &clockRateEstimator{declared: sdp.TimeScale}
+		}
+		if d := NewDepacketizer(pusher.ACodec(), sdp.PayloadType, sdp); d != nil {
+			pusher.aDepacketizer = &trackDepacketizer{Depacketizer: d}
+		}
+	}
+	if ChannelKey(pusher.Path(), "adaptive_buffers_enable").MustBool(false) {
+		_, video := sdpMap["video"]
+		pusher.bufferSizer = newBufferSizer(time.Duration(ChannelKey(pusher.Path(), "adaptive_buffers_warmup").MustInt(10))*time.Second, video)
+	}
+	if _, ok := sdpMap["video"]; !ok {
+		// no gop cache or keyframe to wait for, audio is relayed frame by frame as it comes.
+		logger.Printf("audio-only channel[%s]", pusher.Path())
+	} else if aSDP == nil {
+		logger.Printf("video-only channel[%s]", pusher.Path())
+	}
+	for {
 		var pack *RTPPack
 		pusher.cond.L.Lock()
-		if len(pusher.queue) == 0 {
+		if len(pusher.queue) == 0 && !pusher.queueClosed {
 			pusher.cond.Wait()
 		}
-		if len(pusher.queue) > 0 {
+		closed := pusher.queueClosed
+		if !closed && len(pusher.queue) > 0 {
 			pack = pusher.queue[0]
 			pusher.queue = pusher.queue[1:]
 		}
 		pusher.cond.L.Unlock()
+		if closed {
+			return
+		}
 		if pack == nil {
-			if !pusher.Stoped() {
-				logger.Printf("pusher not stoped, but queue take out nil pack")
-			}
+			logger.Printf("pusher not stoped, but queue take out nil pack")
 			continue
 		}
 
-		if pusher.gopCacheEnable && pack.Type == RTP_TYPE_VIDEO {
-			pusher.gopCacheLock.Lock()
-			if rtp := ParseRTP(pack.Buffer.Bytes()); rtp != nil && pusher.shouldSequenceStart(rtp) {
-				pusher.gopCache = make([]*RTPPack, 0)
+		if pack.Type == RTP_TYPE_VIDEO {
+			rtp := ParseRTP(pack.Buffer.Bytes())
+			if rtp != nil && rtp.Keepalive {
+				// nothing to inspect, but still relayed, and its sequence number is no loss.
+				if pusher.fuReassembler != nil {
+					pusher.fuReassembler.Push(rtp)
+				}
+				pusher.depacketize(pusher.vDepacketizer, pack.Type, rtp)
+				rtp = nil
+			}
+			paramSet := false
+			if rtp != nil {
+				paramSet = pusher.inspectVideo(pack, rtp)
+			}
+			if rtp != nil && pusher.paramSetsMissing != nil && pusher.paramSetsMissing.push(!pusher.ParameterSets().Empty()) {
+				if pusher.paramSetsMissing.isMissing() {
+					logger.Printf("stream has no SPS/PPS, neither in sdp nor in band, decoding impossible: configure the source to send them, e.g. repeat headers on every keyframe")
+				} else {
+					logger.Printf("stream got its SPS/PPS")
+				}
+			}
+			if rtp != nil {
+				pusher.arrived(pack.Type, rtp)
+				pusher.checkKeyframeGap(pack, rtp)
+			}
+			if rtp != nil && pusher.fuReassembler != nil {
+				pusher.fuReassembler.Push(rtp)
+			}
+			if rtp != nil && pusher.reorderMeter != nil {
+				pusher.reorderMeter.Push(rtp.Timestamp, pack.Keyframe)
+			}
+			if rtp != nil && pusher.frameRate != nil {
+				if rate, changed := pusher.frameRate.Push(rtp.Timestamp); changed {
+					logger.Printf("video frame rate changed to %.2f", rate)
+				}
+			}
+			if pusher.gopCacheEnable {
+				pusher.cacheGop(pack, paramSet)
+			}
+			if rtp != nil {
+				pusher.depacketize(pusher.vDepacketizer, pack.Type, rtp)
+			}
+		} else if pack.Type == RTP_TYPE_VIDEOCONTROL || pack.Type == RTP_TYPE_AUDIOCONTROL {
+			if pusher.srIgnore {
+				pusher.rewriteSR(pack)
+			} else {
+				pusher.checkClockRate(pack)
+			}
+			if isRTCPBye(pack.Buffer.Bytes()) && ChannelKey(pusher.Path(), "end_of_stream_notify").MustBool(false) {
+				logger.Printf("source sent a rtcp BYE, end of stream")
+				go pusher.Stop()
+			}
+		} else if pack.Type == RTP_TYPE_AUDIO {
+			if rtp := ParseRTP(pack.Buffer.Bytes()); rtp != nil && !rtp.Keepalive {
+				pusher.arrived(pack.Type, rtp)
+				// comfort noise is relayed to players as is, but it is not the audio codec, keep it out of depacketizing.
+				cn := aSDP != nil && aSDP.IsComfortNoise(rtp.PayloadType)
+				pusher.setComfortNoise(cn)
+				if !cn {
+					pusher.depacketize(pusher.aDepacketizer, pack.Type, rtp)
+				}
+			}
+		}
+		if pusher.bufferSizer != nil {
+			if gop, bitrate := pusher.bufferSizer.push(pack); gop > 0 {
+				pusher.sizeBuffers(gop, bitrate)
 			}
-			pusher.gopCache = append(pusher.gopCache, pack)
-			pusher.gopCacheLock.Unlock()
 		}
 		pusher.BroadcastRTP(pack)
 	}
 }
 
+// cacheGop appends a video packet to the gop cache, which restarts at the gop it starts. A gop
+// over gopCacheCap is dropped whole.
+func (pusher *Pusher) cacheGop(pack *RTPPack, paramSet bool) {
+	pusher.gopCacheLock.Lock()
+	if pusher.gopCacheCap > 0 && len(pusher.gopCache) >= pusher.gopCacheCap {
+		// players joining start at the next gop rather than a truncated one.
+		if !pusher.gopCacheFull {
+			pusher.Logger().Printf("gop over the gop cache cap[%d], not cached", pusher.gopCacheCap)
+		}
+		pusher.gopCache = nil
+		pusher.gopCacheFull = true
+		pusher.gopStart.reset()
+	}
+	if start, from := pusher.gopStart.push(len(pusher.gopCache), paramSet, pack.Keyframe); start {
+		pusher.gopCache = append(make([]*RTPPack, 0, pusher.gopCacheCap), pusher.gopCache[from:]...)
+		pusher.gopCacheFull = false
+	}
+	if !pusher.gopCacheFull || pusher.gopStart.candidate() {
+		pusher.gopCache = append(pusher.gopCache, pack)
+	} else if len(pusher.gopCache) > 0 {
+		// parameter sets no keyframe followed
+		pusher.gopCache = nil
+	}
+	pusher.gopCacheLock.Unlock()
+}
+
+// restartRecord has the hls recording of the channel restarted after a discontinuity when the sps
+// changes, as the running ffmpeg can not follow a new resolution or profile.
+func (pusher *Pusher) restartRecord() {
+	if !ChannelKey(pusher.Path(), "record_discontinuity").MustBool(true) {
+		return
+	}
+	select {
+	case pusher.Server().restartRecordCh <- pusher:
+		pusher.Logger().Printf("sps changed, restart recording after a discontinuity")
+	default:
+		pusher.Logger().Printf("sps changed, but recording restarts are backlogged")
+	}
+}
+
+// spsFormatChanged tells whether sps has another resolution, profile or level than prev. The
+// bytes may differ in the vui or trailing bits alone, which the recording can go on with.
+func spsFormatChanged(codec string, prev []byte, sps []byte) bool {
+	if strings.EqualFold(codec, "h265") {
+		p, err1 := ParseH265SPS(prev)
+		s, err2 := ParseH265SPS(sps)
+		if err1 != nil || err2 != nil {
+			return false
+		}
+		return p.Width != s.Width || p.Height != s.Height || p.ProfileIdc != s.ProfileIdc || p.TierFlag != s.TierFlag || p.LevelIdc != s.LevelIdc
+	}
+	p, err1 := ParseH264SPS(prev)
+	s, err2 := ParseH264SPS(sps)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return p.Width != s.Width || p.Height != s.Height || p.ProfileIdc != s.ProfileIdc || p.LevelIdc != s.LevelIdc
+}
+
+// newParamSetsReassembler makes the reassembler of the parameter sets, and of the sei if they are
+// extracted, slices are not buffered.
+func (pusher *Pusher) newParamSetsReassembler(codec string) *FUReassembler {
+	r := NewFUReassembler(codec)
+	r.Only = func(header []byte) bool {
+		switch NALUType(codec, header) {
+		case NALU_TYPE_VPS, NALU_TYPE_SPS, NALU_TYPE_PPS:
+			return true
+		}
+		return pusher.sei != nil && NALUTypeName(codec, header) == "sei"
+	}
+	return r
+}
+
+// inspectVideo caches in-band parameter sets and marks the first packet of each keyframe,
+// returns whether the packet carries a parameter set.
+func (pusher *Pusher) inspectVideo(pack *RTPPack, rtp *RTPInfo) (paramSet bool) {
+	codec := pusher.VCodec()
+	if pusher.psReassembler != nil {
+		// whole NAL units only, the first fragment of a parameter set is just its header.
+		for _, nalu := range pusher.psReassembler.Push(rtp) {
+			pusher.inspectParamSet(codec, nalu, rtp)
+		}
+	}
+	for _, nalu := range RTPNALUnits(codec, rtp.Payload) {
+		if pusher.naluStats != nil {
+			pusher.naluStats.push(codec, nalu)
+		}
+		switch NALUType(codec, nalu) {
+		case NALU_TYPE_VPS, NALU_TYPE_SPS, NALU_TYPE_PPS:
+			paramSet = true
+			continue
+		}
+		if NALUType(codec, nalu) == NALU_TYPE_IDR && rtp.Timestamp != pusher.lastKeyframeTimestamp {
+			pusher.lastKeyframeTimestamp = rtp.Timestamp
+			pack.Keyframe = true
+			if pusher.liveGate != nil && pusher.liveGate.keyframe(time.Now()) {
+				pusher.Logger().Printf("%v live after %d keyframes", pusher, pusher.liveGate.need)
+			}
+			pack.ParameterSetsInline = pusher.paramSets.seen && pusher.paramSets.timestamp == rtp.Timestamp
+		}
+	}
+	return
+}
+
+// inspectParamSet validates and caches a reassembled parameter set, or extracts a sei.
+func (pusher *Pusher) inspectParamSet(codec string, nalu []byte, rtp *RTPInfo) {
+	if pusher.sei != nil {
+		// the sps too, the pic_timing syntax depends on it.
+		pusher.sei.Push(nalu)
+	}
+	if NALUType(codec, nalu) == NALU_TYPE_OTHER {
+		return
+	}
+	if pusher.paramSetsValidate {
+		// keep the last good set, a corrupt one is not cached.
+		if err := ValidateParameterSet(codec, nalu); err != nil {
+			pusher.Logger().Printf("drop corrupt parameter set, %v", err)
+			return
+		}
+	}
+	prevSPS := pusher.paramSets.get().SPS
+	if isParamSet, changed := pusher.paramSets.update(codec, nalu); isParamSet {
+		if changed && pusher.paramSetsLog {
+			pusher.Logger().Printf("%s parameter set changed: %s", codec, hex.EncodeToString(nalu))
+		}
+		if NALUType(codec, nalu) == NALU_TYPE_SPS {
+			if changed && pusher.inBandSPS && spsFormatChanged(codec, prevSPS, nalu) {
+				pusher.restartRecord()
+			}
+			pusher.inBandSPS = true
+		}
+		pusher.paramSets.seen = true
+		pusher.paramSets.timestamp = rtp.Timestamp
+	}
+}
+
+// AddAccessUnitHandle registers a handle to receive the depacketized access units of this pusher,
+// depacketizing only runs while at least one handle is registered.
+func (pusher *Pusher) AddAccessUnitHandle(handle AccessUnitHandle) {
+	pusher.accessUnitHandlesLock.Lock()
+	pusher.accessUnitHandles = append(pusher.accessUnitHandles, handle)
+	pusher.accessUnitHandlesLock.Unlock()
+}
+
+func (pusher *Pusher) hasAccessUnitHandles() bool {
+	pusher.accessUnitHandlesLock.RLock()
+	defer pusher.accessUnitHandlesLock.RUnlock()
+	return len(pusher.accessUnitHandles) > 0
+}
+
+func (pusher *Pusher) depacketize(d *trackDepacketizer, t RTPType, rtp *RTPInfo) {
+	if d == nil || !pusher.hasAccessUnitHandles() {
+		return
+	}
+	au, timestamp, complete, err := d.push(rtp)
+	if err != nil {
+		pusher.Logger().Printf("depacketize %v rtp err:%v", t, err)
+		return
+	}
+	if nd, ok := d.Depacketizer.(*NALUDepacketizer); ok && nd.IgnoreMarker() != d.ignoreMarker {
+		d.ignoreMarker = nd.IgnoreMarker()
+		if d.ignoreMarker {
+			pusher.Logger().Printf("%v marker bit set on most packets, frames are told apart by timestamp", t)
+		} else {
+			pusher.Logger().Printf("%v marker bit trusted again", t)
+		}
+	}
+	if !complete {
+		return
+	}
+	pusher.accessUnitHandlesLock.RLock()
+	handles := pusher.accessUnitHandles
+	pusher.accessUnitHandlesLock.RUnlock()
+	for _, h := range handles {
+		h(t, timestamp, au)
+	}
+}
+
+// closeQueue ends the queue loop of Start.
+func (pusher *Pusher) closeQueue() {
+	pusher.cond.L.Lock()
+	pusher.queueClosed = true
+	pusher.cond.L.Unlock()
+	pusher.cond.Broadcast()
+}
+
 func (pusher *Pusher) Stop() {
 	if pusher.Session != nil {
 		pusher.Session.Stop()
@@ -294,6 +898,7 @@ func (pusher *Pusher) Stop() {
 }
 
 func (pusher *Pusher) BroadcastRTP(pack *RTPPack) *Pusher {
+	pusher.recordRTPPosition(pack)
 	for _, player := range pusher.GetPlayers() {
 		player.QueueRTP(pack)
 		pusher.AddOutputBytes(pack.Buffer.Len())
@@ -313,7 +918,7 @@ func (pusher *Pusher) GetPlayers() (players map[string]*Player) {
 
 func (pusher *Pusher) AddPlayer(player *Player) *Pusher {
 	logger := pusher.Logger()
-	if pusher.gopCacheEnable {
+	if pusher.gopCacheEnable && !player.LowLatency {
 		pusher.gopCacheLock.RLock()
 		for _, pack := range pusher.gopCache {
 			player.QueueRTP(pack)
@@ -323,12 +928,15 @@ func (pusher *Pusher) AddPlayer(player *Player) *Pusher {
 	}
 
 	pusher.playersLock.Lock()
-	if _, ok := pusher.players[player.ID]; !ok {
+	_, ok := pusher.players[player.ID]
+	if !ok {
 		pusher.players[player.ID] = player
-		go player.Start()
 		logger.Printf("%v start, now player size[%d]", player, len(pusher.players))
 	}
 	pusher.playersLock.Unlock()
+	if !ok {
+		pusher.Go(player.Start)
+	}
 	return pusher
 }
 
@@ -340,11 +948,25 @@ func (pusher *Pusher) RemovePlayer(player *Player) *Pusher {
 		return pusher
 	}
 	delete(pusher.players, player.ID)
+	pusher.lastPlayerAt = time.Now()
 	logger.Printf("%v end, now player size[%d]\n", player, len(pusher.players))
 	pusher.playersLock.Unlock()
 	return pusher
 }
 
+// IdleSince returns since when the pusher has no player, false if it has any.
+func (pusher *Pusher) IdleSince() (time.Time, bool) {
+	pusher.playersLock.RLock()
+	defer pusher.playersLock.RUnlock()
+	if len(pusher.players) > 0 {
+		return time.Time{}, false
+	}
+	if pusher.lastPlayerAt.IsZero() {
+		return pusher.StartAt(), true
+	}
+	return pusher.lastPlayerAt, true
+}
+
 func (pusher *Pusher) ClearPlayer() {
 	// copy a new map to avoid deadlock
 	players := make(map[string]*Player)
@@ -355,120 +977,21 @@ func (pusher *Pusher) ClearPlayer() {
 	}
 	pusher.players = make(map[string]*Player)
 	pusher.playersLock.Unlock()
-	go func() { // do not block
+	endOfStream := ChannelKey(pusher.Path(), "end_of_stream_notify").MustBool(false)
+	pusher.Go(func() { // do not block
+		var wg sync.WaitGroup
 		for _, v := range players {
-			v.Stop()
-		}
-	}()
-}
-
-func (pusher *Pusher) shouldSequenceStart(rtp *RTPInfo) bool {
-	if strings.EqualFold(pusher.VCodec(), "h264") {
-		var realNALU uint8
-		payloadHeader := rtp.Payload[0] //https://tools.ietf.org/html/rfc6184#section-5.2
-		NaluType := uint8(payloadHeader & 0x1F)
-		// log.Printf("RTP Type:%d", NaluType)
-		switch {
-		case NaluType <= 23:
-			realNALU = rtp.Payload[0]
-			// log.Printf("Single NAL:%d", NaluType)
-		case NaluType == 28 || NaluType == 29:
-			realNALU = rtp.Payload[1]
-			if realNALU&0x40 != 0 {
-				// log.Printf("FU NAL End :%02X", realNALU)
-			}
-			if realNALU&0x80 != 0 {
-				// log.Printf("FU NAL Begin :%02X", realNALU)
-			} else {
-				return false
-			}
-		case NaluType == 24:
-			// log.Printf("STAP-A")
-			off := 1
-			singleSPSPPS := 0
-			for {
-				nalSize := ((uint16(rtp.Payload[off])) << 8) | uint16(rtp.Payload[off+1])
-				if nalSize < 1 {
-					return false
-				}
-				off += 2
-				nalUnit := rtp.Payload[off : off+int(nalSize)]
-				off += int(nalSize)
-				realNALU = nalUnit[0]
-				singleSPSPPS += int(realNALU & 0x1F)
-				if off >= len(rtp.Payload) {
-					break
-				}
-			}
-			if singleSPSPPS == 0x0F {
-				pusher.spsppsInSTAPaPack = true
-				return true
-			}
-		}
-		if realNALU&0x1F == 0x05 {
-			if pusher.spsppsInSTAPaPack {
-				return false
-			}
-			return true
-		}
-		if realNALU&0x1F == 0x07 { // maybe sps pps header + key frame?
-			if len(rtp.Payload) < 200 { // consider sps pps header only.
-				return true
-			}
-			return true
-		}
-		return false
-	} else if strings.EqualFold(pusher.VCodec(), "h265") {
-		if len(rtp.Payload) >= 3 {
-			firstByte := rtp.Payload[0]
-			headerType := (firstByte >> 1) & 0x3f
-			var frameType uint8
-			if headerType == 49 { //Fragmentation Units
-
-				FUHeader := rtp.Payload[2]
-				/*
-				   +---------------+
-				   |0|1|2|3|4|5|6|7|
-				   +-+-+-+-+-+-+-+-+
-				   |S|E|  FuType   |
-				   +---------------+
-				*/
-				rtpStart := (FUHeader & 0x80) != 0
-				if !rtpStart {
-					if (FUHeader & 0x40) != 0 {
-						//log.Printf("FU frame end")
-					}
-					return false
-				} else {
-					//log.Printf("FU frame start")
-				}
-				frameType = FUHeader & 0x3f
-			} else if headerType == 48 { //Aggregation Packets
-
-			} else if headerType == 50 { //PACI Packets
-
-			} else { // Single NALU
-				/*
-					+---------------+---------------+
-					|0|1|2|3|4|5|6|7|0|1|2|3|4|5|6|7|
-					+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+-+
-					|F|   Type    |  LayerId  | TID |
-					+-------------+-----------------+
-				*/
-				frameType = firstByte & 0x7e
-			}
-			if frameType >= 16 && frameType <= 21 {
-				return true
-			}
-			if frameType == 32 {
-				// vps sps pps...
-				if len(rtp.Payload) < 200 { // consider sps pps header only.
-					return false
-				}
-				return true
+			if endOfStream {
+				wg.Add(1)
+				go func(v *Player) {
+					defer wg.Done()
+					v.endOfStream()
+				}(v)
+				continue
 			}
+			v.Stop()
 		}
-		return false
-	}
-	return false
+		// accounted to the pusher as one goroutine until the players are all gone
+		wg.Wait()
+	})
 }
@@ -0,0 +1,279 @@
+package rtsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSPSFormatChanged(t *testing.T) {
+	base := testSPS{profile: 66, level: 30, width: 640, height: 480}
+	for _, c := range []struct {
+		name string
+		sps  testSPS
+		want bool
+	}{
+		{"same", base, false},
+		{"vui only", testSPS{profile: 66, level: 30, width: 640, height: 480, fps: 25}, false},
+		{"resolution", testSPS{profile: 66, level: 30, width: 1280, height: 720}, true},
+		{"cropped height", testSPS{profile: 66, level: 30, width: 640, height: 470}, true},
+		{"profile", testSPS{profile: 100, level: 30, width: 640, height: 480}, true},
+		{"level", testSPS{profile: 66, level: 31, width: 640, height: 480}, true},
+	} {
+		if got := spsFormatChanged("h264", base.h264(), c.sps.h264()); got != c.want {
+			t.Errorf("%s: changed %v, want %v", c.name, got, c.want)
+		}
+	}
+	if spsFormatChanged("h264", base.h264(), []byte{0x67, 0xFF}) {
+		t.Errorf("an unparsable sps is taken as a change")
+	}
+}
+
+func newTestPusher(codec string) *Pusher {
+	session := &Session{Path: "/test", VCodec: codec}
+	session.logger = log.New(io.Discard, "", 0)
+	return &Pusher{Session: session, lastKeyframeTimestamp: -1}
+}
+
+// testRTPPackets packetizes the NAL units to rtp packets no larger than blocksize.
+func testRTPPackets(codec string, blocksize int, seq uint16, timestamp uint32, nalus ...[]byte) (packets []*RTPInfo) {
+	for i, nalu := range nalus {
+		rtp := selfTestRTP(96, 0, timestamp, i == len(nalus)-1, nalu)
+		frags := fragmentRTP(codec, rtp, blocksize)
+		if frags == nil {
+			frags = [][]byte{rtp}
+		}
+		for _, frag := range frags {
+			binary.BigEndian.PutUint16(frag[2:], seq)
+			seq++
+			packets = append(packets, ParseRTP(frag))
+		}
+	}
+	return
+}
+
+func TestInspectVideoFragmentedSPS(t *testing.T) {
+	pusher := newTestPusher("h264")
+	pusher.psReassembler = pusher.newParamSetsReassembler("h264")
+	// an sps larger than a packet, the bytes after its vui are ignored by the parser.
+	sps := append(testSPS{profile: 100, level: 40, width: 1920, height: 1080, fps: 25}.h264(), bytes.Repeat([]byte{0x55}, 2500)...)
+	pps := []byte{0x68, 0xCE, 0x3C, 0x80}
+	idr := append([]byte{0x65}, bytes.Repeat([]byte{0x11}, 3000)...)
+	packets := testRTPPackets("h264", 1200, 100, 9000, sps, pps, idr)
+	var paramSetPackets, keyframes int
+	for _, rtp := range packets {
+		pack := &RTPPack{Type: RTP_TYPE_VIDEO}
+		if pusher.inspectVideo(pack, rtp) {
+			paramSetPackets++
+		}
+		if pack.Keyframe {
+			keyframes++
+			if !pack.ParameterSetsInline {
+				t.Errorf("keyframe not marked with inline parameter sets")
+			}
+		}
+	}
+	if ps := pusher.paramSets.get(); !bytes.Equal(ps.SPS, sps) || !bytes.Equal(ps.PPS, pps) {
+		t.Errorf("cached sps size[%d] pps size[%d], want the whole sets size[%d] size[%d]", len(ps.SPS), len(ps.PPS), len(sps), len(pps))
+	}
+	// the first fragment of the sps and the pps
+	if paramSetPackets != 2 || keyframes != 1 {
+		t.Errorf("%d parameter set packets and %d keyframes, want 2 and 1", paramSetPackets, keyframes)
+	}
+}
+
+func TestInspectVideoLostSPSFragment(t *testing.T) {
+	pusher := newTestPusher("h264")
+	pusher.psReassembler = pusher.newParamSetsReassembler("h264")
+	sps := append(testSPS{profile: 66, level: 30, width: 640, height: 480}.h264(), bytes.Repeat([]byte{0x55}, 2500)...)
+	packets := testRTPPackets("h264", 1200, 100, 9000, sps)
+	for i, rtp := range packets {
+		if i != 1 {
+			pusher.inspectVideo(&RTPPack{Type: RTP_TYPE_VIDEO}, rtp)
+		}
+	}
+	if ps := pusher.paramSets.get(); ps.SPS != nil {
+		t.Errorf("sps with a lost fragment cached, size[%d]", len(ps.SPS))
+	}
+}
+
+func TestReorderBufferConfiguredOnce(t *testing.T) {
+	setTestConf(t, "reorder_buffer_depth", "4")
+	for _, c := range []struct {
+		transType TransType
+		want      bool
+	}{
+		{TRANS_TYPE_UDP, true},
+		// tcp bypasses it by default
+		{TRANS_TYPE_TCP, false},
+	} {
+		pusher := newTestPusher("h264")
+		pusher.Session.TransType = c.transType
+		video := pusher.reorderBuffer(RTP_TYPE_VIDEO)
+		if (video != nil) != c.want || (pusher.reorderBuffer(RTP_TYPE_AUDIO) != nil) != c.want {
+			t.Errorf("%v: reorder buffers %v, want %v", c.transType, video != nil, c.want)
+		}
+		if pusher.reorderBuffer(RTP_TYPE_VIDEOCONTROL) != nil {
+			t.Errorf("%v: reorder buffer for rtcp", c.transType)
+		}
+		setTestConf(t, "reorder_buffer_depth", "0")
+		if pusher.reorderBuffer(RTP_TYPE_VIDEO) != video {
+			t.Errorf("%v: reorder buffer changed after the first packet", c.transType)
+		}
+		setTestConf(t, "reorder_buffer_depth", "4")
+	}
+}
+
+func TestInspectParamSetValidate(t *testing.T) {
+	good := testSPS{profile: 66, level: 30, width: 640, height: 480}.h264()
+	truncated := good[:3]
+	for _, validate := range []bool{true, false} {
+		pusher := newTestPusher("h264")
+		pusher.paramSetsValidate = validate
+		rtp := ParseRTP(selfTestRTP(96, 1, 3000, false, good))
+		pusher.inspectParamSet("h264", good, rtp)
+		pusher.inspectParamSet("h264", truncated, rtp)
+		want := good
+		if !validate {
+			want = truncated
+		}
+		if sps := pusher.paramSets.get().SPS; !bytes.Equal(sps, want) {
+			t.Errorf("validate %v: cached sps % x, want % x", validate, sps, want)
+		}
+	}
+}
+
+func TestInspectParamSetLog(t *testing.T) {
+	sps := testSPS{profile: 66, level: 30, width: 640, height: 480}.h264()
+	for _, enabled := range []bool{true, false} {
+		var out bytes.Buffer
+		pusher := newTestPusher("h264")
+		pusher.Session.logger = log.New(&out, "", 0)
+		pusher.paramSetsLog = enabled
+		rtp := ParseRTP(selfTestRTP(96, 1, 3000, false, sps))
+		// logged once, when it changes
+		pusher.inspectParamSet("h264", sps, rtp)
+		pusher.inspectParamSet("h264", sps, rtp)
+		want := 0
+		if enabled {
+			want = 1
+		}
+		if n := bytes.Count(out.Bytes(), []byte("parameter set changed")); n != want {
+			t.Errorf("log_parameter_sets %v: logged %d times, want %d", enabled, n, want)
+		}
+	}
+}
+
+func TestInspectVideoSEI(t *testing.T) {
+	pusher := newTestPusher("h264")
+	pusher.sei = NewSEIExtractor("h264")
+	pusher.psReassembler = pusher.newParamSetsReassembler("h264")
+	vui := testSPS{profile: 77, level: 30, width: 720, height: 576, fps: 25, picStruct: true}
+	// a sei fragmented over packets, a user data unregistered message after the pic_timing, behind
+	// the sps it depends on
+	sei := testPicTimingSEI(vui, 3, nil)
+	sei = append(append(sei[:len(sei)-1], 5, 200), bytes.Repeat([]byte{0x55}, 200)...)
+	sei = append(sei, 0x80)
+	for _, rtp := range testRTPPackets("h264", 100, 1, 3000, vui.h264(), sei) {
+		pusher.inspectVideo(&RTPPack{Type: RTP_TYPE_VIDEO}, rtp)
+	}
+	if timing := pusher.sei.PicTiming(); timing == nil || timing.FieldOrder() != "tff" {
+		t.Errorf("pic_timing %+v, want top field first", timing)
+	}
+}
+
+// startTestSDPPusher runs the queue loop of a pusher of sdp, with a player that is not started to
+// see what the pusher relays.
+func startTestSDPPusher(t *testing.T, sdp string) (*Pusher, *Player) {
+	session := &Session{Server: newTestServer(), Path: "/test", SDPRaw: sdp, logger: log.New(io.Discard, "", 0)}
+	session.SDPMap = ParseSDP(sdp)
+	if info, ok := session.SDPMap["video"]; ok {
+		session.VCodec = info.Codec
+	}
+	if info, ok := session.SDPMap["audio"]; ok {
+		session.ACodec = info.Codec
+	}
+	pusher := NewPusher(session)
+	player := &Player{Session: &Session{ID: "player", Path: "/test", logger: session.logger}, Pusher: pusher, cond: sync.NewCond(&sync.Mutex{})}
+	pusher.players[player.ID] = player
+	done := make(chan struct{})
+	go func() {
+		pusher.Start()
+		close(done)
+	}()
+	t.Cleanup(func() {
+		session.Stop()
+		<-done
+	})
+	return pusher, player
+}
+
+// waitRelayed returns the types of the packets queued to player once there are n.
+func waitRelayed(t *testing.T, player *Player, n int) (types []RTPType) {
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		player.cond.L.Lock()
+		types = types[:0]
+		for _, pack := range player.queue {
+			types = append(types, pack.Type)
+		}
+		player.cond.L.Unlock()
+		if len(types) >= n || time.Now().After(deadline) {
+			return
+		}
+	}
+}
+
+const (
+	testAudioSDP = "m=audio 0 RTP/AVP 97\r\na=rtpmap:97 MPEG4-GENERIC/44100/2\r\na=control:streamid=1\r\n"
+	testVideoSDP = "m=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\na=control:streamid=0\r\n"
+	testSDPHead  = "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=test\r\nt=0 0\r\n"
+)
+
+func testAudioPack(seq uint16, timestamp uint32) *RTPPack {
+	return &RTPPack{Type: RTP_TYPE_AUDIO, Buffer: bytes.NewBuffer(selfTestRTP(97, seq, timestamp, true, []byte{0x00, 0x10, 0x01, 0x20, 0xAA}))}
+}
+
+func TestAudioOnlyPusherRelays(t *testing.T) {
+	pusher, player := startTestSDPPusher(t, testSDPHead+testAudioSDP)
+	if pusher.HasVideo() || !pusher.HasAudio() {
+		t.Fatalf("audio-only sdp has video %v, audio %v", pusher.HasVideo(), pusher.HasAudio())
+	}
+	// no keyframe to wait for, every frame goes to the player as it comes
+	for i := 0; i < 3; i++ {
+		pusher.QueueRTP(testAudioPack(uint16(i), uint32(i*1024)))
+	}
+	if got := waitRelayed(t, player, 3); len(got) != 3 {
+		t.Fatalf("relayed %v, want 3 audio packets", got)
+	}
+}
+
+func TestVideoOnlyPusher(t *testing.T) {
+	pusher, player := startTestSDPPusher(t, testSDPHead+testVideoSDP)
+	if !pusher.HasVideo() || pusher.HasAudio() {
+		t.Fatalf("video-only sdp has video %v, audio %v", pusher.HasVideo(), pusher.HasAudio())
+	}
+	for i, nalu := range [][]byte{{0x65, 0x88}, {0x41, 0x9A}, {0x41, 0x9A}} {
+		pusher.QueueRTP(&RTPPack{Type: RTP_TYPE_VIDEO, Buffer: bytes.NewBuffer(selfTestRTP(96, uint16(i), uint32(i*3600), true, nalu))})
+	}
+	// video is relayed with no audio ever coming
+	if got := waitRelayed(t, player, 3); len(got) != 3 {
+		t.Fatalf("relayed %v, want 3 video packets", got)
+	}
+
+	// a player of the video track only is not sent audio
+	var out bytes.Buffer
+	session := &Session{TransType: TRANS_TYPE_TCP, vRTPChannel: 0, vRTPControlChannel: 1, aRTPChannel: -1, aRTPControlChannel: -1}
+	session.connRW = bufio.NewReadWriter(bufio.NewReader(bytes.NewReader(nil)), bufio.NewWriter(&out))
+	if err := session.SendRTP(testAudioPack(0, 0)); err != nil || out.Len() != 0 {
+		t.Errorf("audio to a video-only player: err %v, %d bytes sent", err, out.Len())
+	}
+	video := selfTestRTP(96, 0, 0, true, []byte{0x65, 0x88})
+	if err := session.SendRTP(&RTPPack{Type: RTP_TYPE_VIDEO, Buffer: bytes.NewBuffer(video)}); err != nil || out.Len() != 4+len(video) {
+		t.Errorf("video to a video-only player: err %v, %d bytes sent, want %d", err, out.Len(), 4+len(video))
+	}
+}
@@ -0,0 +1,62 @@
+package rtsp
+
+import (
+	"sync"
+	"time"
+)
+
+// RateMeter measures a byte rate over the last seconds, with one bucket per second.
+type RateMeter struct {
+	buckets []int
+	second  int64
+	lock    sync.Mutex
+}
+
+func NewRateMeter(seconds int) *RateMeter {
+	if seconds < 2 {
+		seconds = 5
+	}
+	return &RateMeter{
+		buckets: make([]int, seconds),
+		second:  time.Now().Unix(),
+	}
+}
+
+// advance clears the buckets of the seconds passed since the last call, caller holds the lock.
+func (m *RateMeter) advance(now int64) {
+	passed := now - m.second
+	if passed <= 0 {
+		return
+	}
+	if passed > int64(len(m.buckets)) {
+		passed = int64(len(m.buckets))
+	}
+	for i := int64(1); i <= passed; i++ {
+		m.buckets[(m.second+i)%int64(len(m.buckets))] = 0
+	}
+	m.second = now
+}
+
+func (m *RateMeter) Add(bytes int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := time.Now().Unix()
+	m.advance(now)
+	m.buckets[now%int64(len(m.buckets))] += bytes
+}
+
+// BitRate returns the bits per second over the complete seconds of the window.
+func (m *RateMeter) BitRate() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := time.Now().Unix()
+	m.advance(now)
+	total := 0
+	for i, bytes := range m.buckets {
+		// the current second is still filling up.
+		if int64(i) != now%int64(len(m.buckets)) {
+			total += bytes
+		}
+	}
+	return total * 8 / (len(m.buckets) - 1)
+}
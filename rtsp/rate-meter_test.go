@@ -0,0 +1,83 @@
+package rtsp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRateMeterAdvance(t *testing.T) {
+	m := NewRateMeter(5)
+	m.second = 100
+	for i := range m.buckets {
+		m.buckets[i] = 1000
+	}
+	m.advance(102)
+	// the buckets of seconds 101 and 102 start over
+	if want := []int{1000, 0, 0, 1000, 1000}; !reflect.DeepEqual(m.buckets, want) {
+		t.Errorf("buckets %v, want %v", m.buckets, want)
+	}
+	m.advance(200)
+	if want := []int{0, 0, 0, 0, 0}; !reflect.DeepEqual(m.buckets, want) {
+		t.Errorf("buckets %v after a long pause, want %v", m.buckets, want)
+	}
+	// the window without the current second is 4 seconds
+	m = NewRateMeter(5)
+	for i := range m.buckets {
+		m.buckets[i] = 1000
+	}
+	// no second passes meanwhile
+	m.second = time.Now().Unix() + 60
+	if rate := m.BitRate(); rate != 8000 {
+		t.Errorf("bitrate %d, want 8000", rate)
+	}
+}
+
+// The egress bitrate of a player counts what it is sent, not the gops the egress limit sheds.
+func TestPlayerEgressBitRateShed(t *testing.T) {
+	setTestConf(t, "player_bitrate_enable", "1")
+	server := newTestServer()
+	// 800 kbit/s, a bucket of 100000 bytes
+	server.egress = newEgressScheduler(800)
+	session := &Session{Server: server, Path: "/test", logger: log.New(io.Discard, "", 0)}
+	session.connRW = bufio.NewReadWriter(bufio.NewReader(bytes.NewReader(nil)), bufio.NewWriter(io.Discard))
+	player := NewPlayer(session, newTestPusher("h264"))
+	if player.EgressBitRate() != 0 {
+		t.Fatalf("bitrate %d before sending", player.EgressBitRate())
+	}
+	pack := func(t RTPType, size int, keyframe bool) *RTPPack {
+		return &RTPPack{Type: t, Buffer: bytes.NewBuffer(make([]byte, size)), Keyframe: keyframe}
+	}
+	player.QueueRTP(pack(RTP_TYPE_VIDEO, 60000, true))
+	// over budget, dropped with the rest of the gop
+	player.QueueRTP(pack(RTP_TYPE_VIDEO, 60000, false))
+	player.QueueRTP(pack(RTP_TYPE_VIDEO, 100, false))
+	player.QueueRTP(pack(RTP_TYPE_AUDIO, 1000, false))
+	sent := func() (total int) {
+		player.egressRate.lock.Lock()
+		defer player.egressRate.lock.Unlock()
+		for _, n := range player.egressRate.buckets {
+			total += n
+		}
+		return
+	}
+	done := make(chan bool)
+	go func() {
+		player.Start()
+		close(done)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); sent() < 61000 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+	}
+	if total := sent(); total != 61000 {
+		t.Errorf("%d bytes counted, want the keyframe and the audio only", total)
+	}
+	player.cond.L.Lock()
+	player.Stoped = true
+	player.cond.Broadcast()
+	player.cond.L.Unlock()
+	<-done
+}
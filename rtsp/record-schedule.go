@@ -0,0 +1,126 @@
+package rtsp
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RecordSchedule is the time windows a channel records in, like "mon-fri 08:00-18:00;sat,sun 10:00-12:00".
+// A window without days is every day, one ending before it starts runs past midnight and
+// belongs to the day it starts, one ending when it starts lasts the whole day.
+type RecordSchedule struct {
+	windows  []recordWindow
+	location *time.Location
+}
+
+type recordWindow struct {
+	days [7]bool
+	// minutes of the day
+	start, end int
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseRecordSchedule parses a schedule, whose times are in timezone, the local one if empty.
+func ParseRecordSchedule(spec string, timezone string) (schedule *RecordSchedule, err error) {
+	schedule = &RecordSchedule{location: time.Local}
+	if timezone != "" {
+		if schedule.location, err = time.LoadLocation(timezone); err != nil {
+			return nil, fmt.Errorf("record schedule timezone[%s] err:%v", timezone, err)
+		}
+	}
+	for _, part := range strings.Split(spec, ";") {
+		fields := strings.Fields(strings.ToLower(part))
+		if len(fields) == 0 {
+			continue
+		}
+		var w recordWindow
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("record schedule window[%s] invalid", part)
+		}
+		if len(fields) == 2 {
+			if err = w.parseDays(fields[0]); err != nil {
+				return nil, err
+			}
+		} else {
+			w.days = [7]bool{true, true, true, true, true, true, true}
+		}
+		span := strings.Split(fields[len(fields)-1], "-")
+		if len(span) != 2 {
+			return nil, fmt.Errorf("record schedule window[%s] invalid", part)
+		}
+		if w.start, err = parseMinuteOfDay(span[0]); err != nil {
+			return nil, err
+		}
+		if w.end, err = parseMinuteOfDay(span[1]); err != nil {
+			return nil, err
+		}
+		schedule.windows = append(schedule.windows, w)
+	}
+	if len(schedule.windows) == 0 {
+		return nil, fmt.Errorf("record schedule[%s] has no window", spec)
+	}
+	return
+}
+
+// parseDays parses "mon-fri", "sat,sun" or "*".
+func (w *recordWindow) parseDays(days string) error {
+	for _, item := range strings.Split(days, ",") {
+		if item == "*" {
+			w.days = [7]bool{true, true, true, true, true, true, true}
+			continue
+		}
+		span := strings.Split(item, "-")
+		from, ok := weekdays[span[0]]
+		to := from
+		if len(span) == 2 && ok {
+			to, ok = weekdays[span[1]]
+		}
+		if !ok || len(span) > 2 {
+			return fmt.Errorf("record schedule days[%s] invalid", days)
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func parseMinuteOfDay(hhmm string) (int, error) {
+	var hour, minute int
+	if n, err := fmt.Sscanf(hhmm, "%d:%d", &hour, &minute); n != 2 || err != nil || hour < 0 || hour > 24 || minute < 0 || minute > 59 || hour*60+minute > 24*60 {
+		return 0, fmt.Errorf("record schedule time[%s] invalid", hhmm)
+	}
+	return hour*60 + minute, nil
+}
+
+// Active tells whether t is in a window of the schedule.
+func (s *RecordSchedule) Active(t time.Time) bool {
+	t = t.In(s.location)
+	day, minute := t.Weekday(), t.Hour()*60+t.Minute()
+	yesterday := (day + 6) % 7
+	for _, w := range s.windows {
+		switch {
+		case w.start == w.end:
+			if w.days[day] {
+				return true
+			}
+		case w.start < w.end:
+			if w.days[day] && minute >= w.start && minute < w.end {
+				return true
+			}
+		default:
+			if (w.days[day] && minute >= w.start) || (w.days[yesterday] && minute < w.end) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package rtsp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRecordSchedule(t *testing.T) {
+	// 2024-01-01 is a monday
+	at := func(day int, hhmm string) time.Time {
+		clock, err := time.Parse("15:04", hhmm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return time.Date(2024, 1, day, clock.Hour(), clock.Minute(), 0, 0, time.UTC)
+	}
+	for _, c := range []struct {
+		spec   string
+		day    int
+		hhmm   string
+		active bool
+	}{
+		{"mon-fri 08:00-18:00", 1, "08:00", true},
+		{"mon-fri 08:00-18:00", 1, "17:59", true},
+		{"mon-fri 08:00-18:00", 1, "18:00", false},
+		{"mon-fri 08:00-18:00", 6, "12:00", false},
+		{"mon-fri 08:00-18:00;sat,sun 10:00-12:00", 6, "11:00", true},
+		{"mon-fri 08:00-18:00;sat,sun 10:00-12:00", 7, "12:30", false},
+		{"09:00-10:00", 7, "09:30", true},
+		// past midnight, belonging to the day it starts
+		{"fri 22:00-06:00", 5, "23:00", true},
+		{"fri 22:00-06:00", 6, "05:59", true},
+		{"fri 22:00-06:00", 6, "06:00", false},
+		{"fri 22:00-06:00", 5, "05:00", false},
+		// the whole day
+		{"sun 00:00-00:00", 7, "23:59", true},
+		{"sun 00:00-00:00", 1, "00:00", false},
+		// wrapping days
+		{"sat-mon 00:00-24:00", 1, "12:00", true},
+		{"sat-mon 00:00-24:00", 3, "12:00", false},
+	} {
+		s, err := ParseRecordSchedule(c.spec, "UTC")
+		if err != nil {
+			t.Fatalf("%q: %v", c.spec, err)
+		}
+		if active := s.Active(at(c.day, c.hhmm)); active != c.active {
+			t.Errorf("%q at day %d %s: active %v, want %v", c.spec, c.day, c.hhmm, active, c.active)
+		}
+	}
+	for _, spec := range []string{"", ";", "mon-fri", "xyz 08:00-18:00", "mon 08:00", "mon 25:00-26:00", "mon 08:60-09:00", "mon tue 08:00-09:00"} {
+		if _, err := ParseRecordSchedule(spec, "UTC"); err == nil {
+			t.Errorf("%q parsed", spec)
+		}
+	}
+	if _, err := ParseRecordSchedule("08:00-18:00", "Nowhere/City"); err == nil {
+		t.Error("unknown timezone accepted")
+	}
+}
+
+func TestRecordScheduleTimezone(t *testing.T) {
+	s, err := ParseRecordSchedule("08:00-09:00", "Asia/Shanghai")
+	if err != nil {
+		t.Skip(err)
+	}
+	// 08:30 in Shanghai is 00:30 utc
+	if !s.Active(time.Date(2024, 1, 1, 0, 30, 0, 0, time.UTC)) {
+		t.Error("window not in the schedule timezone")
+	}
+	if s.Active(time.Date(2024, 1, 1, 8, 30, 0, 0, time.UTC)) {
+		t.Error("window in utc")
+	}
+}
+
+func TestTSRecorderTrigger(t *testing.T) {
+	s, err := ParseRecordSchedule("sun 00:00-00:01", "UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &TSRecorder{schedule: s}
+	now := time.Now()
+	if s.Active(now) {
+		t.Skip("run in the schedule window")
+	}
+	if r.recording(now) {
+		t.Fatal("recording out of schedule")
+	}
+	r.Trigger(time.Minute)
+	if !r.recording(time.Now()) {
+		t.Error("not recording after a trigger")
+	}
+	// a shorter trigger does not cut the deadline
+	r.Trigger(time.Second)
+	if !r.recording(time.Now().Add(30 * time.Second)) {
+		t.Error("trigger deadline shortened")
+	}
+	if r.recording(time.Now().Add(2 * time.Minute)) {
+		t.Error("recording past the trigger deadline")
+	}
+	if !(&TSRecorder{}).recording(now) {
+		t.Error("not recording without a schedule")
+	}
+}
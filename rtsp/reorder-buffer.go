@@ -0,0 +1,64 @@
+package rtsp
+
+import (
+	"encoding/binary"
+)
+
+// ReorderBuffer holds up to depth rtp packets of one track and releases them in sequence order.
+// When it is full the oldest missing packets are given up as lost.
+type ReorderBuffer struct {
+	depth   int
+	packs   map[uint16]*RTPPack
+	nextSeq uint16
+	started bool
+}
+
+func NewReorderBuffer(depth int) *ReorderBuffer {
+	return &ReorderBuffer{
+		depth: depth,
+		packs: make(map[uint16]*RTPPack),
+	}
+}
+
+// Push adds a packet, and returns the packets ready to be relayed in order.
+func (b *ReorderBuffer) Push(pack *RTPPack) (out []*RTPPack) {
+	rtpBytes := pack.Buffer.Bytes()
+	if len(rtpBytes) < RTP_FIXED_HEADER_LENGTH {
+		return []*RTPPack{pack}
+	}
+	seq := binary.BigEndian.Uint16(rtpBytes[2:])
+	if !b.started {
+		b.started = true
+		b.nextSeq = seq
+	}
+	if int16(seq-b.nextSeq) < 0 {
+		// too late to reorder, relay it as is.
+		return []*RTPPack{pack}
+	}
+	b.packs[seq] = pack
+	out = b.release(out)
+	for len(b.packs) > b.depth {
+		var oldest uint16
+		first := true
+		for s := range b.packs {
+			if first || int16(s-oldest) < 0 {
+				oldest, first = s, false
+			}
+		}
+		b.nextSeq = oldest
+		out = b.release(out)
+	}
+	return
+}
+
+func (b *ReorderBuffer) release(out []*RTPPack) []*RTPPack {
+	for {
+		pack, ok := b.packs[b.nextSeq]
+		if !ok {
+			return out
+		}
+		delete(b.packs, b.nextSeq)
+		out = append(out, pack)
+		b.nextSeq++
+	}
+}
@@ -0,0 +1,58 @@
+package rtsp
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// ntpEpochOffset is the seconds from 1900, the ntp epoch, to 1970.
+const ntpEpochOffset = 2208988800
+
+// arrivalClock remembers when the last media packet of a track came and its rtp timestamp.
+type arrivalClock struct {
+	at        time.Time
+	timestamp uint32
+	seen      bool
+}
+
+// With rtcp_sr_ignore on, the RTCP sender reports of a source are not trusted: cameras sending
+// a wrong ntp time in them break the A/V sync of players and recordings. The rtp timestamps
+// are then mapped to the arrival time of the packets instead, both for clock_rate_check and in
+// the sender reports relayed to players, whose ntp time and rtp timestamp are rewritten to the
+// last packet of the track and the time it was received.
+
+// arrived notes the arrival of a media packet of the track, when rtcp_sr_ignore is on.
+func (pusher *Pusher) arrived(t RTPType, rtp *RTPInfo) {
+	if !pusher.srIgnore {
+		return
+	}
+	clock, estimator := &pusher.vArrival, pusher.vClockRate
+	if t == RTP_TYPE_AUDIO {
+		clock, estimator = &pusher.aArrival, pusher.aClockRate
+	}
+	now := time.Now()
+	*clock = arrivalClock{at: now, timestamp: uint32(rtp.Timestamp), seen: true}
+	if estimator != nil {
+		rate, changed := estimator.push(float64(now.UnixNano())/1e9, uint32(rtp.Timestamp))
+		pusher.clockRateChanged(estimator, t == RTP_TYPE_VIDEO, rate, changed)
+	}
+}
+
+// rewriteSR maps a sender report to the arrival time of the last packet of its track.
+func (pusher *Pusher) rewriteSR(pack *RTPPack) {
+	rtcp := pack.Buffer.Bytes()
+	if len(rtcp) < 20 || rtcp[1] != 200 {
+		return
+	}
+	clock := pusher.vArrival
+	if pack.Type == RTP_TYPE_AUDIOCONTROL {
+		clock = pusher.aArrival
+	}
+	if !clock.seen {
+		return
+	}
+	nanos := clock.at.UnixNano()
+	binary.BigEndian.PutUint32(rtcp[8:], uint32(nanos/1e9+ntpEpochOffset))
+	binary.BigEndian.PutUint32(rtcp[12:], uint32((nanos%1e9)<<32/1e9))
+	binary.BigEndian.PutUint32(rtcp[16:], clock.timestamp)
+}
@@ -0,0 +1,98 @@
+package rtsp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// setupTrack is a track a player SETUP, with the url it used.
+type setupTrack struct {
+	Type RTPType
+	URL  string
+}
+
+// addSetupTrack records the tracks in the order the player SETUP them, for RTP-Info.
+func (session *Session) addSetupTrack(t RTPType, url string) {
+	for i, track := range session.setupTracks {
+		if track.Type == t {
+			session.setupTracks[i].URL = url
+			return
+		}
+	}
+	session.setupTracks = append(session.setupTracks, setupTrack{Type: t, URL: url})
+}
+
+// rtpInfo returns the RTP-Info of a PLAY response, an entry per track in SETUP order, with the
+// sequence number and timestamp of the first packet the player will get, as far as they are known.
+func (session *Session) rtpInfo(fromCache bool) string {
+	var infos []string
+	for _, track := range session.setupTracks {
+		info := "url=" + track.URL
+		if seq, timestamp, known := session.Pusher.nextRTPPosition(track.Type, fromCache); known != rtpPositionUnknown {
+			info += fmt.Sprintf(";seq=%d", seq)
+			if known == rtpPositionKnown {
+				info += fmt.Sprintf(";rtptime=%d", timestamp)
+			}
+		}
+		infos = append(infos, info)
+	}
+	return strings.Join(infos, ",")
+}
+
+type rtpPosition struct {
+	seq       uint16
+	timestamp uint32
+	ssrc      uint32
+}
+
+// lastRTPPositions keeps the sequence number and timestamp of the last relayed packet per track.
+type lastRTPPositions struct {
+	positions map[RTPType]rtpPosition
+	lock      sync.RWMutex
+}
+
+func (pusher *Pusher) recordRTPPosition(pack *RTPPack) {
+	if pack.Type != RTP_TYPE_VIDEO && pack.Type != RTP_TYPE_AUDIO {
+		return
+	}
+	b := pack.Buffer.Bytes()
+	if len(b) < RTP_FIXED_HEADER_LENGTH {
+		return
+	}
+	pusher.lastRTP.lock.Lock()
+	if pusher.lastRTP.positions == nil {
+		pusher.lastRTP.positions = make(map[RTPType]rtpPosition)
+	}
+	pusher.lastRTP.positions[pack.Type] = rtpPosition{binary.BigEndian.Uint16(b[2:]), binary.BigEndian.Uint32(b[4:]), binary.BigEndian.Uint32(b[8:])}
+	pusher.lastRTP.lock.Unlock()
+}
+
+const (
+	rtpPositionUnknown = iota
+	// the sequence number only
+	rtpPositionSeq
+	rtpPositionKnown
+)
+
+// nextRTPPosition returns the sequence number and timestamp of the first packet of a track a new
+// player gets: the first cached one when it starts from the gop cache. Else it is the packet after
+// the last relayed one, whose sequence number follows but whose timestamp is not known yet.
+func (pusher *Pusher) nextRTPPosition(t RTPType, fromCache bool) (seq uint16, timestamp uint32, known int) {
+	if fromCache && pusher.gopCacheEnable {
+		pusher.gopCacheLock.RLock()
+		defer pusher.gopCacheLock.RUnlock()
+		for _, pack := range pusher.gopCache {
+			if b := pack.Buffer.Bytes(); pack.Type == t && len(b) >= RTP_FIXED_HEADER_LENGTH {
+				return binary.BigEndian.Uint16(b[2:]), binary.BigEndian.Uint32(b[4:]), rtpPositionKnown
+			}
+		}
+	}
+	pusher.lastRTP.lock.RLock()
+	defer pusher.lastRTP.lock.RUnlock()
+	if position, ok := pusher.lastRTP.positions[t]; ok {
+		return position.seq + 1, 0, rtpPositionSeq
+	}
+	return 0, 0, rtpPositionUnknown
+}
@@ -0,0 +1,32 @@
+package rtsp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRTPInfo(t *testing.T) {
+	pack := func(t RTPType, seq uint16, timestamp uint32) *RTPPack {
+		return &RTPPack{Type: t, Buffer: bytes.NewBuffer(selfTestRTP(96, seq, timestamp, false, []byte{0x41}))}
+	}
+	pusher := newTestPusher("h264")
+	pusher.gopCacheEnable = true
+	pusher.gopCache = []*RTPPack{pack(RTP_TYPE_VIDEO, 100, 9000), pack(RTP_TYPE_VIDEO, 101, 9000), pack(RTP_TYPE_AUDIO, 500, 1600)}
+	pusher.recordRTPPosition(pack(RTP_TYPE_VIDEO, 120, 18000))
+	session := &Session{Pusher: pusher}
+	session.addSetupTrack(RTP_TYPE_AUDIO, "rtsp://host/live/audio")
+	session.addSetupTrack(RTP_TYPE_VIDEO, "rtsp://host/live/video")
+	for _, c := range []struct {
+		name      string
+		fromCache bool
+		want      string
+	}{
+		{"from the gop cache", true, "url=rtsp://host/live/audio;seq=500;rtptime=1600,url=rtsp://host/live/video;seq=100;rtptime=9000"},
+		// the next packet follows the last relayed one, its timestamp is not known.
+		{"live", false, "url=rtsp://host/live/audio,url=rtsp://host/live/video;seq=121"},
+	} {
+		if got := session.rtpInfo(c.fromCache); got != c.want {
+			t.Errorf("%s: RTP-Info %q, want %q", c.name, got, c.want)
+		}
+	}
+}
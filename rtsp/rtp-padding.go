@@ -0,0 +1,89 @@
+package rtsp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+)
+
+// rtpPadder keeps the video of a player at padding_min_packet_rate packets per second: when no
+// video packet went out for a 1/rate interval, a padding only packet is sent, with the header of
+// the last one, the padding bit set, and padding_size bytes of padding whose last byte is the
+// count. It takes the next sequence number, the later packets are shifted behind it.
+type rtpPadder struct {
+	size     int
+	interval time.Duration
+	// video packets sent, read by the ticker
+	sent int64
+
+	// the header and the source sequence number of the last video packet sent
+	header []byte
+	seq    uint16
+}
+
+func newRTPPadder(path string) *rtpPadder {
+	rate := ChannelKey(path, "padding_min_packet_rate").MustInt(0)
+	if rate <= 0 {
+		return nil
+	}
+	size := ChannelKey(path, "padding_size").MustInt(100)
+	if size < 1 {
+		size = 1
+	} else if size > 255 {
+		size = 255
+	}
+	return &rtpPadder{size: size, interval: time.Second / time.Duration(rate)}
+}
+
+// videoSent records a video packet going out, before the sequence numbers are shifted.
+func (p *rtpPadder) videoSent(rtpBytes []byte) {
+	p.header = append(p.header[:0], rtpBytes[:RTP_FIXED_HEADER_LENGTH]...)
+	p.seq = binary.BigEndian.Uint16(rtpBytes[2:])
+	atomic.AddInt64(&p.sent, 1)
+}
+
+// packet builds the padding packet following the last video packet sent, nil if none was. The
+// sequence numbers of the player are shifted by seqOffset from the source ones.
+func (p *rtpPadder) packet(seqOffset uint16) []byte {
+	if p.header == nil {
+		return nil
+	}
+	rtp := make([]byte, RTP_FIXED_HEADER_LENGTH+p.size)
+	copy(rtp, p.header)
+	rtp[0] = 0xA0  // version 2 with padding, no extension nor csrc
+	rtp[1] &= 0x7F // no marker
+	binary.BigEndian.PutUint16(rtp[2:], p.seq+1+seqOffset)
+	rtp[len(rtp)-1] = byte(p.size)
+	return rtp
+}
+
+// padIdle queues a padding packet for every interval without video sent, until the player stops.
+func (player *Player) padIdle() {
+	ticker := time.NewTicker(player.padder.interval)
+	defer ticker.Stop()
+	last := atomic.LoadInt64(&player.padder.sent)
+	for range ticker.C {
+		if player.Stoped {
+			return
+		}
+		sent := atomic.LoadInt64(&player.padder.sent)
+		if sent == last {
+			player.QueueRTP(&RTPPack{Type: RTP_TYPE_VIDEO, Buffer: &bytes.Buffer{}, padding: true})
+		}
+		last = sent
+	}
+}
+
+// sendPadding sends a padding packet in the video sequence, from the player goroutine.
+func (player *Player) sendPadding() (err error) {
+	rtp := player.padder.packet(player.vSeqOffset)
+	if rtp == nil {
+		return
+	}
+	if err = player.SendRTP(&RTPPack{Type: RTP_TYPE_VIDEO, Buffer: bytes.NewBuffer(rtp)}); err != nil {
+		return
+	}
+	player.vSeqOffset++
+	return
+}
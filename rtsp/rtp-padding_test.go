@@ -0,0 +1,34 @@
+package rtsp
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestRTPPadderPacket(t *testing.T) {
+	p := &rtpPadder{size: 20}
+	if p.packet(0) != nil {
+		t.Errorf("padding packet before any video")
+	}
+	p.videoSent(selfTestRTP(96, 100, 3000, true, []byte{0x65, 1, 2, 3}))
+	for _, c := range []struct {
+		offset uint16
+		seq    uint16
+	}{
+		{0, 101},
+		{3, 104},
+		{0xFFFF, 100},
+	} {
+		rtp := p.packet(c.offset)
+		info := ParseRTP(rtp)
+		if info == nil {
+			t.Fatalf("padding packet does not parse")
+		}
+		if seq := binary.BigEndian.Uint16(rtp[2:]); seq != c.seq {
+			t.Errorf("offset %d: seq %d, want %d", c.offset, seq, c.seq)
+		}
+		if rtp[0]&0x20 == 0 || rtp[1]&0x80 != 0 || len(info.Payload) != 0 || int(rtp[len(rtp)-1]) != p.size {
+			t.Errorf("offset %d: not a padding only packet without marker, % x", c.offset, rtp[:2])
+		}
+	}
+}
@@ -20,6 +20,8 @@ type RTPInfo struct {
 	SSRC           int
 	Payload        []byte
 	PayloadOffset  int
+	// Keepalive marks a valid packet without payload, e.g. header + padding only.
+	Keepalive bool
 }
 
 func ParseRTP(rtpBytes []byte) *RTPInfo {
@@ -42,27 +44,36 @@ func ParseRTP(rtpBytes []byte) *RTPInfo {
 	}
 	offset := RTP_FIXED_HEADER_LENGTH
 	end := len(rtpBytes)
-	if end-offset >= 4*info.CSRCCnt {
-		offset += 4 * info.CSRCCnt
+	if end-offset < 4*info.CSRCCnt {
+		return nil
 	}
-	if info.Extension && end-offset >= 4 {
+	offset += 4 * info.CSRCCnt
+	if info.Extension {
+		// the extension length counts 32-bit words after the 4 bytes extension header, zero is valid.
+		if end-offset < 4 {
+			return nil
+		}
 		extLen := 4 * int(binary.BigEndian.Uint16(rtpBytes[offset+2:]))
 		offset += 4
-		if end-offset >= extLen {
-			offset += extLen
+		if end-offset < extLen {
+			return nil
 		}
+		offset += extLen
 	}
-	if info.Padding && end-offset > 0 {
+	if info.Padding {
+		// the last octet counts the padding octets, itself included, so it is at least 1.
+		if end-offset < 1 {
+			return nil
+		}
 		paddingLen := int(rtpBytes[end-1])
-		if end-offset >= paddingLen {
-			end -= paddingLen
+		if paddingLen < 1 || end-offset < paddingLen {
+			return nil
 		}
+		end -= paddingLen
 	}
 	info.Payload = rtpBytes[offset:end]
 	info.PayloadOffset = offset
-	if end-offset < 1 {
-		return nil
-	}
+	info.Keepalive = end-offset < 1
 
 	return info
 }
@@ -0,0 +1,131 @@
+package rtsp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseRTPKeepalive(t *testing.T) {
+	padded := func(payload []byte, padding int) []byte {
+		rtp := selfTestRTP(96, 1, 3000, false, payload)
+		rtp[0] |= 0x20
+		for i := 1; i < padding; i++ {
+			rtp = append(rtp, 0)
+		}
+		return append(rtp, byte(padding))
+	}
+	for _, c := range []struct {
+		name      string
+		rtp       []byte
+		parsed    bool
+		keepalive bool
+	}{
+		{"payload", selfTestRTP(96, 1, 3000, false, []byte{0x41, 0x9A}), true, false},
+		{"header only", selfTestRTP(96, 1, 3000, false, nil), true, true},
+		{"padding only", padded(nil, 4), true, true},
+		{"padded payload", padded([]byte{0x41}, 4), true, false},
+		{"zero padding", padded(nil, 4)[:RTP_FIXED_HEADER_LENGTH+2], false, false},
+		{"padding over the payload", padded([]byte{0x41}, 4)[:RTP_FIXED_HEADER_LENGTH+1], false, false},
+		{"short header", selfTestRTP(96, 1, 3000, false, nil)[:8], false, false},
+	} {
+		info := ParseRTP(c.rtp)
+		if (info != nil) != c.parsed {
+			t.Errorf("%s: parsed %v, want %v", c.name, info != nil, c.parsed)
+			continue
+		}
+		if info != nil && info.Keepalive != c.keepalive {
+			t.Errorf("%s: keepalive %v, want %v", c.name, info.Keepalive, c.keepalive)
+		}
+	}
+}
+
+func TestDepacketizeKeepalive(t *testing.T) {
+	slice := append([]byte{0x41}, bytes.Repeat([]byte{0x22}, 300)...)
+	packets := append(testRTPPackets("h264", 100, 0, 3000, slice), testRTPPackets("h264", 100, 0, 6000, slice)...)
+	d := &trackDepacketizer{Depacketizer: NewNALUDepacketizer("h264")}
+	seq := 10
+	var timestamps []int
+	for i, rtp := range packets {
+		// keepalives of their own timestamp between the access units and between fragments
+		if i == len(packets)/2 || i == len(packets)/2+1 {
+			keepalive := ParseRTP(selfTestRTP(96, uint16(seq), 99000, false, nil))
+			seq++
+			if _, _, complete, err := d.push(keepalive); complete || err != nil {
+				t.Fatalf("keepalive completes[%v] err[%v]", complete, err)
+			}
+		}
+		rtp.SequenceNumber = seq
+		seq++
+		au, timestamp, complete, err := d.push(rtp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if complete {
+			if !bytes.Equal(au, append(append([]byte{}, annexBStartCode...), slice...)) {
+				t.Errorf("access unit at %d differs", timestamp)
+			}
+			timestamps = append(timestamps, timestamp)
+		}
+	}
+	if len(timestamps) != 2 || timestamps[0] != 3000 || timestamps[1] != 6000 {
+		t.Errorf("access units at %v, want [3000 6000]", timestamps)
+	}
+	if stats := d.Depacketizer.(*NALUDepacketizer).Stats(); stats.Discarded != 0 {
+		t.Errorf("%d fragmentation units discarded, a keepalive is no loss", stats.Discarded)
+	}
+}
+
+func TestParseRTPExtensionPadding(t *testing.T) {
+	payload := []byte{0x41, 0x9A, 0x00, 0x00, 0x01}
+	// build makes a packet with csrcs, an extension of extWords (zero) words and padding octets.
+	build := func(csrcs int, extWords int, padding int) []byte {
+		rtp := selfTestRTP(96, 1, 3000, false, nil)
+		rtp[0] |= byte(csrcs)
+		for i := 0; i < csrcs; i++ {
+			rtp = append(rtp, 0, 0, 0, byte(i+1))
+		}
+		if extWords >= 0 {
+			rtp[0] |= 0x10
+			rtp = append(rtp, 0xBE, 0xDE, byte(extWords>>8), byte(extWords))
+			rtp = append(rtp, make([]byte, 4*extWords)...)
+		}
+		rtp = append(rtp, payload...)
+		if padding > 0 {
+			rtp[0] |= 0x20
+			rtp = append(rtp, make([]byte, padding-1)...)
+			rtp = append(rtp, byte(padding))
+		}
+		return rtp
+	}
+	for _, c := range []struct {
+		name                     string
+		csrcs, extWords, padding int
+		offset                   int
+	}{
+		{"no extension", 0, -1, 0, 12},
+		{"zero length extension", 0, 0, 0, 16},
+		{"zero length extension and padding", 0, 0, 3, 16},
+		{"zero padded extension and padding", 0, 2, 4, 24},
+		{"csrcs, extension and one octet of padding", 2, 1, 1, 28},
+		{"several octets of padding", 0, -1, 7, 12},
+	} {
+		info := ParseRTP(build(c.csrcs, c.extWords, c.padding))
+		if info == nil {
+			t.Errorf("%s: does not parse", c.name)
+			continue
+		}
+		if info.PayloadOffset != c.offset || !bytes.Equal(info.Payload, payload) {
+			t.Errorf("%s: payload at %d % x, want at %d % x", c.name, info.PayloadOffset, info.Payload, c.offset, payload)
+		}
+	}
+	// an extension longer than the packet, and padding eating into the extension
+	if ParseRTP(build(0, 2, 0)[:20]) != nil {
+		t.Errorf("truncated extension parses")
+	}
+	bad := build(0, 1, 0)
+	bad = append(bad[:20], byte(len(payload)+5))
+	bad[0] |= 0x20
+	if ParseRTP(bad) != nil {
+		t.Errorf("padding over the extension parses")
+	}
+}
@@ -4,16 +4,16 @@ import (
 	"bufio"
 	"bytes"
 	"crypto/md5"
+	"encoding/base64"
 	"encoding/binary"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"net/url"
-	"os"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/teris-io/shortid"
@@ -31,11 +31,13 @@ type RTSPClient struct {
 	URL                  string
 	Path                 string
 	CustomPath           string //custom path for pusher
+	Priority             int    //higher is kept longer under resource pressure
 	ID                   string
 	Conn                 *RichConn
 	Session              string
 	Seq                  int
 	connRW               *bufio.ReadWriter
+	connWLock            sync.Mutex
 	InBytes              int
 	OutBytes             int
 	TransType            TransType
@@ -58,12 +60,22 @@ type RTSPClient struct {
 	vRTPControlChannel int
 
 	UDPServer   *UDPServer
+	pusher      *Pusher // the pusher of NewClientPusher, if any
 	RTPHandles  []func(*RTPPack)
 	StopHandles []func()
+	stopOnce    sync.Once
+
+	// rotation at max session duration, see session-rotation.go
+	owner      *RTSPClient   // the client a session opened by a rotation feeds, nil for the client itself
+	feed       *RTSPClient   // the session the stream comes over, nil for the client itself
+	splice     *streamSplice // how the stream of a session opened by a rotation maps onto the old one
+	rotating   *streamSplice // the rotation in progress
+	spliceLock sync.Mutex
+	rotatedIn  int // bytes received over the sessions rotated away from
 }
 
 func (client *RTSPClient) String() string {
-	return fmt.Sprintf("client[%s]", client.URL)
+	return fmt.Sprintf("client[%s]", RedactURL(client.URL))
 }
 
 func NewRTSPClient(server *Server, rawUrl string, sendOptionMillis int64, agent string) (client *RTSPClient, err error) {
@@ -86,10 +98,7 @@ func NewRTSPClient(server *Server, rawUrl string, sendOptionMillis int64, agent
 		StartAt:              time.Now(),
 		Agent:                agent,
 	}
-	client.logger = log.New(os.Stdout, fmt.Sprintf("[%s]", client.ID), log.LstdFlags|log.Lshortfile)
-	if !utils.Debug {
-		client.logger.SetOutput(utils.GetLogWriter())
-	}
+	client.logger = NewSessionLogger(fmt.Sprintf("[%s]", client.ID))
 	return
 }
 
@@ -131,41 +140,61 @@ func DigestAuth(authLine string, method string, URL string) (string, error) {
 	return Authorization, nil
 }
 
-func (client *RTSPClient) checkAuth(method string, resp *Response) (string, error) {
-	if resp.StatusCode == 401 {
-
-		// need auth.
-		AuthHeaders := resp.Header["WWW-Authenticate"]
-		auths, ok := AuthHeaders.([]string)
-
-		if ok {
-			for _, authLine := range auths {
-
-				if strings.IndexAny(authLine, "Digest") == 0 {
-					// 					realm="HipcamRealServer",
-					// nonce="3b27a446bfa49b0c48c3edb83139543d"
-					client.authLine = authLine
-					return DigestAuth(authLine, method, client.URL)
-				} else if strings.IndexAny(authLine, "Basic") == 0 {
-					// not support yet
-					// TODO..
-				}
+// BasicAuth returns the Basic Authorization of the credentials in the userinfo of URL.
+func BasicAuth(URL string) (string, error) {
+	l, err := url.Parse(URL)
+	if err != nil {
+		return "", fmt.Errorf("Url parse error:%v,%v", URL, err)
+	}
+	password, _ := l.User.Password()
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(l.User.Username()+":"+password)), nil
+}
 
-			}
-			return "", fmt.Errorf("auth error")
-		} else {
-			authLine, _ := AuthHeaders.(string)
-			if strings.IndexAny(authLine, "Digest") == 0 {
+// RedactURL hides the password in the userinfo of a url, for logging.
+func RedactURL(rawURL string) string {
+	l, err := url.Parse(rawURL)
+	if err != nil || l.User == nil {
+		return rawURL
+	}
+	if _, ok := l.User.Password(); ok {
+		l.User = url.UserPassword(l.User.Username(), "xxxxx")
+	}
+	return l.String()
+}
+
+// authorization answers the challenge of authLine with the credentials in the userinfo of the url.
+func (client *RTSPClient) authorization(method string, authLine string) (string, error) {
+	if strings.HasPrefix(authLine, "Basic") {
+		return BasicAuth(client.URL)
+	}
+	return DigestAuth(authLine, method, client.URL)
+}
+
+func (client *RTSPClient) checkAuth(method string, resp *Response) (string, error) {
+	if resp.StatusCode != 401 {
+		return "", nil
+	}
+	// need auth.
+	var auths []string
+	switch AuthHeaders := resp.Header["WWW-Authenticate"].(type) {
+	case []string:
+		auths = AuthHeaders
+	case string:
+		auths = []string{AuthHeaders}
+	}
+	if l, err := url.Parse(client.URL); err != nil || l.User == nil {
+		return "", fmt.Errorf("auth error : no credentials in url")
+	}
+	// Digest is preferred, Basic sends the password in clear.
+	for _, scheme := range []string{"Digest", "Basic"} {
+		for _, authLine := range auths {
+			if strings.HasPrefix(authLine, scheme) {
 				client.authLine = authLine
-				return DigestAuth(authLine, method, client.URL)
-			} else if strings.IndexAny(authLine, "Basic") == 0 {
-				// not support yet
-				// TODO..
-				return "", fmt.Errorf("not support Basic auth yet")
+				return client.authorization(method, authLine)
 			}
 		}
 	}
-	return "", nil
+	return "", fmt.Errorf("auth error")
 }
 
 func (client *RTSPClient) requestStream(timeout time.Duration) (err error) {
@@ -257,18 +286,20 @@ func (client *RTSPClient) requestStream(timeout time.Duration) (err error) {
 	}
 	client.Sdp = _sdp
 	client.SDPRaw = resp.Body
+	requestURL := client.URL
+	if l, err := url.Parse(client.URL); err == nil {
+		// the credentials go to the Authorization header, not to the SETUP/PLAY urls.
+		l.User = nil
+		requestURL = l.String()
+	}
+	baseURL := DescribeBaseURL(resp, requestURL)
 	session := ""
 	for _, media := range _sdp.Media {
 		switch media.Type {
 		case "video":
 			client.VControl = media.Attributes.Get("control")
 			client.VCodec = media.Formats[0].Name
-			var _url = ""
-			if strings.Index(strings.ToLower(client.VControl), "rtsp://") == 0 {
-				_url = client.VControl
-			} else {
-				_url = strings.TrimRight(client.URL, "/") + "/" + strings.TrimLeft(client.VControl, "/")
-			}
+			_url := ResolveControlURL(baseURL, client.VControl)
 			headers = make(map[string]string)
 			if client.TransType == TRANS_TYPE_TCP {
 				headers["Transport"] = fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d", client.vRTPChannel, client.vRTPControlChannel)
@@ -297,12 +328,7 @@ func (client *RTSPClient) requestStream(timeout time.Duration) (err error) {
 		case "audio":
 			client.AControl = media.Attributes.Get("control")
 			client.ACodec = media.Formats[0].Name
-			var _url = ""
-			if strings.Index(strings.ToLower(client.AControl), "rtsp://") == 0 {
-				_url = client.AControl
-			} else {
-				_url = strings.TrimRight(client.URL, "/") + "/" + strings.TrimLeft(client.AControl, "/")
-			}
+			_url := ResolveControlURL(baseURL, client.AControl)
 			headers = make(map[string]string)
 			if client.TransType == TRANS_TYPE_TCP {
 				headers["Transport"] = fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d", client.aRTPChannel, client.aRTPControlChannel)
@@ -333,7 +359,12 @@ func (client *RTSPClient) requestStream(timeout time.Duration) (err error) {
 	if session != "" {
 		headers["Session"] = session
 	}
-	resp, err = client.Request("PLAY", headers)
+	// the aggregate control of the presentation, "*" or absent means the base url.
+	playURL := requestURL
+	if control := _sdp.Attributes.Get("control"); control != "" {
+		playURL = ResolveControlURL(baseURL, control)
+	}
+	resp, err = client.RequestWithPath("PLAY", playURL, headers, true)
 	if err != nil {
 		return err
 	}
@@ -343,7 +374,7 @@ func (client *RTSPClient) requestStream(timeout time.Duration) (err error) {
 func (client *RTSPClient) startStream() {
 	startTime := time.Now()
 	loggerTime := time.Now().Add(-10 * time.Second)
-	defer client.Stop()
+	defer client.feedEnded()
 	for !client.Stoped {
 		if client.OptionIntervalMillis > 0 {
 			if time.Since(startTime) > time.Duration(client.OptionIntervalMillis)*time.Millisecond {
@@ -423,9 +454,7 @@ func (client *RTSPClient) startStream() {
 				loggerTime = time.Now()
 			}
 			client.InBytes += int(length + 4)
-			for _, h := range client.RTPHandles {
-				h(pack)
-			}
+			client.ownerClient().deliver(client, pack)
 
 		default: // rtsp
 			builder := bytes.Buffer{}
@@ -484,27 +513,61 @@ func (client *RTSPClient) Start(timeout time.Duration) (err error) {
 	if err != nil {
 		return
 	}
+	client.watchMediaFlow()
 	go client.startStream()
 	return
 }
 
-func (client *RTSPClient) Stop() {
-	if client.Stoped {
+// SendInterleaved writes data to the server on an interleaved channel, e.g. rtcp feedback.
+func (client *RTSPClient) SendInterleaved(channel int, data []byte) (err error) {
+	if feed := client.currentFeed(); feed != client {
+		return feed.SendInterleaved(channel, data)
+	}
+	if client.Stoped || client.Conn == nil {
+		return fmt.Errorf("client stoped")
+	}
+	header := []byte{0x24, byte(channel), 0, 0}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(data)))
+	client.connWLock.Lock()
+	defer client.connWLock.Unlock()
+	if _, err = client.connRW.Write(header); err != nil {
+		return
+	}
+	if _, err = client.connRW.Write(data); err != nil {
 		return
 	}
+	return client.connRW.Flush()
+}
+
+func (client *RTSPClient) Stop() {
+	client.stopOnce.Do(client.stop)
+}
+
+func (client *RTSPClient) stop() {
 	client.Stoped = true
 	for _, h := range client.StopHandles {
 		h()
 	}
+	client.connWLock.Lock()
 	if client.Conn != nil {
 		client.connRW.Flush()
 		client.Conn.Close()
 		client.Conn = nil
 	}
+	client.connWLock.Unlock()
 	if client.UDPServer != nil {
 		client.UDPServer.Stop()
 		client.UDPServer = nil
 	}
+	client.spliceLock.Lock()
+	feed, rotating := client.feed, client.rotating
+	client.spliceLock.Unlock()
+	if feed != nil {
+		feed.Stop()
+	}
+	if rotating != nil {
+		rotating.next.Stop()
+	}
 }
 
 func (client *RTSPClient) RequestWithPath(method string, path string, headers map[string]string, needResp bool) (resp *Response, err error) {
@@ -512,7 +575,7 @@ func (client *RTSPClient) RequestWithPath(method string, path string, headers ma
 	headers["User-Agent"] = client.Agent
 	if len(headers["Authorization"]) == 0 {
 		if len(client.authLine) != 0 {
-			Authorization, _ := DigestAuth(client.authLine, method, client.URL)
+			Authorization, _ := client.authorization(method, client.authLine)
 			if len(Authorization) > 0 {
 				headers["Authorization"] = Authorization
 			}
@@ -531,12 +594,20 @@ func (client *RTSPClient) RequestWithPath(method string, path string, headers ma
 	}
 	builder.WriteString(fmt.Sprintf("\r\n"))
 	s := builder.String()
-	logger.Printf("[OUT]>>>\n%s", s)
+	if auth := headers["Authorization"]; strings.HasPrefix(auth, "Basic") {
+		logger.Printf("[OUT]>>>\n%s", strings.Replace(s, auth, "Basic xxxxx", 1))
+	} else {
+		logger.Printf("[OUT]>>>\n%s", s)
+	}
+	client.connWLock.Lock()
 	_, err = client.connRW.WriteString(s)
+	if err == nil {
+		err = client.connRW.Flush()
+	}
+	client.connWLock.Unlock()
 	if err != nil {
 		return
 	}
-	client.connRW.Flush()
 
 	if !needResp {
 		return nil, nil
@@ -3,8 +3,27 @@ package rtsp
 import (
 	"fmt"
 	"strconv"
+	"strings"
+
+	"github.com/penggy/EasyGoLib/utils"
+)
+
+// ServerVersion and ServerBuildDateTime fill the Server header of responses, set by main.
+var (
+	ServerVersion       = ""
+	ServerBuildDateTime = ""
 )
 
+// ServerHeader returns the Server header of responses from server_header, with {version} and
+// {build} replaced, empty if it is "none".
+func ServerHeader() string {
+	header := utils.Conf().Section("rtsp").Key("server_header").MustString("EasyDarwin/{version}")
+	if header == "none" {
+		return ""
+	}
+	return strings.Replace(strings.Replace(header, "{version}", ServerVersion, -1), "{build}", ServerBuildDateTime, -1)
+}
+
 type Response struct {
 	Version    string
 	StatusCode int
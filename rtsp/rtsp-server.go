@@ -20,26 +20,85 @@ type Server struct {
 	SessionLogger
 	TCPListener    *net.TCPListener
 	TCPPort        int
+	UnixServer     *UnixServer
 	Stoped         bool
 	pushers        map[string]*Pusher // Path <-> Pusher
 	pushersLock    sync.RWMutex
 	addPusherCh    chan *Pusher
 	removePusherCh chan *Pusher
+	// pushers whose recording restarts after a sps change
+	restartRecordCh chan *Pusher
+
+	httpTunnelEnable bool
+	tunnels          map[string]*httpTunnel // x-sessioncookie <-> GET half of the tunnel
+	tunnelsLock      sync.Mutex
+
+	pullRejected int
+	// slots taken by pull sources not added yet
+	pullReserved int
+	pullLock     sync.Mutex
+	// nil unless the streams are pulled, set once
+	ffmpegFallback *FFmpegFallback
+
+	// sessions with no media flowing after the signaling, see media_flow_timeout
+	oneWayMedia int64
+
+	transcodes     map[string]*exec.Cmd // output path <-> ffmpeg transcoding to it
+	transcodesLock sync.Mutex
+
+	// nil unless egress_bandwidth_limit is set
+	egress *egressScheduler
+
+	// closed on Stop, nil if metrics export is off
+	metricsStop chan struct{}
 }
 
 var Instance *Server = &Server{
-	SessionLogger:  SessionLogger{log.New(os.Stdout, "[RTSPServer]", log.LstdFlags|log.Lshortfile)},
-	Stoped:         true,
-	TCPPort:        utils.Conf().Section("rtsp").Key("port").MustInt(554),
-	pushers:        make(map[string]*Pusher),
-	addPusherCh:    make(chan *Pusher),
-	removePusherCh: make(chan *Pusher),
+	SessionLogger:   SessionLogger{log.New(os.Stdout, "[RTSPServer]", log.LstdFlags|log.Lshortfile)},
+	Stoped:          true,
+	TCPPort:         utils.Conf().Section("rtsp").Key("port").MustInt(554),
+	pushers:         make(map[string]*Pusher),
+	addPusherCh:     make(chan *Pusher),
+	removePusherCh:  make(chan *Pusher),
+	restartRecordCh: make(chan *Pusher, 16),
+
+	httpTunnelEnable: utils.Conf().Section("rtsp").Key("http_tunnel_enable").MustBool(false),
+	tunnels:          make(map[string]*httpTunnel),
+	transcodes:       make(map[string]*exec.Cmd),
+	egress:           newEgressScheduler(utils.Conf().Section("rtsp").Key("egress_bandwidth_limit").MustInt(0)),
 }
 
 func GetServer() *Server {
 	return Instance
 }
 
+// endedRecords are the recordings of pushers gone by path, resumed by a pusher of the path within
+// record_resume_grace.
+type endedRecords map[string]endedRecord
+
+type endedRecord struct {
+	dir string
+	at  time.Time
+}
+
+// end remembers the recording in dir of the pusher at path, and forgets the recordings ended
+// more than grace ago, whose paths did not come back.
+func (records endedRecords) end(path string, dir string, now time.Time, grace time.Duration) {
+	for p, ended := range records {
+		if now.Sub(ended.at) > grace {
+			delete(records, p)
+		}
+	}
+	records[path] = endedRecord{dir: dir, at: now}
+}
+
+// resume returns the recording of path ended within grace, if any, and forgets it.
+func (records endedRecords) resume(path string, now time.Time, grace time.Duration) (ended endedRecord, ok bool) {
+	ended, ok = records[path]
+	delete(records, path)
+	return ended, ok && now.Sub(ended.at) <= grace
+}
+
 func (server *Server) Start() (err error) {
 	logger := server.logger
 	addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf(":%d", server.TCPPort))
@@ -55,6 +114,7 @@ func (server *Server) Start() (err error) {
 	ffmpeg := utils.Conf().Section("rtsp").Key("ffmpeg_path").MustString("")
 	m3u8_dir_path := utils.Conf().Section("rtsp").Key("m3u8_dir_path").MustString("")
 	ts_duration_second := utils.Conf().Section("rtsp").Key("ts_duration_second").MustInt(6)
+	resumeGrace := time.Duration(utils.Conf().Section("rtsp").Key("record_resume_grace").MustInt(0)) * time.Second
 	SaveStreamToLocal := false
 	if (len(ffmpeg) > 0) && localRecord > 0 && len(m3u8_dir_path) > 0 {
 		err := utils.EnsureDir(m3u8_dir_path)
@@ -66,10 +126,59 @@ func (server *Server) Start() (err error) {
 	}
 	go func() { // save to local.
 		pusher2ffmpegMap := make(map[*Pusher]*exec.Cmd)
+		pusher2dirMap := make(map[*Pusher]string)
+		records := endedRecords{}
 		if SaveStreamToLocal {
 			logger.Printf("Prepare to save stream to local....")
 			defer logger.Printf("End save stream to local....")
 		}
+		// startRecord records pusher in dir, today's directory if empty.
+		startRecord := func(pusher *Pusher, dir string, discontinuity bool) *exec.Cmd {
+			if dir == "" {
+				dir = path.Join(m3u8_dir_path, pusher.Path(), time.Now().Format("20060102"))
+			}
+			err := utils.EnsureDir(dir)
+			if err != nil {
+				logger.Printf("EnsureDir:[%s] err:%v.", dir, err)
+				return nil
+			}
+			m3u8path := path.Join(dir, fmt.Sprintf("out.m3u8"))
+			port := pusher.Server().TCPPort
+			rtsp := fmt.Sprintf("rtsp://localhost:%d%s", port, pusher.Path())
+			paramStr := utils.Conf().Section("rtsp").Key(pusher.Path()).MustString("-c:v copy -c:a aac")
+			params := []string{"-fflags", "genpts", "-rtsp_transport", "tcp", "-i", rtsp, "-hls_time", strconv.Itoa(ts_duration_second), "-hls_list_size", "0", m3u8path}
+			if paramStr != "default" {
+				paramsOfThisPath := strings.Split(paramStr, " ")
+				params = append(params[:6], append(paramsOfThisPath, params[6:]...)...)
+			}
+			if discontinuity {
+				params = append(params[:len(params)-1], "-hls_flags", "append_list+discont_start", m3u8path)
+			}
+			switch video, audio := recordTracks(pusher.Path()); {
+			case !audio:
+				params = append(params[:len(params)-1], "-an", m3u8path)
+			case !video:
+				params = append(params[:len(params)-1], "-vn", m3u8path)
+			}
+			// ffmpeg -i ~/Downloads/720p.mp4 -s 640x360 -g 15 -c:a aac -hls_time 5 -hls_list_size 0 record.m3u8
+			cmd := exec.Command(ffmpeg, params...)
+			flag := os.O_RDWR | os.O_CREATE
+			if discontinuity {
+				flag |= os.O_APPEND
+			}
+			f, err := os.OpenFile(path.Join(dir, fmt.Sprintf("log.txt")), flag, 0755)
+			if err == nil {
+				cmd.Stdout = f
+				cmd.Stderr = f
+			}
+			err = cmd.Start()
+			if err != nil {
+				logger.Printf("Start ffmpeg err:%v", err)
+			}
+			logger.Printf("add ffmpeg [%v] to pull stream from pusher[%v]", cmd, pusher)
+			pusher2dirMap[pusher] = dir
+			return cmd
+		}
 		var pusher *Pusher
 		addChnOk := true
 		removeChnOk := true
@@ -78,38 +187,34 @@ func (server *Server) Start() (err error) {
 			case pusher, addChnOk = <-server.addPusherCh:
 				if SaveStreamToLocal {
 					if addChnOk {
-						dir := path.Join(m3u8_dir_path, pusher.Path(), time.Now().Format("20060102"))
-						err := utils.EnsureDir(dir)
-						if err != nil {
-							logger.Printf("EnsureDir:[%s] err:%v.", dir, err)
-							continue
+						// a source reconnecting within the grace window goes on with the same
+						// playlist, after a discontinuity, even past midnight.
+						dir, discontinuity := "", false
+						if ended, ok := records.resume(pusher.Path(), time.Now(), resumeGrace); ok {
+							dir, discontinuity = ended.dir, true
+							logger.Printf("resume recording of [%s] in [%s] after %v", pusher.Path(), dir, time.Since(ended.at))
 						}
-						m3u8path := path.Join(dir, fmt.Sprintf("out.m3u8"))
-						port := pusher.Server().TCPPort
-						rtsp := fmt.Sprintf("rtsp://localhost:%d%s", port, pusher.Path())
-						paramStr := utils.Conf().Section("rtsp").Key(pusher.Path()).MustString("-c:v copy -c:a aac")
-						params := []string{"-fflags", "genpts", "-rtsp_transport", "tcp", "-i", rtsp, "-hls_time", strconv.Itoa(ts_duration_second), "-hls_list_size", "0", m3u8path}
-						if paramStr != "default" {
-							paramsOfThisPath := strings.Split(paramStr, " ")
-							params = append(params[:6], append(paramsOfThisPath, params[6:]...)...)
+						if cmd := startRecord(pusher, dir, discontinuity); cmd != nil {
+							pusher2ffmpegMap[pusher] = cmd
 						}
-						// ffmpeg -i ~/Downloads/720p.mp4 -s 640x360 -g 15 -c:a aac -hls_time 5 -hls_list_size 0 record.m3u8
-						cmd := exec.Command(ffmpeg, params...)
-						f, err := os.OpenFile(path.Join(dir, fmt.Sprintf("log.txt")), os.O_RDWR|os.O_CREATE, 0755)
-						if err == nil {
-							cmd.Stdout = f
-							cmd.Stderr = f
-						}
-						err = cmd.Start()
-						if err != nil {
-							logger.Printf("Start ffmpeg err:%v", err)
-						}
-						pusher2ffmpegMap[pusher] = cmd
-						logger.Printf("add ffmpeg [%v] to pull stream from pusher[%v]", cmd, pusher)
 					} else {
 						logger.Printf("addPusherChan closed")
 					}
 				}
+			case pusher = <-server.restartRecordCh:
+				cmd, ok := pusher2ffmpegMap[pusher]
+				if !SaveStreamToLocal || !ok {
+					continue
+				}
+				// restart ffmpeg at the new sps, appending to the playlist after a discontinuity.
+				if proc := cmd.Process; proc != nil {
+					proc.Signal(syscall.SIGTERM)
+					proc.Wait()
+				}
+				delete(pusher2ffmpegMap, pusher)
+				if cmd := startRecord(pusher, pusher2dirMap[pusher], true); cmd != nil {
+					pusher2ffmpegMap[pusher] = cmd
+				}
 			case pusher, removeChnOk = <-server.removePusherCh:
 				if SaveStreamToLocal {
 					if removeChnOk {
@@ -129,6 +234,10 @@ func (server *Server) Start() (err error) {
 							logger.Printf("process:%v terminate.", proc)
 						}
 						delete(pusher2ffmpegMap, pusher)
+						if resumeGrace > 0 {
+							records.end(pusher.Path(), pusher2dirMap[pusher], time.Now(), resumeGrace)
+						}
+						delete(pusher2dirMap, pusher)
 						logger.Printf("delete ffmpeg from pull stream from pusher[%v]", pusher)
 					} else {
 						for _, cmd := range pusher2ffmpegMap {
@@ -139,6 +248,7 @@ func (server *Server) Start() (err error) {
 							}
 						}
 						pusher2ffmpegMap = make(map[*Pusher]*exec.Cmd)
+						pusher2dirMap = make(map[*Pusher]string)
 						logger.Printf("removePusherChan closed")
 					}
 				}
@@ -149,6 +259,14 @@ func (server *Server) Start() (err error) {
 	server.Stoped = false
 	server.TCPListener = listener
 	logger.Println("rtsp server start on", server.TCPPort)
+	server.UnixServer = NewUnixServer(server)
+	if err := server.UnixServer.Start(); err != nil {
+		logger.Printf("start unix socket ingest err:%v", err)
+	}
+	if utils.Conf().Section("rtsp").Key("metrics_export_url").MustString("") != "" {
+		server.metricsStop = make(chan struct{})
+		go server.exportMetrics(server.metricsStop)
+	}
 	networkBuffer := utils.Conf().Section("rtsp").Key("network_buffer").MustInt(1048576)
 	for !server.Stoped {
 		conn, err := server.TCPListener.Accept()
@@ -165,6 +283,10 @@ func (server *Server) Start() (err error) {
 			}
 		}
 
+		if server.httpTunnelEnable {
+			go server.handleConn(conn)
+			continue
+		}
 		session := NewSession(server, conn)
 		go session.Start()
 	}
@@ -179,6 +301,18 @@ func (server *Server) Stop() {
 		server.TCPListener.Close()
 		server.TCPListener = nil
 	}
+	if server.UnixServer != nil {
+		server.UnixServer.Stop()
+		server.UnixServer = nil
+	}
+	if server.metricsStop != nil {
+		close(server.metricsStop)
+		server.metricsStop = nil
+	}
+	if server.ffmpegFallback != nil {
+		server.ffmpegFallback.Stop()
+	}
+	server.stopTranscodes()
 	server.pushersLock.Lock()
 	server.pushers = make(map[string]*Pusher)
 	server.pushersLock.Unlock()
@@ -190,6 +324,10 @@ func (server *Server) Stop() {
 func (server *Server) AddPusher(pusher *Pusher) bool {
 	logger := server.logger
 	added := false
+	if pusher.liveGate == nil {
+		// set before the pusher is found by players.
+		pusher.liveGate = newLiveGate(pusher)
+	}
 	server.pushersLock.Lock()
 	_, ok := server.pushers[pusher.Path()]
 	if !ok {
@@ -201,7 +339,9 @@ func (server *Server) AddPusher(pusher *Pusher) bool {
 	}
 	server.pushersLock.Unlock()
 	if added {
-		go pusher.Start()
+		pusher.startTSRecord()
+		pusher.startRotation()
+		pusher.Go(pusher.Start)
 		server.addPusherCh <- pusher
 	}
 	return added
@@ -235,6 +375,9 @@ func (server *Server) RemovePusher(pusher *Pusher) {
 	}
 	server.pushersLock.Unlock()
 	if removed {
+		if pusher.tsRecorder != nil {
+			pusher.tsRecorder.Close()
+		}
 		server.removePusherCh <- pusher
 	}
 }
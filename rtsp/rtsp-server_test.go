@@ -0,0 +1,31 @@
+package rtsp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEndedRecords(t *testing.T) {
+	grace := 10 * time.Second
+	start := time.Now()
+	records := endedRecords{}
+	records.end("/a", "/rec/a", start, grace)
+	records.end("/b", "/rec/b", start.Add(5*time.Second), grace)
+	if ended, ok := records.resume("/a", start.Add(8*time.Second), grace); !ok || ended.dir != "/rec/a" {
+		t.Errorf("/a not resumed within the grace, %+v", ended)
+	}
+	if _, ok := records.resume("/a", start.Add(9*time.Second), grace); ok {
+		t.Errorf("/a resumed twice")
+	}
+	// /b expired, /c ended now: /b is pruned although its path never came back
+	records.end("/c", "/rec/c", start.Add(20*time.Second), grace)
+	if _, ok := records["/b"]; ok || len(records) != 1 {
+		t.Errorf("expired records kept: %v", records)
+	}
+	if _, ok := records.resume("/c", start.Add(31*time.Second), grace); ok {
+		t.Errorf("/c resumed after the grace")
+	}
+	if len(records) != 0 {
+		t.Errorf("records left: %v", records)
+	}
+}
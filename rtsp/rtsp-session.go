@@ -7,10 +7,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"net/url"
-	"os"
 	"regexp"
 	"strconv"
 	"strings"
@@ -27,6 +25,13 @@ import (
 type RTPPack struct {
 	Type   RTPType
 	Buffer *bytes.Buffer
+
+	// set by the pusher on the first video packet of a keyframe
+	Keyframe bool
+	// the keyframe is already preceded by parameter sets in the stream
+	ParameterSetsInline bool
+	// queued by the padder of a player, the packet is built when it is sent
+	padding bool
 }
 
 type SessionType int
@@ -74,6 +79,7 @@ type TransType int
 const (
 	TRANS_TYPE_TCP TransType = iota
 	TRANS_TYPE_UDP
+	TRANS_TYPE_UNIX
 )
 
 func (tt TransType) String() string {
@@ -82,6 +88,8 @@ func (tt TransType) String() string {
 		return "TCP"
 	case TRANS_TYPE_UDP:
 		return "UDP"
+	case TRANS_TYPE_UNIX:
+		return "UNIX"
 	}
 	return "unknow"
 }
@@ -117,7 +125,8 @@ type Session struct {
 	StartAt  time.Time
 	Timeout  int
 
-	Stoped bool
+	Stoped   bool
+	stopOnce sync.Once
 
 	//tcp channels
 	aRTPChannel        int
@@ -125,11 +134,18 @@ type Session struct {
 	vRTPChannel        int
 	vRTPControlChannel int
 
-	Pusher      *Pusher
-	Player      *Player
+	Pusher *Pusher
+	Player *Player
+	// the tracks a player SETUP, in order
+	setupTracks []setupTrack
 	UDPClient   *UDPClient
 	RTPHandles  []func(*RTPPack)
 	StopHandles []func()
+
+	// ends the session at max_session_duration
+	expireTimer *time.Timer
+	// a udp player is checked for media flowing once
+	mediaFlowWatched bool
 }
 
 func (session *Session) String() string {
@@ -159,26 +175,29 @@ func NewSession(server *Server, conn net.Conn) *Session {
 		closeOld:            close_old != 0,
 	}
 
-	session.logger = log.New(os.Stdout, fmt.Sprintf("[%s]", session.ID), log.LstdFlags|log.Lshortfile)
-	if !utils.Debug {
-		session.logger.SetOutput(utils.GetLogWriter())
-	}
+	session.logger = NewSessionLogger(fmt.Sprintf("[%s]", session.ID))
 	return session
 }
 
 func (session *Session) Stop() {
-	if session.Stoped {
-		return
-	}
+	session.stopOnce.Do(session.stop)
+}
+
+func (session *Session) stop() {
 	session.Stoped = true
+	if session.expireTimer != nil {
+		session.expireTimer.Stop()
+	}
 	for _, h := range session.StopHandles {
 		h()
 	}
+	session.connWLock.Lock()
 	if session.Conn != nil {
 		session.connRW.Flush()
 		session.Conn.Close()
 		session.Conn = nil
 	}
+	session.connWLock.Unlock()
 	if session.UDPClient != nil {
 		session.UDPClient.Stop()
 		session.UDPClient = nil
@@ -366,6 +385,9 @@ func (session *Session) handleRequest(req *Request) {
 	logger := session.logger
 	logger.Printf("<<<\n%s", req)
 	res := NewResponse(200, "OK", req.Header["CSeq"], session.ID, "")
+	if server := ServerHeader(); server != "" {
+		res.Header["Server"] = server
+	}
 	defer func() {
 		if p := recover(); p != nil {
 			logger.Printf("handleRequest err ocurs:%v", p)
@@ -384,6 +406,8 @@ func (session *Session) handleRequest(req *Request) {
 			switch session.Type {
 			case SESSEION_TYPE_PLAYER:
 				session.Pusher.AddPlayer(session.Player)
+				session.startExpireTimer()
+				session.watchMediaFlow()
 				// case SESSION_TYPE_PUSHER:
 				// 	session.Server.AddPusher(session.Pusher)
 			}
@@ -507,14 +531,64 @@ func (session *Session) handleRequest(req *Request) {
 			res.Status = "NOT FOUND"
 			return
 		}
+		if !pusher.Live() {
+			logger.Printf("%v not live yet, waiting for %d keyframes", pusher, pusher.liveGate.need)
+			res.StatusCode = 503
+			res.Status = "Service Unavailable"
+			return
+		}
+		if strings.EqualFold(pusher.VCodec(), "h265") && ChannelKey(session.Path, "transcode_on_demand").MustBool(false) && needsH264(req) {
+			transcode, err := session.Server.H264Transcode(session.Path)
+			if err != nil {
+				logger.Printf("%v", err)
+				res.StatusCode = 503
+				res.Status = "Service Unavailable"
+				if err == ErrTranscodeStarting {
+					res.Header["Retry-After"] = "1"
+				}
+				return
+			}
+			pusher = transcode
+		}
+		if sps := pusher.H264SPS(); sps != nil {
+			if action, err := CheckH264Profile(session.Path, sps); err != nil {
+				logger.Printf("%v, %v, %s", sps, err, action)
+				switch action {
+				case H264ProfileActionReject:
+					res.StatusCode = 406
+					res.Status = "Not Acceptable"
+					return
+				case H264ProfileActionTranscode:
+					compat, err := session.Server.H264CompatTranscode(pusher.Path())
+					if err != nil {
+						logger.Printf("%v", err)
+						res.StatusCode = 503
+						res.Status = "Service Unavailable"
+						if err == ErrTranscodeStarting {
+							res.Header["Retry-After"] = "1"
+						}
+						return
+					}
+					pusher = compat
+				}
+			}
+		}
 		session.Player = NewPlayer(session, pusher)
 		session.Pusher = pusher
 		session.AControl = pusher.AControl()
 		session.VControl = pusher.VControl()
 		session.ACodec = pusher.ACodec()
 		session.VCodec = pusher.VCodec()
+		sdp := pusher.SDPRaw()
+		if pusher.AudioDisabled() {
+			session.AControl, session.ACodec = "", ""
+			sdp = StripSDPMedia(sdp, "audio")
+		}
 		session.Conn.timeout = 0
-		res.SetBody(session.Pusher.SDPRaw())
+		// relative track controls of the sdp resolve against it.
+		url.RawQuery = ""
+		res.Header["Content-Base"] = strings.TrimRight(url.String(), "/") + "/"
+		res.SetBody(InjectSDPAttributes(session.Path, sdp, logger))
 	case "SETUP":
 		ts := req.Header["Transport"]
 		// control字段可能是`stream=1`字样，也可能是rtsp://...字样。即control可能是url的path，也可能是整个url
@@ -524,16 +598,12 @@ func (session *Session) handleRequest(req *Request) {
 		// a=control:rtsp://192.168.1.64/trackID=1
 		// 例3：
 		// a=control:?ctype=video
-		setupUrl, err := url.Parse(req.URL)
+		setupPath, err := setupControlURL(req.URL)
 		if err != nil {
 			res.StatusCode = 500
 			res.Status = "Invalid URL"
 			return
 		}
-		if setupUrl.Port() == "" {
-			setupUrl.Host = fmt.Sprintf("%s:554", setupUrl.Host)
-		}
-		setupPath := setupUrl.String()
 
 		// error status. SETUP without ANNOUNCE or DESCRIBE.
 		if session.Pusher == nil {
@@ -541,58 +611,36 @@ func (session *Session) handleRequest(req *Request) {
 			res.Status = "Error Status"
 			return
 		}
-		//setupPath = setupPath[strings.LastIndex(setupPath, "/")+1:]
-		vPath := ""
-		if strings.Index(strings.ToLower(session.VControl), "rtsp://") == 0 {
-			vControlUrl, err := url.Parse(session.VControl)
-			if err != nil {
-				res.StatusCode = 500
-				res.Status = "Invalid VControl"
-				return
-			}
-			if vControlUrl.Port() == "" {
-				vControlUrl.Host = fmt.Sprintf("%s:554", vControlUrl.Host)
-			}
-			vPath = vControlUrl.String()
-		} else {
-			vPath = session.VControl
+		vPath, err := setupControlURL(session.VControl)
+		if err != nil {
+			res.StatusCode = 500
+			res.Status = "Invalid VControl"
+			return
 		}
-
-		aPath := ""
-		if strings.Index(strings.ToLower(session.AControl), "rtsp://") == 0 {
-			aControlUrl, err := url.Parse(session.AControl)
-			if err != nil {
-				res.StatusCode = 500
-				res.Status = "Invalid AControl"
-				return
-			}
-			if aControlUrl.Port() == "" {
-				aControlUrl.Host = fmt.Sprintf("%s:554", aControlUrl.Host)
-			}
-			aPath = aControlUrl.String()
-		} else {
-			aPath = session.AControl
+		aPath, err := setupControlURL(session.AControl)
+		if err != nil {
+			res.StatusCode = 500
+			res.Status = "Invalid AControl"
+			return
 		}
 
-		mtcp := regexp.MustCompile("interleaved=(\\d+)(-(\\d+))?")
-		mudp := regexp.MustCompile("client_port=(\\d+)(-(\\d+))?")
-
-		if tcpMatchs := mtcp.FindStringSubmatch(ts); tcpMatchs != nil {
-			session.TransType = TRANS_TYPE_TCP
-			if setupPath == aPath || aPath != "" && strings.LastIndex(setupPath, aPath) == len(setupPath)-len(aPath) {
-				session.aRTPChannel, _ = strconv.Atoi(tcpMatchs[1])
-				session.aRTPControlChannel, _ = strconv.Atoi(tcpMatchs[3])
-			} else if setupPath == vPath || vPath != "" && strings.LastIndex(setupPath, vPath) == len(setupPath)-len(vPath) {
-				session.vRTPChannel, _ = strconv.Atoi(tcpMatchs[1])
-				session.vRTPControlChannel, _ = strconv.Atoi(tcpMatchs[3])
+		t, statusCode, err := session.negotiateSetup(setupPath, aPath, vPath, ts, session.Type == SESSEION_TYPE_PLAYER && session.Pusher.AudioDisabled())
+		if err != nil {
+			res.StatusCode = statusCode
+			res.Status = fmt.Sprintf("SETUP %v", err)
+			logger.Printf("SETUP %v", err)
+			return
+		}
+		session.TransType = t.transType
+		logger.Printf("Parse SETUP req.TRANSPORT:%v.Session.Type:%d,control:%s, AControl:%s,VControl:%s", t.transType, session.Type, setupPath, aPath, vPath)
+		if t.transType == TRANS_TYPE_TCP {
+			if t.rtpType == RTP_TYPE_AUDIO {
+				session.aRTPChannel, session.aRTPControlChannel = t.channels[0], t.channels[1]
 			} else {
-				res.StatusCode = 500
-				res.Status = fmt.Sprintf("SETUP [TCP] got UnKown control:%s", setupPath)
-				logger.Printf("SETUP [TCP] got UnKown control:%s", setupPath)
+				session.vRTPChannel, session.vRTPControlChannel = t.channels[0], t.channels[1]
 			}
-			logger.Printf("Parse SETUP req.TRANSPORT:TCP.Session.Type:%d,control:%s, AControl:%s,VControl:%s", session.Type, setupPath, aPath, vPath)
-		} else if udpMatchs := mudp.FindStringSubmatch(ts); udpMatchs != nil {
-			session.TransType = TRANS_TYPE_UDP
+			session.addSetupTrack(t.rtpType, req.URL)
+		} else {
 			// no need for tcp timeout.
 			session.Conn.timeout = 0
 			if session.Type == SESSEION_TYPE_PLAYER && session.UDPClient == nil {
@@ -605,16 +653,16 @@ func (session *Session) handleRequest(req *Request) {
 					Session: session,
 				}
 			}
-			logger.Printf("Parse SETUP req.TRANSPORT:UDP.Session.Type:%d,control:%s, AControl:%s,VControl:%s", session.Type, setupPath, aPath, vPath)
-			if setupPath == aPath || aPath != "" && strings.LastIndex(setupPath, aPath) == len(setupPath)-len(aPath) {
+			if t.rtpType == RTP_TYPE_AUDIO {
 				if session.Type == SESSEION_TYPE_PLAYER {
-					session.UDPClient.APort, _ = strconv.Atoi(udpMatchs[1])
-					session.UDPClient.AControlPort, _ = strconv.Atoi(udpMatchs[3])
+					session.UDPClient.APort, session.UDPClient.AControlPort = t.channels[0], t.channels[1]
 					if err := session.UDPClient.SetupAudio(); err != nil {
 						res.StatusCode = 500
 						res.Status = fmt.Sprintf("udp client setup audio error, %v", err)
 						return
 					}
+					session.addSetupTrack(RTP_TYPE_AUDIO, req.URL)
+					ts = transportServerPort(ts, t.match, session.UDPClient.localPort(session.UDPClient.AConn), session.UDPClient.localPort(session.UDPClient.AControlConn))
 				}
 				if session.Type == SESSION_TYPE_PUSHER {
 					if err := session.Pusher.UDPServer.SetupAudio(); err != nil {
@@ -622,52 +670,36 @@ func (session *Session) handleRequest(req *Request) {
 						res.Status = fmt.Sprintf("udp server setup audio error, %v", err)
 						return
 					}
-					tss := strings.Split(ts, ";")
-					idx := -1
-					for i, val := range tss {
-						if val == udpMatchs[0] {
-							idx = i
-						}
-					}
-					tail := append([]string{}, tss[idx+1:]...)
-					tss = append(tss[:idx+1], fmt.Sprintf("server_port=%d-%d", session.Pusher.UDPServer.APort, session.Pusher.UDPServer.AControlPort))
-					tss = append(tss, tail...)
-					ts = strings.Join(tss, ";")
+					ts = transportServerPort(ts, t.match, session.Pusher.UDPServer.APort, session.Pusher.UDPServer.AControlPort)
 				}
-			} else if setupPath == vPath || vPath != "" && strings.LastIndex(setupPath, vPath) == len(setupPath)-len(vPath) {
+			} else {
 				if session.Type == SESSEION_TYPE_PLAYER {
-					session.UDPClient.VPort, _ = strconv.Atoi(udpMatchs[1])
-					session.UDPClient.VControlPort, _ = strconv.Atoi(udpMatchs[3])
+					session.UDPClient.VPort, session.UDPClient.VControlPort = t.channels[0], t.channels[1]
 					if err := session.UDPClient.SetupVideo(); err != nil {
 						res.StatusCode = 500
 						res.Status = fmt.Sprintf("udp client setup video error, %v", err)
 						return
 					}
+					session.addSetupTrack(RTP_TYPE_VIDEO, req.URL)
+					ts = transportServerPort(ts, t.match, session.UDPClient.localPort(session.UDPClient.VConn), session.UDPClient.localPort(session.UDPClient.VControlConn))
 				}
-
 				if session.Type == SESSION_TYPE_PUSHER {
 					if err := session.Pusher.UDPServer.SetupVideo(); err != nil {
 						res.StatusCode = 500
 						res.Status = fmt.Sprintf("udp server setup video error, %v", err)
 						return
 					}
-					tss := strings.Split(ts, ";")
-					idx := -1
-					for i, val := range tss {
-						if val == udpMatchs[0] {
-							idx = i
-						}
-					}
-					tail := append([]string{}, tss[idx+1:]...)
-					tss = append(tss[:idx+1], fmt.Sprintf("server_port=%d-%d", session.Pusher.UDPServer.VPort, session.Pusher.UDPServer.VControlPort))
-					tss = append(tss, tail...)
-					ts = strings.Join(tss, ";")
+					ts = transportServerPort(ts, t.match, session.Pusher.UDPServer.VPort, session.Pusher.UDPServer.VControlPort)
 				}
-			} else {
-				logger.Printf("SETUP [UDP] got UnKown control:%s", setupPath)
 			}
 		}
 		res.Header["Transport"] = ts
+		if session.Type == SESSEION_TYPE_PLAYER {
+			if blocksize := sessionBlocksize(session.Path, req.Header["Blocksize"]); blocksize > 0 {
+				session.Player.Blocksize = blocksize
+				res.Header["Blocksize"] = strconv.Itoa(blocksize)
+			}
+		}
 	case "PLAY":
 		// error status. PLAY without ANNOUNCE or DESCRIBE.
 		if session.Pusher == nil {
@@ -676,6 +708,22 @@ func (session *Session) handleRequest(req *Request) {
 			return
 		}
 		res.Header["Range"] = req.Header["Range"]
+		if session.Type == SESSEION_TYPE_PLAYER {
+			session.Player.LowLatency = isRequestFlagSet(req, session.URL, "lowlatency", "X-Low-Latency")
+			session.Player.PinParameterSets = ChannelKey(session.Path, "pin_parameter_sets").MustBool(false) ||
+				isRequestFlagSet(req, session.URL, "pinparamsets", "X-Pin-Parameter-Sets")
+			maxLayer := ChannelKey(session.Path, "max_temporal_layer").MustInt(-1)
+			if layer, ok := requestInt(req, session.URL, "maxtid", "X-Max-Temporal-Layer"); ok {
+				maxLayer = layer
+			}
+			session.Player.temporalLayers = newTemporalLayerFilter(session.VCodec, maxLayer)
+			if pacing, ok := requestString(req, session.URL, "pacing", "X-Pacing"); ok {
+				session.Player.Pacing = parsePacing(pacing)
+			}
+			if rtpInfo := session.rtpInfo(!session.Player.LowLatency); rtpInfo != "" {
+				res.Header["RTP-Info"] = rtpInfo
+			}
+		}
 	case "RECORD":
 		// error status. RECORD without ANNOUNCE or DESCRIBE.
 		if session.Pusher == nil {
@@ -691,6 +739,10 @@ func (session *Session) SendRTP(pack *RTPPack) (err error) {
 		err = fmt.Errorf("player send rtp got nil pack")
 		return
 	}
+	if !session.hasTrack(pack.Type) {
+		// the player did not SETUP this track, e.g. an audio-only player of an audio/video channel.
+		return
+	}
 	if session.TransType == TRANS_TYPE_UDP {
 		if session.UDPClient == nil {
 			err = fmt.Errorf("player use udp transport but udp client not found")
@@ -757,3 +809,126 @@ func (session *Session) SendRTP(pack *RTPPack) (err error) {
 	}
 	return
 }
+
+// startExpireTimer ends the session when it has run for max_session_duration seconds of its channel.
+func (session *Session) startExpireTimer() {
+	maxDuration := ChannelKey(session.Path, "max_session_duration").MustInt(0)
+	if maxDuration <= 0 || session.expireTimer != nil {
+		return
+	}
+	session.expireTimer = time.AfterFunc(time.Until(session.StartAt.Add(time.Duration(maxDuration)*time.Second)), session.expire)
+}
+
+// expire tells the client the session is over by a server to client TEARDOWN (RFC 7826 13.7,
+// ignored by clients that do not know it), then closes the connection. It runs on the timer, so it
+// leaves the stop to the read loop of the session, which ends on the closed connection.
+func (session *Session) expire() {
+	session.connWLock.Lock()
+	defer session.connWLock.Unlock()
+	if session.Conn == nil {
+		// stopped meanwhile
+		return
+	}
+	session.logger.Printf("%v reaches max session duration, teardown", session)
+	req := &Request{
+		Method:  "TEARDOWN",
+		URL:     session.URL,
+		Version: RTSP_VERSION,
+		Header:  map[string]string{"CSeq": "1", "Session": session.ID},
+	}
+	session.connRW.WriteString(req.String())
+	session.connRW.Flush()
+	session.Conn.Close()
+}
+
+// hasTrack tells whether the track of a pack type is set up by the player session.
+func (session *Session) hasTrack(t RTPType) bool {
+	if session.TransType == TRANS_TYPE_UDP {
+		if session.UDPClient == nil {
+			return true
+		}
+		switch t {
+		case RTP_TYPE_AUDIO, RTP_TYPE_AUDIOCONTROL:
+			return session.UDPClient.AConn != nil
+		case RTP_TYPE_VIDEO, RTP_TYPE_VIDEOCONTROL:
+			return session.UDPClient.VConn != nil
+		}
+		return true
+	}
+	switch t {
+	case RTP_TYPE_AUDIO, RTP_TYPE_AUDIOCONTROL:
+		return session.aRTPChannel >= 0
+	case RTP_TYPE_VIDEO, RTP_TYPE_VIDEOCONTROL:
+		return session.vRTPChannel >= 0
+	}
+	return true
+}
+
+// requestInt returns an int value of the request, from the header first, then the query of the
+// request or describe url.
+func requestInt(req *Request, describeURL string, query string, header string) (int, bool) {
+	if v, err := strconv.Atoi(strings.TrimSpace(req.Header[header])); err == nil {
+		return v, true
+	}
+	for _, rawURL := range []string{req.URL, describeURL} {
+		l, err := url.Parse(rawURL)
+		if err != nil {
+			continue
+		}
+		if v, err := strconv.Atoi(l.Query().Get(query)); err == nil {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// transportServerPort adds the server_port of a udp SETUP behind its client_port.
+func transportServerPort(ts string, clientPort string, rtpPort int, rtcpPort int) string {
+	tss := strings.Split(ts, ";")
+	idx := -1
+	for i, val := range tss {
+		if val == clientPort {
+			idx = i
+		}
+	}
+	tail := append([]string{}, tss[idx+1:]...)
+	tss = append(tss[:idx+1], fmt.Sprintf("server_port=%d-%d", rtpPort, rtcpPort))
+	tss = append(tss, tail...)
+	return strings.Join(tss, ";")
+}
+
+// requestString returns a non empty value of the request, from the header first, then the query
+// of the request or describe url.
+func requestString(req *Request, describeURL string, query string, header string) (string, bool) {
+	if v := strings.TrimSpace(req.Header[header]); v != "" {
+		return v, true
+	}
+	for _, rawURL := range []string{req.URL, describeURL} {
+		l, err := url.Parse(rawURL)
+		if err != nil {
+			continue
+		}
+		if v := l.Query().Get(query); v != "" {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// isRequestFlagSet reports whether the client turned on an option, either by a "<query>=1" query
+// on the PLAY/DESCRIBE url or by a "<header>: 1" header.
+func isRequestFlagSet(req *Request, describeURL string, query string, header string) bool {
+	if v, err := strconv.ParseBool(req.Header[header]); err == nil && v {
+		return true
+	}
+	for _, rawURL := range []string{req.URL, describeURL} {
+		l, err := url.Parse(rawURL)
+		if err != nil {
+			continue
+		}
+		if v, err := strconv.ParseBool(l.Query().Get(query)); err == nil && v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package rtsp
+
+import (
+	"log"
+	"regexp"
+	"strings"
+)
+
+var sdpAttribute = regexp.MustCompile(`^[A-Za-z0-9\-_.]+(:[^\r\n]*)?$`)
+
+// channelSDPAttributes reads the "|" separated attributes of key, without the "a=" prefix.
+func channelSDPAttributes(path string, key string) (attrs []string) {
+	for _, attr := range strings.Split(ChannelKey(path, key).String(), "|") {
+		attr = strings.TrimPrefix(strings.TrimSpace(attr), "a=")
+		if attr != "" {
+			attrs = append(attrs, attr)
+		}
+	}
+	return
+}
+
+func sdpAttributeName(attr string) string {
+	return strings.ToLower(strings.SplitN(attr, ":", 2)[0])
+}
+
+// InjectSDPAttributes appends the attributes configured by sdp_session_attributes,
+// sdp_video_attributes and sdp_audio_attributes of the channel to the session level and to the
+// video and audio media of sdp. An attribute that is malformed, or already present in its section,
+// is left out.
+func InjectSDPAttributes(path string, sdp string, logger *log.Logger) string {
+	inject := map[string][]string{
+		"":      channelSDPAttributes(path, "sdp_session_attributes"),
+		"video": channelSDPAttributes(path, "sdp_video_attributes"),
+		"audio": channelSDPAttributes(path, "sdp_audio_attributes"),
+	}
+	if len(inject[""]) == 0 && len(inject["video"]) == 0 && len(inject["audio"]) == 0 {
+		return sdp
+	}
+	var out []string
+	media := ""
+	present := make(map[string]bool)
+	flush := func() {
+		for _, attr := range inject[media] {
+			if !sdpAttribute.MatchString(attr) {
+				logger.Printf("sdp attribute[%s] of channel %s is malformed, left out", attr, path)
+				continue
+			}
+			if present[sdpAttributeName(attr)] {
+				logger.Printf("sdp attribute[%s] of channel %s conflicts with the sdp, left out", attr, path)
+				continue
+			}
+			out = append(out, "a="+attr)
+		}
+		// each media type gets its attributes once.
+		delete(inject, media)
+	}
+	for _, line := range strings.Split(strings.TrimRight(strings.Replace(sdp, "\r\n", "\n", -1), "\n"), "\n") {
+		if strings.HasPrefix(line, "m=") {
+			flush()
+			media = strings.SplitN(strings.TrimPrefix(line, "m="), " ", 2)[0]
+			present = make(map[string]bool)
+		} else if strings.HasPrefix(line, "a=") {
+			present[sdpAttributeName(strings.TrimPrefix(line, "a="))] = true
+		}
+		out = append(out, line)
+	}
+	flush()
+	return strings.Join(out, "\r\n") + "\r\n"
+}
+
+// StripSDPMedia removes the media sections of a media type (e.g. "audio") from sdp.
+func StripSDPMedia(sdp string, media string) string {
+	var out []string
+	strip := false
+	for _, line := range strings.Split(strings.TrimRight(strings.Replace(sdp, "\r\n", "\n", -1), "\n"), "\n") {
+		if strings.HasPrefix(line, "m=") {
+			strip = strings.SplitN(strings.TrimPrefix(line, "m="), " ", 2)[0] == media
+		}
+		if !strip {
+			out = append(out, line)
+		}
+	}
+	return strings.Join(out, "\r\n") + "\r\n"
+}
@@ -0,0 +1,51 @@
+package rtsp
+
+import (
+	"io"
+	"log"
+	"testing"
+)
+
+const testInjectSDP = "v=0\r\n" +
+	"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+	"s=test\r\n" +
+	"t=0 0\r\n" +
+	"a=tool:easydarwin\r\n" +
+	"m=video 0 RTP/AVP 96\r\n" +
+	"a=rtpmap:96 H264/90000\r\n" +
+	"a=control:streamid=0\r\n" +
+	"m=audio 0 RTP/AVP 97\r\n" +
+	"a=rtpmap:97 MPEG4-GENERIC/44100/2\r\n" +
+	"a=control:streamid=1\r\n"
+
+func TestInjectSDPAttributes(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	if sdp := InjectSDPAttributes("/live", testInjectSDP, logger); sdp != testInjectSDP {
+		t.Errorf("sdp changed without attributes:\n%s", sdp)
+	}
+	setTestConf(t, "sdp_session_attributes", "a=range:npt=0- | tool:other")
+	setTestConf(t, "sdp_video_attributes", "framerate:25|bad attr!|control:streamid=9")
+	setTestConf(t, "sdp_audio_attributes", "a=ptime:20")
+	want := "v=0\r\n" +
+		"o=- 0 0 IN IP4 127.0.0.1\r\n" +
+		"s=test\r\n" +
+		"t=0 0\r\n" +
+		"a=tool:easydarwin\r\n" +
+		"a=range:npt=0-\r\n" +
+		"m=video 0 RTP/AVP 96\r\n" +
+		"a=rtpmap:96 H264/90000\r\n" +
+		"a=control:streamid=0\r\n" +
+		"a=framerate:25\r\n" +
+		"m=audio 0 RTP/AVP 97\r\n" +
+		"a=rtpmap:97 MPEG4-GENERIC/44100/2\r\n" +
+		"a=control:streamid=1\r\n" +
+		"a=ptime:20\r\n"
+	if sdp := InjectSDPAttributes("/live", testInjectSDP, logger); sdp != want {
+		t.Errorf("got sdp:\n%s\nwant:\n%s", sdp, want)
+	}
+	// the sdp parses back with the attributes in place
+	infos := ParseSDP(want)
+	if infos["video"] == nil || infos["audio"] == nil || infos["video"].Control != "streamid=0" {
+		t.Errorf("injected sdp parsed to %v", infos)
+	}
+}
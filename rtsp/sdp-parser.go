@@ -3,6 +3,8 @@ package rtsp
 import (
 	"encoding/base64"
 	"encoding/hex"
+	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -18,11 +20,53 @@ type SDPInfo struct {
 	PayloadType        int
 	SizeLength         int
 	IndexLength        int
+	// Fmtp holds the raw a=fmtp parameters of the media.
+	Fmtp map[string]string
+	// Bandwidths holds the b= lines of the media, in kbps for AS/CT and bps for TIAS, keyed by bwtype.
+	Bandwidths map[string]int
+	// SessionBandwidths holds the session level b= lines, the same for every media of the sdp.
+	SessionBandwidths map[string]int
+	// Direction is sendonly/recvonly/sendrecv/inactive, inherited from the session level, empty if neither has one.
+	Direction string
+	// ComfortNoisePayloadType is the dynamic payload type of comfort noise (RFC 3389) declared by rtpmap, 0 if none.
+	ComfortNoisePayloadType int
+	// SSRCs are the synchronization sources declared by a=ssrc, in order.
+	SSRCs []uint32
+}
+
+// RTP_PAYLOAD_TYPE_CN is the static payload type of comfort noise.
+const RTP_PAYLOAD_TYPE_CN = 13
+
+// IsComfortNoise tells whether payloadType of the media is comfort noise instead of its codec.
+func (info *SDPInfo) IsComfortNoise(payloadType int) bool {
+	if payloadType == info.PayloadType {
+		return false
+	}
+	return payloadType == RTP_PAYLOAD_TYPE_CN || (info.ComfortNoisePayloadType > 0 && payloadType == info.ComfortNoisePayloadType)
+}
+
+func (info *SDPInfo) hasSSRC(ssrc uint32) bool {
+	for _, v := range info.SSRCs {
+		if v == ssrc {
+			return true
+		}
+	}
+	return false
+}
+
+func isSDPDirection(attr string) bool {
+	switch attr {
+	case "sendonly", "recvonly", "sendrecv", "inactive":
+		return true
+	}
+	return false
 }
 
 func ParseSDP(sdpRaw string) map[string]*SDPInfo {
 	sdpMap := make(map[string]*SDPInfo)
 	var info *SDPInfo
+	sessionDirection := ""
+	var sessionBandwidths map[string]int
 	for _, line := range strings.Split(sdpRaw, "\n") {
 		line = strings.TrimSpace(line)
 		typeval := strings.SplitN(line, "=", 2)
@@ -41,10 +85,43 @@ func ParseSDP(sdpRaw string) map[string]*SDPInfo {
 						}
 					}
 				}
-
+			case "b":
+				bw := strings.SplitN(typeval[1], ":", 2)
+				if len(bw) != 2 {
+					continue
+				}
+				val, err := strconv.Atoi(strings.TrimSpace(bw[1]))
+				if err != nil {
+					continue
+				}
+				if info == nil {
+					if sessionBandwidths == nil {
+						sessionBandwidths = make(map[string]int)
+					}
+					sessionBandwidths[bw[0]] = val
+				} else {
+					if info.Bandwidths == nil {
+						info.Bandwidths = make(map[string]int)
+					}
+					info.Bandwidths[bw[0]] = val
+				}
 			case "a":
+				if isSDPDirection(typeval[1]) {
+					if info != nil {
+						info.Direction = typeval[1]
+					} else {
+						sessionDirection = typeval[1]
+					}
+				}
+				// a comfort noise rtpmap must not override the codec and clock rate of the media.
+				if info != nil && len(fields) == 2 && strings.HasPrefix(fields[0], "rtpmap:") && strings.HasPrefix(strings.ToUpper(fields[1]), "CN/") {
+					info.ComfortNoisePayloadType, _ = strconv.Atoi(strings.TrimPrefix(fields[0], "rtpmap:"))
+					continue
+				}
+				// only a=fmtp carries the ;-separated format parameters.
+				isFmtp := len(fields) == 2 && strings.HasPrefix(fields[0], "fmtp:")
 				if info != nil {
-					for _, field := range fields {
+					for i, field := range fields {
 						keyval := strings.SplitN(field, ":", 2)
 						if len(keyval) >= 2 {
 							key := keyval[0]
@@ -54,6 +131,10 @@ func ParseSDP(sdpRaw string) map[string]*SDPInfo {
 								info.Control = val
 							case "rtpmap":
 								info.Rtpmap, _ = strconv.Atoi(val)
+							case "ssrc":
+								if ssrc, err := strconv.ParseUint(val, 10, 32); err == nil && !info.hasSSRC(uint32(ssrc)) {
+									info.SSRCs = append(info.SSRCs, uint32(ssrc))
+								}
 							}
 						}
 						keyval = strings.Split(field, "/")
@@ -71,13 +152,17 @@ func ParseSDP(sdpRaw string) map[string]*SDPInfo {
 								info.TimeScale = i
 							}
 						}
-						keyval = strings.Split(field, ";")
-						if len(keyval) > 1 {
+						if isFmtp && i == 1 {
+							keyval = strings.Split(field, ";")
 							for _, field := range keyval {
 								keyval := strings.SplitN(field, "=", 2)
 								if len(keyval) == 2 {
 									key := strings.TrimSpace(keyval[0])
-									val := keyval[1]
+									val := strings.TrimSpace(keyval[1])
+									if info.Fmtp == nil {
+										info.Fmtp = make(map[string]string)
+									}
+									info.Fmtp[key] = val
 									switch key {
 									case "config":
 										info.Config, _ = hex.DecodeString(val)
@@ -85,6 +170,9 @@ func ParseSDP(sdpRaw string) map[string]*SDPInfo {
 										info.SizeLength, _ = strconv.Atoi(val)
 									case "indexlength":
 										info.IndexLength, _ = strconv.Atoi(val)
+									case "sprop-vps", "sprop-sps", "sprop-pps":
+										val, _ := base64.StdEncoding.DecodeString(val)
+										info.SpropParameterSets = append(info.SpropParameterSets, val)
 									case "sprop-parameter-sets":
 										fields := strings.Split(val, ",")
 										for _, field := range fields {
@@ -101,5 +189,86 @@ func ParseSDP(sdpRaw string) map[string]*SDPInfo {
 			}
 		}
 	}
+	for _, info := range sdpMap {
+		if info.Direction == "" {
+			info.Direction = sessionDirection
+		}
+		info.SessionBandwidths = sessionBandwidths
+	}
 	return sdpMap
 }
+
+// sdpEncodingNames maps SDPInfo.Codec to the encoding name of a=rtpmap.
+var sdpEncodingNames = map[string]string{
+	"aac":  "MPEG4-GENERIC",
+	"h264": "H264",
+	"h265": "H265",
+}
+
+// marshalSDPBandwidths writes the b= lines of bandwidths, sorted by bwtype.
+func marshalSDPBandwidths(b *strings.Builder, bandwidths map[string]int) {
+	bwtypes := make([]string, 0, len(bandwidths))
+	for bwtype := range bandwidths {
+		bwtypes = append(bwtypes, bwtype)
+	}
+	sort.Strings(bwtypes)
+	for _, bwtype := range bwtypes {
+		fmt.Fprintf(b, "b=%s:%d\r\n", bwtype, bandwidths[bwtype])
+	}
+}
+
+// Marshal writes the media description of info, the m= line, its b= lines, a=rtpmap, a=fmtp,
+// a=control and the direction.
+func (info *SDPInfo) Marshal() string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "m=%s 0 RTP/AVP %d\r\n", info.AVType, info.PayloadType)
+	marshalSDPBandwidths(b, info.Bandwidths)
+	if info.Codec != "" {
+		name, ok := sdpEncodingNames[info.Codec]
+		if !ok {
+			name = strings.ToUpper(info.Codec)
+		}
+		fmt.Fprintf(b, "a=rtpmap:%d %s/%d", info.PayloadType, name, info.TimeScale)
+		// AudioSpecificConfig: 5 bits object type, 4 bits frequency index, 4 bits channel configuration.
+		if info.Codec == "aac" && len(info.Config) >= 2 {
+			fmt.Fprintf(b, "/%d", int(info.Config[1]>>3)&0x0F)
+		}
+		b.WriteString("\r\n")
+	}
+	if len(info.Fmtp) > 0 {
+		keys := make([]string, 0, len(info.Fmtp))
+		for key := range info.Fmtp {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		params := make([]string, len(keys))
+		for i, key := range keys {
+			params[i] = key + "=" + info.Fmtp[key]
+		}
+		fmt.Fprintf(b, "a=fmtp:%d %s\r\n", info.PayloadType, strings.Join(params, ";"))
+	}
+	if info.Control != "" {
+		fmt.Fprintf(b, "a=control:%s\r\n", info.Control)
+	}
+	if info.Direction != "" {
+		fmt.Fprintf(b, "a=%s\r\n", info.Direction)
+	}
+	return b.String()
+}
+
+// MarshalSDP writes an sdp of the medias of sdpMap, as ParseSDP returns it, video first, with the
+// session level b= lines.
+func MarshalSDP(sdpMap map[string]*SDPInfo) string {
+	b := &strings.Builder{}
+	b.WriteString("v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=EasyDarwin\r\nc=IN IP4 0.0.0.0\r\nt=0 0\r\n")
+	for _, info := range sdpMap {
+		marshalSDPBandwidths(b, info.SessionBandwidths)
+		break
+	}
+	for _, media := range []string{"video", "audio"} {
+		if info, ok := sdpMap[media]; ok {
+			b.WriteString(info.Marshal())
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,76 @@
+package rtsp
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testSDP = "v=0\r\n" +
+	"o=- 1 1 IN IP4 192.168.1.64\r\n" +
+	"s=camera\r\n" +
+	"b=AS:5100\r\n" +
+	"a=recvonly\r\n" +
+	"a=x-onvif-track:a=1;b=2\r\n" +
+	"t=0 0\r\n" +
+	"m=video 0 RTP/AVP 96\r\n" +
+	"b=AS:5000\r\n" +
+	"b=TIAS:5000000\r\n" +
+	"a=rtpmap:96 H264/90000\r\n" +
+	"a=fmtp:96 packetization-mode=1; profile-level-id=42001F\r\n" +
+	"a=x-params:foo=1;bar=2\r\n" +
+	"a=control:trackID=1\r\n" +
+	"a=sendonly\r\n" +
+	"m=audio 0 RTP/AVP 97\r\n" +
+	"a=rtpmap:97 MPEG4-GENERIC/44100/2\r\n" +
+	"a=fmtp:97 config=1210\r\n" +
+	"a=control:trackID=2\r\n"
+
+func TestParseSDP(t *testing.T) {
+	sdpMap := ParseSDP(testSDP)
+	for _, c := range []struct {
+		media             string
+		bandwidths        map[string]int
+		sessionBandwidths map[string]int
+		direction         string
+		fmtp              map[string]string
+		config            []byte
+	}{
+		{"video", map[string]int{"AS": 5000, "TIAS": 5000000}, map[string]int{"AS": 5100}, "sendonly",
+			map[string]string{"packetization-mode": "1", "profile-level-id": "42001F"}, nil},
+		{"audio", nil, map[string]int{"AS": 5100}, "recvonly",
+			map[string]string{"config": "1210"}, []byte{0x12, 0x10}},
+	} {
+		info := sdpMap[c.media]
+		if info == nil {
+			t.Fatalf("%s: no media", c.media)
+		}
+		if !reflect.DeepEqual(info.Bandwidths, c.bandwidths) {
+			t.Errorf("%s: bandwidths %v, want %v", c.media, info.Bandwidths, c.bandwidths)
+		}
+		if !reflect.DeepEqual(info.SessionBandwidths, c.sessionBandwidths) {
+			t.Errorf("%s: session bandwidths %v, want %v", c.media, info.SessionBandwidths, c.sessionBandwidths)
+		}
+		if info.Direction != c.direction {
+			t.Errorf("%s: direction %q, want %q", c.media, info.Direction, c.direction)
+		}
+		if !reflect.DeepEqual(info.Fmtp, c.fmtp) {
+			t.Errorf("%s: fmtp %v, want %v", c.media, info.Fmtp, c.fmtp)
+		}
+		if !reflect.DeepEqual(info.Config, c.config) {
+			t.Errorf("%s: config % x, want % x", c.media, info.Config, c.config)
+		}
+	}
+}
+
+func TestMarshalSDPRoundTrip(t *testing.T) {
+	sdpMap := ParseSDP(testSDP)
+	got := ParseSDP(MarshalSDP(sdpMap))
+	if len(got) != len(sdpMap) {
+		t.Fatalf("%d medias after the round trip, want %d", len(got), len(sdpMap))
+	}
+	for media, want := range sdpMap {
+		if !reflect.DeepEqual(got[media], want) {
+			t.Errorf("%s: round trip %+v, want %+v", media, got[media], want)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package rtsp
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// SEIMessage is a message of a h264/h265 SEI NAL unit, its payload without emulation prevention bytes.
+type SEIMessage struct {
+	PayloadType int
+	Payload     []byte
+}
+
+const seiPayloadTypePicTiming = 1
+
+// ParseSEI splits a SEI NAL unit, nal header included, into its messages.
+func ParseSEI(codec string, nalu []byte) (messages []SEIMessage, err error) {
+	headerLen := 1
+	if strings.EqualFold(codec, "h265") {
+		headerLen = 2
+	}
+	if len(nalu) <= headerLen {
+		return nil, fmt.Errorf("sei too short, size[%d]", len(nalu))
+	}
+	rbsp := RemoveEmulationPrevention(nalu[headerLen:])
+	// the rbsp ends with the stop bit.
+	for len(rbsp) > 0 && !(len(rbsp) == 1 && rbsp[0] == 0x80) {
+		var payloadType, payloadSize int
+		for len(rbsp) > 0 && rbsp[0] == 0xFF {
+			payloadType += 255
+			rbsp = rbsp[1:]
+		}
+		if len(rbsp) == 0 {
+			return messages, fmt.Errorf("sei truncated in payload type")
+		}
+		payloadType += int(rbsp[0])
+		rbsp = rbsp[1:]
+		for len(rbsp) > 0 && rbsp[0] == 0xFF {
+			payloadSize += 255
+			rbsp = rbsp[1:]
+		}
+		if len(rbsp) == 0 {
+			return messages, fmt.Errorf("sei truncated in payload size")
+		}
+		payloadSize += int(rbsp[0])
+		rbsp = rbsp[1:]
+		if payloadSize > len(rbsp) {
+			return messages, fmt.Errorf("sei payload type[%d] size[%d] exceeds the nal unit", payloadType, payloadSize)
+		}
+		messages = append(messages, SEIMessage{PayloadType: payloadType, Payload: rbsp[:payloadSize]})
+		rbsp = rbsp[payloadSize:]
+	}
+	return
+}
+
+// SEIClockTimestamp is a clock timestamp of a pic_timing SEI, fields not sent are -1.
+type SEIClockTimestamp struct {
+	Hours   int `json:"hours"`
+	Minutes int `json:"minutes"`
+	Seconds int `json:"seconds"`
+	Frames  int `json:"frames"`
+}
+
+// H264PicTiming is a pic_timing SEI message (H.264 D.1.3).
+type H264PicTiming struct {
+	CpbRemovalDelay int `json:"cpbRemovalDelay"`
+	DpbOutputDelay  int `json:"dpbOutputDelay"`
+	// PicStruct is how the picture is displayed, as frame or fields (H.264 table D-1), -1 if absent.
+	PicStruct       int                 `json:"picStruct"`
+	ClockTimestamps []SEIClockTimestamp `json:"clockTimestamps,omitempty"`
+}
+
+// H264PicTimingParams are the sps vui fields the pic_timing SEI syntax depends on.
+type H264PicTimingParams struct {
+	CpbDpbDelaysPresent   bool
+	CpbRemovalDelayLength int
+	DpbOutputDelayLength  int
+	TimeOffsetLength      int
+	PicStructPresent      bool
+}
+
+// parse reads the end of the vui, from nal_hrd_parameters_present_flag to pic_struct_present_flag.
+func (params *H264PicTimingParams) parse(r *bitReader) {
+	nalHRD := r.readFlag()
+	if nalHRD {
+		params.parseHRD(r)
+	}
+	vclHRD := r.readFlag()
+	if vclHRD {
+		params.parseHRD(r)
+	}
+	if nalHRD || vclHRD {
+		r.readFlag() // low_delay_hrd_flag
+	}
+	params.CpbDpbDelaysPresent = (nalHRD || vclHRD) && r.err == nil
+	params.PicStructPresent = r.readFlag() && r.err == nil
+}
+
+func (params *H264PicTimingParams) parseHRD(r *bitReader) {
+	cpbCnt := int(r.readUE()) + 1
+	r.readBits(4) // bit_rate_scale
+	r.readBits(4) // cpb_size_scale
+	for i := 0; i < cpbCnt && r.err == nil; i++ {
+		r.readUE()
+		r.readUE()
+		r.readFlag()
+	}
+	r.readBits(5) // initial_cpb_removal_delay_length_minus1
+	params.CpbRemovalDelayLength = int(r.readBits(5)) + 1
+	params.DpbOutputDelayLength = int(r.readBits(5)) + 1
+	params.TimeOffsetLength = int(r.readBits(5))
+}
+
+// NumClockTS of each pic_struct, H.264 table D-1.
+var h264NumClockTS = []int{1, 1, 1, 2, 2, 3, 3, 2, 3}
+
+// ParseH264PicTiming parses the payload of a pic_timing SEI message, which is sized by the vui
+// of the active sps: the cpb/dpb delays are there with hrd parameters, pic_struct when
+// pic_struct_present_flag is set.
+func ParseH264PicTiming(payload []byte, sps *H264SPS) (timing *H264PicTiming, err error) {
+	if sps == nil {
+		return nil, fmt.Errorf("pic_timing without sps")
+	}
+	r := &bitReader{buf: payload}
+	timing = &H264PicTiming{PicStruct: -1}
+	if sps.CpbDpbDelaysPresent {
+		timing.CpbRemovalDelay = int(r.readBits(sps.CpbRemovalDelayLength))
+		timing.DpbOutputDelay = int(r.readBits(sps.DpbOutputDelayLength))
+	}
+	if sps.PicStructPresent {
+		timing.PicStruct = int(r.readBits(4))
+		if timing.PicStruct >= len(h264NumClockTS) {
+			return nil, fmt.Errorf("pic_timing reserved pic_struct[%d]", timing.PicStruct)
+		}
+		for i := 0; i < h264NumClockTS[timing.PicStruct]; i++ {
+			if !r.readFlag() { // clock_timestamp_flag
+				continue
+			}
+			ts := SEIClockTimestamp{Hours: -1, Minutes: -1, Seconds: -1}
+			r.readBits(2) // ct_type
+			r.readFlag()  // nuit_field_based_flag
+			r.readBits(5) // counting_type
+			full := r.readFlag()
+			r.readFlag() // discontinuity_flag
+			r.readFlag() // cnt_dropped_flag
+			ts.Frames = int(r.readBits(8))
+			if full {
+				ts.Seconds = int(r.readBits(6))
+				ts.Minutes = int(r.readBits(6))
+				ts.Hours = int(r.readBits(5))
+			} else if r.readFlag() {
+				ts.Seconds = int(r.readBits(6))
+				if r.readFlag() {
+					ts.Minutes = int(r.readBits(6))
+					if r.readFlag() {
+						ts.Hours = int(r.readBits(5))
+					}
+				}
+			}
+			if sps.TimeOffsetLength > 0 {
+				r.readBits(sps.TimeOffsetLength)
+			}
+			timing.ClockTimestamps = append(timing.ClockTimestamps, ts)
+		}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("pic_timing %v", r.err)
+	}
+	return
+}
+
+// FieldOrder returns "tff" or "bff" for an interlaced picture by the field that comes first,
+// "progressive" for a frame, "" if the pic_struct is absent.
+func (timing *H264PicTiming) FieldOrder() string {
+	switch timing.PicStruct {
+	case 0, 7, 8:
+		return "progressive"
+	case 1, 3, 5:
+		return "tff"
+	case 2, 4, 6:
+		return "bff"
+	}
+	return ""
+}
+
+// SEIExtractor takes the SEI messages out of the NAL units of a video track, and keeps the last
+// h264 pic_timing, parsed by the last sps seen.
+type SEIExtractor struct {
+	codec     string
+	sps       *H264SPS
+	picTiming *H264PicTiming
+	lock      sync.RWMutex
+}
+
+func NewSEIExtractor(codec string) *SEIExtractor {
+	return &SEIExtractor{codec: strings.ToLower(codec)}
+}
+
+// Push feeds a whole NAL unit, it returns the messages of a SEI.
+func (e *SEIExtractor) Push(nalu []byte) []SEIMessage {
+	if len(nalu) < 1 {
+		return nil
+	}
+	if e.codec == "h264" && nalu[0]&0x1F == 7 {
+		if sps, err := ParseH264SPS(nalu); err == nil {
+			e.lock.Lock()
+			e.sps = sps
+			e.lock.Unlock()
+		}
+		return nil
+	}
+	sei := e.codec == "h264" && nalu[0]&0x1F == 6
+	if e.codec == "h265" {
+		t := (nalu[0] >> 1) & 0x3F
+		sei = t == 39 || t == 40
+	}
+	if !sei {
+		return nil
+	}
+	messages, _ := ParseSEI(e.codec, nalu)
+	for _, msg := range messages {
+		if msg.PayloadType != seiPayloadTypePicTiming || e.codec != "h264" {
+			continue
+		}
+		e.lock.Lock()
+		if timing, err := ParseH264PicTiming(msg.Payload, e.sps); err == nil {
+			e.picTiming = timing
+		}
+		e.lock.Unlock()
+	}
+	return messages
+}
+
+// PicTiming returns the last h264 pic_timing, nil if none.
+func (e *SEIExtractor) PicTiming() *H264PicTiming {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return e.picTiming
+}
@@ -0,0 +1,97 @@
+package rtsp
+
+import (
+	"testing"
+)
+
+// testPicTimingSEI makes a h264 sei NAL unit of a pic_timing message for the vui of sps.
+func testPicTimingSEI(sps testSPS, picStruct int, clock *SEIClockTimestamp) []byte {
+	w := &bitWriter{}
+	if sps.hrd {
+		w.writeBits(1234, 24) // cpb_removal_delay
+		w.writeBits(2, 24)    // dpb_output_delay
+	}
+	if sps.picStruct {
+		w.writeBits(uint(picStruct), 4)
+		for i := 0; i < h264NumClockTS[picStruct]; i++ {
+			w.writeFlag(clock != nil && i == 0)
+			if clock == nil || i > 0 {
+				continue
+			}
+			w.writeBits(1, 2) // ct_type
+			w.writeFlag(false)
+			w.writeBits(0, 5) // counting_type
+			w.writeFlag(true) // full_timestamp_flag
+			w.writeFlag(false)
+			w.writeFlag(false)
+			w.writeBits(uint(clock.Frames), 8)
+			w.writeBits(uint(clock.Seconds), 6)
+			w.writeBits(uint(clock.Minutes), 6)
+			w.writeBits(uint(clock.Hours), 5)
+			if sps.hrd {
+				w.writeBits(0, 24) // time_offset
+			}
+		}
+	}
+	payload := w.rbsp()
+	return append(append([]byte{0x06, seiPayloadTypePicTiming, byte(len(payload))}, addEmulationPrevention(payload)...), 0x80)
+}
+
+func TestParseH264PicTiming(t *testing.T) {
+	clock := &SEIClockTimestamp{Hours: 12, Minutes: 34, Seconds: 56, Frames: 7}
+	for _, c := range []struct {
+		name       string
+		sps        testSPS
+		picStruct  int
+		clock      *SEIClockTimestamp
+		fieldOrder string
+	}{
+		{"hrd and top field first", testSPS{fps: 25, hrd: true, picStruct: true}, 3, clock, "tff"},
+		{"bottom field first", testSPS{fps: 25, picStruct: true}, 2, nil, "bff"},
+		{"progressive frame", testSPS{fps: 25, hrd: true, picStruct: true}, 0, clock, "progressive"},
+		{"hrd only", testSPS{fps: 25, hrd: true}, 0, nil, ""},
+	} {
+		c.sps.profile, c.sps.level, c.sps.width, c.sps.height = 77, 30, 720, 576
+		sps, err := ParseH264SPS(c.sps.h264())
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if sps.CpbDpbDelaysPresent != c.sps.hrd || sps.PicStructPresent != c.sps.picStruct || (c.sps.hrd && sps.TimeOffsetLength != 24) {
+			t.Errorf("%s: vui parsed to %+v", c.name, sps.H264PicTimingParams)
+		}
+		messages, err := ParseSEI("h264", testPicTimingSEI(c.sps, c.picStruct, c.clock))
+		if err != nil || len(messages) != 1 || messages[0].PayloadType != seiPayloadTypePicTiming {
+			t.Fatalf("%s: sei messages %v, err:%v", c.name, messages, err)
+		}
+		timing, err := ParseH264PicTiming(messages[0].Payload, sps)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if c.sps.hrd && (timing.CpbRemovalDelay != 1234 || timing.DpbOutputDelay != 2) {
+			t.Errorf("%s: delays %d %d", c.name, timing.CpbRemovalDelay, timing.DpbOutputDelay)
+		}
+		if timing.FieldOrder() != c.fieldOrder {
+			t.Errorf("%s: field order %q, want %q", c.name, timing.FieldOrder(), c.fieldOrder)
+		}
+		if c.clock != nil && (len(timing.ClockTimestamps) != 1 || timing.ClockTimestamps[0] != *c.clock) {
+			t.Errorf("%s: clock timestamps %v, want %v", c.name, timing.ClockTimestamps, *c.clock)
+		}
+	}
+}
+
+func TestSEIExtractorPicTiming(t *testing.T) {
+	vui := testSPS{profile: 77, level: 30, width: 720, height: 576, fps: 25, picStruct: true}
+	e := NewSEIExtractor("h264")
+	// no sps to size the pic_timing yet
+	e.Push(testPicTimingSEI(vui, 4, nil))
+	if e.PicTiming() != nil {
+		t.Errorf("pic_timing parsed without sps")
+	}
+	e.Push(vui.h264())
+	if messages := e.Push(testPicTimingSEI(vui, 4, nil)); len(messages) != 1 {
+		t.Errorf("%d sei messages, want 1", len(messages))
+	}
+	if timing := e.PicTiming(); timing == nil || timing.FieldOrder() != "bff" {
+		t.Errorf("pic_timing %+v, want bottom field first", timing)
+	}
+}
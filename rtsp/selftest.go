@@ -0,0 +1,214 @@
+package rtsp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+)
+
+// SelfTest runs synthetic h264, h265 and aac frames through the packetizing code (aggregation of
+// the parameter sets, fragmentation to a blocksize) and back through ParseRTP and the
+// depacketizers, checking the access units come out byte for byte and the gop starts where the
+// pusher would start it. It writes a PASS/FAIL line per check to w, and returns whether all passed.
+func SelfTest(w io.Writer) bool {
+	rnd := rand.New(rand.NewSource(1))
+	passed := true
+	for _, check := range []struct {
+		name string
+		run  func(*rand.Rand) (string, error)
+	}{
+		{"h264 round trip", func(rnd *rand.Rand) (string, error) { return selfTestVideo("h264", rnd) }},
+		{"h265 round trip", func(rnd *rand.Rand) (string, error) { return selfTestVideo("h265", rnd) }},
+		{"aac round trip", selfTestAAC},
+	} {
+		detail, err := check.run(rnd)
+		if err != nil {
+			passed = false
+			fmt.Fprintf(w, "FAIL %s: %v\n", check.name, err)
+			continue
+		}
+		fmt.Fprintf(w, "PASS %s: %s\n", check.name, detail)
+	}
+	return passed
+}
+
+const (
+	selfTestBlocksize = 1200
+	selfTestSSRC      = 0x5E1F7E57
+)
+
+// selfTestNALU makes a NAL unit of codec with the header of nalType and size bytes in all.
+func selfTestNALU(codec string, nalType byte, size int, rnd *rand.Rand) []byte {
+	nalu := make([]byte, size)
+	rnd.Read(nalu)
+	if codec == "h265" {
+		nalu[0], nalu[1] = nalType<<1, 1
+	} else {
+		nalu[0] = 0x60 | nalType
+	}
+	return nalu
+}
+
+func selfTestRTP(payloadType int, seq uint16, timestamp uint32, marker bool, payload []byte) []byte {
+	rtp := make([]byte, RTP_FIXED_HEADER_LENGTH, RTP_FIXED_HEADER_LENGTH+len(payload))
+	rtp[0] = 0x80
+	rtp[1] = byte(payloadType)
+	if marker {
+		rtp[1] |= 0x80
+	}
+	binary.BigEndian.PutUint16(rtp[2:], seq)
+	binary.BigEndian.PutUint32(rtp[4:], timestamp)
+	binary.BigEndian.PutUint32(rtp[8:], selfTestSSRC)
+	return append(rtp, payload...)
+}
+
+// selfTestVideo packetizes a gop, a keyframe with its parameter sets and the frames after it, and
+// depacketizes it back.
+func selfTestVideo(codec string, rnd *rand.Rand) (string, error) {
+	var ps ParameterSets
+	idr, slice := byte(5), byte(1)
+	if codec == "h265" {
+		ps.VPS = selfTestNALU(codec, 32, 24, rnd)
+		ps.SPS = selfTestNALU(codec, 33, 40, rnd)
+		ps.PPS = selfTestNALU(codec, 34, 8, rnd)
+		idr = 19
+	} else {
+		ps.SPS = selfTestNALU(codec, 7, 20, rnd)
+		ps.PPS = selfTestNALU(codec, 8, 4, rnd)
+	}
+	accessUnits := [][][]byte{{selfTestNALU(codec, idr, 5000, rnd)}}
+	for i := 1; i < 6; i++ {
+		// small and fragmented frames, and a frame of two slices
+		frame := [][]byte{selfTestNALU(codec, slice, 200+rnd.Intn(selfTestBlocksize*2), rnd)}
+		if i == 3 {
+			frame = append(frame, selfTestNALU(codec, slice, 300, rnd))
+		}
+		accessUnits = append(accessUnits, frame)
+	}
+
+	var packets [][]byte
+	seq, timestamp := uint16(rnd.Intn(0x10000)), uint32(rnd.Int31())
+	send := func(payload []byte, marker bool) {
+		rtp := selfTestRTP(96, seq, timestamp, marker, payload)
+		frags := fragmentRTP(codec, rtp, selfTestBlocksize)
+		if frags == nil {
+			frags = [][]byte{rtp}
+		}
+		for _, frag := range frags {
+			binary.BigEndian.PutUint16(frag[2:], seq)
+			seq++
+			packets = append(packets, frag)
+		}
+	}
+	var expected [][]byte
+	for i, nalus := range accessUnits {
+		var au []byte
+		if i == 0 {
+			for _, payload := range parameterSetsPayloads(codec, ps) {
+				send(payload, false)
+			}
+			for _, nalu := range ps.NALUnits() {
+				au = append(append(au, annexBStartCode...), nalu...)
+			}
+		}
+		for j, nalu := range nalus {
+			send(nalu, j == len(nalus)-1)
+			au = append(append(au, annexBStartCode...), nalu...)
+		}
+		expected = append(expected, au)
+		timestamp += 3000
+	}
+
+	d := NewDepacketizer(codec, 96, nil)
+	if d == nil {
+		return "", fmt.Errorf("no %s depacketizer", codec)
+	}
+	gopStart := newGopStartDetector(300, 2*time.Second)
+	var got [][]byte
+	gops := 0
+	for i, rtpBytes := range packets {
+		info := ParseRTP(rtpBytes)
+		if info == nil {
+			return "", fmt.Errorf("packet %d does not parse", i)
+		}
+		paramSet, keyframe := false, false
+		for _, nalu := range RTPNALUnits(codec, info.Payload) {
+			switch NALUType(codec, nalu) {
+			case NALU_TYPE_VPS, NALU_TYPE_SPS, NALU_TYPE_PPS:
+				paramSet = true
+			case NALU_TYPE_IDR:
+				keyframe = true
+			}
+		}
+		if start, from := gopStart.push(i, paramSet, keyframe); start {
+			gops++
+			if from != 0 {
+				return "", fmt.Errorf("gop starts at packet %d, not at the parameter sets", from)
+			}
+		}
+		au, complete, err := d.Depacketize(info)
+		if err != nil {
+			return "", fmt.Errorf("packet %d: %v", i, err)
+		}
+		if complete {
+			got = append(got, au)
+		}
+	}
+	if gops != 1 {
+		return "", fmt.Errorf("%d gop starts, want 1", gops)
+	}
+	if len(got) != len(expected) {
+		return "", fmt.Errorf("%d access units, want %d", len(got), len(expected))
+	}
+	for i := range expected {
+		if !bytes.Equal(got[i], expected[i]) {
+			return "", fmt.Errorf("access unit %d differs, size[%d] want[%d]", i, len(got[i]), len(expected[i]))
+		}
+	}
+	return fmt.Sprintf("%d access units in %d packets", len(got), len(packets)), nil
+}
+
+// selfTestAAC packs aac frames in RFC 3640 AU-headers packets, and depacketizes them to ADTS.
+func selfTestAAC(rnd *rand.Rand) (string, error) {
+	// AAC LC, 44100Hz, stereo
+	sdp := &SDPInfo{Codec: "aac", SizeLength: 13, IndexLength: 3, Config: []byte{0x12, 0x10}}
+	d := NewDepacketizer("aac", 97, sdp)
+	if d == nil {
+		return "", fmt.Errorf("no aac depacketizer")
+	}
+	frames, packets := 0, 0
+	seq, timestamp := uint16(rnd.Intn(0x10000)), uint32(rnd.Int31())
+	for _, count := range []int{3, 1, 2} {
+		var headers, data, expected []byte
+		for i := 0; i < count; i++ {
+			frame := make([]byte, 100+rnd.Intn(400))
+			rnd.Read(frame)
+			// 13 bits size, 3 bits index
+			headers = append(headers, byte(len(frame)>>5), byte(len(frame)<<3))
+			data = append(data, frame...)
+			expected = append(expected, 0xFF, 0xF1, 0x50, 0x80, byte((len(frame)+7)>>3), byte((len(frame)+7)<<5|0x1F), 0xFC)
+			expected = append(expected, frame...)
+		}
+		payload := []byte{byte(len(headers) * 8 >> 8), byte(len(headers) * 8)}
+		payload = append(append(payload, headers...), data...)
+		info := ParseRTP(selfTestRTP(97, seq, timestamp, true, payload))
+		if info == nil {
+			return "", fmt.Errorf("packet %d does not parse", packets)
+		}
+		au, complete, err := d.Depacketize(info)
+		if err != nil {
+			return "", fmt.Errorf("packet %d: %v", packets, err)
+		}
+		if !complete || !bytes.Equal(au, expected) {
+			return "", fmt.Errorf("packet %d of %d frames differs, size[%d] want[%d]", packets, count, len(au), len(expected))
+		}
+		frames += count
+		packets++
+		seq++
+		timestamp += uint32(1024 * count)
+	}
+	return fmt.Sprintf("%d frames in %d packets", frames, packets), nil
+}
@@ -0,0 +1,69 @@
+package rtsp
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTestPlayerSession runs a session over a pipe as if it played since startAt, the returned
+// channel is closed when it stops.
+func startTestPlayerSession(t *testing.T, startAt time.Time) (*Session, net.Conn, chan struct{}) {
+	serverConn, clientConn := net.Pipe()
+	session := NewSession(newTestServer(), serverConn)
+	session.Type = SESSEION_TYPE_PLAYER
+	session.Path, session.URL, session.StartAt = "/test", "rtsp://127.0.0.1/test", startAt
+	stopped := make(chan struct{})
+	session.StopHandles = append(session.StopHandles, func() {
+		close(stopped)
+	})
+	session.startExpireTimer()
+	go session.Start()
+	t.Cleanup(func() {
+		clientConn.Close()
+		<-stopped
+	})
+	return session, clientConn, stopped
+}
+
+func TestSessionExpire(t *testing.T) {
+	setTestConf(t, "max_session_duration", "1")
+	session, conn, stopped := startTestPlayerSession(t, time.Now().Add(-300*time.Millisecond))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	req, err := io.ReadAll(bufio.NewReader(conn))
+	if err != nil {
+		t.Fatalf("read from the session: %v", err)
+	}
+	if !strings.HasPrefix(string(req), "TEARDOWN rtsp://127.0.0.1/test RTSP/1.0\r\n") || !strings.Contains(string(req), "Session: "+session.ID+"\r\n") {
+		t.Errorf("teardown sent:\n%s", req)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session not stopped at max_session_duration")
+	}
+}
+
+func TestSessionExpireOff(t *testing.T) {
+	setTestConf(t, "max_session_duration", "0")
+	session, _, _ := startTestPlayerSession(t, time.Now())
+	if session.expireTimer != nil {
+		t.Error("timer started without max_session_duration")
+	}
+}
+
+func TestSessionExpireAfterStop(t *testing.T) {
+	setTestConf(t, "max_session_duration", "1")
+	session, conn, stopped := startTestPlayerSession(t, time.Now().Add(-900*time.Millisecond))
+	// the client leaves before the end
+	conn.Close()
+	<-stopped
+	// a timer firing meanwhile finds the session stopped
+	session.expire()
+	if session.Conn != nil {
+		t.Error("connection of a stopped session")
+	}
+}
@@ -1,7 +1,126 @@
 package rtsp
 
-import "log"
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/penggy/EasyGoLib/utils"
+)
 
 type SessionLogger struct {
 	logger *log.Logger
 }
+
+// NewSessionLogger creates a logger whose output collapses repeated identical lines
+// according to log_throttle_interval/log_throttle_burst.
+func NewSessionLogger(prefix string) *log.Logger {
+	var out io.Writer = os.Stdout
+	if !utils.Debug {
+		out = utils.GetLogWriter()
+	}
+	interval := utils.Conf().Section("rtsp").Key("log_throttle_interval").MustInt(10)
+	burst := utils.Conf().Section("rtsp").Key("log_throttle_burst").MustInt(5)
+	if interval > 0 && burst > 0 {
+		out = NewThrottleWriter(out, prefix, time.Duration(interval)*time.Second, burst)
+	}
+	return log.New(out, prefix, log.LstdFlags|log.Lshortfile)
+}
+
+type throttleEntry struct {
+	since time.Time
+	count int
+	line  string
+}
+
+// ThrottleWriter passes at most burst identical log lines per interval, the rest are
+// summarized as "N occurrences in the last M seconds" once the interval is over, by a timer
+// if nothing else is logged by then.
+type ThrottleWriter struct {
+	out      io.Writer
+	prefix   string
+	interval time.Duration
+	burst    int
+	entries  map[string]*throttleEntry
+	// armed while lines are suppressed
+	timer *time.Timer
+	lock  sync.Mutex
+}
+
+func NewThrottleWriter(out io.Writer, prefix string, interval time.Duration, burst int) *ThrottleWriter {
+	return &ThrottleWriter{
+		out:      out,
+		prefix:   prefix,
+		interval: interval,
+		burst:    burst,
+		entries:  make(map[string]*throttleEntry),
+	}
+}
+
+// body strips the prefix and the "2006/01/02 15:04:05 " date so that identical messages
+// logged at different times share the same key.
+func (w *ThrottleWriter) body(line string) string {
+	const dateLen = len("2006/01/02 15:04:05 ")
+	if len(line) < len(w.prefix)+dateLen {
+		return line
+	}
+	return line[len(w.prefix)+dateLen:]
+}
+
+func (w *ThrottleWriter) Write(p []byte) (n int, err error) {
+	now := time.Now()
+	line := string(p)
+	key := w.body(line)
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.flush(now)
+	entry, ok := w.entries[key]
+	if !ok {
+		entry = &throttleEntry{since: now, line: key}
+		w.entries[key] = entry
+	}
+	entry.count++
+	if entry.count > w.burst {
+		if w.timer == nil {
+			w.timer = time.AfterFunc(entry.since.Add(w.interval).Sub(now), w.timerFlush)
+		}
+		return len(p), nil
+	}
+	return w.out.Write(p)
+}
+
+// timerFlush writes the summaries due, and arms the timer again for the lines still suppressed.
+func (w *ThrottleWriter) timerFlush() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	now := time.Now()
+	w.timer = nil
+	w.flush(now)
+	var next time.Time
+	for _, entry := range w.entries {
+		if entry.count > w.burst && (next.IsZero() || entry.since.Before(next)) {
+			next = entry.since
+		}
+	}
+	if !next.IsZero() {
+		w.timer = time.AfterFunc(next.Add(w.interval).Sub(now), w.timerFlush)
+	}
+}
+
+// flush writes the summaries of expired entries, caller must hold the lock.
+func (w *ThrottleWriter) flush(now time.Time) {
+	for key, entry := range w.entries {
+		if now.Sub(entry.since) < w.interval {
+			continue
+		}
+		delete(w.entries, key)
+		if entry.count <= w.burst {
+			continue
+		}
+		summary := fmt.Sprintf("%s%s %d occurrences in the last %d seconds: %s", w.prefix, now.Format("2006/01/02 15:04:05"), entry.count, int(now.Sub(entry.since).Seconds()), entry.line)
+		w.out.Write([]byte(summary))
+	}
+}
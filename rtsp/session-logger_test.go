@@ -0,0 +1,51 @@
+package rtsp
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type lockedBuffer struct {
+	buf  bytes.Buffer
+	lock sync.Mutex
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+func TestThrottleWriterCollapses(t *testing.T) {
+	var out lockedBuffer
+	logger := log.New(NewThrottleWriter(&out, "[test]", 100*time.Millisecond, 2), "[test]", log.LstdFlags)
+	for i := 0; i < 5; i++ {
+		logger.Printf("rtp packet lost")
+	}
+	logger.Printf("another line")
+	if got := strings.Count(out.String(), "rtp packet lost"); got != 2 {
+		t.Fatalf("%d lines passed in the burst, want 2:\n%s", got, out.String())
+	}
+	// nothing more is logged, the timer writes the summary.
+	for deadline := time.Now().Add(2 * time.Second); !strings.Contains(out.String(), "occurrences"); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("no summary written:\n%s", out.String())
+		}
+	}
+	if !strings.Contains(out.String(), "5 occurrences in the last 0 seconds: rtp packet lost") {
+		t.Errorf("unexpected summary:\n%s", out.String())
+	}
+	if strings.Count(out.String(), "another line") != 1 || strings.Count(out.String(), "occurrences") != 1 {
+		t.Errorf("a line under the burst summarized:\n%s", out.String())
+	}
+}
@@ -0,0 +1,372 @@
+package rtsp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// how long a rotation waits for the new session to send a keyframe
+	rotationKeyframeTimeout = 10 * time.Second
+	// how long the old session may take to end its frame once the new one has a keyframe
+	rotationFrameTimeout = time.Second
+	// a failed rotation is tried again after this
+	rotationRetry = 10 * time.Second
+)
+
+// spliceTrack is the last rtp packet relayed on a track of the old session, and how the packets of
+// the new session map onto it.
+type spliceTrack struct {
+	seen      bool
+	ssrc      uint32
+	seq       uint16
+	timestamp uint32
+	// the timestamp advance of the last frame
+	step uint32
+
+	mapped    bool
+	seqOffset uint16
+	tsOffset  uint32
+}
+
+func (t *spliceTrack) observe(rtp *RTPInfo) {
+	timestamp := uint32(rtp.Timestamp)
+	if t.seen && int32(timestamp-t.timestamp) > 0 {
+		t.step = timestamp - t.timestamp
+	}
+	if !t.seen || int32(timestamp-t.timestamp) > 0 {
+		t.timestamp = timestamp
+	}
+	t.seen, t.ssrc, t.seq = true, uint32(rtp.SSRC), uint16(rtp.SequenceNumber)
+}
+
+// streamSplice moves the stream of a pulled channel from the old session to a new one at a
+// keyframe of the new one: the old session is relayed up to the end of the frame it is in, then
+// the new one from its keyframe on, its sequence numbers, timestamps and ssrc rewritten to go on
+// from the old ones, so that players and the recording see one stream with no frame lost.
+// Its methods are called with the spliceLock of the client held.
+type streamSplice struct {
+	client *RTSPClient
+	old    *RTSPClient
+	next   *RTSPClient
+	codec  string
+	// the channel has video, the switch waits for a keyframe
+	video bool
+	// the clock rates to step the timestamps by when a track has no frame step yet
+	vClockRate int
+	aClockRate int
+	tracks     map[RTPType]*spliceTrack
+
+	// the packets of the new session from its keyframe on, waiting for the switch
+	pending   []*RTPPack
+	pendingTs int
+	ready     bool
+	readyAt   time.Time
+	switched  bool
+	done      chan struct{}
+}
+
+func newStreamSplice(client *RTSPClient, old *RTSPClient, next *RTSPClient, vClockRate int, aClockRate int) *streamSplice {
+	return &streamSplice{
+		client:     client,
+		old:        old,
+		next:       next,
+		codec:      strings.ToLower(client.VCodec),
+		video:      client.VControl != "" || client.VCodec != "",
+		vClockRate: vClockRate,
+		aClockRate: aClockRate,
+		tracks:     map[RTPType]*spliceTrack{RTP_TYPE_VIDEO: {}, RTP_TYPE_AUDIO: {}},
+		pendingTs:  -1,
+		done:       make(chan struct{}),
+	}
+}
+
+// fromOld relays a packet of the old session, and switches once its frame ends after the new
+// session got ready.
+func (s *streamSplice) fromOld(pack *RTPPack) {
+	var rtp *RTPInfo
+	if pack.Type == RTP_TYPE_VIDEO || pack.Type == RTP_TYPE_AUDIO {
+		rtp = ParseRTP(pack.Buffer.Bytes())
+	}
+	if rtp != nil && !rtp.Keepalive {
+		track := s.tracks[pack.Type]
+		if s.ready && pack.Type == RTP_TYPE_VIDEO && track.seen && uint32(rtp.Timestamp) != track.timestamp {
+			// a frame of the old session after the last one relayed, the new session takes it.
+			s.switchOver()
+			return
+		}
+		track.observe(rtp)
+	}
+	s.client.handle(pack)
+	if s.ready && (!s.video || (rtp != nil && pack.Type == RTP_TYPE_VIDEO && rtp.Marker)) {
+		s.switchOver()
+	}
+}
+
+// fromNext holds the packets of the new session from its first keyframe on until the switch.
+func (s *streamSplice) fromNext(pack *RTPPack) {
+	switch pack.Type {
+	case RTP_TYPE_VIDEO:
+		rtp := ParseRTP(pack.Buffer.Bytes())
+		if rtp == nil || rtp.Keepalive {
+			return
+		}
+		if !s.ready {
+			// the parameter sets of the keyframe come before it, with its timestamp.
+			if rtp.Timestamp != s.pendingTs {
+				s.pending, s.pendingTs = s.pending[:0], rtp.Timestamp
+			}
+			s.pending = append(s.pending, pack)
+			if rtpKeyframe(s.codec, rtp) {
+				s.ready, s.readyAt = true, time.Now()
+			}
+			return
+		}
+		s.pending = append(s.pending, pack)
+	case RTP_TYPE_AUDIO:
+		if !s.video {
+			// audio frames stand alone, the switch is right away.
+			s.ready = true
+		}
+		if s.ready {
+			s.pending = append(s.pending, pack)
+		}
+	}
+	if s.ready && (!s.video || time.Since(s.readyAt) > rotationFrameTimeout) {
+		// the old session went quiet mid frame, or has no video to wait for.
+		s.switchOver()
+	}
+}
+
+// switchOver relays the stream from the new session on, the packets of the old one are dropped.
+func (s *streamSplice) switchOver() {
+	if s.switched {
+		return
+	}
+	s.switched = true
+	s.client.feed, s.client.rotating = s.next, nil
+	for _, pack := range s.pending {
+		if pack = s.rewrite(pack); pack != nil {
+			s.client.handle(pack)
+		}
+	}
+	s.pending = nil
+	close(s.done)
+}
+
+// rewrite maps a packet of the new session onto the stream of the old one, nil to drop it.
+func (s *streamSplice) rewrite(pack *RTPPack) *RTPPack {
+	b := pack.Buffer.Bytes()
+	switch pack.Type {
+	case RTP_TYPE_VIDEO, RTP_TYPE_AUDIO:
+		track := s.tracks[pack.Type]
+		if !track.seen || len(b) < RTP_FIXED_HEADER_LENGTH {
+			// nothing to go on from, e.g. the old session had no packet of the track.
+			return pack
+		}
+		seq, timestamp := binary.BigEndian.Uint16(b[2:]), binary.BigEndian.Uint32(b[4:])
+		if !track.mapped {
+			step := track.step
+			if step == 0 {
+				step = uint32(s.aClockRate / 50)
+				if pack.Type == RTP_TYPE_VIDEO {
+					step = uint32(s.vClockRate / 25)
+				}
+			}
+			track.seqOffset = track.seq + 1 - seq
+			track.tsOffset = track.timestamp + step - timestamp
+			track.mapped = true
+		}
+		out := append([]byte{}, b...)
+		binary.BigEndian.PutUint16(out[2:], seq+track.seqOffset)
+		binary.BigEndian.PutUint32(out[4:], timestamp+track.tsOffset)
+		binary.BigEndian.PutUint32(out[8:], track.ssrc)
+		return &RTPPack{Type: pack.Type, Buffer: bytes.NewBuffer(out)}
+	case RTP_TYPE_VIDEOCONTROL, RTP_TYPE_AUDIOCONTROL:
+		track := s.tracks[RTP_TYPE_VIDEO]
+		if pack.Type == RTP_TYPE_AUDIOCONTROL {
+			track = s.tracks[RTP_TYPE_AUDIO]
+		}
+		if !track.mapped || len(b) < 8 {
+			return nil
+		}
+		out := append([]byte{}, b...)
+		binary.BigEndian.PutUint32(out[4:], track.ssrc)
+		if out[1] == 200 && len(out) >= 20 {
+			// the rtp timestamp of a sender report
+			binary.BigEndian.PutUint32(out[16:], binary.BigEndian.Uint32(out[16:])+track.tsOffset)
+		}
+		return &RTPPack{Type: pack.Type, Buffer: bytes.NewBuffer(out)}
+	}
+	return pack
+}
+
+// rtpKeyframe tells whether a h264/h265 rtp packet starts an IDR picture.
+func rtpKeyframe(codec string, rtp *RTPInfo) bool {
+	for _, nalu := range RTPNALUnits(codec, rtp.Payload) {
+		if NALUType(codec, nalu) == NALU_TYPE_IDR {
+			return true
+		}
+	}
+	return false
+}
+
+// ownerClient returns the client the stream of this session goes to, the client itself unless
+// it is a session opened by a rotation.
+func (client *RTSPClient) ownerClient() *RTSPClient {
+	if client.owner != nil {
+		return client.owner
+	}
+	return client
+}
+
+// currentFeed returns the session the client gets its stream over.
+func (client *RTSPClient) currentFeed() *RTSPClient {
+	client.spliceLock.Lock()
+	defer client.spliceLock.Unlock()
+	if client.feed != nil {
+		return client.feed
+	}
+	return client
+}
+
+// deliver hands a packet that came over feed to the handlers of the client.
+func (client *RTSPClient) deliver(feed *RTSPClient, pack *RTPPack) {
+	client.spliceLock.Lock()
+	defer client.spliceLock.Unlock()
+	if splice := feed.splice; splice != nil {
+		if !splice.switched {
+			splice.fromNext(pack)
+			return
+		}
+		if pack = splice.rewrite(pack); pack == nil {
+			return
+		}
+	}
+	current := client.feed
+	if current == nil {
+		current = client
+	}
+	if client.rotating != nil && client.rotating.old == feed {
+		client.rotating.fromOld(pack)
+	} else if feed == current {
+		client.handle(pack)
+	}
+}
+
+func (client *RTSPClient) handle(pack *RTPPack) {
+	for _, h := range client.RTPHandles {
+		h(pack)
+	}
+}
+
+// feedEnded is called when the read loop of a session ends: the client stops if the session
+// still carries its stream, and goes on otherwise, a rotation moved the stream to another one.
+func (client *RTSPClient) feedEnded() {
+	owner := client.ownerClient()
+	if owner.currentFeed() == client {
+		owner.Stop()
+	}
+	if client != owner {
+		owner.spliceLock.Lock()
+		owner.rotatedIn += client.InBytes
+		owner.spliceLock.Unlock()
+		client.Stop()
+	}
+}
+
+// Rotate opens a new session to the source and moves the stream over to it as a streamSplice
+// does, then closes the session it came over.
+func (client *RTSPClient) Rotate(timeout time.Duration, vClockRate int, aClockRate int) (err error) {
+	old := client.currentFeed()
+	next, err := NewRTSPClient(client.Server, client.URL, client.OptionIntervalMillis, client.Agent)
+	if err != nil {
+		return
+	}
+	next.TransType, next.owner, next.logger = client.TransType, client, client.logger
+	splice := newStreamSplice(client, old, next, vClockRate, aClockRate)
+	next.splice = splice
+	if err = next.requestStream(timeout); err != nil {
+		next.Stop()
+		return
+	}
+	if !strings.EqualFold(next.VCodec, client.VCodec) || !strings.EqualFold(next.ACodec, client.ACodec) {
+		next.Stop()
+		return fmt.Errorf("source codecs changed to %s/%s", next.VCodec, next.ACodec)
+	}
+	client.spliceLock.Lock()
+	client.rotating = splice
+	client.spliceLock.Unlock()
+	go next.startStream()
+	select {
+	case <-splice.done:
+	case <-time.After(rotationKeyframeTimeout):
+		client.spliceLock.Lock()
+		if !splice.switched {
+			client.rotating = nil
+			err = fmt.Errorf("no keyframe from the new session in %v", rotationKeyframeTimeout)
+		}
+		client.spliceLock.Unlock()
+		if err != nil {
+			next.Stop()
+			return
+		}
+	}
+	if old == client {
+		// the client itself stays, only its connection goes.
+		client.connWLock.Lock()
+		if client.Conn != nil {
+			client.Conn.Close()
+		}
+		client.connWLock.Unlock()
+	} else {
+		old.Stop()
+	}
+	client.logger.Printf("%v rotated to a new session", client)
+	return
+}
+
+// startRotation rotates the channel every max_session_duration seconds. A pulled channel moves to
+// a new session to its source with no frame lost, a pushed one is the client's to end and goes
+// on. The recording segment is finalized either way, at the next keyframe.
+func (pusher *Pusher) startRotation() {
+	maxDuration := time.Duration(ChannelKey(pusher.Path(), "max_session_duration").MustInt(0)) * time.Second
+	if maxDuration <= 0 {
+		return
+	}
+	startAt := pusher.StartAt()
+	var rotate func()
+	rotate = func() {
+		server := pusher.Server()
+		if server.GetPusher(pusher.Path()) != pusher {
+			return
+		}
+		next := maxDuration
+		if pusher.RTSPClient != nil {
+			timeout := time.Duration(ChannelKey(pusher.Path(), "timeout").MustInt(0)) * time.Millisecond
+			if err := pusher.RTSPClient.Rotate(timeout, pusher.ClockRate(RTP_TYPE_VIDEO), pusher.ClockRate(RTP_TYPE_AUDIO)); err != nil {
+				pusher.Logger().Printf("rotate at max session duration err:%v", err)
+				next = rotationRetry
+			}
+		}
+		if next == maxDuration && pusher.tsRecorder != nil {
+			pusher.tsRecorder.Finalize()
+		}
+		time.AfterFunc(next, rotate)
+	}
+	time.AfterFunc(time.Until(startAt.Add(maxDuration)), rotate)
+}
+
+// TotalInBytes returns the bytes received for the client over all its sessions.
+func (client *RTSPClient) TotalInBytes() int {
+	client.spliceLock.Lock()
+	defer client.spliceLock.Unlock()
+	n := client.InBytes + client.rotatedIn
+	if client.feed != nil {
+		n += client.feed.InBytes
+	}
+	return n
+}
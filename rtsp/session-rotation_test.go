@@ -0,0 +1,259 @@
+package rtsp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const rotationTestSDP = "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=test\r\nt=0 0\r\n" +
+	"m=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\na=control:trackID=0\r\n"
+
+// rotationTestSource is a rtsp source streaming h264 gops over tcp, each connection with its own
+// sequence numbers, timestamps and ssrc, the number of the connection in the second payload byte.
+type rotationTestSource struct {
+	listener net.Listener
+	lock     sync.Mutex
+	conns    []net.Conn
+}
+
+func startRotationTestSource(t *testing.T) *rotationTestSource {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := &rotationTestSource{listener: listener}
+	go func() {
+		for n := 0; ; n++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			source.lock.Lock()
+			source.conns = append(source.conns, conn)
+			source.lock.Unlock()
+			go source.serve(conn, n)
+		}
+	}()
+	t.Cleanup(source.Close)
+	return source
+}
+
+func (source *rotationTestSource) Close() {
+	source.listener.Close()
+	source.lock.Lock()
+	for _, conn := range source.conns {
+		conn.Close()
+	}
+	source.lock.Unlock()
+}
+
+func (source *rotationTestSource) Conns() int {
+	source.lock.Lock()
+	defer source.lock.Unlock()
+	return len(source.conns)
+}
+
+func (source *rotationTestSource) serve(conn net.Conn, n int) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		method, cseq := "", ""
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSpace(line)
+			if line == "" {
+				break
+			}
+			if method == "" {
+				method = strings.Fields(line)[0]
+			} else if strings.HasPrefix(line, "CSeq:") {
+				cseq = strings.TrimSpace(line[len("CSeq:"):])
+			}
+		}
+		resp := fmt.Sprintf("RTSP/1.0 200 OK\r\nCSeq: %s\r\n", cseq)
+		switch method {
+		case "DESCRIBE":
+			resp += fmt.Sprintf("Content-Type: application/sdp\r\nContent-Length: %d\r\n\r\n%s", len(rotationTestSDP), rotationTestSDP)
+		case "SETUP":
+			resp += fmt.Sprintf("Transport: RTP/AVP/TCP;unicast;interleaved=0-1\r\nSession: %d\r\n\r\n", n+1)
+		default:
+			resp += "\r\n"
+		}
+		if _, err := io.WriteString(conn, resp); err != nil {
+			return
+		}
+		if method == "PLAY" {
+			source.stream(conn, n)
+			return
+		}
+	}
+}
+
+// stream sends gops of 10 frames every 5ms, the keyframe with its parameter sets, until the
+// connection is closed.
+func (source *rotationTestSource) stream(conn net.Conn, n int) {
+	seq, timestamp, ssrc := uint16(7000*(n+1)), uint32(n)*0x40000000+12345, uint32(0x1000+n)
+	send := func(marker bool, payload ...byte) error {
+		rtp := selfTestRTP(96, seq, timestamp, marker, payload)
+		binary.BigEndian.PutUint32(rtp[8:], ssrc)
+		seq++
+		_, err := conn.Write(append([]byte{0x24, 0, byte(len(rtp) >> 8), byte(len(rtp))}, rtp...))
+		return err
+	}
+	for frame := 0; ; frame++ {
+		var err error
+		if frame%10 == 0 {
+			if err = send(false, 0x67, byte(n)); err == nil {
+				if err = send(false, 0x68, byte(n)); err == nil {
+					err = send(true, 0x65, byte(n), byte(frame))
+				}
+			}
+		} else {
+			err = send(true, 0x41, byte(n), byte(frame))
+		}
+		if err != nil {
+			return
+		}
+		timestamp += 3600
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+type rotationTestPacket struct {
+	seq       uint16
+	timestamp uint32
+	ssrc      uint32
+	marker    bool
+	naluType  byte
+	conn      byte
+}
+
+func TestRotateClean(t *testing.T) {
+	source := startRotationTestSource(t)
+	client, err := NewRTSPClient(newTestServer(), "rtsp://"+source.listener.Addr().String()+"/test", 0, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.logger = log.New(io.Discard, "", 0)
+	var lock sync.Mutex
+	var got []rotationTestPacket
+	client.RTPHandles = append(client.RTPHandles, func(pack *RTPPack) {
+		rtp := ParseRTP(pack.Buffer.Bytes())
+		if pack.Type != RTP_TYPE_VIDEO || rtp == nil || len(rtp.Payload) < 2 {
+			return
+		}
+		lock.Lock()
+		got = append(got, rotationTestPacket{uint16(rtp.SequenceNumber), uint32(rtp.Timestamp), uint32(rtp.SSRC),
+			rtp.Marker, rtp.Payload[0] & 0x1F, rtp.Payload[1]})
+		lock.Unlock()
+	})
+	stopped := make(chan struct{})
+	client.StopHandles = append(client.StopHandles, func() { close(stopped) })
+	count := func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(got)
+	}
+	waitCount := func(n int) {
+		for deadline := time.Now().Add(5 * time.Second); count() < n; time.Sleep(5 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("got %d packets, want %d", count(), n)
+			}
+		}
+	}
+
+	if err := client.Start(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	waitCount(25)
+	if err := client.Rotate(time.Second, 90000, 0); err != nil {
+		t.Fatal(err)
+	}
+	waitCount(count() + 25)
+	source.Close()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client not stopped with the source gone")
+	}
+
+	if conns := source.Conns(); conns != 2 {
+		t.Fatalf("%d connections to the source, want 2", conns)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	boundary := -1
+	for i, p := range got {
+		if p.conn == 1 && boundary < 0 {
+			boundary = i
+		}
+		if boundary >= 0 && p.conn != 1 {
+			t.Fatalf("packet %d from the old session after the switch", i)
+		}
+		if i == 0 {
+			continue
+		}
+		last := got[i-1]
+		if p.seq != last.seq+1 {
+			t.Errorf("packet %d: seq %d after %d", i, p.seq, last.seq)
+		}
+		if p.ssrc != got[0].ssrc {
+			t.Errorf("packet %d: ssrc %#x, want %#x", i, p.ssrc, got[0].ssrc)
+		}
+		if step := p.timestamp - last.timestamp; (last.marker && step != 3600) || (!last.marker && step != 0) {
+			t.Errorf("packet %d: timestamp %d after %d", i, p.timestamp, last.timestamp)
+		}
+	}
+	if boundary <= 0 {
+		t.Fatalf("no packet from the new session")
+	}
+	if !got[boundary-1].marker {
+		t.Errorf("the old session switched away mid frame")
+	}
+	if got[boundary].naluType != 7 {
+		t.Errorf("the new session starts with nalu type %d, want the sps of its keyframe", got[boundary].naluType)
+	}
+}
+
+func TestTSRecorderFinalize(t *testing.T) {
+	setTestConf(t, "video_clock_rate", "90000")
+	dir := t.TempDir()
+	r := &TSRecorder{pusher: newTestPusher("h264"), dir: dir, maxDuration: time.Hour, videoCodec: "h264"}
+	idr := annexBNALUs([]byte{0x67, 0x42}, []byte{0x68, 0xCE}, []byte{0x65, 0x88})
+	slice := annexBNALUs([]byte{0x41, 0x9A})
+	files := func() int {
+		entries, _ := os.ReadDir(path.Join(dir, "test", time.Now().Format("20060102")))
+		return len(entries)
+	}
+
+	r.handle(RTP_TYPE_VIDEO, 0, idr)
+	r.handle(RTP_TYPE_VIDEO, 3600, slice)
+	r.handle(RTP_TYPE_VIDEO, 7200, idr)
+	if n := files(); n != 1 {
+		t.Fatalf("%d files before the finalize, want 1", n)
+	}
+	r.Finalize()
+	r.handle(RTP_TYPE_VIDEO, 10800, slice)
+	if n := files(); n != 1 {
+		t.Fatalf("%d files after the finalize before a keyframe, want 1", n)
+	}
+	r.handle(RTP_TYPE_VIDEO, 14400, idr)
+	r.handle(RTP_TYPE_VIDEO, 18000, idr)
+	if n := files(); n != 2 {
+		t.Fatalf("%d files after the keyframe, want 2", n)
+	}
+	r.Close()
+}
@@ -0,0 +1,123 @@
+package rtsp
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	transportInterleaved = regexp.MustCompile("interleaved=(\\d+)(-(\\d+))?")
+	transportClientPort  = regexp.MustCompile("client_port=(\\d+)(-(\\d+))?")
+)
+
+// setupTransport is what a SETUP negotiates: the track, and the transport with its interleaved
+// channels or client ports.
+type setupTransport struct {
+	rtpType   RTPType
+	transType TransType
+	// the interleaved or client_port parameter of the Transport header
+	match    string
+	channels [2]int
+}
+
+// negotiateSetup matches the control url of a SETUP to a track of the session and parses its
+// Transport header, it returns the status to answer the SETUP with on error. The audio track is
+// refused when audioDisabled, a player does not have it in its sdp.
+func (session *Session) negotiateSetup(setupPath string, aPath string, vPath string, ts string, audioDisabled bool) (t setupTransport, statusCode int, err error) {
+	isAudio, isVideo := session.setupTrack(setupPath, aPath, vPath)
+	switch {
+	case isAudio && audioDisabled:
+		return t, 404, fmt.Errorf("audio disabled, control %s", setupPath)
+	case isAudio:
+		t.rtpType = RTP_TYPE_AUDIO
+	case isVideo:
+		t.rtpType = RTP_TYPE_VIDEO
+	default:
+		return t, 500, fmt.Errorf("unknown control %s, audio control[%s] video control[%s]", setupPath, aPath, vPath)
+	}
+	matchs := transportInterleaved.FindStringSubmatch(ts)
+	t.transType = TRANS_TYPE_TCP
+	if matchs == nil {
+		matchs = transportClientPort.FindStringSubmatch(ts)
+		t.transType = TRANS_TYPE_UDP
+	}
+	if matchs == nil {
+		return t, 461, fmt.Errorf("no interleaved or client_port in transport[%s]", ts)
+	}
+	t.match = matchs[0]
+	t.channels[0], _ = strconv.Atoi(matchs[1])
+	t.channels[1], _ = strconv.Atoi(matchs[3])
+	return t, 200, nil
+}
+
+type SetupDryRun struct {
+	Path      string `json:"path"`
+	Track     string `json:"track"`
+	TransType string `json:"transType"`
+	// Channels are the interleaved channels for TCP, the client ports for UDP.
+	Channels  [2]int `json:"channels"`
+	Transport string `json:"transport"`
+}
+
+// DryRunSetup tells how a player SETUP request, given as raw headers, would be answered on the
+// live channel it targets, as if the player had done DESCRIBE first, by the negotiation of the
+// real SETUP. Nothing is set up, no udp socket is dialed.
+func (server *Server) DryRunSetup(raw string) (result SetupDryRun, err error) {
+	// captured requests often lost their CR.
+	raw = strings.Replace(strings.Replace(raw, "\r\n", "\n", -1), "\n", "\r\n", -1)
+	req := NewRequest(raw)
+	if req == nil {
+		err = fmt.Errorf("invalid rtsp request")
+		return
+	}
+	if req.Method != SETUP {
+		err = fmt.Errorf("not a SETUP request: %s", req.Method)
+		return
+	}
+	setupPath, err := setupControlURL(req.URL)
+	if err != nil {
+		err = fmt.Errorf("invalid url %s: %v", req.URL, err)
+		return
+	}
+	u, _ := url.Parse(setupPath)
+	pusher := server.GetPusher(u.Path)
+	// the url of a SETUP is the track control, usually below the channel path.
+	for path := u.Path; pusher == nil && strings.LastIndex(path, "/") > 0; {
+		path = path[:strings.LastIndex(path, "/")]
+		pusher = server.GetPusher(path)
+	}
+	if pusher == nil {
+		err = fmt.Errorf("no channel for %s", u.Path)
+		return
+	}
+	result.Path = pusher.Path()
+	vPath, err := setupControlURL(pusher.VControl())
+	if err != nil {
+		err = fmt.Errorf("invalid video control %s: %v", pusher.VControl(), err)
+		return
+	}
+	aPath, err := setupControlURL(pusher.AControl())
+	if err != nil {
+		err = fmt.Errorf("invalid audio control %s: %v", pusher.AControl(), err)
+		return
+	}
+	session := &Session{ACodec: pusher.ACodec(), VCodec: pusher.VCodec()}
+	ts := req.Header["Transport"]
+	t, statusCode, err := session.negotiateSetup(setupPath, aPath, vPath, ts, pusher.AudioDisabled())
+	if err != nil {
+		err = fmt.Errorf("%d, %v", statusCode, err)
+		return
+	}
+	result.Track = "video"
+	if t.rtpType == RTP_TYPE_AUDIO {
+		result.Track = "audio"
+	}
+	result.TransType = t.transType.String()
+	result.Channels = t.channels
+	// players get their transport echoed, with the server_port of a udp SETUP.
+	result.Transport = ts
+	return
+}
@@ -0,0 +1,64 @@
+package rtsp
+
+import (
+	"testing"
+)
+
+func TestNegotiateSetup(t *testing.T) {
+	session := &Session{ACodec: "aac", VCodec: "h264"}
+	base := "rtsp://127.0.0.1:554/camera/"
+	for _, c := range []struct {
+		name          string
+		url           string
+		transport     string
+		audioDisabled bool
+		statusCode    int
+		rtpType       RTPType
+		transType     TransType
+		channels      [2]int
+	}{
+		{"tcp video", base + "trackID=1", "RTP/AVP/TCP;unicast;interleaved=0-1", false, 200, RTP_TYPE_VIDEO, TRANS_TYPE_TCP, [2]int{0, 1}},
+		{"tcp audio", base + "trackID=2", "RTP/AVP/TCP;unicast;interleaved=2-3", false, 200, RTP_TYPE_AUDIO, TRANS_TYPE_TCP, [2]int{2, 3}},
+		{"udp video", base + "trackID=1", "RTP/AVP;unicast;client_port=5000-5001", false, 200, RTP_TYPE_VIDEO, TRANS_TYPE_UDP, [2]int{5000, 5001}},
+		{"udp audio disabled", base + "trackID=2", "RTP/AVP;unicast;client_port=5002-5003", true, 404, 0, 0, [2]int{}},
+		{"video with audio disabled", base + "trackID=1", "RTP/AVP;unicast;client_port=5000-5001", true, 200, RTP_TYPE_VIDEO, TRANS_TYPE_UDP, [2]int{5000, 5001}},
+		{"unknown control", base + "trackID=9", "RTP/AVP/TCP;unicast;interleaved=0-1", false, 500, 0, 0, [2]int{}},
+		{"no transport", base + "trackID=1", "RTP/AVP;multicast", false, 461, 0, 0, [2]int{}},
+	} {
+		setupPath, err := setupControlURL(c.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr, statusCode, err := session.negotiateSetup(setupPath, "trackID=2", "trackID=1", c.transport, c.audioDisabled)
+		if statusCode != c.statusCode || (err == nil) != (c.statusCode == 200) {
+			t.Errorf("%s: status %d err %v, want %d", c.name, statusCode, err, c.statusCode)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if tr.rtpType != c.rtpType || tr.transType != c.transType || tr.channels != c.channels {
+			t.Errorf("%s: %v %v %v, want %v %v %v", c.name, tr.rtpType, tr.transType, tr.channels, c.rtpType, c.transType, c.channels)
+		}
+	}
+}
+
+func TestDryRunSetupAudioDisabled(t *testing.T) {
+	setTestConf(t, "audio_disable", "1")
+	server := newTestServer()
+	pusher := newTestPusher("h264")
+	pusher.Session.ACodec = "aac"
+	pusher.Session.VControl = "trackID=1"
+	pusher.Session.AControl = "trackID=2"
+	server.pushers[pusher.Path()] = pusher
+	request := func(track string) string {
+		return "SETUP rtsp://127.0.0.1/test/" + track + " RTSP/1.0\nCSeq: 3\nTransport: RTP/AVP/TCP;unicast;interleaved=0-1\n\n"
+	}
+	result, err := server.DryRunSetup(request("trackID=1"))
+	if err != nil || result.Track != "video" || result.TransType != "TCP" {
+		t.Errorf("video SETUP: %+v err %v", result, err)
+	}
+	if _, err := server.DryRunSetup(request("trackID=2")); err == nil {
+		t.Errorf("audio SETUP accepted with audio_disable")
+	}
+}
@@ -0,0 +1,387 @@
+package rtsp
+
+import (
+	"fmt"
+)
+
+type bitReader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (r *bitReader) readBit() uint {
+	if r.err != nil {
+		return 0
+	}
+	if r.pos >= len(r.buf)*8 {
+		r.err = fmt.Errorf("bit reader out of range, size[%d]", len(r.buf))
+		return 0
+	}
+	bit := (r.buf[r.pos/8] >> uint(7-r.pos%8)) & 1
+	r.pos++
+	return uint(bit)
+}
+
+func (r *bitReader) readBits(n int) uint {
+	var val uint
+	for i := 0; i < n; i++ {
+		val = val<<1 | r.readBit()
+	}
+	return val
+}
+
+func (r *bitReader) readFlag() bool {
+	return r.readBit() == 1
+}
+
+// readUE reads an Exp-Golomb coded unsigned integer.
+func (r *bitReader) readUE() uint {
+	zeros := 0
+	for r.readBit() == 0 {
+		if r.err != nil || zeros > 31 {
+			if r.err == nil {
+				r.err = fmt.Errorf("bit reader invalid exp-golomb code")
+			}
+			return 0
+		}
+		zeros++
+	}
+	return (1<<uint(zeros) - 1) + r.readBits(zeros)
+}
+
+// readSE reads an Exp-Golomb coded signed integer.
+func (r *bitReader) readSE() int {
+	val := r.readUE()
+	if val%2 == 0 {
+		return -int(val / 2)
+	}
+	return int(val+1) / 2
+}
+
+// RemoveEmulationPrevention converts a NAL unit to its RBSP by dropping the 0x03 of every 0x000003 sequence.
+func RemoveEmulationPrevention(nalu []byte) []byte {
+	rbsp := make([]byte, 0, len(nalu))
+	zeros := 0
+	for _, b := range nalu {
+		if zeros >= 2 && b == 0x03 {
+			zeros = 0
+			continue
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		rbsp = append(rbsp, b)
+	}
+	return rbsp
+}
+
+type H264SPS struct {
+	ProfileIdc            int
+	ConstraintFlags       int
+	LevelIdc              int
+	SPSID                 int
+	ChromaFormatIdc       int
+	BitDepthLuma          int
+	BitDepthChroma        int
+	Log2MaxFrameNum       int
+	PicOrderCntType       int
+	Log2MaxPicOrderCntLsb int
+	MaxNumRefFrames       int
+	FrameMbsOnly          bool
+	Width                 int
+	Height                int
+
+	// vui
+	TimingInfoPresent bool
+	NumUnitsInTick    int
+	TimeScale         int
+	FixedFrameRate    bool
+	H264PicTimingParams
+}
+
+func (sps *H264SPS) String() string {
+	return fmt.Sprintf("h264 sps[profile:%d level:%d %dx%d chroma:%d bitdepth:%d]", sps.ProfileIdc, sps.LevelIdc, sps.Width, sps.Height, sps.ChromaFormatIdc, sps.BitDepthLuma)
+}
+
+// FrameRate returns the frame rate declared by the vui timing info, 0 if absent.
+func (sps *H264SPS) FrameRate() float64 {
+	if !sps.TimingInfoPresent || sps.NumUnitsInTick == 0 {
+		return 0
+	}
+	return float64(sps.TimeScale) / float64(2*sps.NumUnitsInTick)
+}
+
+// plausible tells whether the parsed fields make sense, a sps read with the emulation prevention
+// bytes wrongly kept or removed mostly does not.
+func (sps *H264SPS) plausible() bool {
+	return sps.Width > 0 && sps.Width <= 16384 && sps.Height > 0 && sps.Height <= 16384 &&
+		sps.ChromaFormatIdc <= 3 && sps.BitDepthLuma <= 14 && sps.BitDepthChroma <= 14 &&
+		sps.MaxNumRefFrames <= 16 && sps.Log2MaxFrameNum <= 16
+}
+
+// ParseH264SPS parses a H264 SPS NAL unit, nal header included, without start code.
+// The NAL unit is expected with its emulation prevention bytes, as RTP carries it, but a sps that
+// does not parse to plausible values that way is parsed again as is, for sources that strip them.
+func ParseH264SPS(nalu []byte) (sps *H264SPS, err error) {
+	if len(nalu) < 4 {
+		err = fmt.Errorf("h264 sps too short, size[%d]", len(nalu))
+		return
+	}
+	if nalu[0]&0x1F != 7 {
+		err = fmt.Errorf("not a h264 sps, nal type[%d]", nalu[0]&0x1F)
+		return
+	}
+	rbsp := RemoveEmulationPrevention(nalu[1:])
+	sps, err = parseH264SPS(rbsp)
+	if (err != nil || !sps.plausible()) && len(rbsp) != len(nalu)-1 {
+		if raw, rawErr := parseH264SPS(nalu[1:]); rawErr == nil && raw.plausible() {
+			return raw, nil
+		}
+	}
+	return
+}
+
+func parseH264SPS(rbsp []byte) (sps *H264SPS, err error) {
+	r := &bitReader{buf: rbsp}
+	sps = &H264SPS{
+		ChromaFormatIdc: 1,
+		BitDepthLuma:    8,
+		BitDepthChroma:  8,
+	}
+	sps.ProfileIdc = int(r.readBits(8))
+	sps.ConstraintFlags = int(r.readBits(8))
+	sps.LevelIdc = int(r.readBits(8))
+	sps.SPSID = int(r.readUE())
+	separateColourPlane := false
+	switch sps.ProfileIdc {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		sps.ChromaFormatIdc = int(r.readUE())
+		if sps.ChromaFormatIdc == 3 {
+			separateColourPlane = r.readFlag()
+		}
+		sps.BitDepthLuma = int(r.readUE()) + 8
+		sps.BitDepthChroma = int(r.readUE()) + 8
+		r.readFlag()      // qpprime_y_zero_transform_bypass_flag
+		if r.readFlag() { // seq_scaling_matrix_present_flag
+			cnt := 8
+			if sps.ChromaFormatIdc == 3 {
+				cnt = 12
+			}
+			for i := 0; i < cnt; i++ {
+				if !r.readFlag() {
+					continue
+				}
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+				lastScale, nextScale := 8, 8
+				for j := 0; j < size; j++ {
+					if nextScale != 0 {
+						nextScale = (lastScale + r.readSE() + 256) % 256
+					}
+					if nextScale != 0 {
+						lastScale = nextScale
+					}
+				}
+			}
+		}
+	}
+	sps.Log2MaxFrameNum = int(r.readUE()) + 4
+	sps.PicOrderCntType = int(r.readUE())
+	switch sps.PicOrderCntType {
+	case 0:
+		sps.Log2MaxPicOrderCntLsb = int(r.readUE()) + 4
+	case 1:
+		r.readFlag() // delta_pic_order_always_zero_flag
+		r.readSE()   // offset_for_non_ref_pic
+		r.readSE()   // offset_for_top_to_bottom_field
+		cycle := int(r.readUE())
+		for i := 0; i < cycle && r.err == nil; i++ {
+			r.readSE()
+		}
+	}
+	sps.MaxNumRefFrames = int(r.readUE())
+	r.readFlag() // gaps_in_frame_num_value_allowed_flag
+	widthInMbs := int(r.readUE()) + 1
+	heightInMapUnits := int(r.readUE()) + 1
+	sps.FrameMbsOnly = r.readFlag()
+	if !sps.FrameMbsOnly {
+		r.readFlag() // mb_adaptive_frame_field_flag
+	}
+	r.readFlag() // direct_8x8_inference_flag
+	var cropLeft, cropRight, cropTop, cropBottom int
+	if r.readFlag() {
+		cropLeft = int(r.readUE())
+		cropRight = int(r.readUE())
+		cropTop = int(r.readUE())
+		cropBottom = int(r.readUE())
+	}
+	frameHeightFactor := 1
+	if !sps.FrameMbsOnly {
+		frameHeightFactor = 2
+	}
+	cropUnitX, cropUnitY := 1, frameHeightFactor
+	if sps.ChromaFormatIdc != 0 && !separateColourPlane {
+		subWidthC, subHeightC := 2, 2
+		switch sps.ChromaFormatIdc {
+		case 2:
+			subHeightC = 1
+		case 3:
+			subWidthC, subHeightC = 1, 1
+		}
+		cropUnitX = subWidthC
+		cropUnitY = subHeightC * frameHeightFactor
+	}
+	sps.Width = widthInMbs*16 - cropUnitX*(cropLeft+cropRight)
+	sps.Height = frameHeightFactor*heightInMapUnits*16 - cropUnitY*(cropTop+cropBottom)
+	if r.err != nil {
+		err = r.err
+		return
+	}
+	// a truncated vui still leaves the fields above usable.
+	if r.readFlag() { // vui_parameters_present_flag
+		parseH264VUI(r, sps)
+	}
+	return
+}
+
+func parseH264VUI(r *bitReader, sps *H264SPS) {
+	if r.readFlag() { // aspect_ratio_info_present_flag
+		if r.readBits(8) == 255 { // Extended_SAR
+			r.readBits(16)
+			r.readBits(16)
+		}
+	}
+	if r.readFlag() { // overscan_info_present_flag
+		r.readFlag()
+	}
+	if r.readFlag() { // video_signal_type_present_flag
+		r.readBits(4)
+		if r.readFlag() { // colour_description_present_flag
+			r.readBits(24)
+		}
+	}
+	if r.readFlag() { // chroma_loc_info_present_flag
+		r.readUE()
+		r.readUE()
+	}
+	if r.readFlag() {
+		sps.NumUnitsInTick = int(r.readBits(32))
+		sps.TimeScale = int(r.readBits(32))
+		sps.FixedFrameRate = r.readFlag()
+		sps.TimingInfoPresent = r.err == nil
+	}
+	sps.H264PicTimingParams.parse(r)
+}
+
+type H265SPS struct {
+	ProfileSpace    int
+	TierFlag        int
+	ProfileIdc      int
+	LevelIdc        int
+	SPSID           int
+	ChromaFormatIdc int
+	BitDepthLuma    int
+	BitDepthChroma  int
+	Width           int
+	Height          int
+}
+
+func (sps *H265SPS) String() string {
+	return fmt.Sprintf("h265 sps[profile:%d tier:%d level:%d %dx%d chroma:%d bitdepth:%d]", sps.ProfileIdc, sps.TierFlag, sps.LevelIdc, sps.Width, sps.Height, sps.ChromaFormatIdc, sps.BitDepthLuma)
+}
+
+func (sps *H265SPS) plausible() bool {
+	return sps.Width > 0 && sps.Width <= 16888 && sps.Height > 0 && sps.Height <= 16888 &&
+		sps.ChromaFormatIdc <= 3 && sps.BitDepthLuma <= 16 && sps.BitDepthChroma <= 16
+}
+
+// ParseH265SPS parses a H265 SPS NAL unit, nal header included, without start code, with or
+// without its emulation prevention bytes like ParseH264SPS.
+func ParseH265SPS(nalu []byte) (sps *H265SPS, err error) {
+	if len(nalu) < 4 {
+		err = fmt.Errorf("h265 sps too short, size[%d]", len(nalu))
+		return
+	}
+	if t := (nalu[0] >> 1) & 0x3F; t != 33 {
+		err = fmt.Errorf("not a h265 sps, nal type[%d]", t)
+		return
+	}
+	rbsp := RemoveEmulationPrevention(nalu[2:])
+	sps, err = parseH265SPS(rbsp)
+	if (err != nil || !sps.plausible()) && len(rbsp) != len(nalu)-2 {
+		if raw, rawErr := parseH265SPS(nalu[2:]); rawErr == nil && raw.plausible() {
+			return raw, nil
+		}
+	}
+	return
+}
+
+func parseH265SPS(rbsp []byte) (sps *H265SPS, err error) {
+	r := &bitReader{buf: rbsp}
+	sps = &H265SPS{}
+	r.readBits(4) // sps_video_parameter_set_id
+	maxSubLayersMinus1 := int(r.readBits(3))
+	r.readFlag() // sps_temporal_id_nesting_flag
+	// profile_tier_level
+	sps.ProfileSpace = int(r.readBits(2))
+	sps.TierFlag = int(r.readBits(1))
+	sps.ProfileIdc = int(r.readBits(5))
+	r.readBits(32) // general_profile_compatibility_flags
+	r.readBits(48) // progressive, interlaced, non_packed, frame_only and 44 reserved bits
+	sps.LevelIdc = int(r.readBits(8))
+	subLayerProfilePresent := make([]bool, maxSubLayersMinus1)
+	subLayerLevelPresent := make([]bool, maxSubLayersMinus1)
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		subLayerProfilePresent[i] = r.readFlag()
+		subLayerLevelPresent[i] = r.readFlag()
+	}
+	if maxSubLayersMinus1 > 0 {
+		r.readBits(2 * (8 - maxSubLayersMinus1)) // reserved_zero_2bits
+	}
+	for i := 0; i < maxSubLayersMinus1; i++ {
+		if subLayerProfilePresent[i] {
+			r.readBits(88)
+		}
+		if subLayerLevelPresent[i] {
+			r.readBits(8)
+		}
+	}
+	sps.SPSID = int(r.readUE())
+	sps.ChromaFormatIdc = int(r.readUE())
+	separateColourPlane := false
+	if sps.ChromaFormatIdc == 3 {
+		separateColourPlane = r.readFlag()
+	}
+	width := int(r.readUE())
+	height := int(r.readUE())
+	var confLeft, confRight, confTop, confBottom int
+	if r.readFlag() { // conformance_window_flag
+		confLeft = int(r.readUE())
+		confRight = int(r.readUE())
+		confTop = int(r.readUE())
+		confBottom = int(r.readUE())
+	}
+	sps.BitDepthLuma = int(r.readUE()) + 8
+	sps.BitDepthChroma = int(r.readUE()) + 8
+	if r.err != nil {
+		err = r.err
+		return
+	}
+	subWidthC, subHeightC := 1, 1
+	if !separateColourPlane {
+		switch sps.ChromaFormatIdc {
+		case 1:
+			subWidthC, subHeightC = 2, 2
+		case 2:
+			subWidthC = 2
+		}
+	}
+	sps.Width = width - subWidthC*(confLeft+confRight)
+	sps.Height = height - subHeightC*(confTop+confBottom)
+	return
+}
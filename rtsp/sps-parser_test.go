@@ -0,0 +1,164 @@
+package rtsp
+
+import (
+	"testing"
+)
+
+// bitWriter writes the exp-golomb coded fields of the parameter sets the tests need.
+type bitWriter struct {
+	buf []byte
+	n   int
+}
+
+func (w *bitWriter) writeBits(val uint, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		w.buf[len(w.buf)-1] |= byte((val>>uint(i))&1) << uint(7-w.n%8)
+		w.n++
+	}
+}
+
+func (w *bitWriter) writeFlag(flag bool) {
+	if flag {
+		w.writeBits(1, 1)
+	} else {
+		w.writeBits(0, 1)
+	}
+}
+
+func (w *bitWriter) writeUE(val uint) {
+	zeros := 0
+	for v := val + 1; v > 1; v >>= 1 {
+		zeros++
+	}
+	w.writeBits(0, zeros)
+	w.writeBits(val+1, zeros+1)
+}
+
+// rbsp ends the bits with the rbsp trailing bits.
+func (w *bitWriter) rbsp() []byte {
+	w.writeBits(1, 1)
+	for w.n%8 != 0 {
+		w.writeBits(0, 1)
+	}
+	return w.buf
+}
+
+// addEmulationPrevention makes a NAL unit payload of rbsp.
+func addEmulationPrevention(rbsp []byte) []byte {
+	var out []byte
+	zeros := 0
+	for _, b := range rbsp {
+		if zeros >= 2 && b <= 3 {
+			out = append(out, 3)
+			zeros = 0
+		}
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+type testSPS struct {
+	profile, level int
+	width, height  int
+	// frame rate of the vui timing info, no vui if 0
+	fps int
+	// nal hrd parameters with 24 bits delays, and pic_struct_present_flag in the vui
+	hrd, picStruct bool
+}
+
+// h264 makes the h264 sps NAL unit of s, 4:2:0 progressive.
+func (s testSPS) h264() []byte {
+	w := &bitWriter{}
+	w.writeBits(uint(s.profile), 8)
+	w.writeBits(0, 8) // constraint flags
+	w.writeBits(uint(s.level), 8)
+	w.writeUE(0) // sps id
+	if s.profile == 100 {
+		w.writeUE(1)       // chroma_format_idc
+		w.writeUE(0)       // bit_depth_luma_minus8
+		w.writeUE(0)       // bit_depth_chroma_minus8
+		w.writeFlag(false) // qpprime_y_zero_transform_bypass_flag
+		w.writeFlag(false) // seq_scaling_matrix_present_flag
+	}
+	w.writeUE(0) // log2_max_frame_num_minus4
+	w.writeUE(2) // pic_order_cnt_type
+	w.writeUE(1) // max_num_ref_frames
+	w.writeFlag(false)
+	mbsWidth, mbsHeight := (s.width+15)/16, (s.height+15)/16
+	w.writeUE(uint(mbsWidth - 1))
+	w.writeUE(uint(mbsHeight - 1))
+	w.writeFlag(true) // frame_mbs_only_flag
+	w.writeFlag(true) // direct_8x8_inference_flag
+	if cropRight, cropBottom := mbsWidth*16-s.width, mbsHeight*16-s.height; cropRight > 0 || cropBottom > 0 {
+		w.writeFlag(true)
+		w.writeUE(0)
+		w.writeUE(uint(cropRight / 2))
+		w.writeUE(0)
+		w.writeUE(uint(cropBottom / 2))
+	} else {
+		w.writeFlag(false)
+	}
+	w.writeFlag(s.fps > 0)
+	if s.fps > 0 {
+		w.writeFlag(false) // aspect_ratio_info_present_flag
+		w.writeFlag(false) // overscan_info_present_flag
+		w.writeFlag(false) // video_signal_type_present_flag
+		w.writeFlag(false) // chroma_loc_info_present_flag
+		w.writeFlag(true)  // timing_info_present_flag
+		w.writeBits(1, 32)
+		w.writeBits(uint(2*s.fps), 32)
+		w.writeFlag(true)
+		w.writeFlag(s.hrd) // nal_hrd_parameters_present_flag
+		if s.hrd {
+			w.writeUE(0)       // cpb_cnt_minus1
+			w.writeBits(4, 4)  // bit_rate_scale
+			w.writeBits(6, 4)  // cpb_size_scale
+			w.writeUE(1000)    // bit_rate_value_minus1
+			w.writeUE(2000)    // cpb_size_value_minus1
+			w.writeFlag(false) // cbr_flag
+			w.writeBits(23, 5) // initial_cpb_removal_delay_length_minus1
+			w.writeBits(23, 5) // cpb_removal_delay_length_minus1
+			w.writeBits(23, 5) // dpb_output_delay_length_minus1
+			w.writeBits(24, 5) // time_offset_length
+		}
+		w.writeFlag(false) // vcl_hrd_parameters_present_flag
+		if s.hrd {
+			w.writeFlag(false) // low_delay_hrd_flag
+		}
+		w.writeFlag(s.picStruct)
+		w.writeFlag(false) // bitstream_restriction_flag
+	}
+	return append([]byte{0x67}, addEmulationPrevention(w.rbsp())...)
+}
+
+func TestParseH264SPSEmulationPrevention(t *testing.T) {
+	for _, s := range []testSPS{
+		{profile: 66, level: 30, width: 640, height: 480, fps: 25},
+		{profile: 100, level: 40, width: 1920, height: 1080, fps: 30, hrd: true},
+		{profile: 77, level: 31, width: 1280, height: 720, fps: 60, picStruct: true},
+	} {
+		withEPB := s.h264()
+		stripped := append([]byte{0x67}, RemoveEmulationPrevention(withEPB[1:])...)
+		if len(stripped) == len(withEPB) {
+			t.Fatalf("%dx%d: test sps has no emulation prevention byte", s.width, s.height)
+		}
+		for name, nalu := range map[string][]byte{"with epb": withEPB, "epb stripped": stripped} {
+			sps, err := ParseH264SPS(nalu)
+			if err != nil {
+				t.Errorf("%dx%d %s: %v", s.width, s.height, name, err)
+				continue
+			}
+			if sps.Width != s.width || sps.Height != s.height || sps.ProfileIdc != s.profile || sps.FrameRate() != float64(s.fps) {
+				t.Errorf("%dx%d %s: parsed %dx%d profile[%d] fps[%v]", s.width, s.height, name, sps.Width, sps.Height, sps.ProfileIdc, sps.FrameRate())
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package rtsp
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+type SSRCStats struct {
+	// Video and Audio are the learned ssrc of the tracks, -1 while not learned.
+	Video    int64 `json:"video"`
+	Audio    int64 `json:"audio"`
+	Rejected int   `json:"rejected"`
+}
+
+// ssrcFilter learns the ssrc of a media track and drops packets of other sources, e.g. cross-talk on
+// shared udp ports. ssrc_learning of the channel is where it is learned from:
+//   - first: the first media packet
+//   - sdp: a=ssrc of the media, or rtcp if the sdp has none
+//   - rtcp: the sender of the first SR or SDES, media is dropped until then
+//
+// A source other than the learned one is taken over after ssrc_relearn_packets packets in a row,
+// as a camera restarting picks a new ssrc.
+type ssrcFilter struct {
+	fromRTCP   bool
+	ssrc       uint32
+	learned    bool
+	relearn    int
+	candidate  uint32
+	candidates int
+}
+
+func newSSRCFilter(mode string, sdp *SDPInfo, relearn int) *ssrcFilter {
+	f := &ssrcFilter{relearn: relearn}
+	switch mode {
+	case "first":
+	case "sdp":
+		if sdp != nil && len(sdp.SSRCs) > 0 {
+			f.ssrc, f.learned = sdp.SSRCs[0], true
+		} else {
+			f.fromRTCP = true
+		}
+	case "rtcp":
+		f.fromRTCP = true
+	default:
+		return nil
+	}
+	return f
+}
+
+// control learns the ssrc from a rtcp packet of the track.
+func (f *ssrcFilter) control(rtcp []byte) {
+	if f.learned || len(rtcp) < 8 {
+		return
+	}
+	// SR and SDES start with the ssrc of the sender, the first chunk for SDES.
+	if pt := rtcp[1]; pt == 200 || pt == 202 {
+		f.ssrc, f.learned = binary.BigEndian.Uint32(rtcp[4:]), true
+	}
+}
+
+// accept tells whether a media packet with this ssrc is relayed.
+func (f *ssrcFilter) accept(ssrc uint32) bool {
+	if !f.learned {
+		if f.fromRTCP {
+			return false
+		}
+		f.ssrc, f.learned = ssrc, true
+		return true
+	}
+	if ssrc == f.ssrc {
+		f.candidates = 0
+		return true
+	}
+	if f.candidates > 0 && ssrc == f.candidate {
+		f.candidates++
+	} else {
+		f.candidate, f.candidates = ssrc, 1
+	}
+	if f.relearn > 0 && f.candidates >= f.relearn {
+		f.ssrc, f.candidates = ssrc, 0
+		return true
+	}
+	return false
+}
+
+func (f *ssrcFilter) learnedSSRC() int64 {
+	if f == nil || !f.learned {
+		return -1
+	}
+	return int64(f.ssrc)
+}
+
+type ssrcFilters struct {
+	video    *ssrcFilter
+	audio    *ssrcFilter
+	rejected int
+	lock     sync.Mutex
+}
+
+// filterSSRC tells whether pack is relayed, it feeds rtcp packets to learn from and always relays them.
+func (pusher *Pusher) filterSSRC(pack *RTPPack) bool {
+	filters := pusher.ssrcFilters()
+	if filters == nil {
+		return true
+	}
+	filters.lock.Lock()
+	defer filters.lock.Unlock()
+	b := pack.Buffer.Bytes()
+	switch pack.Type {
+	case RTP_TYPE_VIDEOCONTROL, RTP_TYPE_AUDIOCONTROL:
+		f := filters.video
+		if pack.Type == RTP_TYPE_AUDIOCONTROL {
+			f = filters.audio
+		}
+		if f != nil {
+			f.control(b)
+		}
+		return true
+	}
+	f := filters.video
+	if pack.Type == RTP_TYPE_AUDIO {
+		f = filters.audio
+	}
+	if f == nil || len(b) < RTP_FIXED_HEADER_LENGTH {
+		return true
+	}
+	if f.accept(binary.BigEndian.Uint32(b[8:])) {
+		return true
+	}
+	filters.rejected++
+	return false
+}
+
+// ssrcFilters creates the filters on the first packet, nil if ssrc_learning is off.
+func (pusher *Pusher) ssrcFilters() *ssrcFilters {
+	pusher.ssrcOnce.Do(func() {
+		mode := ChannelKey(pusher.Path(), "ssrc_learning").MustString("off")
+		relearn := ChannelKey(pusher.Path(), "ssrc_relearn_packets").MustInt(50)
+		sdpMap := pusher.SDPMap()
+		video, audio := newSSRCFilter(mode, sdpMap["video"], relearn), newSSRCFilter(mode, sdpMap["audio"], relearn)
+		if video != nil || audio != nil {
+			pusher.ssrc = &ssrcFilters{video: video, audio: audio}
+		}
+	})
+	return pusher.ssrc
+}
+
+// SSRCStats returns the learned ssrc and the packets rejected for another one, nil if ssrc_learning is off.
+func (pusher *Pusher) SSRCStats() *SSRCStats {
+	filters := pusher.ssrcFilters()
+	if filters == nil {
+		return nil
+	}
+	filters.lock.Lock()
+	defer filters.lock.Unlock()
+	return &SSRCStats{
+		Video:    filters.video.learnedSSRC(),
+		Audio:    filters.audio.learnedSSRC(),
+		Rejected: filters.rejected,
+	}
+}
@@ -0,0 +1,86 @@
+package rtsp
+
+import (
+	"strings"
+)
+
+// temporalLayerFilter tells the temporal layer (temporal id) of video rtp packets, to drop the
+// layers above maxLayer. The temporal id is in the nal header of h265, and in the svc extension
+// of the prefix (14) and coded slice extension (20) nal units of h264, a prefix nal unit giving it
+// to the base layer slice after it.
+type temporalLayerFilter struct {
+	codec    string
+	maxLayer int
+	// h264: temporal id of the last prefix nal unit
+	prefixTid int
+	// temporal id of the fragmented nal unit in progress
+	fuTid int
+}
+
+func newTemporalLayerFilter(codec string, maxLayer int) *temporalLayerFilter {
+	codec = strings.ToLower(codec)
+	if maxLayer < 0 || (codec != "h264" && codec != "h265") {
+		return nil
+	}
+	return &temporalLayerFilter{codec: codec, maxLayer: maxLayer}
+}
+
+// drop tells whether the packet with this payload is above the cap.
+func (f *temporalLayerFilter) drop(payload []byte) bool {
+	return f.layer(payload) > f.maxLayer
+}
+
+// layer returns the temporal id of a packet, the highest of the nal units it carries.
+func (f *temporalLayerFilter) layer(payload []byte) int {
+	if f.codec == "h265" {
+		// the payload header of aggregation and fragmentation units carries the temporal id too.
+		if len(payload) < 2 || payload[1]&0x07 == 0 {
+			return 0
+		}
+		return int(payload[1]&0x07) - 1
+	}
+	if len(payload) < 1 {
+		return 0
+	}
+	if t := payload[0] & 0x1F; t == 28 || t == 29 {
+		if len(payload) < 2 {
+			return 0
+		}
+		if payload[1]&0x80 != 0 {
+			// the nal header of the fragmented unit, followed by its first bytes.
+			nalu := append([]byte{payload[0]&0xE0 | payload[1]&0x1F}, payload[2:]...)
+			f.fuTid = f.naluLayer(nalu)
+		}
+		return f.fuTid
+	}
+	tid := 0
+	for _, nalu := range RTPNALUnits("h264", payload) {
+		if t := f.naluLayer(nalu); t > tid {
+			tid = t
+		}
+	}
+	return tid
+}
+
+func (f *temporalLayerFilter) naluLayer(nalu []byte) int {
+	if len(nalu) == 0 {
+		return 0
+	}
+	switch nalu[0] & 0x1F {
+	case 14:
+		// svc_extension_flag, then temporal_id in the top bits of the third extension byte.
+		if len(nalu) >= 4 && nalu[1]&0x80 != 0 {
+			f.prefixTid = int(nalu[3] >> 5)
+		}
+		return f.prefixTid
+	case 20:
+		if len(nalu) >= 4 && nalu[1]&0x80 != 0 {
+			return int(nalu[3] >> 5)
+		}
+	case 1, 5:
+		tid := f.prefixTid
+		f.prefixTid = 0
+		return tid
+	}
+	return 0
+}
@@ -0,0 +1,68 @@
+package rtsp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTemporalLayerFilter(t *testing.T) {
+	// a dyadic 3 layer gop: the temporal ids of the frames in decode order
+	tids := []int{0, 2, 1, 2, 0, 2, 1, 2}
+	h265Frame := func(tid int) [][]byte {
+		nalType := byte(1)
+		if tid == 0 {
+			nalType = 19
+		}
+		return [][]byte{append([]byte{nalType << 1, byte(tid + 1)}, bytes.Repeat([]byte{0x33}, 250)...)}
+	}
+	h264Frame := func(tid int) [][]byte {
+		// a prefix nal unit with the svc extension, then the base layer slice
+		prefix := []byte{0x6E, 0x80, 0x00, byte(tid << 5)}
+		return [][]byte{prefix, append([]byte{0x41}, bytes.Repeat([]byte{0x33}, 250)...)}
+	}
+	for _, c := range []struct {
+		codec    string
+		frame    func(tid int) [][]byte
+		maxLayer int
+		want     []int
+	}{
+		{"h265", h265Frame, 0, []int{0, 4}},
+		{"h265", h265Frame, 1, []int{0, 2, 4, 6}},
+		{"h265", h265Frame, 2, []int{0, 1, 2, 3, 4, 5, 6, 7}},
+		{"h264", h264Frame, 0, []int{0, 4}},
+		{"h264", h264Frame, 1, []int{0, 2, 4, 6}},
+	} {
+		f := newTemporalLayerFilter(c.codec, c.maxLayer)
+		var delivered []int
+		for i, tid := range tids {
+			// fragmented to a 100 bytes blocksize, every packet of a frame dropped or none
+			kept, dropped := 0, 0
+			for _, rtp := range testRTPPackets(c.codec, 100, uint16(i*10), uint32(3000*i), c.frame(tid)...) {
+				if f.drop(rtp.Payload) {
+					dropped++
+				} else {
+					kept++
+				}
+			}
+			if kept > 0 && dropped > 0 {
+				t.Errorf("%s max layer %d: frame %d partly dropped", c.codec, c.maxLayer, i)
+			}
+			if kept > 0 {
+				delivered = append(delivered, i)
+			}
+		}
+		if len(delivered) != len(c.want) {
+			t.Errorf("%s max layer %d: frames %v delivered, want %v", c.codec, c.maxLayer, delivered, c.want)
+			continue
+		}
+		for i := range delivered {
+			if delivered[i] != c.want[i] {
+				t.Errorf("%s max layer %d: frames %v delivered, want %v", c.codec, c.maxLayer, delivered, c.want)
+				break
+			}
+		}
+	}
+	if newTemporalLayerFilter("h264", -1) != nil {
+		t.Errorf("filter without a cap")
+	}
+}
@@ -0,0 +1,141 @@
+package rtsp
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+type TimestampGuardStats struct {
+	Dropped int `json:"dropped"`
+	Clamped int `json:"clamped"`
+	// Discontinuities counts the timestamp jumps taken as a new timeline, confirmed by the packets after them.
+	Discontinuities int `json:"discontinuities"`
+}
+
+// timestampGuard catches rtp packets whose timestamp is off the last one by more than
+// timestamp_guard_max_jump, on top of the time passed since it came for a forward jump. A lone
+// packet like that is corrupt and is dropped, or clamped to the expected timestamp with
+// timestamp_guard_mode=clamp. When timestamp_guard_confirm packets in a row agree with the jump,
+// the source really restarted its timeline, which is then followed.
+type timestampGuard struct {
+	clockRate int
+	maxJump   int64
+	clamp     bool
+	confirm   int
+
+	started  bool
+	last     uint32
+	lastAt   time.Time
+	jumped   uint32
+	jumpedAt time.Time
+	jumps    int
+}
+
+// push returns whether the packet is relayed, it may rewrite its timestamp.
+func (g *timestampGuard) push(rtp []byte, now time.Time, stats *TimestampGuardStats) bool {
+	ts := binary.BigEndian.Uint32(rtp[4:])
+	if !g.started {
+		g.started, g.last, g.lastAt = true, ts, now
+		return true
+	}
+	if g.near(ts, g.last, g.lastAt, now) {
+		g.last, g.lastAt, g.jumps = ts, now, 0
+		return true
+	}
+	if g.jumps > 0 && g.near(ts, g.jumped, g.jumpedAt, now) {
+		g.jumps++
+	} else {
+		g.jumps = 1
+	}
+	g.jumped, g.jumpedAt = ts, now
+	if g.jumps >= g.confirm {
+		stats.Discontinuities++
+		g.last, g.lastAt, g.jumps = ts, now, 0
+		return true
+	}
+	if !g.clamp {
+		stats.Dropped++
+		return false
+	}
+	stats.Clamped++
+	binary.BigEndian.PutUint32(rtp[4:], g.last+uint32(now.Sub(g.lastAt).Seconds()*float64(g.clockRate)))
+	return true
+}
+
+// near tells whether ts follows ref, received at refAt. Packets delayed on the way may come in a
+// burst with timestamps behind the wallclock, so only the forward jump is allowed the time passed.
+func (g *timestampGuard) near(ts, ref uint32, refAt time.Time, now time.Time) bool {
+	elapsed := int64(now.Sub(refAt).Seconds() * float64(g.clockRate))
+	diff := int64(int32(ts - ref))
+	return diff >= -g.maxJump && diff <= g.maxJump+elapsed
+}
+
+type timestampGuards struct {
+	video *timestampGuard
+	audio *timestampGuard
+	stats TimestampGuardStats
+	lock  sync.Mutex
+}
+
+func newTimestampGuard(path string, clockRate int) *timestampGuard {
+	maxJump := ChannelKey(path, "timestamp_guard_max_jump").MustInt(0)
+	if maxJump <= 0 || clockRate <= 0 {
+		return nil
+	}
+	confirm := ChannelKey(path, "timestamp_guard_confirm").MustInt(5)
+	if confirm < 2 {
+		confirm = 2
+	}
+	return &timestampGuard{
+		clockRate: clockRate,
+		maxJump:   int64(maxJump) * int64(clockRate) / 1000,
+		clamp:     ChannelKey(path, "timestamp_guard_mode").MustString("drop") == "clamp",
+		confirm:   confirm,
+	}
+}
+
+// guardTimestamp tells whether a media packet is relayed, see timestampGuard.
+func (pusher *Pusher) guardTimestamp(pack *RTPPack) bool {
+	guards := pusher.timestampGuards()
+	if guards == nil {
+		return true
+	}
+	g := guards.video
+	if pack.Type == RTP_TYPE_AUDIO {
+		g = guards.audio
+	} else if pack.Type != RTP_TYPE_VIDEO {
+		return true
+	}
+	b := pack.Buffer.Bytes()
+	if g == nil || len(b) < RTP_FIXED_HEADER_LENGTH {
+		return true
+	}
+	guards.lock.Lock()
+	defer guards.lock.Unlock()
+	return g.push(b, time.Now(), &guards.stats)
+}
+
+// timestampGuards creates the guards on the first packet, nil if timestamp_guard_max_jump is 0.
+func (pusher *Pusher) timestampGuards() *timestampGuards {
+	pusher.timestampGuardOnce.Do(func() {
+		video := newTimestampGuard(pusher.Path(), pusher.ClockRate(RTP_TYPE_VIDEO))
+		audio := newTimestampGuard(pusher.Path(), pusher.ClockRate(RTP_TYPE_AUDIO))
+		if video != nil || audio != nil {
+			pusher.timestampGuard = &timestampGuards{video: video, audio: audio}
+		}
+	})
+	return pusher.timestampGuard
+}
+
+// TimestampGuardStats returns the packets caught by the timestamp guard, nil if it is off.
+func (pusher *Pusher) TimestampGuardStats() *TimestampGuardStats {
+	guards := pusher.timestampGuards()
+	if guards == nil {
+		return nil
+	}
+	guards.lock.Lock()
+	defer guards.lock.Unlock()
+	stats := guards.stats
+	return &stats
+}
@@ -0,0 +1,102 @@
+package rtsp
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestTimestampGuardPush(t *testing.T) {
+	const second = 90000
+	base := uint32(0xFFFF0000)
+	type packet struct {
+		ts uint32
+		at time.Duration
+		// relayed, and the timestamp it is relayed with
+		relayed bool
+		out     uint32
+	}
+	for _, c := range []struct {
+		name    string
+		clamp   bool
+		packets []packet
+		want    TimestampGuardStats
+	}{
+		{"steady, wrapping", false, []packet{
+			{base, 0, true, base},
+			{base + 0x8000, 40 * time.Millisecond, true, base + 0x8000},
+			{base + 0x10000 + 3600, 80 * time.Millisecond, true, base + 0x10000 + 3600},
+		}, TimestampGuardStats{}},
+		{"lone forward jump dropped", false, []packet{
+			{base, 0, true, base},
+			{base + 3600, 40 * time.Millisecond, true, base + 3600},
+			{base + 3600 + 10*second, 80 * time.Millisecond, false, 0},
+			{base + 7200, 80 * time.Millisecond, true, base + 7200},
+		}, TimestampGuardStats{Dropped: 1}},
+		{"lone forward jump clamped", true, []packet{
+			{base, 0, true, base},
+			{base + 3600, 40 * time.Millisecond, true, base + 3600},
+			// clamped to the last timestamp and the 40ms since it came
+			{base + 3600 + 10*second, 80 * time.Millisecond, true, base + 7200},
+			{base + 7200, 80 * time.Millisecond, true, base + 7200},
+		}, TimestampGuardStats{Clamped: 1}},
+		{"confirmed discontinuity", false, []packet{
+			{base, 0, true, base},
+			{base + 20*second, 40 * time.Millisecond, false, 0},
+			{base + 20*second + 3600, 80 * time.Millisecond, false, 0},
+			{base + 20*second + 7200, 120 * time.Millisecond, true, base + 20*second + 7200},
+			{base + 20*second + 10800, 160 * time.Millisecond, true, base + 20*second + 10800},
+		}, TimestampGuardStats{Dropped: 2, Discontinuities: 1}},
+		{"jumps not in a row are not confirmed", false, []packet{
+			{base, 0, true, base},
+			{base + 20*second, 40 * time.Millisecond, false, 0},
+			{base + 40*second, 80 * time.Millisecond, false, 0},
+			{base + 20*second, 120 * time.Millisecond, false, 0},
+			{base + 3600, 160 * time.Millisecond, true, base + 3600},
+		}, TimestampGuardStats{Dropped: 3}},
+		{"backward jump dropped, late packet kept", false, []packet{
+			{base, 0, true, base},
+			{base + 3600, 40 * time.Millisecond, true, base + 3600},
+			{base + 1800, 80 * time.Millisecond, true, base + 1800},
+			{base - 5*second, 120 * time.Millisecond, false, 0},
+		}, TimestampGuardStats{Dropped: 1}},
+		{"forward jump covered by the time passed", false, []packet{
+			{base, 0, true, base},
+			{base + 5*second, 5 * time.Second, true, base + 5*second},
+		}, TimestampGuardStats{}},
+	} {
+		g := &timestampGuard{clockRate: second, maxJump: second, clamp: c.clamp, confirm: 3}
+		start := time.Unix(1000, 0)
+		var stats TimestampGuardStats
+		for i, p := range c.packets {
+			rtp := selfTestRTP(96, uint16(i), p.ts, true, []byte{0x41})
+			if relayed := g.push(rtp, start.Add(p.at), &stats); relayed != p.relayed {
+				t.Errorf("%s: packet %d relayed %v, want %v", c.name, i, relayed, p.relayed)
+			} else if out := binary.BigEndian.Uint32(rtp[4:]); relayed && out != p.out {
+				t.Errorf("%s: packet %d relayed with timestamp %d, want %d", c.name, i, out, p.out)
+			}
+		}
+		if stats != c.want {
+			t.Errorf("%s: stats %+v, want %+v", c.name, stats, c.want)
+		}
+	}
+}
+
+func TestNewTimestampGuard(t *testing.T) {
+	if g := newTimestampGuard("/test", 90000); g != nil {
+		t.Error("guard with timestamp_guard_max_jump unset")
+	}
+	setTestConf(t, "timestamp_guard_max_jump", "500")
+	setTestConf(t, "timestamp_guard_mode", "clamp")
+	setTestConf(t, "timestamp_guard_confirm", "1")
+	g := newTimestampGuard("/test", 48000)
+	if g == nil {
+		t.Fatal("no guard")
+	}
+	if g.maxJump != 24000 || !g.clamp || g.confirm != 2 {
+		t.Errorf("guard max jump %d clamp %v confirm %d, want 24000 true 2", g.maxJump, g.clamp, g.confirm)
+	}
+	if g := newTimestampGuard("/test", 0); g != nil {
+		t.Error("guard with no clock rate")
+	}
+}
@@ -0,0 +1,154 @@
+package rtsp
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/penggy/EasyGoLib/utils"
+)
+
+const (
+	// transcodeSuffix is appended to the path of a h265 channel for its h264 transcode.
+	transcodeSuffix = "_h264"
+	// compatSuffix is appended to the path of a h264 channel for its transcode within the
+	// h264_max_profile and h264_max_level of the channel.
+	compatSuffix = "_compat"
+)
+
+// needsH264 tells whether a player asks for h264 only, by an "X-Accept-Codec: h264" header, a
+// codec=h264 query on the DESCRIBE url, or a User-Agent listed in transcode_h264_agents.
+func needsH264(req *Request) bool {
+	if strings.EqualFold(strings.TrimSpace(req.Header["X-Accept-Codec"]), "h264") {
+		return true
+	}
+	if u, err := url.Parse(req.URL); err == nil && strings.EqualFold(u.Query().Get("codec"), "h264") {
+		return true
+	}
+	agent := strings.ToLower(req.Header["User-Agent"])
+	for _, a := range strings.Split(utils.Conf().Section("rtsp").Key("transcode_h264_agents").MustString(""), ",") {
+		if a = strings.ToLower(strings.TrimSpace(a)); a != "" && strings.Contains(agent, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrTranscodeStarting is returned by H264Transcode and H264CompatTranscode while ffmpeg has not
+// pushed the transcode yet, the player is to retry.
+var ErrTranscodeStarting = errors.New("h264 transcode starting")
+
+// H264Transcode returns the h264 transcode of the h265 channel at path, pushed by ffmpeg to
+// path+"_h264" with transcode_params, see startTranscode.
+func (server *Server) H264Transcode(path string) (*Pusher, error) {
+	params := utils.Conf().Section("rtsp").Key("transcode_params").MustString("-rtsp_transport tcp -i {input} -c:v libx264 -preset veryfast -tune zerolatency -c:a copy -rtsp_transport tcp -f rtsp {output}")
+	return server.startTranscode(path, path+transcodeSuffix, params)
+}
+
+// H264CompatTranscode returns the transcode of the h264 channel at path within its h264_max_profile
+// and h264_max_level, pushed by ffmpeg to path+"_compat" with h264_compat_params, where {profile}
+// and {level} are replaced by the x264 names of the limits.
+func (server *Server) H264CompatTranscode(path string) (*Pusher, error) {
+	profile := "high"
+	switch maxProfile := ChannelKey(path, "h264_max_profile").MustInt(0); {
+	case maxProfile == 66:
+		profile = "baseline"
+	case maxProfile > 0 && maxProfile < 100:
+		profile = "main"
+	}
+	level := "5.1"
+	if maxLevel := ChannelKey(path, "h264_max_level").MustInt(0); maxLevel > 0 {
+		level = fmt.Sprintf("%d.%d", maxLevel/10, maxLevel%10)
+	}
+	params := ChannelKey(path, "h264_compat_params").MustString("-rtsp_transport tcp -i {input} -c:v libx264 -profile:v {profile} -level {level} -preset veryfast -tune zerolatency -c:a copy -rtsp_transport tcp -f rtsp {output}")
+	params = strings.Replace(strings.Replace(params, "{profile}", profile, -1), "{level}", level, -1)
+	return server.startTranscode(path, path+compatSuffix, params)
+}
+
+// startTranscode returns the pusher at output, which ffmpeg pushes transcoding the channel at path
+// with paramStr. If it is not pushed yet, ffmpeg is started unless it is running, and
+// ErrTranscodeStarting is returned rather than waiting for it. ffmpeg is stopped if it has not
+// pushed the transcode within transcode_start_timeout seconds, once the transcode has had no player
+// for transcode_idle_timeout seconds, and when the server stops; it exits by itself when the
+// source goes away.
+func (server *Server) startTranscode(path string, output string, paramStr string) (*Pusher, error) {
+	logger := server.logger
+	if pusher := server.GetPusher(output); pusher != nil {
+		return pusher, nil
+	}
+	ffmpeg := utils.Conf().Section("rtsp").Key("ffmpeg_path").MustString("")
+	if ffmpeg == "" {
+		return nil, fmt.Errorf("no ffmpeg_path to transcode %s", path)
+	}
+	server.transcodesLock.Lock()
+	defer server.transcodesLock.Unlock()
+	if server.Stoped {
+		return nil, fmt.Errorf("server stopped, no transcode of %s", path)
+	}
+	if _, ok := server.transcodes[output]; !ok {
+		input := fmt.Sprintf("rtsp://127.0.0.1:%d%s", server.TCPPort, path)
+		outputURL := fmt.Sprintf("rtsp://127.0.0.1:%d%s", server.TCPPort, output)
+		params := strings.Fields(paramStr)
+		for i := range params {
+			params[i] = strings.Replace(strings.Replace(params[i], "{input}", input, -1), "{output}", outputURL, -1)
+		}
+		cmd := exec.Command(ffmpeg, params...)
+		if err := cmd.Start(); err != nil {
+			return nil, fmt.Errorf("start ffmpeg to transcode %s err:%v", path, err)
+		}
+		logger.Printf("ffmpeg[%d] transcodes %s to %s", cmd.Process.Pid, path, output)
+		server.transcodes[output] = cmd
+		go server.watchTranscode(path, output, cmd)
+	}
+	return nil, ErrTranscodeStarting
+}
+
+// stopTranscodes kills the ffmpeg of all the transcodes.
+func (server *Server) stopTranscodes() {
+	server.transcodesLock.Lock()
+	defer server.transcodesLock.Unlock()
+	for output, cmd := range server.transcodes {
+		server.logger.Printf("stop ffmpeg transcode to %s", output)
+		cmd.Process.Kill()
+	}
+}
+
+func (server *Server) watchTranscode(path string, output string, cmd *exec.Cmd) {
+	logger := server.logger
+	conf := utils.Conf().Section("rtsp")
+	startTimeout := time.Duration(conf.Key("transcode_start_timeout").MustInt(10)) * time.Second
+	idleTimeout := time.Duration(conf.Key("transcode_idle_timeout").MustInt(10)) * time.Second
+	startedAt := time.Now()
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-exited:
+			logger.Printf("ffmpeg transcode of %s exited, err:%v", path, err)
+			server.transcodesLock.Lock()
+			delete(server.transcodes, output)
+			server.transcodesLock.Unlock()
+			return
+		case <-ticker.C:
+			pusher := server.GetPusher(output)
+			if pusher == nil {
+				if time.Since(startedAt) >= startTimeout {
+					logger.Printf("h264 transcode of %s not pushed in time, stop ffmpeg", path)
+					cmd.Process.Kill()
+				}
+				continue
+			}
+			if since, idle := pusher.IdleSince(); idle && time.Since(since) >= idleTimeout {
+				logger.Printf("h264 transcode of %s has no player, stop ffmpeg", path)
+				cmd.Process.Kill()
+			}
+		}
+	}
+}
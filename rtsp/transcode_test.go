@@ -0,0 +1,40 @@
+package rtsp
+
+import (
+	"testing"
+	"time"
+)
+
+func transcodeRunning(server *Server, path string) bool {
+	server.transcodesLock.Lock()
+	defer server.transcodesLock.Unlock()
+	_, ok := server.transcodes[path]
+	return ok
+}
+
+func TestH264TranscodeStartsAsync(t *testing.T) {
+	setTestConf(t, "ffmpeg_path", "sleep")
+	setTestConf(t, "transcode_params", "30")
+	server := newTestServer()
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		if _, err := server.H264Transcode("/camera"); err != ErrTranscodeStarting {
+			t.Fatalf("transcode not pushed yet, err:%v", err)
+		}
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("H264Transcode waited %v for ffmpeg", time.Since(start))
+	}
+	if len(server.transcodes) != 1 {
+		t.Errorf("%d ffmpeg started, want 1", len(server.transcodes))
+	}
+	server.Stop()
+	for deadline := time.Now().Add(5 * time.Second); transcodeRunning(server, "/camera_h264"); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("ffmpeg transcode not killed on server stop")
+		}
+	}
+	if _, err := server.H264Transcode("/camera"); err == nil || err == ErrTranscodeStarting {
+		t.Errorf("transcode started after the server stopped, err:%v", err)
+	}
+}
@@ -0,0 +1,231 @@
+package rtsp
+
+import (
+	"io"
+	"strings"
+)
+
+// MPEG-TS (ISO/IEC 13818-1) muxing of Annex-B H264/H265 and ADTS AAC, one program, timestamps in 90kHz.
+
+const (
+	tsPacketSize = 188
+	tsPMTPID     = 0x1000
+	tsVideoPID   = 0x100
+	tsAudioPID   = 0x101
+	// the PCR runs this much ahead of the decoding timestamps
+	tsPCRDelay = 9000
+)
+
+var (
+	tsAUDH264 = []byte{0, 0, 0, 1, 0x09, 0xF0}
+	tsAUDH265 = []byte{0, 0, 0, 1, 0x46, 0x01, 0x50}
+)
+
+type TSMuxer struct {
+	w io.Writer
+	// 0x1B h264, 0x24 h265, 0 for no video
+	videoStreamType byte
+	audio           bool
+	cc              map[uint16]byte
+}
+
+// NewTSMuxer muxes to w a video track of videoCodec, h264 or h265, if any, and an aac track if audio.
+func NewTSMuxer(w io.Writer, videoCodec string, audio bool) *TSMuxer {
+	m := &TSMuxer{w: w, audio: audio, cc: make(map[uint16]byte)}
+	switch strings.ToLower(videoCodec) {
+	case "h264":
+		m.videoStreamType = 0x1B
+	case "h265":
+		m.videoStreamType = 0x24
+	}
+	return m
+}
+
+func (m *TSMuxer) pcrPID() uint16 {
+	if m.videoStreamType != 0 {
+		return tsVideoPID
+	}
+	return tsAudioPID
+}
+
+// WriteTables writes the PAT and the PMT, which a demuxer joining the stream needs first.
+func (m *TSMuxer) WriteTables() error {
+	pat := []byte{
+		0x00, 0xB0, 0x0D, // table id, section length 13
+		0x00, 0x01, // transport stream id
+		0xC1, 0x00, 0x00, // version 0, current, section 0 of 0
+		0x00, 0x01, 0xE0 | tsPMTPID>>8, tsPMTPID & 0xFF, // program 1
+	}
+	if err := m.writePSI(0, pat); err != nil {
+		return err
+	}
+	var streams []byte
+	if m.videoStreamType != 0 {
+		streams = append(streams, m.videoStreamType, 0xE0|tsVideoPID>>8, tsVideoPID&0xFF, 0xF0, 0x00)
+	}
+	if m.audio {
+		streams = append(streams, 0x0F, 0xE0|tsAudioPID>>8, tsAudioPID&0xFF, 0xF0, 0x00)
+	}
+	sectionLen := 13 + len(streams)
+	pcrPID := m.pcrPID()
+	pmt := []byte{
+		0x02, 0xB0 | byte(sectionLen>>8), byte(sectionLen),
+		0x00, 0x01, // program number
+		0xC1, 0x00, 0x00,
+		0xE0 | byte(pcrPID>>8), byte(pcrPID),
+		0xF0, 0x00, // no program info
+	}
+	return m.writePSI(tsPMTPID, append(pmt, streams...))
+}
+
+// WriteVideo writes an access unit in Annex-B, the PAT and PMT go before every keyframe.
+func (m *TSMuxer) WriteVideo(pts, dts int64, accessUnit []byte, keyframe bool) error {
+	if keyframe {
+		if err := m.WriteTables(); err != nil {
+			return err
+		}
+	}
+	aud := tsAUDH264
+	if m.videoStreamType == 0x24 {
+		aud = tsAUDH265
+	}
+	payload := make([]byte, 0, len(aud)+len(accessUnit))
+	payload = append(append(payload, aud...), accessUnit...)
+	return m.writePES(tsVideoPID, 0xE0, pts, dts, payload, keyframe)
+}
+
+// WriteAudio writes ADTS frames.
+func (m *TSMuxer) WriteAudio(pts int64, frames []byte) error {
+	return m.writePES(tsAudioPID, 0xC0, pts, pts, frames, m.videoStreamType == 0)
+}
+
+func (m *TSMuxer) writePSI(pid uint16, section []byte) error {
+	section = append(section, 0, 0, 0, 0)
+	crc := crc32MPEG2(section[:len(section)-4])
+	section[len(section)-4] = byte(crc >> 24)
+	section[len(section)-3] = byte(crc >> 16)
+	section[len(section)-2] = byte(crc >> 8)
+	section[len(section)-1] = byte(crc)
+	pkt := make([]byte, tsPacketSize)
+	pkt[0] = 0x47
+	pkt[1] = 0x40 | byte(pid>>8)
+	pkt[2] = byte(pid)
+	pkt[3] = 0x10 | m.nextCC(pid)
+	pkt[4] = 0 // pointer field
+	n := copy(pkt[5:], section)
+	for i := 5 + n; i < tsPacketSize; i++ {
+		pkt[i] = 0xFF
+	}
+	_, err := m.w.Write(pkt)
+	return err
+}
+
+func (m *TSMuxer) writePES(pid uint16, streamID byte, pts, dts int64, payload []byte, randomAccess bool) error {
+	header := []byte{0, 0, 1, streamID, 0, 0, 0x80}
+	if dts != pts {
+		header = append(header, 0xC0, 10)
+		header = append(header, tsTimestamp(0x3, pts)...)
+		header = append(header, tsTimestamp(0x1, dts)...)
+	} else {
+		header = append(header, 0x80, 5)
+		header = append(header, tsTimestamp(0x2, pts)...)
+	}
+	// an unbounded length is only allowed for video.
+	if pesLen := len(header) - 6 + len(payload); streamID != 0xE0 && pesLen <= 0xFFFF {
+		header[4], header[5] = byte(pesLen>>8), byte(pesLen)
+	}
+	data := append(header, payload...)
+	withPCR := pid == m.pcrPID()
+	first := true
+	pkt := make([]byte, tsPacketSize)
+	for len(data) > 0 {
+		pkt = pkt[:4]
+		pkt[0] = 0x47
+		pkt[1] = byte(pid >> 8)
+		if first {
+			pkt[1] |= 0x40
+		}
+		pkt[2] = byte(pid)
+		var af []byte
+		if first && (withPCR || randomAccess) {
+			af = []byte{0, 0}
+			if randomAccess {
+				af[1] |= 0x40
+			}
+			if withPCR {
+				af[1] |= 0x10
+				af = append(af, tsPCR(dts-tsPCRDelay)...)
+			}
+		}
+		if space := tsPacketSize - 4 - len(af); len(data) < space {
+			stuffing := space - len(data)
+			if af == nil {
+				af = []byte{0}
+				if stuffing > 1 {
+					af = append(af, 0)
+				}
+				stuffing -= len(af)
+			}
+			for i := 0; i < stuffing; i++ {
+				af = append(af, 0xFF)
+			}
+		}
+		if af != nil {
+			af[0] = byte(len(af) - 1)
+			pkt[3] = 0x30 | m.nextCC(pid)
+		} else {
+			pkt[3] = 0x10 | m.nextCC(pid)
+		}
+		pkt = append(pkt, af...)
+		n := tsPacketSize - len(pkt)
+		pkt = append(pkt, data[:n]...)
+		data = data[n:]
+		if _, err := m.w.Write(pkt); err != nil {
+			return err
+		}
+		first = false
+	}
+	return nil
+}
+
+func (m *TSMuxer) nextCC(pid uint16) byte {
+	cc := m.cc[pid]
+	m.cc[pid] = (cc + 1) & 0x0F
+	return cc
+}
+
+// tsTimestamp encodes a 33 bits PTS or DTS behind a 4 bits prefix.
+func tsTimestamp(prefix byte, ts int64) []byte {
+	ts &= 0x1FFFFFFFF
+	return []byte{
+		prefix<<4 | byte(ts>>29)&0x0E | 1,
+		byte(ts >> 22),
+		byte(ts>>14)&0xFE | 1,
+		byte(ts >> 7),
+		byte(ts<<1)&0xFE | 1,
+	}
+}
+
+func tsPCR(pcr int64) []byte {
+	if pcr < 0 {
+		pcr = 0
+	}
+	pcr &= 0x1FFFFFFFF
+	return []byte{byte(pcr >> 25), byte(pcr >> 17), byte(pcr >> 9), byte(pcr >> 1), byte(pcr&1)<<7 | 0x7E, 0}
+}
+
+// crc32MPEG2 is the crc of the PSI sections: polynomial 0x04C11DB7, not reflected, no final xor.
+func crc32MPEG2(data []byte) uint32 {
+	crc := uint32(0xFFFFFFFF)
+	for _, b := range data {
+		crc ^= uint32(b) << 24
+		for i := 0; i < 8; i++ {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,316 @@
+package rtsp
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/penggy/EasyGoLib/utils"
+)
+
+// tsStartPTS is the timestamp of the first access unit of a file, leaving room for the dts delay.
+const tsStartPTS = 90000
+
+// TSRecorder records the depacketized access units of a pusher to MPEG-TS files, without ffmpeg.
+// A file starts at a keyframe and is flushed at every keyframe, so a truncated file loses at most
+// the gop being written. Files rotate at the first keyframe past ts_record_duration seconds or
+// ts_record_size MB. With ts_record_schedule, files are only written in its windows, or until the
+// deadline of a Trigger outside them, starting and stopping at keyframes.
+type TSRecorder struct {
+	pusher      *Pusher
+	dir         string
+	maxDuration time.Duration
+	maxSize     int64
+	videoCodec  string
+	audio       bool
+	// nil to record all the time
+	schedule       *RecordSchedule
+	triggeredUntil time.Time
+
+	file     *os.File
+	writer   *bufio.Writer
+	muxer    *TSMuxer
+	openedAt time.Time
+	size     int64
+	// the segment ends at the next keyframe, see Finalize
+	finalize bool
+
+	vClock   tsClock
+	aClock   tsClock
+	dtsDelay int64
+	lastDTS  int64
+	closed   bool
+	lock     sync.Mutex
+}
+
+// tsClock extends the 32 bits rtp timestamps of a track, and converts them to 90kHz from the file start.
+// The tracks have unrelated rtp timestamps, so each starts at the wallclock time since the file
+// opened when its first access unit came, which keeps the audio in sync with the video.
+type tsClock struct {
+	started  bool
+	origin   int64
+	last     uint32
+	extended int64
+}
+
+func (c *tsClock) convert(timestamp int, clockRate int, sinceOpen time.Duration) int64 {
+	if !c.started {
+		c.started, c.last = true, uint32(timestamp)
+		c.origin = tsStartPTS + int64(sinceOpen)*90000/int64(time.Second)
+	}
+	c.extended += int64(int32(uint32(timestamp) - c.last))
+	c.last = uint32(timestamp)
+	return c.origin + c.extended*90000/int64(clockRate)
+}
+
+// NewTSRecorder records the h264/h265 video and aac audio of pusher in dir/<path>/<date>/<time>.ts.
+func NewTSRecorder(pusher *Pusher, dir string) (*TSRecorder, error) {
+	r := &TSRecorder{
+		pusher:      pusher,
+		dir:         dir,
+		maxDuration: time.Duration(ChannelKey(pusher.Path(), "ts_record_duration").MustInt(600)) * time.Second,
+		maxSize:     int64(ChannelKey(pusher.Path(), "ts_record_size").MustInt(0)) * 1024 * 1024,
+	}
+	video, audio := recordTracks(pusher.Path())
+	if codec := strings.ToLower(pusher.VCodec()); video && pusher.HasVideo() && (codec == "h264" || codec == "h265") {
+		r.videoCodec = codec
+	}
+	r.audio = audio && pusher.HasAudio() && strings.EqualFold(pusher.ACodec(), "aac")
+	if r.videoCodec == "" && !r.audio {
+		return nil, fmt.Errorf("no h264/h265 video or aac audio to record")
+	}
+	if spec := ChannelKey(pusher.Path(), "ts_record_schedule").MustString(""); spec != "" {
+		schedule, err := ParseRecordSchedule(spec, ChannelKey(pusher.Path(), "ts_record_timezone").MustString(""))
+		if err != nil {
+			return nil, err
+		}
+		r.schedule = schedule
+	}
+	pusher.AddAccessUnitHandle(r.handle)
+	return r, nil
+}
+
+func (r *TSRecorder) handle(t RTPType, timestamp int, accessUnit []byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return
+	}
+	clockRate := r.pusher.ClockRate(t)
+	if clockRate <= 0 {
+		return
+	}
+	var err error
+	switch {
+	case t == RTP_TYPE_VIDEO && r.videoCodec != "":
+		keyframe := tsKeyframe(r.videoCodec, accessUnit)
+		if keyframe {
+			if !r.recording(time.Now()) {
+				r.pause()
+				return
+			}
+			if err = r.rotate(); err != nil {
+				break
+			}
+		}
+		if r.muxer == nil {
+			// a file starts at a keyframe.
+			return
+		}
+		pts, dts := r.timestamps(r.vClock.convert(timestamp, clockRate, time.Since(r.openedAt)))
+		err = r.muxer.WriteVideo(pts, dts, accessUnit, keyframe)
+		r.size += int64(len(accessUnit))
+	case t == RTP_TYPE_AUDIO && r.audio:
+		if r.videoCodec == "" {
+			if !r.recording(time.Now()) {
+				r.pause()
+				return
+			}
+			if err = r.rotate(); err != nil {
+				break
+			}
+		}
+		if r.muxer == nil {
+			return
+		}
+		err = r.muxer.WriteAudio(r.aClock.convert(timestamp, clockRate, time.Since(r.openedAt)), accessUnit)
+		r.size += int64(len(accessUnit))
+	}
+	if err != nil {
+		r.pusher.Logger().Printf("ts record err:%v", err)
+		r.closeFile()
+	}
+}
+
+// recording tells whether now is in the schedule or before the trigger deadline, caller holds the lock.
+func (r *TSRecorder) recording(now time.Time) bool {
+	return r.schedule == nil || r.schedule.Active(now) || now.Before(r.triggeredUntil)
+}
+
+// pause closes the file at the end of the schedule window, caller holds the lock.
+func (r *TSRecorder) pause() {
+	if r.file != nil {
+		r.pusher.Logger().Printf("ts record stopped out of schedule")
+		r.closeFile()
+	}
+}
+
+// Trigger records for d from the next keyframe even out of the schedule, as for an event.
+func (r *TSRecorder) Trigger(d time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if until := time.Now().Add(d); until.After(r.triggeredUntil) {
+		r.triggeredUntil = until
+	}
+}
+
+// timestamps returns the pts and dts of a video access unit: the dts is the pts less the reorder
+// delay measured on the channel, kept increasing. A b-frame the delay does not cover grows it, and
+// has its pts raised to its dts as a decoder takes no pts before the dts.
+func (r *TSRecorder) timestamps(pts int64) (int64, int64) {
+	dts := pts - r.dtsDelay
+	if dts <= r.lastDTS {
+		dts = r.lastDTS + 1
+	}
+	if dts > pts {
+		r.dtsDelay += dts - pts
+		pts = dts
+	}
+	r.lastDTS = dts
+	return pts, dts
+}
+
+// rotate starts a file if none is open or the current one is due, and flushes the current one
+// otherwise, caller holds the lock.
+func (r *TSRecorder) rotate() error {
+	if r.muxer != nil {
+		due := r.finalize || time.Since(r.openedAt) >= r.maxDuration || (r.maxSize > 0 && r.size >= r.maxSize)
+		if !due {
+			return r.writer.Flush()
+		}
+		r.closeFile()
+	}
+	dir := path.Join(r.dir, r.pusher.Path(), time.Now().Format("20060102"))
+	if err := utils.EnsureDir(dir); err != nil {
+		return err
+	}
+	// a segment finalized within the second of the last one gets a suffix, not its file.
+	name := time.Now().Format("150405")
+	file, err := os.OpenFile(path.Join(dir, name+".ts"), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	for i := 1; os.IsExist(err); i++ {
+		file, err = os.OpenFile(path.Join(dir, fmt.Sprintf("%s-%d.ts", name, i)), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+	}
+	if err != nil {
+		return err
+	}
+	r.file, r.writer = file, bufio.NewWriterSize(file, 256*1024)
+	r.muxer = NewTSMuxer(r.writer, r.videoCodec, r.audio)
+	r.openedAt, r.size, r.finalize = time.Now(), 0, false
+	r.vClock, r.aClock = tsClock{}, tsClock{}
+	r.lastDTS = 0
+	r.dtsDelay = 0
+	if stats := r.pusher.FrameReorderStats(); stats != nil && r.pusher.FrameRate() > 0 {
+		r.dtsDelay = int64(float64(stats.MaxDistance) * 90000 / r.pusher.FrameRate())
+	}
+	r.pusher.Logger().Printf("ts record to %s", file.Name())
+	return r.muxer.WriteTables()
+}
+
+// Finalize ends the segment being recorded at the next keyframe, the next one starts with it.
+func (r *TSRecorder) Finalize() {
+	r.lock.Lock()
+	r.finalize = r.muxer != nil
+	r.lock.Unlock()
+}
+
+func (r *TSRecorder) closeFile() {
+	if r.file == nil {
+		return
+	}
+	r.writer.Flush()
+	r.file.Close()
+	r.file, r.writer, r.muxer = nil, nil, nil
+}
+
+func (r *TSRecorder) Close() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.closed = true
+	r.closeFile()
+}
+
+// tsKeyframe tells whether an Annex-B access unit holds an IDR picture.
+func tsKeyframe(codec string, accessUnit []byte) bool {
+	for _, nalu := range splitAnnexB(accessUnit) {
+		if NALUType(codec, nalu) == NALU_TYPE_IDR {
+			return true
+		}
+	}
+	return false
+}
+
+// splitAnnexB splits Annex-B data at its 3 or 4 bytes start codes.
+func splitAnnexB(data []byte) (nalus [][]byte) {
+	start := -1
+	for i := 0; i+2 < len(data); i++ {
+		if data[i] != 0 || data[i+1] != 0 || data[i+2] != 1 {
+			continue
+		}
+		if start >= 0 {
+			end := i
+			if end > start && data[end-1] == 0 {
+				end--
+			}
+			nalus = append(nalus, data[start:end])
+		}
+		start = i + 3
+		i += 2
+	}
+	if start >= 0 && start < len(data) {
+		nalus = append(nalus, data[start:])
+	}
+	return
+}
+
+// recordTracks returns the tracks record_tracks selects to record, "video", "audio" or "all".
+// Players get all the tracks whatever it is.
+func recordTracks(path string) (video bool, audio bool) {
+	switch strings.ToLower(ChannelKey(path, "record_tracks").MustString("all")) {
+	case "video":
+		return true, false
+	case "audio":
+		return false, true
+	}
+	return true, true
+}
+
+// startTSRecord starts the ts recording of the channel if ts_record_enable is on.
+func (pusher *Pusher) startTSRecord() {
+	if !ChannelKey(pusher.Path(), "ts_record_enable").MustBool(false) {
+		return
+	}
+	dir := ChannelKey(pusher.Path(), "ts_record_dir").MustString("")
+	if dir == "" {
+		pusher.Logger().Printf("ts_record_enable is on, but no ts_record_dir")
+		return
+	}
+	recorder, err := NewTSRecorder(pusher, dir)
+	if err != nil {
+		pusher.Logger().Printf("ts record err:%v", err)
+		return
+	}
+	pusher.tsRecorder = recorder
+}
+
+// TriggerRecord records the channel for d even out of ts_record_schedule, false if it has no ts recording.
+func (pusher *Pusher) TriggerRecord(d time.Duration) bool {
+	if pusher.tsRecorder == nil {
+		return false
+	}
+	pusher.tsRecorder.Trigger(d)
+	return true
+}
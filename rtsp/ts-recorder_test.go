@@ -0,0 +1,149 @@
+package rtsp
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+type tsTestPES struct {
+	pid      uint16
+	pts, dts int64
+	payload  []byte
+}
+
+// tsTestDemux splits a transport stream back to its PES packets, checking the continuity counters.
+func tsTestDemux(t *testing.T, ts []byte) (pes []*tsTestPES) {
+	if len(ts)%tsPacketSize != 0 {
+		t.Fatalf("ts size[%d] not a multiple of %d", len(ts), tsPacketSize)
+	}
+	current := make(map[uint16]*tsTestPES)
+	cc := make(map[uint16]byte)
+	for off := 0; off < len(ts); off += tsPacketSize {
+		pkt := ts[off : off+tsPacketSize]
+		if pkt[0] != 0x47 {
+			t.Fatalf("packet at %d without sync byte", off)
+		}
+		pid := uint16(pkt[1]&0x1F)<<8 | uint16(pkt[2])
+		if last, ok := cc[pid]; ok && pkt[3]&0x0F != (last+1)&0x0F {
+			t.Errorf("pid %#x continuity counter %d after %d", pid, pkt[3]&0x0F, last)
+		}
+		cc[pid] = pkt[3] & 0x0F
+		data := pkt[4:]
+		if pkt[3]&0x20 != 0 {
+			data = data[1+int(data[0]):]
+		}
+		if pid == 0 || pid == tsPMTPID {
+			continue
+		}
+		if pkt[1]&0x40 != 0 {
+			if data[0] != 0 || data[1] != 0 || data[2] != 1 {
+				t.Fatalf("pid %#x pes without start code", pid)
+			}
+			p := &tsTestPES{pid: pid}
+			flags := data[7]
+			p.pts = tsTestTimestamp(data[9:])
+			p.dts = p.pts
+			if flags&0x40 != 0 {
+				p.dts = tsTestTimestamp(data[14:])
+			}
+			p.payload = append([]byte{}, data[9+int(data[8]):]...)
+			current[pid] = p
+			pes = append(pes, p)
+			continue
+		}
+		if p := current[pid]; p != nil {
+			p.payload = append(p.payload, data...)
+		}
+	}
+	return
+}
+
+func tsTestTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
+
+func TestTSMuxerRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewTSMuxer(&buf, "h264", true)
+	if err := m.WriteTables(); err != nil {
+		t.Fatal(err)
+	}
+	keyframe := append([]byte{0, 0, 0, 1, 0x65}, bytes.Repeat([]byte{0x11}, 5000)...)
+	frame := append([]byte{0, 0, 0, 1, 0x41}, bytes.Repeat([]byte{0x22}, 100)...)
+	adts := append([]byte{0xFF, 0xF1, 0x50, 0x80, 0x02, 0x1F, 0xFC}, bytes.Repeat([]byte{0x33}, 9)...)
+	writes := []tsTestPES{
+		{tsVideoPID, 93600, 90000, keyframe},
+		{tsAudioPID, 90100, 90100, adts},
+		{tsVideoPID, 99000, 93600, frame},
+		{tsAudioPID, 92148, 92148, adts},
+	}
+	for _, w := range writes {
+		var err error
+		if w.pid == tsVideoPID {
+			err = m.WriteVideo(w.pts, w.dts, w.payload, w.pts == 93600)
+		} else {
+			err = m.WriteAudio(w.pts, w.payload)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	pes := tsTestDemux(t, buf.Bytes())
+	if len(pes) != len(writes) {
+		t.Fatalf("%d pes, want %d", len(pes), len(writes))
+	}
+	for i, w := range writes {
+		want := w.payload
+		if w.pid == tsVideoPID {
+			want = append(append([]byte{}, tsAUDH264...), want...)
+		}
+		if got := pes[i]; got.pid != w.pid || got.pts != w.pts || got.dts != w.dts || !bytes.Equal(got.payload, want) {
+			t.Errorf("pes %d: pid %#x pts %d dts %d size[%d], want pid %#x pts %d dts %d size[%d]", i, got.pid, got.pts, got.dts, len(got.payload), w.pid, w.pts, w.dts, len(want))
+		}
+	}
+}
+
+func TestTSRecorderTimestamps(t *testing.T) {
+	for _, c := range []struct {
+		name  string
+		delay int64
+		// pts of the frames in decoding order
+		pts []int64
+	}{
+		{"no reordering", 0, []int64{0, 3600, 7200, 10800}},
+		{"b-frames with the delay known", 10800, []int64{0, 10800, 3600, 7200, 21600, 14400, 18000}},
+		{"b-frames with no delay measured", 0, []int64{0, 10800, 3600, 7200, 21600, 14400, 18000}},
+	} {
+		r := &TSRecorder{dtsDelay: c.delay}
+		var lastDTS int64
+		for i, pts := range c.pts {
+			gotPTS, dts := r.timestamps(tsStartPTS + pts)
+			if dts <= lastDTS || dts > gotPTS {
+				t.Errorf("%s: frame %d pts %d dts %d after dts %d", c.name, i, gotPTS, dts, lastDTS)
+			}
+			if c.delay > 0 && gotPTS != tsStartPTS+pts {
+				t.Errorf("%s: frame %d pts %d moved", c.name, i, gotPTS)
+			}
+			lastDTS = dts
+		}
+	}
+}
+
+func TestTSClockSharedOrigin(t *testing.T) {
+	var video, audio tsClock
+	// unrelated rtp timestamps, the audio starting half a second after the video
+	if pts := video.convert(0xFFFFF000, 90000, 0); pts != tsStartPTS {
+		t.Errorf("video starts at %d", pts)
+	}
+	if pts := audio.convert(1234, 48000, 500*time.Millisecond); pts != tsStartPTS+45000 {
+		t.Errorf("audio starts at %d, want %d", pts, tsStartPTS+45000)
+	}
+	// a second later on both, the video across the rtp timestamp wrap
+	if pts := video.convert(0xFFFFF000+90000, 90000, 0); pts != tsStartPTS+90000 {
+		t.Errorf("video at %d", pts)
+	}
+	if pts := audio.convert(1234+48000, 48000, 0); pts != tsStartPTS+45000+90000 {
+		t.Errorf("audio at %d", pts)
+	}
+}
@@ -1,9 +1,11 @@
 package rtsp
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"strings"
+	"sync/atomic"
 
 	"github.com/penggy/EasyGoLib/utils"
 )
@@ -21,6 +23,17 @@ type UDPClient struct {
 	VControlConn *net.UDPConn
 
 	Stoped bool
+	// set when anything comes from the player, rtcp receiver reports or nat punching packets
+	heard int32
+}
+
+// goroutine runs f on a goroutine accounted to the pusher the player plays, if any.
+func (s *UDPClient) goroutine(f func()) {
+	if s.Session != nil && s.Session.Player != nil && s.Session.Player.Pusher != nil {
+		s.Session.Player.Pusher.Go(f)
+		return
+	}
+	go f()
 }
 
 func (s *UDPClient) Stop() {
@@ -86,6 +99,8 @@ func (c *UDPClient) SetupAudio() (err error) {
 	if err := c.AControlConn.SetWriteBuffer(networkBuffer); err != nil {
 		logger.Printf("udp client audio control conn set write buffer error, %v", err)
 	}
+	c.goroutine(func() { c.listen(c.AConn) })
+	c.goroutine(func() { c.listen(c.AControlConn) })
 	return
 }
 
@@ -129,9 +144,39 @@ func (c *UDPClient) SetupVideo() (err error) {
 	if err := c.VControlConn.SetWriteBuffer(networkBuffer); err != nil {
 		logger.Printf("udp client video control conn set write buffer error, %v", err)
 	}
+	c.goroutine(func() { c.listen(c.VConn) })
+	c.goroutine(func() { c.listen(c.VControlConn) })
 	return
 }
 
+// listen reads what the player sends to conn until it is closed, the connected conn only takes
+// packets from the player's port.
+func (c *UDPClient) listen(conn *net.UDPConn) {
+	buf := make([]byte, 2048)
+	for !c.Stoped {
+		if _, err := conn.Read(buf); err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			// an icmp port unreachable for what was sent fails a single read.
+			continue
+		}
+		atomic.StoreInt32(&c.heard, 1)
+	}
+}
+
+// Heard tells whether anything came from the player.
+func (c *UDPClient) Heard() bool {
+	return atomic.LoadInt32(&c.heard) == 1
+}
+
+func (c *UDPClient) localPort(conn *net.UDPConn) int {
+	if conn == nil {
+		return 0
+	}
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
 func (c *UDPClient) SendRTP(pack *RTPPack) (err error) {
 	if pack == nil {
 		err = fmt.Errorf("udp client send rtp got nil pack")
@@ -7,6 +7,7 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/penggy/EasyGoLib/utils"
@@ -26,6 +27,22 @@ type UDPServer struct {
 	VControlConn *net.UDPConn
 
 	Stoped bool
+
+	// packets that came on the rtcp port of their track but are rtp, or the reverse
+	misrouted int64
+}
+
+// goroutine runs f on a goroutine accounted to the pusher of the session or the client, if any.
+func (s *UDPServer) goroutine(f func()) {
+	if s.Session != nil && s.Session.Pusher != nil {
+		s.Session.Pusher.Go(f)
+		return
+	}
+	if s.RTSPClient != nil && s.RTSPClient.pusher != nil {
+		s.RTSPClient.pusher.Go(f)
+		return
+	}
+	go f()
 }
 
 func (s *UDPServer) AddInputBytes(bytes int) {
@@ -40,7 +57,39 @@ func (s *UDPServer) AddInputBytes(bytes int) {
 	panic(fmt.Errorf("session and RTSPClient both nil"))
 }
 
+// misroutedType returns the type a packet received as t really is, clients with off by one port
+// bugs send rtcp to the rtp port or the reverse. Rtcp packet types are 192-223, and no rtp payload
+// type is in 64-95 (RFC 5761 4), so the second byte tells them apart.
+func misroutedType(t RTPType, data []byte) (RTPType, bool) {
+	if len(data) < 8 || data[0]>>6 != 2 {
+		return t, false
+	}
+	rtcp := data[1]&0x7F >= 64 && data[1]&0x7F <= 95
+	switch {
+	case t == RTP_TYPE_VIDEO && rtcp:
+		return RTP_TYPE_VIDEOCONTROL, true
+	case t == RTP_TYPE_AUDIO && rtcp:
+		return RTP_TYPE_AUDIOCONTROL, true
+	case t == RTP_TYPE_VIDEOCONTROL && !rtcp && len(data) >= RTP_FIXED_HEADER_LENGTH:
+		return RTP_TYPE_VIDEO, true
+	case t == RTP_TYPE_AUDIOCONTROL && !rtcp && len(data) >= RTP_FIXED_HEADER_LENGTH:
+		return RTP_TYPE_AUDIO, true
+	}
+	return t, false
+}
+
+// Misrouted returns the count of packets received on the wrong port of their track.
+func (s *UDPServer) Misrouted() int {
+	return int(atomic.LoadInt64(&s.misrouted))
+}
+
 func (s *UDPServer) HandleRTP(pack *RTPPack) {
+	if t, misrouted := misroutedType(pack.Type, pack.Buffer.Bytes()); misrouted {
+		if atomic.AddInt64(&s.misrouted, 1) == 1 {
+			s.Logger().Printf("udp server got %v on the port of %v, the sender mixes up its rtp and rtcp ports", t, pack.Type)
+		}
+		pack.Type = t
+	}
 	if s.Session != nil {
 		for _, v := range s.Session.RTPHandles {
 			v(pack)
@@ -49,9 +98,7 @@ func (s *UDPServer) HandleRTP(pack *RTPPack) {
 	}
 
 	if s.RTSPClient != nil {
-		for _, v := range s.RTSPClient.RTPHandles {
-			v(pack)
-		}
+		s.RTSPClient.ownerClient().deliver(s.RTSPClient, pack)
 		return
 	}
 	panic(fmt.Errorf("session and RTSPClient both nil"))
@@ -113,7 +160,7 @@ func (s *UDPServer) SetupAudio() (err error) {
 	if err != nil {
 		return
 	}
-	go func() {
+	s.goroutine(func() {
 		bufUDP := make([]byte, UDP_BUF_SIZE)
 		logger.Printf("udp server start listen audio port[%d]", s.APort)
 		defer logger.Printf("udp server stop listen audio port[%d]", s.APort)
@@ -138,7 +185,7 @@ func (s *UDPServer) SetupAudio() (err error) {
 				continue
 			}
 		}
-	}()
+	})
 	addr, err = net.ResolveUDPAddr("udp", ":0")
 	if err != nil {
 		return
@@ -159,7 +206,7 @@ func (s *UDPServer) SetupAudio() (err error) {
 	if err != nil {
 		return
 	}
-	go func() {
+	s.goroutine(func() {
 		bufUDP := make([]byte, UDP_BUF_SIZE)
 		logger.Printf("udp server start listen audio control port[%d]", s.AControlPort)
 		defer logger.Printf("udp server stop listen audio control port[%d]", s.AControlPort)
@@ -179,7 +226,7 @@ func (s *UDPServer) SetupAudio() (err error) {
 				continue
 			}
 		}
-	}()
+	})
 	return
 }
 
@@ -206,7 +253,7 @@ func (s *UDPServer) SetupVideo() (err error) {
 	if err != nil {
 		return
 	}
-	go func() {
+	s.goroutine(func() {
 		bufUDP := make([]byte, UDP_BUF_SIZE)
 		logger.Printf("udp server start listen video port[%d]", s.VPort)
 		defer logger.Printf("udp server stop listen video port[%d]", s.VPort)
@@ -231,7 +278,7 @@ func (s *UDPServer) SetupVideo() (err error) {
 				continue
 			}
 		}
-	}()
+	})
 
 	addr, err = net.ResolveUDPAddr("udp", ":0")
 	if err != nil {
@@ -253,7 +300,7 @@ func (s *UDPServer) SetupVideo() (err error) {
 	if err != nil {
 		return
 	}
-	go func() {
+	s.goroutine(func() {
 		bufUDP := make([]byte, UDP_BUF_SIZE)
 		logger.Printf("udp server start listen video control port[%d]", s.VControlPort)
 		defer logger.Printf("udp server stop listen video control port[%d]", s.VControlPort)
@@ -273,6 +320,6 @@ func (s *UDPServer) SetupVideo() (err error) {
 				continue
 			}
 		}
-	}()
+	})
 	return
 }
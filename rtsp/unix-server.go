@@ -0,0 +1,178 @@
+package rtsp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/penggy/EasyGoLib/utils"
+)
+
+// UnixServer accepts RTP framed as RFC 4571 (2 bytes big-endian length + packet) on a unix
+// domain socket and publishes it as a pusher, so a local sidecar can feed a channel
+// without exposing a TCP port.
+type UnixServer struct {
+	Server     *Server
+	SocketPath string
+	StreamPath string
+	SDPPath    string
+	Listener   net.Listener
+	// closed by Stop, the accept loop ends on it
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewUnixServer(server *Server) *UnixServer {
+	sec := utils.Conf().Section("rtsp")
+	return &UnixServer{
+		Server:     server,
+		SocketPath: sec.Key("unix_socket_path").MustString(""),
+		StreamPath: sec.Key("unix_socket_stream_path").MustString("/unix"),
+		SDPPath:    sec.Key("unix_socket_sdp_path").MustString(""),
+		stop:       make(chan struct{}),
+	}
+}
+
+func (s *UnixServer) Start() (err error) {
+	logger := s.Server.logger
+	if s.SocketPath == "" {
+		return
+	}
+	if s.SDPPath == "" {
+		err = fmt.Errorf("unix socket ingest needs unix_socket_sdp_path")
+		return
+	}
+	// remove the stale socket file left by a previous run, but nothing else on the path.
+	if info, statErr := os.Lstat(s.SocketPath); statErr == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			err = fmt.Errorf("unix_socket_path %s exists and is not a socket", s.SocketPath)
+			return
+		}
+		os.Remove(s.SocketPath)
+	}
+	listener, err := net.Listen("unix", s.SocketPath)
+	if err != nil {
+		return
+	}
+	s.Listener = listener
+	logger.Printf("unix socket ingest start on %s, stream path[%s]", s.SocketPath, s.StreamPath)
+	go func() {
+		defer logger.Printf("unix socket ingest stop on %s", s.SocketPath)
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				select {
+				case <-s.stop:
+					return
+				default:
+				}
+				logger.Println(err)
+				continue
+			}
+			go s.handleConn(conn)
+		}
+	}()
+	return
+}
+
+func (s *UnixServer) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		if s.Listener != nil {
+			s.Listener.Close()
+		}
+	})
+}
+
+func (s *UnixServer) handleConn(conn net.Conn) {
+	session := NewSession(s.Server, conn)
+	defer session.Stop()
+	logger := session.logger
+	sdpRaw, err := ioutil.ReadFile(s.SDPPath)
+	if err != nil {
+		logger.Printf("unix socket ingest read sdp[%s] err:%v", s.SDPPath, err)
+		return
+	}
+	session.Type = SESSION_TYPE_PUSHER
+	session.TransType = TRANS_TYPE_UNIX
+	session.URL = fmt.Sprintf("unix://%s", s.SocketPath)
+	session.Path = s.StreamPath
+	session.SDPRaw = string(sdpRaw)
+	session.SDPMap = ParseSDP(session.SDPRaw)
+	vPayloadType, aPayloadType := -1, -1
+	var aSDP *SDPInfo
+	if sdp, ok := session.SDPMap["video"]; ok {
+		session.VControl = sdp.Control
+		session.VCodec = sdp.Codec
+		vPayloadType = sdp.PayloadType
+	}
+	if sdp, ok := session.SDPMap["audio"]; ok {
+		session.AControl = sdp.Control
+		session.ACodec = sdp.Codec
+		aPayloadType = sdp.PayloadType
+		aSDP = sdp
+	}
+	session.Pusher = NewPusher(session)
+	if !s.Server.AddPusher(session.Pusher) {
+		logger.Printf("unix socket ingest reject pusher, path[%s] already exists", session.Path)
+		return
+	}
+
+	aSSRC := -1
+	bufLen := make([]byte, 2)
+	// the loop ends on the read error of the connection, closed by the sidecar or by session.Stop.
+	for {
+		if _, err := io.ReadFull(session.connRW, bufLen); err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				logger.Println(err)
+			}
+			return
+		}
+		rtpLen := int(binary.BigEndian.Uint16(bufLen))
+		rtpBytes := make([]byte, rtpLen)
+		if _, err := io.ReadFull(session.connRW, rtpBytes); err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Println(err)
+			}
+			return
+		}
+		session.InBytes += rtpLen + 2
+		if rtpLen < RTP_FIXED_HEADER_LENGTH {
+			continue
+		}
+		var pack *RTPPack
+		// rtcp and rtp share the socket, demux them by the second byte as RFC 5761 does.
+		if pt := rtpBytes[1]; pt >= 192 && pt <= 223 {
+			ssrc := int(binary.BigEndian.Uint32(rtpBytes[4:]))
+			if ssrc == aSSRC {
+				pack = &RTPPack{Type: RTP_TYPE_AUDIOCONTROL, Buffer: bytes.NewBuffer(rtpBytes)}
+			} else {
+				pack = &RTPPack{Type: RTP_TYPE_VIDEOCONTROL, Buffer: bytes.NewBuffer(rtpBytes)}
+			}
+		} else {
+			rtp := ParseRTP(rtpBytes)
+			if rtp == nil {
+				continue
+			}
+			switch {
+			case rtp.PayloadType == vPayloadType:
+				pack = &RTPPack{Type: RTP_TYPE_VIDEO, Buffer: bytes.NewBuffer(rtpBytes)}
+			case rtp.PayloadType == aPayloadType, aSDP != nil && aSDP.IsComfortNoise(rtp.PayloadType):
+				aSSRC = rtp.SSRC
+				pack = &RTPPack{Type: RTP_TYPE_AUDIO, Buffer: bytes.NewBuffer(rtpBytes)}
+			default:
+				logger.Printf("unix socket ingest got unknow payload type[%d], ssrc[%d]", rtp.PayloadType, rtp.SSRC)
+				continue
+			}
+		}
+		for _, h := range session.RTPHandles {
+			h(pack)
+		}
+	}
+}
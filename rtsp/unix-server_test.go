@@ -0,0 +1,159 @@
+package rtsp
+
+import (
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUnixServerIngest(t *testing.T) {
+	dir, err := os.MkdirTemp("", "unix-ingest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sdp := "v=0\r\no=- 0 0 IN IP4 127.0.0.1\r\ns=unix\r\nt=0 0\r\n" +
+		"m=video 0 RTP/AVP 96\r\na=rtpmap:96 H264/90000\r\na=control:streamid=0\r\n" +
+		"m=audio 0 RTP/AVP 97\r\na=rtpmap:97 MPEG4-GENERIC/44100/2\r\na=control:streamid=1\r\n"
+	if err := os.WriteFile(filepath.Join(dir, "ingest.sdp"), []byte(sdp), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setTestConf(t, "unix_socket_path", filepath.Join(dir, "ingest.sock"))
+	setTestConf(t, "unix_socket_sdp_path", filepath.Join(dir, "ingest.sdp"))
+	setTestConf(t, "unix_socket_stream_path", "/unix")
+	server := newTestServer()
+	us := NewUnixServer(server)
+	if err := us.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer us.Stop()
+
+	conn, err := net.Dial("unix", us.SocketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var pusher *Pusher
+	for deadline := time.Now().Add(5 * time.Second); pusher == nil && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		pusher = server.GetPushers()["/unix"]
+	}
+	if pusher == nil {
+		t.Fatal("no pusher on the stream path")
+	}
+	defer pusher.Stop()
+	if pusher.VCodec() != "h264" || pusher.ACodec() != "aac" {
+		t.Errorf("codecs %s/%s from the sdp", pusher.VCodec(), pusher.ACodec())
+	}
+	// a player that is not started, to see what the pusher relays
+	session := &Session{ID: "player", Path: "/unix", logger: log.New(io.Discard, "", 0)}
+	player := &Player{Session: session, Pusher: pusher, cond: sync.NewCond(&sync.Mutex{})}
+	pusher.playersLock.Lock()
+	pusher.players[player.ID] = player
+	pusher.playersLock.Unlock()
+
+	audio := selfTestRTP(97, 0, 0, true, []byte{0x00, 0x10, 0x01, 0x20, 0xAA})
+	binary.BigEndian.PutUint32(audio[8:], 0xA0D10)
+	sr := func(ssrc uint32) []byte {
+		b := make([]byte, 28)
+		b[0], b[1] = 0x80, 200
+		binary.BigEndian.PutUint16(b[2:], 6)
+		binary.BigEndian.PutUint32(b[4:], ssrc)
+		return b
+	}
+	var stream []byte
+	for _, pkt := range [][]byte{
+		selfTestRTP(96, 0, 0, true, []byte{0x65, 0x88, 0x84}),
+		// short and unknown payload type packets are dropped
+		{0x80, 96, 0, 1},
+		selfTestRTP(50, 1, 0, true, []byte{1}),
+		audio,
+		sr(0xA0D10),
+		sr(selfTestSSRC),
+	} {
+		stream = append(stream, byte(len(pkt)>>8), byte(len(pkt)))
+		stream = append(stream, pkt...)
+	}
+	if _, err := conn.Write(stream); err != nil {
+		t.Fatal(err)
+	}
+	want := []RTPType{RTP_TYPE_VIDEO, RTP_TYPE_AUDIO, RTP_TYPE_AUDIOCONTROL, RTP_TYPE_VIDEOCONTROL}
+	var got []RTPType
+	for deadline := time.Now().Add(5 * time.Second); len(got) < len(want) && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		player.cond.L.Lock()
+		got = got[:0]
+		for _, pack := range player.queue {
+			got = append(got, pack.Type)
+		}
+		player.cond.L.Unlock()
+	}
+	if len(got) != len(want) {
+		t.Fatalf("relayed %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("relayed %v, want %v", got, want)
+			break
+		}
+	}
+	if in := pusher.InBytes(); in != len(stream) {
+		t.Errorf("in bytes %d, want %d", in, len(stream))
+	}
+
+	// a second sidecar on the same stream path is turned away
+	second, err := net.Dial("unix", us.SocketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := second.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("second connection read err %v, want it closed", err)
+	}
+}
+
+func TestUnixServerNeedsSDP(t *testing.T) {
+	setTestConf(t, "unix_socket_path", filepath.Join(os.TempDir(), "no-sdp.sock"))
+	setTestConf(t, "unix_socket_sdp_path", "")
+	if err := NewUnixServer(newTestServer()).Start(); err == nil {
+		t.Error("started without unix_socket_sdp_path")
+	}
+}
+
+func TestUnixServerKeepsOtherFiles(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "ingest.sock")
+	if err := os.WriteFile(file, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setTestConf(t, "unix_socket_path", file)
+	setTestConf(t, "unix_socket_sdp_path", filepath.Join(dir, "ingest.sdp"))
+	us := NewUnixServer(newTestServer())
+	if err := us.Start(); err == nil {
+		us.Stop()
+		t.Fatal("started over a regular file")
+	}
+	if b, err := os.ReadFile(file); err != nil || string(b) != "data" {
+		t.Errorf("regular file on the socket path read %q, %v", b, err)
+	}
+
+	// a stale socket of a previous run is replaced
+	os.Remove(file)
+	stale, err := net.Listen("unix", file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	us = NewUnixServer(newTestServer())
+	if err := us.Start(); err != nil {
+		t.Fatalf("start over a stale socket: %v", err)
+	}
+	us.Stop()
+	us.Stop()
+}